@@ -6,16 +6,33 @@ package audio
 
 import (
 	"errors"
+	"io"
 	"os"
+	"sync"
 	"time"
 
-	"github.com/dhowden/tag"
 	"github.com/goulash/stat"
 )
 
 var Stats struct {
-	Identify     stat.Run
-	ReadMetadata stat.Run
+	Identify      stat.Run
+	ReadMetadata  stat.Run
+	WriteMetadata stat.Run
+}
+
+// statsMu guards Stats, since the functions may be called concurrently.
+var statsMu sync.Mutex
+
+// observe records an operation on the codec that started at start, in the
+// run r, if it is not nil, and in DefaultMetrics.
+func observe(r *stat.Run, op string, c Codec, start time.Time, err error) {
+	d := time.Since(start)
+	if r != nil {
+		statsMu.Lock()
+		r.Add(float64(d))
+		statsMu.Unlock()
+	}
+	DefaultMetrics.Observe(c, op, d, err)
 }
 
 type Codec int
@@ -41,6 +58,8 @@ const (
 	AAC // Advanced Audio Coding
 	OGG // Vorbis
 	WMA // Windows Media Audio
+
+	numCodecs // must be last
 )
 
 func (c Codec) String() string {
@@ -83,6 +102,8 @@ func (c Codec) String() string {
 }
 
 type Metadata interface {
+	Properties
+
 	Title() string         // The primary song title
 	Album() string         // The album the song belongs to
 	Artist() string        // The primary performer/artist of the song
@@ -105,54 +126,171 @@ type Metadata interface {
 	OriginalFilename() string // The original filename of the song
 }
 
-func Identify(file string) (Codec, error) {
-	start := time.Now()
-	defer func() { Stats.Identify.Add(float64(time.Since(start))) }()
+// Properties are the technical properties of an audio stream, which are
+// independent of the codec.
+type Properties interface {
+	SampleRate() int              // The number of inter-channel samples per second, or 0 if unknown
+	NumChannels() int             // The number of channels, or 0 if unknown
+	BitsPerSample() int           // The number of bits of each sample, or 0 for lossy codecs
+	TotalSamples() int64          // The number of inter-channel samples, or 0 if unknown
+	Duration() time.Duration      // The duration of the stream, or 0 if unknown
+	BitrateMode() BitrateMode     // Whether the bitrate is constant, variable, or lossless
+	ChannelLayout() ChannelLayout // The speaker positions of the channels, or 0 if unknown
+}
+
+// BitrateMode describes how the bitrate of a stream varies.
+type BitrateMode int
 
+const (
+	UnknownBitrate BitrateMode = iota
+	CBR                        // Constant bitrate
+	VBR                        // Variable bitrate
+	Lossless                   // Lossless compression, which has a variable bitrate
+	ABR                        // Average bitrate, which is variable but aims at a target
+)
+
+func (m BitrateMode) String() string {
+	switch m {
+	case CBR:
+		return "CBR"
+	case VBR:
+		return "VBR"
+	case Lossless:
+		return "lossless"
+	case ABR:
+		return "ABR"
+	default:
+		return "?"
+	}
+}
+
+// MutableMetadata is Metadata that can be changed. Setting a string to the
+// empty string or a number to 0 removes the tag.
+type MutableMetadata interface {
+	Metadata
+
+	SetTitle(string)
+	SetAlbum(string)
+	SetArtist(string)
+	SetAlbumArtist(string)
+	SetComposer(string)
+	SetYear(int)
+	SetGenre(string)
+	SetTrack(n, total int)
+	SetDisc(n, total int)
+	SetComment(string)
+	SetCopyright(string)
+	SetWebsite(string)
+	SetEncodedBy(string)
+}
+
+// Identify returns the codec of the file; see IdentifyReader.
+func Identify(file string) (Codec, error) {
 	f, err := os.Open(file)
 	if err != nil {
 		return Unknown, err
 	}
 	defer f.Close()
+	return IdentifyReader(f)
+}
+
+// IdentifyReader returns the codec of the stream in r, which is identified
+// by the formats that are registered with RegisterFormat. If the codec
+// cannot be identified, Unknown is returned. The position of r is undefined
+// afterwards.
+func IdentifyReader(r io.ReadSeeker) (Codec, error) {
+	f, _, err := identifyFormat(r)
+	return f.Codec, err
+}
+
+func identifyFormat(r io.ReadSeeker) (f Format, offset int64, err error) {
+	start := time.Now()
+	defer func() { observe(&Stats.Identify, OpIdentify, f.Codec, start, err) }()
+
+	return identify(r)
+}
 
-	_, ft, err := tag.Identify(f)
+var (
+	ErrUnsupported       = errors.New("reading metadata for this codec unsupported")
+	ErrWriteUnsupported  = errors.New("writing metadata for this codec unsupported")
+	ErrDecodeUnsupported = errors.New("decoding this codec unsupported")
+	ErrInvalidStream     = errors.New("stream is invalid")
+)
+
+// ReadMetadata reads the metadata of the file; see ReadMetadataFrom.
+func ReadMetadata(file string) (Metadata, error) {
+	f, err := os.Open(file)
 	if err != nil {
-		return Unknown, err
+		return nil, err
 	}
+	defer f.Close()
+	return ReadMetadataFrom(f)
+}
 
-	switch ft {
-	case tag.FLAC:
-		return FLAC, nil
-	case tag.OGG:
-		return OGG, nil
-	case tag.MP3:
-		return MP3, nil
-	case tag.M4A:
-		return M4A, nil
-	case tag.M4B:
-		return M4B, nil
-	case tag.M4P:
-		return M4P, nil
-	case tag.ALAC:
-		return ALAC, nil
-	default: // Unknown
-		return Unknown, nil
+// ReadMetadataFrom reads the metadata of the stream in r, which can be
+// anything that is not a file, such as an upload or an archive member.
+func ReadMetadataFrom(r io.ReadSeeker) (Metadata, error) {
+	f, offset, err := identifyFormat(r)
+	if err != nil {
+		return nil, err
 	}
+	return readFormatMetadata(r, f, offset)
 }
 
-var MetadataReaders = make(map[Codec]func(string) (Metadata, error))
+// readFormatMetadata reads the metadata of the stream in r, which has
+// already been identified as the format f starting at the offset.
+func readFormatMetadata(r io.ReadSeeker, f Format, offset int64) (m Metadata, err error) {
+	start := time.Now()
+	defer func() { observe(&Stats.ReadMetadata, OpReadMetadata, f.Codec, start, err) }()
 
-func ReadMetadata(file string) (Metadata, error) {
+	if f.ReadMetadata == nil {
+		return nil, ErrUnsupported
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		r = &offsetReader{r, offset}
+	}
+	return f.ReadMetadata(r)
+}
+
+// WriteMetadata changes the metadata of the file. The changes function is
+// called with the current metadata of the file, and whatever it sets is
+// written back; tags that it does not touch are kept as they are.
+func WriteMetadata(file string, changes func(MutableMetadata)) (err error) {
 	start := time.Now()
-	defer func() { Stats.ReadMetadata.Add(float64(time.Since(start))) }()
+	var c Codec
+	defer func() { observe(&Stats.WriteMetadata, OpWriteMetadata, c, start, err) }()
+
+	c, err = Identify(file)
+	if err != nil {
+		return err
+	}
+	f, _ := lookupFormat(c)
+	if f.WriteMetadata == nil {
+		return ErrWriteUnsupported
+	}
+	return f.WriteMetadata(file, changes)
+}
 
-	c, err := Identify(file)
+// NewDecoder returns a decoder for the stream in r.
+func NewDecoder(r io.ReadSeeker) (d Decoder, err error) {
+	f, offset, err := identifyFormat(r)
 	if err != nil {
 		return nil, err
 	}
-	f, ok := MetadataReaders[c]
-	if !ok {
-		return nil, errors.New("reading metadata for this codec unsupported")
+
+	start := time.Now()
+	defer func() { observe(nil, OpNewDecoder, f.Codec, start, err) }()
+	if f.NewDecoder == nil {
+		return nil, ErrDecodeUnsupported
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		r = &offsetReader{r, offset}
 	}
-	return f(file)
+	return f.NewDecoder(r)
 }
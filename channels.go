@@ -0,0 +1,113 @@
+// Copyright (c) 2016, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package audio
+
+import (
+	"math/bits"
+	"strings"
+)
+
+// ChannelLayout is a set of speaker positions. The bits are the same as in
+// the channel mask of WAVE_FORMAT_EXTENSIBLE, which is also used by FLAC.
+type ChannelLayout uint32
+
+const (
+	FrontLeft ChannelLayout = 1 << iota
+	FrontRight
+	FrontCenter
+	LowFrequency
+	BackLeft
+	BackRight
+	FrontLeftOfCenter
+	FrontRightOfCenter
+	BackCenter
+	SideLeft
+	SideRight
+	TopCenter
+	TopFrontLeft
+	TopFrontCenter
+	TopFrontRight
+	TopBackLeft
+	TopBackCenter
+	TopBackRight
+)
+
+// The common channel layouts.
+const (
+	Mono       = FrontCenter
+	Stereo     = FrontLeft | FrontRight
+	Surround   = FrontLeft | FrontRight | FrontCenter
+	Quad       = FrontLeft | FrontRight | BackLeft | BackRight
+	Surround50 = FrontLeft | FrontRight | FrontCenter | BackLeft | BackRight
+	Surround51 = Surround50 | LowFrequency
+	Surround61 = FrontLeft | FrontRight | FrontCenter | LowFrequency | BackCenter | SideLeft | SideRight
+	Surround71 = Surround51 | SideLeft | SideRight
+)
+
+var channelNames = []string{
+	"FL", "FR", "FC", "LFE", "BL", "BR", "FLC", "FRC", "BC",
+	"SL", "SR", "TC", "TFL", "TFC", "TFR", "TBL", "TBC", "TBR",
+}
+
+// DefaultChannelLayout returns the layout that FLAC, Vorbis, and most other
+// codecs assume for a stream with n channels, or 0 if there is none.
+func DefaultChannelLayout(n int) ChannelLayout {
+	switch n {
+	case 1:
+		return Mono
+	case 2:
+		return Stereo
+	case 3:
+		return Surround
+	case 4:
+		return Quad
+	case 5:
+		return Surround50
+	case 6:
+		return Surround51
+	case 7:
+		return Surround61
+	case 8:
+		return Surround71
+	default:
+		return 0
+	}
+}
+
+// NumChannels returns the number of speaker positions in the layout.
+func (l ChannelLayout) NumChannels() int { return bits.OnesCount32(uint32(l)) }
+
+// String returns the name of a common layout, such as "5.1", or else the
+// speaker positions joined by "+", such as "FL+FR+LFE".
+func (l ChannelLayout) String() string {
+	switch l {
+	case 0:
+		return "?"
+	case Mono:
+		return "mono"
+	case Stereo:
+		return "stereo"
+	case Quad:
+		return "quad"
+	case Surround50:
+		return "5.0"
+	case Surround51:
+		return "5.1"
+	case Surround61:
+		return "6.1"
+	case Surround71:
+		return "7.1"
+	}
+	var names []string
+	for i, name := range channelNames {
+		if l&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	if l>>uint(len(channelNames)) != 0 {
+		names = append(names, "?")
+	}
+	return strings.Join(names, "+")
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2016, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package audio
+
+import "testing"
+
+func TestChannelLayout(z *testing.T) {
+	tests := []struct {
+		Layout   ChannelLayout
+		Channels int
+		String   string
+	}{
+		{0, 0, "?"},
+		{DefaultChannelLayout(1), 1, "mono"},
+		{DefaultChannelLayout(2), 2, "stereo"},
+		{DefaultChannelLayout(3), 3, "FL+FR+FC"},
+		{DefaultChannelLayout(6), 6, "5.1"},
+		{DefaultChannelLayout(8), 8, "7.1"},
+		{DefaultChannelLayout(9), 0, "?"},
+		{FrontLeft | LowFrequency | TopBackRight, 3, "FL+LFE+TBR"},
+		{FrontLeft | 1<<31, 2, "FL+?"},
+	}
+
+	for _, t := range tests {
+		if n := t.Layout.NumChannels(); n != t.Channels {
+			z.Errorf("%#x.NumChannels() = %d; expecting %d", uint32(t.Layout), n, t.Channels)
+		}
+		if s := t.Layout.String(); s != t.String {
+			z.Errorf("%#x.String() = %q; expecting %q", uint32(t.Layout), s, t.String)
+		}
+	}
+}
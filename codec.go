@@ -0,0 +1,92 @@
+// Copyright (c) 2016, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// IsLossless returns true if the codec compresses audio without loss.
+func (c Codec) IsLossless() bool {
+	switch c {
+	case WAV, ALAC, FLAC, APE, OFR, TAK, WV, TTA, WMAL:
+		return true
+	default:
+		return false
+	}
+}
+
+// Extensions returns the file extensions of the codec, including the dot,
+// with the preferred extension first. They are taken from the format that
+// is registered for the codec.
+func (c Codec) Extensions() []string {
+	f, _ := lookupFormat(c)
+	return append([]string(nil), f.Extensions...)
+}
+
+// MIMEType returns the preferred MIME type of the codec, which is taken from
+// the format that is registered for the codec, or application/octet-stream
+// if there is none.
+func (c Codec) MIMEType() string {
+	f, _ := lookupFormat(c)
+	if len(f.MIMETypes) == 0 {
+		return "application/octet-stream"
+	}
+	return f.MIMETypes[0]
+}
+
+// ParseCodec returns the codec with the name s, as returned by String, or
+// with the file extension s, with or without the dot, ignoring case. If
+// several codecs share an extension, such as .m4a, the first registered
+// format wins. The empty string is parsed as Unknown.
+func ParseCodec(s string) (Codec, error) {
+	if s == "" {
+		return Unknown, nil
+	}
+	for c := Unknown + 1; c < numCodecs; c++ {
+		if strings.EqualFold(s, c.String()) {
+			return c, nil
+		}
+	}
+	ext := strings.ToLower(s)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	formats, _ := atomicFormats.Load().([]Format)
+	for _, f := range formats {
+		for _, e := range f.Extensions {
+			if e == ext {
+				return f.Codec, nil
+			}
+		}
+	}
+	return Unknown, fmt.Errorf("%w %q", ErrUnknownCodec, s)
+}
+
+// MarshalText implements encoding.TextMarshaler. Unknown is marshaled as
+// the empty string.
+func (c Codec) MarshalText() ([]byte, error) {
+	if c == Unknown {
+		return []byte{}, nil
+	}
+	if c < Unknown || c >= numCodecs {
+		return nil, fmt.Errorf("%w %d", ErrUnknownCodec, int(c))
+	}
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler; see ParseCodec.
+func (c *Codec) UnmarshalText(text []byte) error {
+	x, err := ParseCodec(string(text))
+	if err != nil {
+		return err
+	}
+	*c = x
+	return nil
+}
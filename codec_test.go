@@ -0,0 +1,85 @@
+// Copyright (c) 2016, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package audio
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecDescriptors(z *testing.T) {
+	assert := assert.New(z)
+
+	assert.True(FLAC.IsLossless())
+	assert.True(WMAL.IsLossless())
+	assert.False(WMA.IsLossless())
+	assert.False(MP3.IsLossless())
+	assert.False(Unknown.IsLossless())
+
+	assert.Equal([]string{".flac"}, FLAC.Extensions())
+	assert.Equal([]string{".mp3"}, MP3.Extensions())
+	assert.Nil(Unknown.Extensions())
+
+	assert.Equal("audio/flac", FLAC.MIMEType())
+	assert.Equal("audio/mpeg", MP3.MIMEType())
+	assert.Equal("audio/mp4", ALAC.MIMEType())
+	assert.Equal("application/octet-stream", Unknown.MIMEType())
+}
+
+func TestParseCodec(z *testing.T) {
+	tests := map[string]Codec{
+		"":      Unknown,
+		"FLAC":  FLAC,
+		"flac":  FLAC,
+		"wmal":  WMAL,
+		".ogg":  OGG,
+		"opus":  OGG,
+		"M4B":   M4B,
+		".wave": WAV,
+		"WV":    WV,
+		"m4a":   M4A,
+		".wma":  WMA,
+	}
+	for k, v := range tests {
+		c, err := ParseCodec(k)
+		if err != nil || c != v {
+			z.Errorf("ParseCodec(%q) = %v, %v; expecting %v", k, c, err, v)
+		}
+	}
+
+	_, err := ParseCodec("midi")
+	if !errors.Is(err, ErrUnknownCodec) {
+		z.Errorf("ParseCodec(%q) = %v; expecting %v", "midi", err, ErrUnknownCodec)
+	}
+}
+
+func TestCodecJSON(z *testing.T) {
+	assert := assert.New(z)
+
+	var cfg struct {
+		Codecs []Codec
+		Target Codec
+	}
+	cfg.Codecs = []Codec{FLAC, MP3, Unknown}
+	cfg.Target = OGG
+	data, err := json.Marshal(cfg)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(`{"Codecs":["FLAC","MP3",""],"Target":"OGG"}`, string(data))
+
+	cfg.Codecs, cfg.Target = nil, Unknown
+	if assert.Nil(json.Unmarshal(data, &cfg)) {
+		assert.Equal([]Codec{FLAC, MP3, Unknown}, cfg.Codecs)
+		assert.Equal(OGG, cfg.Target)
+	}
+
+	assert.NotNil(json.Unmarshal([]byte(`{"Target":"midi"}`), &cfg))
+	_, err = json.Marshal(numCodecs)
+	assert.NotNil(err)
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2016, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package audio
+
+// The interfaces in this file are optional extensions of Metadata, which
+// format packages implement if their metadata can contain the information.
+// Use a type assertion to find out whether the metadata implements them:
+//
+//	if pr, ok := m.(audio.PictureReader); ok {
+//		for _, p := range pr.Pictures() {
+//			...
+//		}
+//	}
+
+// PictureReader is implemented by metadata that can contain embedded pictures.
+type PictureReader interface {
+	// Pictures returns the embedded pictures, such as the cover art,
+	// in the order in which they are stored.
+	Pictures() []Picture
+}
+
+// ReplayGainReader is implemented by metadata that can contain ReplayGain
+// values. The gain is in dB, and the peak is the maximum absolute sample
+// value, where 1.0 is full scale, or 0 if it is unknown; ok is false if the
+// gain is not set.
+type ReplayGainReader interface {
+	TrackGain() (gain, peak float64, ok bool)
+	AlbumGain() (gain, peak float64, ok bool)
+}
+
+// MusicBrainzReader is implemented by metadata that can contain MusicBrainz
+// identifiers.
+type MusicBrainzReader interface {
+	MusicBrainz() MusicBrainzIDs
+}
+
+// MusicBrainzIDs contains the MusicBrainz identifiers of a song. Each
+// identifier is a UUID, or empty if it is not set.
+type MusicBrainzIDs struct {
+	Recording    string // The recording, which MusicBrainz Picard calls the track ID
+	Track        string // The track on the release
+	Release      string // The release, which MusicBrainz Picard calls the album ID
+	ReleaseGroup string
+	Work         string
+
+	Artists      []string // The artists of the recording
+	AlbumArtists []string // The artists of the release
+}
+
+// IsZero returns true if none of the identifiers are set.
+func (ids *MusicBrainzIDs) IsZero() bool {
+	return ids.Recording == "" && ids.Track == "" && ids.Release == "" &&
+		ids.ReleaseGroup == "" && ids.Work == "" &&
+		len(ids.Artists) == 0 && len(ids.AlbumArtists) == 0
+}
+
+// LyricsReader is implemented by metadata that can contain lyrics.
+type LyricsReader interface {
+	Lyrics() string // The unsynchronized lyrics, or the empty string
+}
@@ -0,0 +1,236 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package flac
+
+import (
+	"bufio"
+	"io"
+	"math/bits"
+)
+
+// bitReader reads a stream bit by bit, most significant bit first, and keeps
+// track of the CRC-8 and CRC-16 of the bytes that it has consumed.
+type bitReader struct {
+	r byteReader
+	x uint64 // bit buffer, the n low bits are valid
+	n uint   // number of bits in the buffer
+
+	crc8  uint8
+	crc16 uint16
+	bytes int64 // number of bytes consumed
+}
+
+// newBitReader returns a bitReader for r. If r does not implement
+// io.ByteReader, it is wrapped in a bufio.Reader, which can also be
+// used as an io.Reader for anything that precedes the frames.
+func newBitReader(r io.Reader) *bitReader {
+	if _, ok := r.(io.ByteReader); !ok {
+		r = bufio.NewReader(r)
+	}
+	return &bitReader{r: r.(byteReader)}
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// fill loads bytes into the buffer until at least n bits are available.
+// It must not be called with n > 56.
+func (br *bitReader) fill(n uint) error {
+	for br.n < n {
+		c, err := br.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return ErrUnexpectedEOF
+			}
+			return err
+		}
+		br.crc8 = crc8Table[br.crc8^c]
+		br.crc16 = br.crc16<<8 ^ crc16Table[byte(br.crc16>>8)^c]
+		br.bytes++
+		br.x = br.x<<8 | uint64(c)
+		br.n += 8
+	}
+	return nil
+}
+
+// readBits reads n bits, where n must be at most 56.
+func (br *bitReader) readBits(n uint) (uint64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	if err := br.fill(n); err != nil {
+		return 0, err
+	}
+	br.n -= n
+	return (br.x >> br.n) & (1<<n - 1), nil
+}
+
+// readSigned reads n bits as a two's complement signed integer.
+func (br *bitReader) readSigned(n uint) (int64, error) {
+	x, err := br.readBits(n)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	return int64(x<<(64-n)) >> (64 - n), nil
+}
+
+// readUnary returns the number of zero bits before the next one bit.
+func (br *bitReader) readUnary() (uint64, error) {
+	var k uint64
+	for {
+		if br.n == 0 {
+			if err := br.fill(8); err != nil {
+				return 0, err
+			}
+		}
+		x := br.x << (64 - br.n)
+		if x != 0 {
+			z := uint(bits.LeadingZeros64(x))
+			br.n -= z + 1
+			return k + uint64(z), nil
+		}
+		k += uint64(br.n)
+		br.n = 0
+	}
+}
+
+// align discards the remaining bits of the current byte.
+func (br *bitReader) align() {
+	br.n -= br.n % 8
+}
+
+// reset resets the checksums, which should be done at the start of each
+// frame. The reader must be byte-aligned. Bytes that are buffered but not
+// read yet are included in the new checksums.
+func (br *bitReader) reset() {
+	br.crc8, br.crc16 = 0, 0
+	for i := int(br.n/8) - 1; i >= 0; i-- {
+		c := byte(br.x >> (8 * uint(i)))
+		br.crc8 = crc8Table[br.crc8^c]
+		br.crc16 = br.crc16<<8 ^ crc16Table[byte(br.crc16>>8)^c]
+	}
+}
+
+// sync discards bytes until the next frame sync code, which is left unread.
+func (br *bitReader) sync() error {
+	br.align()
+	var prev uint64
+	for {
+		c, err := br.readBits(8)
+		if err != nil {
+			return err
+		}
+		if prev == 0xFF && c&0xFE == 0xF8 {
+			br.n += 16 // the sync code is still in the buffer
+			return nil
+		}
+		prev = c
+	}
+}
+
+// offset returns the number of bytes read so far, excluding the bytes
+// that are still buffered.
+func (br *bitReader) offset() int64 { return br.bytes - int64(br.n/8) }
+
+// bitWriter writes bits into a byte slice, most significant bit first.
+type bitWriter struct {
+	buf []byte
+	x   uint64 // bit buffer, the n low bits are pending
+	n   uint   // number of pending bits
+}
+
+// writeBits writes the n low bits of v, where n must be at most 56.
+func (bw *bitWriter) writeBits(v uint64, n uint) {
+	if n == 0 {
+		return
+	}
+	bw.x = bw.x<<n | v&(1<<n-1)
+	bw.n += n
+	for bw.n >= 8 {
+		bw.n -= 8
+		bw.buf = append(bw.buf, byte(bw.x>>bw.n))
+	}
+}
+
+// writeUnary writes q zero bits followed by a one bit.
+func (bw *bitWriter) writeUnary(q uint64) {
+	for q >= 32 {
+		bw.writeBits(0, 32)
+		q -= 32
+	}
+	bw.writeBits(1, uint(q)+1)
+}
+
+// align pads the current byte with zero bits.
+func (bw *bitWriter) align() {
+	if bw.n%8 != 0 {
+		bw.writeBits(0, 8-bw.n%8)
+	}
+}
+
+// reset discards everything that has been written.
+func (bw *bitWriter) reset() {
+	bw.buf, bw.x, bw.n = bw.buf[:0], 0, 0
+}
+
+// CRC {{{
+
+// crc8Table is for the polynomial x^8 + x^2 + x^1 + x^0, as used for
+// the frame header.
+var crc8Table = makeCRC8Table(0x07)
+
+// crc16Table is for the polynomial x^16 + x^15 + x^2 + x^0, as used for
+// the whole frame.
+var crc16Table = makeCRC16Table(0x8005)
+
+func makeCRC8Table(poly uint8) (t [256]uint8) {
+	for i := range t {
+		c := uint8(i)
+		for j := 0; j < 8; j++ {
+			if c&0x80 != 0 {
+				c = c<<1 ^ poly
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}
+
+func makeCRC16Table(poly uint16) (t [256]uint16) {
+	for i := range t {
+		c := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if c&0x8000 != 0 {
+				c = c<<1 ^ poly
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}
+
+func crc8(p []byte) uint8 {
+	var c uint8
+	for _, b := range p {
+		c = crc8Table[c^b]
+	}
+	return c
+}
+
+func crc16(p []byte) uint16 {
+	var c uint16
+	for _, b := range p {
+		c = c<<8 ^ crc16Table[byte(c>>8)^b]
+	}
+	return c
+}
+
+// }}}
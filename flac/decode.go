@@ -0,0 +1,745 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package flac
+
+import (
+	"errors"
+	"io"
+
+	"github.com/goulash/audio"
+)
+
+var (
+	ErrChecksum    = errors.New("frame checksum mismatch")
+	ErrUnsupported = errors.New("stream feature unsupported")
+)
+
+// Decoder decodes the audio frames of a FLAC stream into PCM samples.
+type Decoder struct {
+	meta *Metadata
+	info *StreamInfo
+	br   *bitReader
+
+	frame Frame
+	pos   int // position of the next sample in frame for Read
+}
+
+// NewDecoder reads the stream marker and the metadata blocks from r and
+// returns a Decoder that is positioned at the first frame.
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	d := NewFrameDecoder(r, nil)
+	rr := d.br.r
+	if err := readStreamMarker(rr); err != nil {
+		return nil, &ParseError{Offset: 0, Block: -1, Index: -1, Err: err}
+	}
+	m, err := readMetadata(rr, LoadAll, false)
+	if err != nil {
+		return nil, err
+	}
+	d.br.bytes = m.bytes
+	d.meta, d.info = m, m.info
+	return d, nil
+}
+
+// NewFrameDecoder returns a Decoder that reads frames from r, which must be
+// positioned at the beginning of a frame, such as an offset returned by
+// Metadata.SeekSample. The stream info is used for values that frame
+// headers may leave out.
+func NewFrameDecoder(r io.Reader, si *StreamInfo) *Decoder {
+	return &Decoder{
+		info: si,
+		br:   newBitReader(r),
+	}
+}
+
+// Metadata returns the metadata that was read by NewDecoder, or nil
+// if the decoder was created by NewFrameDecoder.
+func (d *Decoder) Metadata() *Metadata { return d.meta }
+
+// StreamInfo returns the stream info that the decoder uses.
+func (d *Decoder) StreamInfo() *StreamInfo { return d.info }
+
+var _ = audio.Decoder(new(Decoder))
+
+// SampleRate returns the sample rate from the stream info, or 0 if the
+// decoder has no stream info.
+func (d *Decoder) SampleRate() int {
+	if d.info == nil {
+		return 0
+	}
+	return int(d.info.SampleRate)
+}
+
+// NumChannels returns the number of channels from the stream info, or 0 if
+// the decoder has no stream info.
+func (d *Decoder) NumChannels() int {
+	if d.info == nil {
+		return 0
+	}
+	return int(d.info.NumChannels)
+}
+
+// BitsPerSample returns the sample size from the stream info, or 0 if the
+// decoder has no stream info.
+func (d *Decoder) BitsPerSample() int {
+	if d.info == nil {
+		return 0
+	}
+	return int(d.info.BitsPerSample)
+}
+
+// ReadFrame reads and decodes the next frame. The returned frame is only
+// valid until the next call to ReadFrame or Read. At the end of the stream
+// io.EOF is returned.
+//
+// If the frame is complete but its CRC-16 does not match, the frame is
+// returned together with ErrChecksum, and decoding can continue with the
+// next frame.
+func (d *Decoder) ReadFrame() (*Frame, error) {
+	d.pos = 0
+	f := &d.frame
+	f.Channels = f.Channels[:0]
+	if err := d.readFrameHeader(&f.FrameHeader); err != nil {
+		return nil, err
+	}
+
+	nc := f.ChannelAssignment.NumChannels()
+	if cap(f.buf) < nc {
+		f.buf = make([][]int32, nc)
+	}
+	f.buf = f.buf[:nc]
+	for i := range f.buf {
+		if cap(f.buf[i]) < int(f.BlockSize) {
+			f.buf[i] = make([]int32, f.BlockSize)
+		}
+		bps := uint(f.BitsPerSample)
+		switch {
+		case f.ChannelAssignment == LeftSide && i == 1,
+			f.ChannelAssignment == SideRight && i == 0,
+			f.ChannelAssignment == MidSide && i == 1:
+			bps++ // the side channel needs one more bit
+		}
+		if bps > 32 {
+			return nil, ErrUnsupported
+		}
+		samples := f.buf[i][:f.BlockSize]
+		if err := d.readSubframe(samples, bps); err != nil {
+			return nil, err
+		}
+		f.Channels = append(f.Channels, samples)
+	}
+	decorrelate(f)
+
+	// Read frame footer
+	d.br.align()
+	crc := d.br.crc16
+	v, err := d.br.readBits(16)
+	if err != nil {
+		return nil, err
+	}
+	f.Size = d.br.offset() - f.Offset
+	if uint16(v) != crc {
+		return f, ErrChecksum
+	}
+	return f, nil
+}
+
+// Read reads interleaved PCM samples into p and returns the number of
+// samples read. At the end of the stream, io.EOF is returned.
+func (d *Decoder) Read(p []int32) (int, error) {
+	var n int
+	for n < len(p) {
+		f := &d.frame
+		if d.pos >= int(f.BlockSize)*len(f.Channels) {
+			if _, err := d.ReadFrame(); err != nil {
+				if n > 0 && err == io.EOF {
+					return n, nil
+				}
+				return n, err
+			}
+		}
+
+		nc := len(f.Channels)
+		for ; d.pos < int(f.BlockSize)*nc && n < len(p); d.pos++ {
+			p[n] = f.Channels[d.pos%nc][d.pos/nc]
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Frame {{{
+
+// Frame is a decoded audio frame.
+type Frame struct {
+	FrameHeader
+
+	// Channels contains the decoded samples of each channel.
+	Channels [][]int32
+
+	buf [][]int32
+}
+
+// Interleave appends the samples of all channels interleaved to dst
+// and returns the extended slice.
+func (f *Frame) Interleave(dst []int32) []int32 {
+	for i := 0; i < int(f.BlockSize); i++ {
+		for _, c := range f.Channels {
+			dst = append(dst, c[i])
+		}
+	}
+	return dst
+}
+
+// }}}
+
+// Frame Header {{{
+
+/*
+Encoding format
+
+BITS DESCRIPTION
+==== ============================================================================
+  14 Sync code '11111111111110'
+   1 Reserved: must be 0
+   1 Blocking strategy: 0 for fixed-blocksize, 1 for variable-blocksize stream.
+   4 Block size in inter-channel samples:
+       0000 : reserved
+       0001 : 192 samples
+       0010-0101 : 576 * (2^(n-2)) samples, i.e. 576/1152/2304/4608
+       0110 : get 8 bit (blocksize-1) from end of header
+       0111 : get 16 bit (blocksize-1) from end of header
+       1000-1111 : 256 * (2^(n-8)) samples, i.e. 256/512/1024/.../32768
+   4 Sample rate:
+       0000 : get from STREAMINFO metadata block
+       0001-1011 : 88.2kHz, 176.4kHz, 192kHz, 8kHz, 16kHz, 22.05kHz, 24kHz,
+                   32kHz, 44.1kHz, 48kHz, 96kHz
+       1100 : get 8 bit sample rate (in kHz) from end of header
+       1101 : get 16 bit sample rate (in Hz) from end of header
+       1110 : get 16 bit sample rate (in tens of Hz) from end of header
+       1111 : invalid
+   4 Channel assignment:
+       0000-0111 : (number of independent channels)-1.
+       1000 : left/side stereo
+       1001 : right/side stereo
+       1010 : mid/side stereo
+       1011-1111 : reserved
+   3 Sample size in bits:
+       000 : get from STREAMINFO metadata block
+       001-110 : 8, 12, reserved, 16, 20, 24 bits per sample
+       111 : 32 bits per sample
+   1 Reserved: must be 0
+   ? The frame number (fixed-blocksize) or the sample number (variable-
+     blocksize), coded like UTF-8 with up to 36 bits.
+   ? If blocksize bits are 011x, 8/16 bit (blocksize-1)
+   ? If sample rate bits are 11xx, 8/16 bit sample rate
+   8 CRC-8 (polynomial = x^8 + x^2 + x^1 + x^0, initialized with 0) of
+     everything before the crc, including the sync code
+==== ============================================================================
+*/
+func (d *Decoder) readFrameHeader(h *FrameHeader) error {
+	br := d.br
+	br.reset()
+	h.Offset = br.offset()
+
+	x, err := br.readBits(16)
+	if err != nil {
+		if err == ErrUnexpectedEOF && br.offset() == h.Offset {
+			return io.EOF
+		}
+		return err
+	}
+	if x>>1 != 0x7FFC {
+		return ErrInvalidStream
+	}
+	h.VariableBlockSize = x&1 != 0
+
+	x, err = br.readBits(16)
+	if err != nil {
+		return err
+	}
+	bsCode, srCode := x>>12, (x>>8)&0x0F
+	chCode, ssCode := x>>4&0x0F, (x>>1)&0x07
+	if x&1 != 0 || srCode == 0x0F || chCode > 0x0A || ssCode == 0x03 || bsCode == 0 {
+		return ErrInvalidStream
+	}
+
+	h.Number, err = readUTF8Number(br)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case bsCode == 1:
+		h.BlockSize = 192
+	case bsCode <= 5:
+		h.BlockSize = 576 << (bsCode - 2)
+	case bsCode == 6:
+		x, err = br.readBits(8)
+		h.BlockSize = uint16(x + 1)
+	case bsCode == 7:
+		x, err = br.readBits(16)
+		if x == 0xFFFF {
+			return ErrInvalidStream
+		}
+		h.BlockSize = uint16(x + 1)
+	default:
+		h.BlockSize = 256 << (bsCode - 8)
+	}
+	if err != nil {
+		return err
+	}
+
+	if (srCode == 0 || ssCode == 0) && d.info == nil {
+		return ErrInvalidStream
+	}
+	switch srCode {
+	case 0:
+		h.SampleRate = d.info.SampleRate
+	case 12:
+		x, err = br.readBits(8)
+		h.SampleRate = uint32(x) * 1000
+	case 13:
+		x, err = br.readBits(16)
+		h.SampleRate = uint32(x)
+	case 14:
+		x, err = br.readBits(16)
+		h.SampleRate = uint32(x) * 10
+	default:
+		h.SampleRate = sampleRates[srCode]
+	}
+	if err != nil {
+		return err
+	}
+
+	h.ChannelAssignment = ChannelAssignment(chCode)
+	if ssCode == 0 {
+		h.BitsPerSample = d.info.BitsPerSample
+	} else {
+		h.BitsPerSample = sampleSizes[ssCode]
+	}
+
+	crc := br.crc8
+	x, err = br.readBits(8)
+	if err != nil {
+		return err
+	}
+	if uint8(x) != crc {
+		return ErrChecksum
+	}
+
+	h.FirstSample = h.Number
+	if !h.VariableBlockSize {
+		bs := uint64(h.BlockSize)
+		if d.info != nil && d.info.MaxBlockSize != 0 {
+			bs = uint64(d.info.MaxBlockSize)
+		}
+		h.FirstSample = h.Number * bs
+	}
+	return nil
+}
+
+var sampleRates = [...]uint32{
+	0, 88200, 176400, 192000, 8000, 16000, 22050, 24000, 32000, 44100, 48000, 96000,
+}
+
+var sampleSizes = [...]uint8{0, 8, 12, 0, 16, 20, 24, 32}
+
+// readUTF8Number reads a number of up to 36 bits that is coded like UTF-8.
+func readUTF8Number(br *bitReader) (uint64, error) {
+	x, err := br.readBits(8)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	switch {
+	case x&0x80 == 0x00:
+		return x, nil
+	case x&0xE0 == 0xC0:
+		x, n = x&0x1F, 1
+	case x&0xF0 == 0xE0:
+		x, n = x&0x0F, 2
+	case x&0xF8 == 0xF0:
+		x, n = x&0x07, 3
+	case x&0xFC == 0xF8:
+		x, n = x&0x03, 4
+	case x&0xFE == 0xFC:
+		x, n = x&0x01, 5
+	case x == 0xFE:
+		x, n = 0, 6
+	default:
+		return 0, ErrInvalidStream
+	}
+	for i := 0; i < n; i++ {
+		c, err := br.readBits(8)
+		if err != nil {
+			return 0, err
+		}
+		if c&0xC0 != 0x80 {
+			return 0, ErrInvalidStream
+		}
+		x = x<<6 | c&0x3F
+	}
+	return x, nil
+}
+
+// FrameHeader contains the information from the header of a frame.
+type FrameHeader struct {
+	// VariableBlockSize is true if the stream has a variable block size,
+	// in which case Number is a sample number instead of a frame number.
+	VariableBlockSize bool
+
+	// BlockSize is the number of inter-channel samples in the frame.
+	BlockSize uint16
+
+	// SampleRate is the sample rate in Hz.
+	SampleRate uint32
+
+	// ChannelAssignment describes the number of channels and how they
+	// are stored.
+	ChannelAssignment ChannelAssignment
+
+	// BitsPerSample is the number of bits per sample.
+	BitsPerSample uint8
+
+	// Number is the frame number for fixed-block-size streams and the
+	// number of the first sample for variable-block-size streams.
+	Number uint64
+
+	// FirstSample is the number of the first sample in the frame.
+	FirstSample uint64
+
+	// Offset is the position of the frame in bytes, relative to the
+	// beginning of the stream given to the decoder, and Size is the
+	// length of the frame in bytes.
+	Offset int64
+	Size   int64
+}
+
+// ChannelAssignment describes the number of channels and how they are
+// stored in a frame.
+type ChannelAssignment uint8
+
+const (
+	LeftSide  ChannelAssignment = 8  // Left and side channel
+	SideRight ChannelAssignment = 9  // Side and right channel
+	MidSide   ChannelAssignment = 10 // Mid and side channel
+)
+
+// NumChannels returns the number of channels.
+func (c ChannelAssignment) NumChannels() int {
+	if c < LeftSide {
+		return int(c) + 1
+	}
+	return 2
+}
+
+func (c ChannelAssignment) String() string {
+	switch c {
+	case LeftSide:
+		return "left/side"
+	case SideRight:
+		return "side/right"
+	case MidSide:
+		return "mid/side"
+	default:
+		return "independent"
+	}
+}
+
+// decorrelate restores the left and right channels of a stereo frame.
+func decorrelate(f *Frame) {
+	if f.ChannelAssignment < LeftSide {
+		return
+	}
+	a, b := f.Channels[0], f.Channels[1]
+	switch f.ChannelAssignment {
+	case LeftSide:
+		for i := range a {
+			b[i] = a[i] - b[i]
+		}
+	case SideRight:
+		for i := range a {
+			a[i] += b[i]
+		}
+	case MidSide:
+		for i := range a {
+			mid := int64(a[i])<<1 | int64(b[i])&1
+			side := int64(b[i])
+			a[i] = int32((mid + side) >> 1)
+			b[i] = int32((mid - side) >> 1)
+		}
+	}
+}
+
+// }}}
+
+// Subframe {{{
+
+/*
+Encoding format
+
+BITS DESCRIPTION
+==== ============================================================================
+   1 Zero bit padding, to prevent sync-fooling string of 1s
+   6 Subframe type:
+       000000 : SUBFRAME_CONSTANT
+       000001 : SUBFRAME_VERBATIM
+       00001x : reserved
+       0001xx : reserved
+       001xxx : if(xxx <= 4) SUBFRAME_FIXED, xxx=order ; else reserved
+       01xxxx : reserved
+       1xxxxx : SUBFRAME_LPC, xxxxx=order-1
+ 1+k 'Wasted bits-per-sample' flag:
+       0 : no wasted bits-per-sample in source subblock, k=0
+       1 : k wasted bits-per-sample in source subblock, k-1 follows, unary
+           coded; e.g. k=3 => 001 follows, k=7 => 0000001 follows.
+==== ============================================================================
+
+SUBFRAME_CONSTANT is a single unencoded sample value, SUBFRAME_VERBATIM
+contains all samples unencoded. SUBFRAME_FIXED contains the unencoded warm-up
+samples followed by the residual; SUBFRAME_LPC additionally contains the
+quantized linear predictor coefficients:
+
+BITS DESCRIPTION
+==== ============================================================================
+ n*o Unencoded warm-up samples (o = predictor order).
+   4 (Quantized linear predictor coefficients' precision in bits)-1
+     (1111 = invalid).
+   5 Quantized linear predictor coefficient shift needed in bits (NOTE: this
+     number is signed two's-complement).
+ p*o Unencoded predictor coefficients (p = precision, o = order).
+==== ============================================================================
+*/
+func (d *Decoder) readSubframe(samples []int32, bps uint) error {
+	br := d.br
+	x, err := br.readBits(8)
+	if err != nil {
+		return err
+	}
+	if x&0x80 != 0 {
+		return ErrInvalidStream
+	}
+	kind := (x >> 1) & 0x3F
+
+	var wasted uint
+	if x&1 != 0 {
+		k, err := br.readUnary()
+		if err != nil {
+			return err
+		}
+		wasted = uint(k) + 1
+		if wasted >= bps {
+			return ErrInvalidStream
+		}
+		bps -= wasted
+	}
+
+	switch {
+	case kind == 0x00:
+		err = d.readConstant(samples, bps)
+	case kind == 0x01:
+		err = d.readVerbatim(samples, bps)
+	case kind&0x38 == 0x08 && kind&0x07 <= 4:
+		err = d.readFixed(samples, bps, int(kind&0x07))
+	case kind&0x20 != 0:
+		err = d.readLPC(samples, bps, int(kind&0x1F)+1)
+	default:
+		err = ErrInvalidStream
+	}
+	if err != nil {
+		return err
+	}
+
+	if wasted > 0 {
+		for i := range samples {
+			samples[i] <<= wasted
+		}
+	}
+	return nil
+}
+
+func (d *Decoder) readConstant(samples []int32, bps uint) error {
+	v, err := d.br.readSigned(bps)
+	if err != nil {
+		return err
+	}
+	for i := range samples {
+		samples[i] = int32(v)
+	}
+	return nil
+}
+
+func (d *Decoder) readVerbatim(samples []int32, bps uint) error {
+	for i := range samples {
+		v, err := d.br.readSigned(bps)
+		if err != nil {
+			return err
+		}
+		samples[i] = int32(v)
+	}
+	return nil
+}
+
+// fixedCoefficients contains the coefficients of the fixed predictors,
+// which can be expressed as linear predictors with a shift of zero.
+var fixedCoefficients = [...][]int32{
+	{},
+	{1},
+	{2, -1},
+	{3, -3, 1},
+	{4, -6, 4, -1},
+}
+
+func (d *Decoder) readFixed(samples []int32, bps uint, order int) error {
+	if order > len(samples) {
+		return ErrInvalidStream
+	}
+	if err := d.readVerbatim(samples[:order], bps); err != nil {
+		return err
+	}
+	if err := d.readResidual(samples, order); err != nil {
+		return err
+	}
+	predict(samples, fixedCoefficients[order], 0)
+	return nil
+}
+
+func (d *Decoder) readLPC(samples []int32, bps uint, order int) error {
+	br := d.br
+	if order > len(samples) {
+		return ErrInvalidStream
+	}
+	if err := d.readVerbatim(samples[:order], bps); err != nil {
+		return err
+	}
+
+	x, err := br.readBits(4)
+	if err != nil {
+		return err
+	}
+	if x == 0x0F {
+		return ErrInvalidStream
+	}
+	prec := uint(x) + 1
+	shift, err := br.readSigned(5)
+	if err != nil {
+		return err
+	}
+	if shift < 0 {
+		return ErrInvalidStream
+	}
+	coeffs := make([]int32, order)
+	for i := range coeffs {
+		c, err := br.readSigned(prec)
+		if err != nil {
+			return err
+		}
+		coeffs[i] = int32(c)
+	}
+
+	if err := d.readResidual(samples, order); err != nil {
+		return err
+	}
+	predict(samples, coeffs, uint(shift))
+	return nil
+}
+
+// predict adds the prediction to the residual in samples[order:], where
+// coeffs[j] is the coefficient for the sample j+1 positions back.
+func predict(samples []int32, coeffs []int32, shift uint) {
+	order := len(coeffs)
+	for i := order; i < len(samples); i++ {
+		var sum int64
+		for j, c := range coeffs {
+			sum += int64(c) * int64(samples[i-j-1])
+		}
+		samples[i] += int32(sum >> shift)
+	}
+}
+
+// }}}
+
+// Residual {{{
+
+/*
+Encoding format
+
+BITS DESCRIPTION
+==== ============================================================================
+   2 Residual coding method:
+       00 : partitioned Rice coding with 4-bit Rice parameter
+       01 : partitioned Rice coding with 5-bit Rice parameter
+       1x : reserved
+   4 Partition order.
+   * There will be 2^order partitions. For each partition:
+   4 or 5 Encoding parameter; 1111 or 11111 is the escape code, in which case
+     5 bits follow that give the number of bits per unencoded sample.
+   ? Encoded residual. The number of samples (n) in the partition is
+     determined as follows:
+       - if the partition order is zero, n = frame's blocksize - predictor order
+       - else if this is not the first partition of the subframe, n =
+         (frame's blocksize / (2^partition order))
+       - else n = (frame's blocksize / (2^partition order)) - predictor order
+==== ============================================================================
+*/
+func (d *Decoder) readResidual(samples []int32, order int) error {
+	br := d.br
+	x, err := br.readBits(6)
+	if err != nil {
+		return err
+	}
+	method, porder := x>>4, uint(x&0x0F)
+	if method > 1 {
+		return ErrInvalidStream
+	}
+	pbits, escape := uint(4), uint64(0x0F)
+	if method == 1 {
+		pbits, escape = 5, 0x1F
+	}
+
+	n := len(samples) >> porder
+	if n<<porder != len(samples) || n < order {
+		return ErrInvalidStream
+	}
+	i := order
+	for p := 0; p < 1<<porder; p++ {
+		end := (p + 1) * n
+		k, err := br.readBits(pbits)
+		if err != nil {
+			return err
+		}
+		if k == escape {
+			z, err := br.readBits(5)
+			if err != nil {
+				return err
+			}
+			for ; i < end; i++ {
+				v, err := br.readSigned(uint(z))
+				if err != nil {
+					return err
+				}
+				samples[i] = int32(v)
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			q, err := br.readUnary()
+			if err != nil {
+				return err
+			}
+			r, err := br.readBits(uint(k))
+			if err != nil {
+				return err
+			}
+			u := q<<k | r
+			samples[i] = int32(u>>1) ^ -int32(u&1)
+		}
+	}
+	return nil
+}
+
+// }}}
@@ -0,0 +1,151 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package flac
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/goulash/audio"
+	"github.com/stretchr/testify/assert"
+)
+
+// readTestWAV returns the samples of the 16-bit test WAV file, which
+// contains the same audio as the test FLAC file.
+func readTestWAV() ([]int32, error) {
+	data, err := ioutil.ReadFile(testWAV)
+	if err != nil {
+		return nil, err
+	}
+	data = data[44:]
+	samples := make([]int32, len(data)/2)
+	for i := range samples {
+		samples[i] = int32(int16(binary.LittleEndian.Uint16(data[2*i:])))
+	}
+	return samples, nil
+}
+
+func TestDecoder(z *testing.T) {
+	assert := assert.New(z)
+	want, err := readTestWAV()
+	if !assert.Nil(err) {
+		return
+	}
+
+	f, err := os.Open(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+	defer f.Close()
+	d, err := NewDecoder(f)
+	if !assert.Nil(err) {
+		return
+	}
+	si := d.StreamInfo()
+
+	var got []int32
+	h := md5.New()
+	buf := make([]byte, 2)
+	for {
+		fr, err := d.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if !assert.Nil(err) {
+			return
+		}
+		assert.Equal(uint64(len(got)/2), fr.FirstSample)
+		assert.Equal(si.SampleRate, fr.SampleRate)
+		assert.Equal(si.BitsPerSample, fr.BitsPerSample)
+		got = fr.Interleave(got)
+		for i := 0; i < int(fr.BlockSize); i++ {
+			for _, c := range fr.Channels {
+				binary.LittleEndian.PutUint16(buf, uint16(c[i]))
+				h.Write(buf)
+			}
+		}
+	}
+	assert.Equal(int(si.TotalSamples)*int(si.NumChannels), len(got))
+	assert.Equal(want, got)
+	assert.Equal(si.MD5Sum, h.Sum(nil))
+}
+
+func TestDecoderRead(z *testing.T) {
+	assert := assert.New(z)
+	want, err := readTestWAV()
+	if !assert.Nil(err) {
+		return
+	}
+
+	f, err := os.Open(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+	defer f.Close()
+	m, err := ReadMetadata(f)
+	if !assert.Nil(err) {
+		return
+	}
+
+	// Start decoding at the seek point, with an odd buffer size
+	off, first := m.SeekSample(10000)
+	_, err = f.Seek(off, io.SeekStart)
+	if !assert.Nil(err) {
+		return
+	}
+	d := NewFrameDecoder(f, m.StreamInfo())
+	var got []int32
+	buf := make([]int32, 999)
+	for {
+		n, err := d.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if !assert.Nil(err) {
+			return
+		}
+	}
+	assert.Equal(want[first*2:], got)
+}
+
+func TestAudioNewDecoder(z *testing.T) {
+	assert := assert.New(z)
+	want, err := readTestWAV()
+	if !assert.Nil(err) {
+		return
+	}
+
+	f, err := os.Open(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+	defer f.Close()
+	d, err := audio.NewDecoder(f)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(44100, d.SampleRate())
+	assert.Equal(2, d.NumChannels())
+	assert.Equal(16, d.BitsPerSample())
+
+	var got []int32
+	buf := make([]int32, 4096)
+	for {
+		n, err := d.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if !assert.Nil(err) {
+			return
+		}
+	}
+	assert.Equal(want, got)
+}
@@ -0,0 +1,920 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package flac
+
+import (
+	"bufio"
+	"crypto/md5"
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"math/bits"
+)
+
+var (
+	ErrSampleRange   = errors.New("sample out of range")
+	ErrInvalidFormat = errors.New("invalid audio format")
+	ErrClosed        = errors.New("encoder closed")
+)
+
+// EncoderOptions configures an Encoder. The zero value of each field is
+// valid, but DefaultEncoderOptions is what the reference encoder does
+// by default.
+type EncoderOptions struct {
+	// Level is the compression level from 0 (fastest) to 8 (smallest), with
+	// roughly the same meaning as for the reference encoder. Levels 0 to 2
+	// only use fixed predictors, levels 3 and up also use linear prediction,
+	// and levels 7 and 8 search exhaustively for the best predictor order.
+	Level int
+
+	// BlockSize is the number of inter-channel samples per frame, from 16
+	// to 65535. If it is zero, the default block size of the level is used.
+	BlockSize int
+
+	// SeekPoints is the number of seek points to reserve in the seek table.
+	// They are spread evenly over the stream when the encoder is closed.
+	// If it is zero, no seek table is written.
+	SeekPoints int
+
+	// Padding is the size of the padding block in bytes. If it is zero,
+	// no padding block is written.
+	Padding int
+
+	// Metadata contains the metadata blocks that should be written, such
+	// as Vorbis comments, pictures, and a cue sheet. The stream info and
+	// seek table of Metadata are ignored.
+	Metadata *Metadata
+}
+
+// DefaultEncoderOptions is used if NewEncoder is given nil options.
+var DefaultEncoderOptions = EncoderOptions{
+	Level:      5,
+	SeekPoints: 100,
+	Padding:    DefaultPadding,
+}
+
+// encoderLevel contains the settings of a compression level.
+type encoderLevel struct {
+	blockSize   int
+	stereo      bool // try stereo decorrelation
+	maxLPCOrder int  // maximum LPC order, 0 means only fixed predictors
+	maxPorder   uint // maximum Rice partition order
+	exhaustive  bool // try every LPC order instead of estimating the best
+}
+
+var encoderLevels = [...]encoderLevel{
+	{1152, false, 0, 3, false},
+	{1152, true, 0, 3, false},
+	{1152, true, 0, 3, false},
+	{4096, false, 6, 4, false},
+	{4096, true, 8, 4, false},
+	{4096, true, 8, 5, false},
+	{4096, true, 8, 6, false},
+	{4096, true, 12, 6, true},
+	{4096, true, 12, 8, true},
+}
+
+// Encoder encodes PCM samples into a FLAC stream.
+type Encoder struct {
+	w     io.WriteSeeker
+	bw    *bufio.Writer
+	start int64 // position of the stream in w
+	meta  Metadata
+	info  StreamInfo
+	lvl   encoderLevel
+
+	pad    int
+	offset int64 // number of bytes written after the metadata blocks
+	frames []SeekPoint
+
+	md5 hash.Hash
+	tmp []byte // scratch space for the MD5 sum
+
+	block [][]int32 // samples of the current block per channel
+	pos   int       // number of interleaved samples in block
+	min   int32     // minimum sample value
+	max   int32     // maximum sample value
+
+	fw     bitWriter
+	window []float64
+	closed bool
+}
+
+// NewEncoder writes the stream marker and metadata blocks to w at its
+// current position and returns an Encoder that writes audio frames after
+// them. Only the SampleRate,
+// NumChannels and BitsPerSample fields of si are used; the other fields are
+// filled in when the encoder is closed, after which the metadata blocks are
+// written again. If opts is nil, DefaultEncoderOptions is used.
+func NewEncoder(w io.WriteSeeker, si *StreamInfo, opts *EncoderOptions) (*Encoder, error) {
+	if opts == nil {
+		opts = &DefaultEncoderOptions
+	}
+	if si.SampleRate == 0 || si.SampleRate > 655350 ||
+		si.NumChannels < 1 || si.NumChannels > 8 ||
+		si.BitsPerSample < 4 || si.BitsPerSample > 32 {
+		return nil, ErrInvalidFormat
+	}
+	if opts.Level < 0 || opts.Level >= len(encoderLevels) {
+		return nil, ErrInvalidFormat
+	}
+	lvl := encoderLevels[opts.Level]
+	if opts.BlockSize != 0 {
+		if opts.BlockSize < 16 || opts.BlockSize > 65535 {
+			return nil, ErrInvalidFormat
+		}
+		lvl.blockSize = opts.BlockSize
+	}
+
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	e := &Encoder{
+		w:     w,
+		bw:    bufio.NewWriter(w),
+		start: start,
+		lvl:   lvl,
+		pad:   opts.Padding,
+		md5:   md5.New(),
+		tmp:   make([]byte, 0, 4*lvl.blockSize*int(si.NumChannels)),
+		min:   -1 << (si.BitsPerSample - 1),
+		max:   1<<(si.BitsPerSample-1) - 1,
+		info: StreamInfo{
+			MinBlockSize:  uint16(lvl.blockSize),
+			MaxBlockSize:  uint16(lvl.blockSize),
+			SampleRate:    si.SampleRate,
+			NumChannels:   si.NumChannels,
+			BitsPerSample: si.BitsPerSample,
+		},
+	}
+	if opts.Metadata != nil {
+		e.meta = *opts.Metadata
+	}
+	e.meta.info = &e.info
+	e.meta.seek = make(SeekTable, opts.SeekPoints)
+	for i := range e.meta.seek {
+		e.meta.seek[i].SampleNumber = placeholderPoint
+	}
+	e.block = make([][]int32, si.NumChannels)
+	for i := range e.block {
+		e.block[i] = make([]int32, lvl.blockSize)
+	}
+
+	if err := e.writeHeader(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// writeHeader writes the stream marker and the metadata blocks.
+func (e *Encoder) writeHeader() error {
+	buf, err := encodeMetadata(&e.meta)
+	if err != nil {
+		return err
+	}
+	if e.pad > 0 {
+		buf = appendPaddingBlock(buf, e.pad)
+	}
+	_, err = e.bw.Write(markLastBlock(buf))
+	return err
+}
+
+// Write encodes the interleaved samples in p, which must fit into the bits
+// per sample of the stream. Samples are buffered until a block is complete.
+func (e *Encoder) Write(p []int32) (int, error) {
+	if e.closed {
+		return 0, ErrClosed
+	}
+	nc := len(e.block)
+	bs := e.lvl.blockSize
+	for i, v := range p {
+		if v < e.min || v > e.max {
+			return i, ErrSampleRange
+		}
+		e.block[e.pos%nc][e.pos/nc] = v
+		e.pos++
+		if e.pos == bs*nc {
+			if err := e.writeFrame(bs); err != nil {
+				return i, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Close writes any buffered samples and then writes the metadata blocks
+// again, now with the complete stream info and seek table. It does not
+// close the underlying writer. An incomplete inter-channel sample at the
+// end of the stream is discarded.
+func (e *Encoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	if n := e.pos / len(e.block); n > 0 {
+		if err := e.writeFrame(n); err != nil {
+			return err
+		}
+	}
+	if err := e.bw.Flush(); err != nil {
+		return err
+	}
+
+	e.info.MD5Sum = e.md5.Sum(nil)
+	e.fillSeekTable()
+	end, err := e.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := e.w.Seek(e.start, io.SeekStart); err != nil {
+		return err
+	}
+	e.bw.Reset(e.w)
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	if err := e.bw.Flush(); err != nil {
+		return err
+	}
+	_, err = e.w.Seek(end, io.SeekStart)
+	return err
+}
+
+// fillSeekTable spreads the seek points evenly over the stream. Seek points
+// that would point to the same frame remain placeholders.
+func (e *Encoder) fillSeekTable() {
+	st := e.meta.seek
+	if len(st) == 0 || len(e.frames) == 0 {
+		return
+	}
+	var (
+		i, j int
+		last = -1
+	)
+	for k := range st {
+		target := e.info.TotalSamples * uint64(k) / uint64(len(st))
+		for j+1 < len(e.frames) && e.frames[j+1].SampleNumber <= target {
+			j++
+		}
+		if j != last {
+			st[i] = e.frames[j]
+			i++
+			last = j
+		}
+	}
+}
+
+// writeFrame encodes the first n samples of each channel in the block
+// and writes the frame.
+func (e *Encoder) writeFrame(n int) error {
+	nc := len(e.block)
+	bps := uint(e.info.BitsPerSample)
+	chs := make([][]int32, nc)
+	for i := range chs {
+		chs[i] = e.block[i][:n]
+	}
+	e.updateMD5(chs, n)
+
+	// Choose the channel assignment and encode the subframes
+	fw := &e.fw
+	fw.reset()
+	assignment := ChannelAssignment(nc - 1)
+	var subframes []*subframe
+	if nc == 2 && e.lvl.stereo && bps < 32 {
+		assignment, subframes = e.encodeStereo(chs[0], chs[1], bps)
+	} else {
+		for _, c := range chs {
+			subframes = append(subframes, e.encodeSubframe(c, bps))
+		}
+	}
+
+	writeFrameHeader(fw, &e.info, uint64(len(e.frames)), n, assignment)
+	for _, sf := range subframes {
+		sf.write(fw)
+	}
+	fw.align()
+	crc := crc16(fw.buf)
+	fw.writeBits(uint64(crc), 16)
+
+	if _, err := e.bw.Write(fw.buf); err != nil {
+		return err
+	}
+	size := uint32(len(fw.buf))
+	if e.info.MinFrameSize == 0 || size < e.info.MinFrameSize {
+		e.info.MinFrameSize = size
+	}
+	if size > e.info.MaxFrameSize {
+		e.info.MaxFrameSize = size
+	}
+	e.frames = append(e.frames, SeekPoint{
+		SampleNumber: e.info.TotalSamples,
+		Offset:       uint64(e.offset),
+		FrameSamples: uint16(n),
+	})
+	e.offset += int64(size)
+	e.info.TotalSamples += uint64(n)
+	e.pos = 0
+	return nil
+}
+
+// updateMD5 adds the samples to the MD5 sum of the stream.
+func (e *Encoder) updateMD5(chs [][]int32, n int) {
+	e.tmp = appendPCM(e.tmp[:0], chs, n, e.info.BitsPerSample)
+	e.md5.Write(e.tmp)
+}
+
+// appendPCM appends the first n samples of the channels to p as interleaved
+// little-endian signed integers of the smallest number of bytes that fits
+// the bits per sample. This is the format that the MD5 sum of the stream
+// info is calculated over.
+func appendPCM(p []byte, chs [][]int32, n int, bps uint8) []byte {
+	width := (int(bps) + 7) / 8
+	for i := 0; i < n; i++ {
+		for _, c := range chs {
+			v := c[i]
+			for k := 0; k < width; k++ {
+				p = append(p, byte(v>>(8*uint(k))))
+			}
+		}
+	}
+	return p
+}
+
+// Frame Header {{{
+
+// writeFrameHeader writes the header of a frame in a fixed-block-size
+// stream; see readFrameHeader for the format.
+func writeFrameHeader(fw *bitWriter, si *StreamInfo, number uint64, blockSize int, ca ChannelAssignment) {
+	fw.writeBits(0xFFF8, 16)
+
+	var bsCode, bsExtra, bsBits = uint64(0), uint64(0), uint(0)
+	switch {
+	case blockSize == 192:
+		bsCode = 1
+	case blockSize%576 == 0 && isPow2(blockSize/576) && blockSize <= 4608:
+		bsCode = 2 + uint64(bits.TrailingZeros(uint(blockSize/576)))
+	case blockSize%256 == 0 && isPow2(blockSize/256) && blockSize <= 32768:
+		bsCode = 8 + uint64(bits.TrailingZeros(uint(blockSize/256)))
+	case blockSize <= 256:
+		bsCode, bsExtra, bsBits = 6, uint64(blockSize-1), 8
+	default:
+		bsCode, bsExtra, bsBits = 7, uint64(blockSize-1), 16
+	}
+
+	var srCode, srExtra, srBits = uint64(0), uint64(0), uint(0)
+	sr := si.SampleRate
+	for i, v := range sampleRates {
+		if i > 0 && v == sr {
+			srCode = uint64(i)
+		}
+	}
+	if srCode == 0 {
+		switch {
+		case sr%1000 == 0 && sr/1000 < 256:
+			srCode, srExtra, srBits = 12, uint64(sr/1000), 8
+		case sr < 65536:
+			srCode, srExtra, srBits = 13, uint64(sr), 16
+		case sr%10 == 0 && sr/10 < 65536:
+			srCode, srExtra, srBits = 14, uint64(sr/10), 16
+		}
+	}
+
+	var ssCode uint64
+	for i, v := range sampleSizes {
+		if i > 0 && v == si.BitsPerSample {
+			ssCode = uint64(i)
+		}
+	}
+
+	fw.writeBits(bsCode<<4|srCode, 8)
+	fw.writeBits(uint64(ca)<<4|ssCode<<1, 8)
+	writeUTF8Number(fw, number)
+	fw.writeBits(bsExtra, bsBits)
+	fw.writeBits(srExtra, srBits)
+	fw.writeBits(uint64(crc8(fw.buf)), 8)
+}
+
+func isPow2(n int) bool { return n > 0 && n&(n-1) == 0 }
+
+// writeUTF8Number writes a number of up to 36 bits coded like UTF-8.
+func writeUTF8Number(fw *bitWriter, x uint64) {
+	if x < 0x80 {
+		fw.writeBits(x, 8)
+		return
+	}
+	n := 1 // number of continuation bytes
+	for x >= 1<<(5*uint(n)+6) {
+		n++
+	}
+	lead := uint64(0xFF00>>uint(n+1)) & 0xFF
+	fw.writeBits(lead|x>>(6*uint(n)), 8)
+	for i := n - 1; i >= 0; i-- {
+		fw.writeBits(0x80|(x>>(6*uint(i)))&0x3F, 8)
+	}
+}
+
+// }}}
+
+// Subframe {{{
+
+// subframe is an encoded subframe, which can be compared with other
+// encodings of the same samples before it is written.
+type subframe struct {
+	kind    int // subframe type: constant, verbatim, fixed, or lpc
+	bps     uint
+	wasted  uint
+	samples []int32 // samples with the wasted bits removed
+	order   int
+	prec    uint
+	shift   int
+	coeffs  []int32
+	res     *residual
+	bits    int // size of the subframe in bits
+}
+
+const (
+	constantSubframe = iota
+	verbatimSubframe
+	fixedSubframe
+	lpcSubframe
+)
+
+// encodeStereo tries all stereo channel assignments and returns the one
+// that results in the smallest subframes.
+func (e *Encoder) encodeStereo(left, right []int32, bps uint) (ChannelAssignment, []*subframe) {
+	n := len(left)
+	mid, side := make([]int32, n), make([]int32, n)
+	for i := range left {
+		mid[i] = int32((int64(left[i]) + int64(right[i])) >> 1)
+		side[i] = left[i] - right[i]
+	}
+	l := e.encodeSubframe(left, bps)
+	r := e.encodeSubframe(right, bps)
+	m := e.encodeSubframe(mid, bps)
+	s := e.encodeSubframe(side, bps+1)
+
+	ca, a, b := ChannelAssignment(1), l, r
+	if l.bits+s.bits < a.bits+b.bits {
+		ca, a, b = LeftSide, l, s
+	}
+	if s.bits+r.bits < a.bits+b.bits {
+		ca, a, b = SideRight, s, r
+	}
+	if m.bits+s.bits < a.bits+b.bits {
+		ca, a, b = MidSide, m, s
+	}
+	return ca, []*subframe{a, b}
+}
+
+// encodeSubframe returns the smallest encoding of the samples.
+func (e *Encoder) encodeSubframe(samples []int32, bps uint) *subframe {
+	var or int32
+	for _, v := range samples {
+		or |= v
+	}
+	constant := true
+	for _, v := range samples[1:] {
+		if v != samples[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		return &subframe{kind: constantSubframe, bps: bps, samples: samples, bits: 8 + int(bps)}
+	}
+
+	// Remove wasted bits, which are zero in all samples
+	var wasted uint
+	if or != 0 {
+		wasted = uint(bits.TrailingZeros32(uint32(or)))
+	}
+	if wasted > 0 {
+		shifted := make([]int32, len(samples))
+		for i, v := range samples {
+			shifted[i] = v >> wasted
+		}
+		samples = shifted
+		bps -= wasted
+	}
+
+	best := &subframe{
+		kind:    verbatimSubframe,
+		bps:     bps,
+		samples: samples,
+		bits:    len(samples) * int(bps),
+	}
+	consider := func(sf *subframe) {
+		if sf != nil && sf.bits < best.bits {
+			best = sf
+		}
+	}
+	for order := 0; order <= 4 && order < len(samples); order++ {
+		consider(e.encodePredicted(samples, bps, fixedSubframe, fixedCoefficients[order], 0, 0))
+	}
+	if e.lvl.maxLPCOrder > 0 {
+		consider(e.encodeLPC(samples, bps))
+	}
+
+	best.wasted = wasted
+	best.bits += 8 + int(wasted)
+	return best
+}
+
+// encodePredicted computes the residual of the predictor and returns the
+// subframe, or nil if the residual does not fit into 32 bits.
+func (e *Encoder) encodePredicted(samples []int32, bps uint, kind int, coeffs []int32, prec uint, shift int) *subframe {
+	order := len(coeffs)
+	res := make([]int32, len(samples))
+	for i := order; i < len(samples); i++ {
+		var sum int64
+		for j, c := range coeffs {
+			sum += int64(c) * int64(samples[i-j-1])
+		}
+		r := int64(samples[i]) - sum>>uint(shift)
+		if r < math.MinInt32 || r > math.MaxInt32 {
+			return nil
+		}
+		res[i] = int32(r)
+	}
+
+	rc := encodeResidual(res, order, e.lvl.maxPorder)
+	sf := &subframe{
+		kind:    kind,
+		bps:     bps,
+		samples: samples,
+		order:   order,
+		prec:    prec,
+		shift:   shift,
+		coeffs:  coeffs,
+		res:     rc,
+		bits:    order*int(bps) + rc.bits,
+	}
+	if kind == lpcSubframe {
+		sf.bits += 4 + 5 + order*int(prec)
+	}
+	return sf
+}
+
+// encodeLPC computes the linear predictor coefficients and returns the
+// smallest LPC subframe, or nil if linear prediction is not possible.
+func (e *Encoder) encodeLPC(samples []int32, bps uint) *subframe {
+	n := len(samples)
+	maxOrder := e.lvl.maxLPCOrder
+	if maxOrder >= n {
+		maxOrder = n - 1
+	}
+	if maxOrder < 1 {
+		return nil
+	}
+
+	if len(e.window) != n {
+		e.window = tukeyWindow(n, 0.5)
+	}
+	x := make([]float64, n)
+	for i, v := range samples {
+		x[i] = float64(v) * e.window[i]
+	}
+	lpcs, errs := levinsonDurbin(autocorrelation(x, maxOrder), maxOrder)
+	if len(lpcs) == 0 {
+		return nil
+	}
+	prec := lpcPrecision(bps, n)
+
+	orders := make([]int, 0, len(lpcs))
+	if e.lvl.exhaustive {
+		for i := range lpcs {
+			orders = append(orders, i+1)
+		}
+	} else {
+		// Estimate the number of bits for each order from the prediction
+		// error, and only try the best one.
+		best, bestBits := 0, math.Inf(1)
+		for i, err := range errs {
+			order := i + 1
+			est := float64(order) * float64(int(bps)+int(prec))
+			if err > 0 {
+				bpr := 0.5 * math.Log2(err*0.5/float64(n))
+				if bpr > 0 {
+					est += bpr * float64(n-order)
+				}
+			}
+			if est < bestBits {
+				best, bestBits = order, est
+			}
+		}
+		orders = append(orders, best)
+	}
+
+	var best *subframe
+	for _, order := range orders {
+		coeffs, shift, ok := quantizeCoefficients(lpcs[order-1], prec)
+		if !ok {
+			continue
+		}
+		sf := e.encodePredicted(samples, bps, lpcSubframe, coeffs, prec, shift)
+		if sf != nil && (best == nil || sf.bits < best.bits) {
+			best = sf
+		}
+	}
+	return best
+}
+
+// write writes the subframe; see readSubframe for the format.
+func (sf *subframe) write(fw *bitWriter) {
+	var kind uint64
+	switch sf.kind {
+	case constantSubframe:
+		kind = 0x00
+	case verbatimSubframe:
+		kind = 0x01
+	case fixedSubframe:
+		kind = 0x08 | uint64(sf.order)
+	case lpcSubframe:
+		kind = 0x20 | uint64(sf.order-1)
+	}
+	fw.writeBits(kind, 7) // zero bit and type
+	if sf.wasted > 0 {
+		fw.writeBits(1, 1)
+		fw.writeUnary(uint64(sf.wasted - 1))
+	} else {
+		fw.writeBits(0, 1)
+	}
+
+	switch sf.kind {
+	case constantSubframe:
+		fw.writeBits(uint64(sf.samples[0]), sf.bps)
+		return
+	case verbatimSubframe:
+		for _, v := range sf.samples {
+			fw.writeBits(uint64(v), sf.bps)
+		}
+		return
+	}
+
+	for _, v := range sf.samples[:sf.order] {
+		fw.writeBits(uint64(v), sf.bps)
+	}
+	if sf.kind == lpcSubframe {
+		fw.writeBits(uint64(sf.prec-1), 4)
+		fw.writeBits(uint64(sf.shift), 5)
+		for _, c := range sf.coeffs {
+			fw.writeBits(uint64(c), sf.prec)
+		}
+	}
+	sf.res.write(fw)
+}
+
+// }}}
+
+// Linear Prediction {{{
+
+// tukeyWindow returns a Tukey window of length n, where p is the fraction of
+// the window that is tapered.
+func tukeyWindow(n int, p float64) []float64 {
+	w := make([]float64, n)
+	taper := int(p / 2 * float64(n))
+	for i := range w {
+		switch {
+		case i < taper:
+			w[i] = 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(taper))
+		case i >= n-taper:
+			w[i] = 0.5 - 0.5*math.Cos(math.Pi*float64(n-1-i)/float64(taper))
+		default:
+			w[i] = 1
+		}
+	}
+	return w
+}
+
+// autocorrelation returns the autocorrelation of x for lags 0 to maxLag.
+func autocorrelation(x []float64, maxLag int) []float64 {
+	r := make([]float64, maxLag+1)
+	for lag := range r {
+		var sum float64
+		for i := lag; i < len(x); i++ {
+			sum += x[i] * x[i-lag]
+		}
+		r[lag] = sum
+	}
+	return r
+}
+
+// levinsonDurbin computes the linear predictor coefficients for every order
+// up to maxOrder from the autocorrelation r, together with the prediction
+// error of each order. The coefficient lpcs[o-1][j] of order o is for the
+// sample j+1 positions back.
+func levinsonDurbin(r []float64, maxOrder int) (lpcs [][]float64, errs []float64) {
+	if r[0] == 0 {
+		return nil, nil
+	}
+	err := r[0]
+	var a []float64
+	for m := 1; m <= maxOrder; m++ {
+		acc := r[m]
+		for j := 0; j < m-1; j++ {
+			acc -= a[j] * r[m-1-j]
+		}
+		k := acc / err
+
+		next := make([]float64, m)
+		for j := 0; j < m-1; j++ {
+			next[j] = a[j] - k*a[m-2-j]
+		}
+		next[m-1] = k
+		a = next
+		err *= 1 - k*k
+		if err <= 0 || math.IsNaN(err) {
+			break
+		}
+
+		lpcs = append(lpcs, a)
+		errs = append(errs, err)
+	}
+	return lpcs, errs
+}
+
+// lpcPrecision returns the precision of the quantized coefficients in bits,
+// which is what the reference encoder uses.
+func lpcPrecision(bps uint, blockSize int) uint {
+	if bps > 16 {
+		return 15
+	}
+	switch {
+	case blockSize <= 192:
+		return 7
+	case blockSize <= 384:
+		return 8
+	case blockSize <= 576:
+		return 9
+	case blockSize <= 1152:
+		return 10
+	case blockSize <= 2304:
+		return 11
+	case blockSize <= 4608:
+		return 12
+	default:
+		return 13
+	}
+}
+
+// quantizeCoefficients quantizes the coefficients to signed integers of
+// prec bits with a shift, feeding the rounding error forward.
+func quantizeCoefficients(lpc []float64, prec uint) (q []int32, shift int, ok bool) {
+	var cmax float64
+	for _, c := range lpc {
+		cmax = math.Max(cmax, math.Abs(c))
+	}
+	if cmax <= 0 {
+		return nil, 0, false
+	}
+	_, exp := math.Frexp(cmax)
+	shift = int(prec) - exp - 1
+	if shift > 15 {
+		shift = 15
+	}
+	if shift < 0 {
+		return nil, 0, false
+	}
+
+	qmax := int64(1)<<(prec-1) - 1
+	qmin := -qmax - 1
+	q = make([]int32, len(lpc))
+	var e float64
+	for i, c := range lpc {
+		e += c * float64(int64(1)<<uint(shift))
+		v := int64(math.Round(e))
+		if v > qmax {
+			v = qmax
+		} else if v < qmin {
+			v = qmin
+		}
+		e -= float64(v)
+		q[i] = int32(v)
+	}
+	return q, shift, true
+}
+
+// }}}
+
+// Residual {{{
+
+// residual is a Rice-coded residual; see readResidual for the format.
+type residual struct {
+	values    []int32 // the residual, starting at the predictor order
+	blockSize int
+	order     int
+	method    uint // 0 for 4-bit and 1 for 5-bit Rice parameters
+	porder    uint
+	params    []uint
+	bits      int
+}
+
+// encodeResidual finds the partition order and Rice parameters that result
+// in the smallest encoding of res[order:].
+func encodeResidual(res []int32, order int, maxPorder uint) *residual {
+	n := len(res)
+	u := make([]uint64, n)
+	for i := order; i < n; i++ {
+		u[i] = uint64(uint32(res[i]<<1 ^ res[i]>>31))
+	}
+
+	var best *residual
+	for p := uint(0); p <= maxPorder; p++ {
+		psize := n >> p
+		if psize<<p != n || psize < order || (p > 0 && psize == 0) {
+			break
+		}
+		rc := &residual{
+			values:    res[order:],
+			blockSize: n,
+			order:     order,
+			porder:    p,
+			params:    make([]uint, 1<<p),
+		}
+		bits := 0
+		for i := range rc.params {
+			start, end := i*psize, (i+1)*psize
+			if i == 0 {
+				start = order
+			}
+			k, b := riceParameter(u[start:end])
+			rc.params[i] = k
+			bits += b
+			if k > 14 {
+				rc.method = 1
+			}
+		}
+		rc.bits = 2 + 4 + bits + (4+int(rc.method))<<p
+		if best == nil || rc.bits < best.bits {
+			best = rc
+		}
+	}
+	return best
+}
+
+// riceParameter returns the best Rice parameter for the folded values,
+// together with the number of bits that the values need. Only the
+// parameters around the estimate from the mean are tried.
+func riceParameter(u []uint64) (uint, int) {
+	if len(u) == 0 {
+		return 0, 0
+	}
+	var sum uint64
+	for _, v := range u {
+		sum += v
+	}
+	est := uint(0)
+	if mean := sum / uint64(len(u)); mean > 0 {
+		est = uint(bits.Len64(mean)) - 1
+	}
+	lo, hi := est, est+1
+	if lo > 0 {
+		lo--
+	}
+	if hi > 30 {
+		hi = 30
+	}
+	if lo > hi {
+		lo = hi
+	}
+
+	bestK, bestBits := uint(0), -1
+	for k := lo; k <= hi; k++ {
+		b := len(u) * int(k+1)
+		for _, v := range u {
+			b += int(v >> k)
+		}
+		if bestBits < 0 || b < bestBits {
+			bestK, bestBits = k, b
+		}
+	}
+	return bestK, bestBits
+}
+
+func (rc *residual) write(fw *bitWriter) {
+	pbits := uint(4 + rc.method)
+	fw.writeBits(uint64(rc.method), 2)
+	fw.writeBits(uint64(rc.porder), 4)
+
+	psize := rc.blockSize >> rc.porder
+	values := rc.values
+	for i, k := range rc.params {
+		n := psize
+		if i == 0 {
+			n -= rc.order // the first partition is shorter by the predictor order
+		}
+		fw.writeBits(uint64(k), pbits)
+		for _, v := range values[:n] {
+			u := uint64(uint32(v<<1 ^ v>>31))
+			fw.writeUnary(u >> k)
+			fw.writeBits(u, k)
+		}
+		values = values[n:]
+	}
+}
+
+// }}}
@@ -0,0 +1,216 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package flac
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memFile is an in-memory io.WriteSeeker.
+type memFile struct {
+	buf []byte
+	off int
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if n := f.off + len(p); n > len(f.buf) {
+		f.buf = append(f.buf, make([]byte, n-len(f.buf))...)
+	}
+	copy(f.buf[f.off:], p)
+	f.off += len(p)
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		f.off = int(offset)
+	case io.SeekCurrent:
+		f.off += int(offset)
+	case io.SeekEnd:
+		f.off = len(f.buf) + int(offset)
+	}
+	if f.off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	return int64(f.off), nil
+}
+
+func encode(si *StreamInfo, opts *EncoderOptions, samples []int32) ([]byte, error) {
+	var f memFile
+	e, err := NewEncoder(&f, si, opts)
+	if err != nil {
+		return nil, err
+	}
+	// Write in uneven pieces to exercise the buffering
+	for len(samples) > 0 {
+		n := 777
+		if n > len(samples) {
+			n = len(samples)
+		}
+		if _, err := e.Write(samples[:n]); err != nil {
+			return nil, err
+		}
+		samples = samples[n:]
+	}
+	if err := e.Close(); err != nil {
+		return nil, err
+	}
+	return f.buf, nil
+}
+
+func decodeAll(data []byte) (*Metadata, []int32, error) {
+	d, err := NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	var samples []int32
+	for {
+		f, err := d.ReadFrame()
+		if err == io.EOF {
+			return d.Metadata(), samples, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		samples = f.Interleave(samples)
+	}
+}
+
+func TestEncoder(z *testing.T) {
+	assert := assert.New(z)
+	want, err := readTestWAV()
+	if !assert.Nil(err) {
+		return
+	}
+
+	info := &StreamInfo{SampleRate: 44100, NumChannels: 2, BitsPerSample: 16}
+	meta := &Metadata{}
+	meta.Set("title", "Flow Away My Love")
+	for level := range encoderLevels {
+		opts := DefaultEncoderOptions
+		opts.Level = level
+		opts.Metadata = meta
+		data, err := encode(info, &opts, want)
+		if !assert.Nil(err) {
+			return
+		}
+		m, got, err := decodeAll(data)
+		if !assert.Nil(err, "level %d", level) {
+			continue
+		}
+		z.Logf("level %d: %d bytes", level, len(data)-int(m.AudioOffset()))
+		assert.Equal(want, got, "level %d", level)
+
+		si := m.StreamInfo()
+		assert.Equal(uint64(len(want)/2), si.TotalSamples)
+		assert.Equal(string(testFileStreamInfo.MD5Sum), hex.EncodeToString(si.MD5Sum))
+		assert.Equal(uint16(encoderLevels[level].blockSize), si.MaxBlockSize)
+		assert.True(si.MinFrameSize > 0 && si.MinFrameSize <= si.MaxFrameSize)
+		assert.Equal("Flow Away My Love", m.Title())
+
+		// Every real seek point must point to a frame
+		for _, p := range m.SeekTable() {
+			if p.IsPlaceholder() {
+				continue
+			}
+			r := bytes.NewReader(data[m.AudioOffset()+int64(p.Offset):])
+			f, err := NewFrameDecoder(r, si).ReadFrame()
+			if assert.Nil(err) {
+				assert.Equal(p.SampleNumber, f.FirstSample)
+				assert.Equal(p.FrameSamples, f.BlockSize)
+			}
+		}
+	}
+}
+
+func TestEncoderOffset(z *testing.T) {
+	assert := assert.New(z)
+	want := make([]int32, 2*10000)
+	for i := range want {
+		want[i] = int32(i % 1000)
+	}
+
+	// The stream is written after a prefix, which must not be overwritten
+	// when the metadata blocks are written again.
+	f := memFile{buf: []byte("prefix")}
+	f.off = len(f.buf)
+	e, err := NewEncoder(&f, &StreamInfo{SampleRate: 44100, NumChannels: 2, BitsPerSample: 16}, nil)
+	if !assert.Nil(err) {
+		return
+	}
+	if _, err := e.Write(want); !assert.Nil(err) {
+		return
+	}
+	if !assert.Nil(e.Close()) {
+		return
+	}
+	assert.Equal(len(f.buf), f.off)
+	assert.Equal("prefix", string(f.buf[:6]))
+	m, got, err := decodeAll(f.buf[6:])
+	if assert.Nil(err) {
+		assert.Equal(uint64(10000), m.StreamInfo().TotalSamples)
+		assert.Equal(want, got)
+	}
+}
+
+func TestEncoderFormats(z *testing.T) {
+	assert := assert.New(z)
+	rnd := rand.New(rand.NewSource(1))
+
+	tests := []struct {
+		Name      string
+		Info      StreamInfo
+		BlockSize int
+		Sample    func(i, c int) int32
+	}{
+		{"silence", StreamInfo{SampleRate: 48000, NumChannels: 2, BitsPerSample: 16}, 0,
+			func(i, c int) int32 { return 0 }},
+		{"sine24", StreamInfo{SampleRate: 96000, NumChannels: 1, BitsPerSample: 24}, 0,
+			func(i, c int) int32 { return int32(8000000 * math.Sin(float64(i)/20)) }},
+		{"noise8", StreamInfo{SampleRate: 8000, NumChannels: 3, BitsPerSample: 8}, 1000,
+			func(i, c int) int32 { return int32(rnd.Intn(256) - 128) }},
+		{"wasted", StreamInfo{SampleRate: 44100, NumChannels: 2, BitsPerSample: 16}, 192,
+			func(i, c int) int32 { return int32(rnd.Intn(64)-32) * 256 }},
+		{"noise32", StreamInfo{SampleRate: 22050, NumChannels: 2, BitsPerSample: 32}, 0,
+			func(i, c int) int32 { return int32(rnd.Uint32()) }},
+		{"odd", StreamInfo{SampleRate: 44123, NumChannels: 2, BitsPerSample: 12}, 333,
+			func(i, c int) int32 { return int32(1000*math.Sin(float64(i)/(5+float64(c)))) + int32(rnd.Intn(9)) }},
+	}
+	for _, t := range tests {
+		nc := int(t.Info.NumChannels)
+		samples := make([]int32, 10007*nc)
+		for i := range samples {
+			samples[i] = t.Sample(i/nc, i%nc)
+		}
+		for _, level := range []int{0, 5, 8} {
+			opts := EncoderOptions{Level: level, BlockSize: t.BlockSize}
+			data, err := encode(&t.Info, &opts, samples)
+			if !assert.Nil(err, t.Name) {
+				continue
+			}
+			m, got, err := decodeAll(data)
+			if !assert.Nil(err, t.Name) {
+				continue
+			}
+			assert.Equal(samples, got, "%s at level %d", t.Name, level)
+			assert.Equal(t.Info.SampleRate, m.StreamInfo().SampleRate)
+			assert.Nil(m.SeekTable())
+		}
+	}
+
+	_, err := encode(&StreamInfo{SampleRate: 44100, NumChannels: 1, BitsPerSample: 8}, nil, []int32{128})
+	assert.Equal(ErrSampleRange, err)
+	_, err = encode(&StreamInfo{SampleRate: 44100, NumChannels: 9, BitsPerSample: 8}, nil, nil)
+	assert.Equal(ErrInvalidFormat, err)
+}
@@ -11,14 +11,17 @@
 package flac
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/goulash/audio"
+	"github.com/goulash/audio/vorbis"
 	"github.com/goulash/stat"
 )
 
@@ -26,12 +29,52 @@ var Stats struct {
 	Identify         stat.Run
 	ReadFileMetadata stat.Run
 	ReadMetadata     stat.Run
+	Verify           stat.Run
+}
+
+// statsMu guards Stats, since the functions may be called concurrently.
+var statsMu sync.Mutex
+
+// addStat adds the time since start to the run.
+func addStat(r *stat.Run, start time.Time) {
+	statsMu.Lock()
+	r.Add(float64(time.Since(start)))
+	statsMu.Unlock()
 }
 
 func init() {
-	audio.MetadataReaders[audio.FLAC] = func(path string) (audio.Metadata, error) {
-		return ReadFileMetadata(path)
-	}
+	audio.RegisterFormat(audio.Format{
+		Name:       "flac",
+		Codec:      audio.FLAC,
+		Magic:      []string{"fLaC"},
+		Extensions: []string{".flac"},
+		MIMETypes:  []string{"audio/flac", "audio/x-flac"},
+		ReadMetadata: func(r io.ReadSeeker) (audio.Metadata, error) {
+			m, err := ReadMetadata(r)
+			if err != nil {
+				return nil, err
+			}
+			if n, err := r.Seek(0, io.SeekEnd); err == nil {
+				m.SetFileSize(n)
+			}
+			return m, nil
+		},
+		WriteMetadata: func(path string, changes func(audio.MutableMetadata)) error {
+			m, err := ReadFileMetadataBlocks(path, LoadVorbisComment)
+			if err != nil {
+				return err
+			}
+			changes(m)
+			return WriteFileMetadata(path, m)
+		},
+		NewDecoder: func(r io.Reader) (audio.Decoder, error) {
+			d, err := NewDecoder(r)
+			if err != nil {
+				return nil, err
+			}
+			return d, nil
+		},
+	})
 }
 
 var (
@@ -39,10 +82,33 @@ var (
 	ErrInvalidStream = errors.New("stream is invalid")
 )
 
+// ParseError is returned when the metadata of a stream cannot be parsed.
+// The underlying cause is usually ErrUnexpectedEOF, when the stream is
+// truncated, or ErrInvalidStream, when a block is malformed, so errors.Is
+// can be used to tell these apart. Otherwise, it is the error of the reader.
+type ParseError struct {
+	Offset int64     // offset of the block header in bytes
+	Block  BlockType // type of the block, or -1 if it is not known
+	Index  int       // index of the block, or -1 for the stream marker
+	Err    error     // underlying cause
+}
+
+func (e *ParseError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("stream marker at offset %d: %v", e.Offset, e.Err)
+	}
+	if e.Block < 0 {
+		return fmt.Sprintf("block %d at offset %d: %v", e.Index, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("%v block %d at offset %d: %v", e.Block, e.Index, e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
 // Identify returns true if the stream looks like a FLAC stream.
 func Identify(r io.Reader) (bool, error) {
 	start := time.Now()
-	defer func() { Stats.Identify.Add(float64(time.Since(start))) }()
+	defer addStat(&Stats.Identify, start)
 
 	if err := readStreamMarker(r); err != nil {
 		if err == ErrInvalidStream {
@@ -54,8 +120,14 @@ func Identify(r io.Reader) (bool, error) {
 }
 
 func ReadFileMetadata(path string) (*Metadata, error) {
+	return ReadFileMetadataBlocks(path, LoadAll)
+}
+
+// ReadFileMetadataBlocks reads the metadata of the file at path, but only
+// loads the given blocks; see ReadMetadataBlocks.
+func ReadFileMetadataBlocks(path string, load BlockSet) (*Metadata, error) {
 	start := time.Now()
-	defer func() { Stats.ReadFileMetadata.Add(float64(time.Since(start))) }()
+	defer addStat(&Stats.ReadFileMetadata, start)
 
 	f, err := os.Open(path)
 	if err != nil {
@@ -63,7 +135,7 @@ func ReadFileMetadata(path string) (*Metadata, error) {
 	}
 	defer f.Close()
 
-	m, err := ReadMetadata(f)
+	m, err := ReadMetadataBlocks(f, load)
 	if err != nil {
 		return nil, err
 	}
@@ -76,14 +148,44 @@ func ReadFileMetadata(path string) (*Metadata, error) {
 }
 
 func ReadMetadata(r io.Reader) (*Metadata, error) {
+	return ReadMetadataBlocks(r, LoadAll)
+}
+
+// ReadMetadataBlocks reads the metadata of the stream in r, but only loads
+// the given blocks. The stream info is always loaded. Blocks that are not
+// loaded are skipped without reading them if r implements io.Seeker, so
+// it is much faster to read only the tags of a file with large pictures.
+//
+// If the metadata cannot be parsed, a *ParseError is returned.
+func ReadMetadataBlocks(r io.Reader, load BlockSet) (*Metadata, error) {
 	start := time.Now()
-	defer func() { Stats.ReadMetadata.Add(float64(time.Since(start))) }()
+	defer addStat(&Stats.ReadMetadata, start)
 
-	err := readStreamMarker(r)
-	if err != nil {
-		return nil, err
+	if err := readStreamMarker(r); err != nil {
+		return nil, &ParseError{Offset: 0, Block: -1, Index: -1, Err: err}
 	}
-	return readMetadata(r)
+	return readMetadata(r, load, false)
+}
+
+// ReadMetadataLenient is the same as ReadMetadataBlocks, except that blocks
+// that cannot be parsed are skipped instead of aborting, and a truncated
+// stream yields the blocks that could be read. The problems are recorded
+// in Metadata.Problems. An error is only returned if the stream marker or
+// the stream info cannot be read, or if reading from r fails.
+func ReadMetadataLenient(r io.Reader, load BlockSet) (*Metadata, error) {
+	start := time.Now()
+	defer addStat(&Stats.ReadMetadata, start)
+
+	if err := readStreamMarker(r); err != nil {
+		return nil, &ParseError{Offset: 0, Block: -1, Index: -1, Err: err}
+	}
+	return readMetadata(r, load, true)
+}
+
+// ReadMetadataAt is the same as ReadMetadataBlocks, except that it reads
+// from an io.ReaderAt, starting at the beginning.
+func ReadMetadataAt(r io.ReaderAt, load BlockSet) (*Metadata, error) {
+	return ReadMetadataBlocks(io.NewSectionReader(r, 0, 1<<63-1), load)
 }
 
 // Stream Marker {{{
@@ -103,59 +205,71 @@ func readStreamMarker(r io.Reader) error {
 
 // Metadata {{{
 
-func readMetadata(r io.Reader) (*Metadata, error) {
+// BlockSet is a set of metadata block types, which selects the blocks
+// that ReadMetadataBlocks loads.
+type BlockSet uint32
+
+const (
+	LoadApplication   BlockSet = 1 << ApplicationBlock
+	LoadSeekTable     BlockSet = 1 << SeekTableBlock
+	LoadVorbisComment BlockSet = 1 << VorbisCommentBlock
+	LoadCueSheet      BlockSet = 1 << CueSheetBlock
+	LoadPicture       BlockSet = 1 << PictureBlock
+	LoadUnknown       BlockSet = 1 << 31 // all block types that are not known
+
+	LoadAll BlockSet = 0xFFFFFFFF
+)
+
+// has returns true if the set contains the block type.
+func (s BlockSet) has(t BlockType) bool {
+	if t > PictureBlock {
+		return s&LoadUnknown != 0
+	}
+	return s&(1<<uint(t)) != 0
+}
+
+// readMetadata reads the metadata blocks that follow the stream marker.
+// Every block that is loaded is read completely before it is parsed, so
+// that a malformed block cannot cause more than its length to be read.
+//
+// If lenient is true, blocks that cannot be parsed are recorded as problems
+// and skipped, and if the stream is truncated, the blocks read so far are
+// returned, as long as they include the stream info.
+func readMetadata(r io.Reader, load BlockSet, lenient bool) (*Metadata, error) {
 	m := Metadata{
-		bytes: 4,
+		bytes:  4,
+		loaded: load,
 	}
+	size := streamSize(r)
 
-	for {
+	for i := 0; ; i++ {
+		off := m.bytes
 		h, err := readBlockHeader(r)
 		if err != nil {
-			return nil, err
+			return m.abort(&ParseError{Offset: off, Block: -1, Index: i, Err: err}, lenient)
 		}
 		m.bytes += h.Length() + 4
 
-		switch h.Type() {
-		case streamInfoBlock:
-			si, err := readStreamInfoBlock(r, h)
-			if err != nil {
-				return nil, err
-			}
-			m.info = si
-		case paddingBlock:
-			if err := readPaddingBlock(r, h); err != nil {
-				return nil, err
-			}
-		case applicationBlock:
-			if err := readApplicationBlock(r, h); err != nil {
-				return nil, err
-			}
-		case seektableBlock:
-			if err := readSeekTableBlock(r, h); err != nil {
-				return nil, err
-			}
-		case vorbisCommentBlock:
-			raw, err := readVorbisCommentBlock(r, h)
-			if err != nil {
-				return nil, err
-			}
-			m.raw = raw
-		case cuesheetBlock:
-			if err := readCuesheetBlock(r, h); err != nil {
-				return nil, err
-			}
-		case pictureBlock:
-			if err := readPictureBlock(r, h); err != nil {
-				return nil, err
+		var data []byte
+		t := h.Type()
+		parse := t == StreamInfoBlock || t == InvalidBlock || (t != PaddingBlock && load.has(t))
+		if parse {
+			data, err = readBytes(r, int(h.Length()))
+		} else {
+			err = skipBytes(r, h.Length(), size)
+		}
+		if err != nil {
+			return m.abort(&ParseError{Offset: off, Block: t, Index: i, Err: err}, lenient)
+		}
+
+		if parse {
+			if err := m.readBlock(h, data); err != nil {
+				pe := &ParseError{Offset: off, Block: t, Index: i, Err: err}
+				if !lenient {
+					return nil, pe
+				}
+				m.problems = append(m.problems, pe)
 			}
-		case invalidBlock:
-			return nil, ErrInvalidStream
-		default:
-			// The standard allows for new block types to be defined.
-			// We can either die or ignore them. For our purpose, it
-			// is better to ignore them, which as far as the implementation
-			// goes, is basically the same as padding.
-			readPaddingBlock(r, h)
 		}
 
 		if h.IsLast() {
@@ -163,9 +277,62 @@ func readMetadata(r io.Reader) (*Metadata, error) {
 		}
 	}
 
+	if m.info == nil {
+		return nil, &ParseError{Offset: 4, Block: StreamInfoBlock, Index: 0, Err: ErrInvalidStream}
+	}
 	return &m, nil
 }
 
+// abort returns the error that stopped reading the metadata. If lenient is
+// true and the error is due to a truncated stream, it is recorded instead and
+// the metadata read so far is returned, if it is usable.
+func (m *Metadata) abort(err *ParseError, lenient bool) (*Metadata, error) {
+	if !lenient || err.Err != ErrUnexpectedEOF || m.info == nil {
+		return nil, err
+	}
+	m.problems = append(m.problems, err)
+	return m, nil
+}
+
+// readBlock parses the data of a metadata block and stores it in m.
+func (m *Metadata) readBlock(h blockHeader, data []byte) (err error) {
+	r := bytes.NewReader(data)
+	switch h.Type() {
+	case StreamInfoBlock:
+		m.info, err = readStreamInfoBlock(r, h)
+	case ApplicationBlock:
+		var a *Application
+		if a, err = readApplicationBlock(r, h); err == nil {
+			m.apps = append(m.apps, *a)
+		}
+	case SeekTableBlock:
+		m.seek, err = readSeekTableBlock(r, h)
+	case VorbisCommentBlock:
+		m.raw, err = readVorbisCommentBlock(data)
+	case CueSheetBlock:
+		m.cue, err = readCuesheetBlock(r, h)
+	case PictureBlock:
+		var p *Picture
+		if p, err = readPictureBlock(r, h); err == nil {
+			m.pics = append(m.pics, *p)
+		}
+	case InvalidBlock:
+		return ErrInvalidStream
+	default:
+		// The standard allows for new block types to be defined.
+		// We can either die or ignore them. For our purpose, it
+		// is better to ignore them, but we keep the data so that
+		// they survive when the metadata is written back.
+		m.other = append(m.other, rawBlock{h.Type(), data})
+	}
+	if err == ErrUnexpectedEOF {
+		// The whole block has been read, so the block is malformed
+		// rather than the stream truncated.
+		err = ErrInvalidStream
+	}
+	return err
+}
+
 var _ = audio.Metadata(new(Metadata))
 
 type Metadata struct {
@@ -173,16 +340,101 @@ type Metadata struct {
 	bytes int64
 	info  *StreamInfo
 	raw   map[string][]string
+	pics  []Picture
+	cue   *CueSheet
+	seek  SeekTable
+	apps  []Application
+	other []rawBlock
+
+	// problems contains the blocks that could not be parsed when the
+	// metadata was read with ReadMetadataLenient.
+	problems []*ParseError
+
+	// loaded contains the blocks that were loaded or set, the others
+	// are taken from the file when the metadata is written.
+	loaded BlockSet
 }
 
-func (m *Metadata) Raw() map[string][]string { return m.raw }
-func (m *Metadata) StreamInfo() *StreamInfo  { return m.info }
-func (m *Metadata) Length() time.Duration    { return m.info.Duration() }
+// rawBlock is a metadata block of an unknown type.
+type rawBlock struct {
+	typ  BlockType
+	data []byte
+}
+
+func (m *Metadata) Raw() map[string][]string    { return m.raw }
+func (m *Metadata) StreamInfo() *StreamInfo     { return m.info }
+func (m *Metadata) Pictures() []Picture         { return m.pics }
+func (m *Metadata) CueSheet() *CueSheet         { return m.cue }
+func (m *Metadata) SeekTable() SeekTable        { return m.seek }
+func (m *Metadata) Applications() []Application { return m.apps }
+func (m *Metadata) Length() time.Duration       { return m.info.Duration() }
+
+func (m *Metadata) SampleRate() int                { return int(m.info.SampleRate) }
+func (m *Metadata) NumChannels() int               { return int(m.info.NumChannels) }
+func (m *Metadata) BitsPerSample() int             { return int(m.info.BitsPerSample) }
+func (m *Metadata) TotalSamples() int64            { return int64(m.info.TotalSamples) }
+func (m *Metadata) Duration() time.Duration        { return m.info.Duration() }
+func (m *Metadata) BitrateMode() audio.BitrateMode { return audio.Lossless }
+
+// ChannelLayout returns the channel mask in the WAVEFORMATEXTENSIBLE_CHANNEL_MASK
+// tag, which is set by the reference encoder for non-default layouts, or else
+// the default layout for the number of channels.
+func (m *Metadata) ChannelLayout() audio.ChannelLayout {
+	if v := m.raw["waveformatextensible_channel_mask"]; len(v) > 0 {
+		if x, err := strconv.ParseUint(v[0], 0, 32); err == nil {
+			return audio.ChannelLayout(x)
+		}
+	}
+	return audio.DefaultChannelLayout(int(m.info.NumChannels))
+}
 
 func (m *Metadata) Encoding() audio.Codec   { return audio.FLAC }
 func (m *Metadata) EncodedBy() string       { return m.jstr("encoded-by", "/") }
 func (m *Metadata) EncoderSettings() string { return "" } // TODO
 
+// Problems returns the problems that were found when the metadata was read
+// with ReadMetadataLenient.
+func (m *Metadata) Problems() []*ParseError { return m.problems }
+
+// VirtualTracks returns the tracks described by the cue sheet, or nil if
+// the stream does not contain a cue sheet.
+func (m *Metadata) VirtualTracks() []VirtualTrack {
+	if m.cue == nil {
+		return nil
+	}
+	return m.cue.VirtualTracks(m.info.SampleRate)
+}
+
+// AudioOffset returns the offset in bytes of the first frame in the stream,
+// which is directly after the metadata blocks.
+func (m *Metadata) AudioOffset() int64 { return m.bytes }
+
+// SeekSample returns the absolute offset in bytes of the frame that contains
+// the nearest sample at or before the given sample, together with the number
+// of that sample. Decoding from the offset, sample-first samples need to be
+// skipped to arrive at the requested sample.
+//
+// If the seek table has no suitable point, the offset of the first frame is
+// returned, so the stream needs to be decoded from the beginning.
+func (m *Metadata) SeekSample(sample uint64) (offset int64, first uint64) {
+	p, ok := m.seek.Lookup(sample)
+	if !ok {
+		return m.bytes, 0
+	}
+	return m.bytes + int64(p.Offset), p.SampleNumber
+}
+
+// SeekTime is the same as SeekSample, except that the position is given
+// as a time offset from the beginning of the stream.
+func (m *Metadata) SeekTime(t time.Duration) (offset int64, first uint64) {
+	if t < 0 {
+		t = 0
+	}
+	sr := uint64(m.info.SampleRate)
+	sample := uint64(t/time.Second)*sr + uint64(t%time.Second)*sr/uint64(time.Second)
+	return m.SeekSample(sample)
+}
+
 func (m *Metadata) SetFileSize(size int64) { m.fsize = size }
 func (m *Metadata) EncodingBitrate() int {
 	if m.fsize == 0 {
@@ -190,10 +442,17 @@ func (m *Metadata) EncodingBitrate() int {
 	}
 	return m.Bitrate(m.fsize)
 }
+
+// Bitrate returns the average bitrate in kbps of the audio in a file of the
+// given size, or -1 if the duration is less than a millisecond, which is the
+// case when the encoder did not know TotalSamples.
 func (m *Metadata) Bitrate(filesize int64) int {
 	z := filesize - m.bytes
-	d := m.Length()
-	kbps := (z * 8) / int64(d*1000/time.Second)
+	ms := int64(m.Length() / time.Millisecond)
+	if ms == 0 {
+		return -1
+	}
+	kbps := (z * 8) / ms
 	if kbps <= 0 {
 		return -1
 	}
@@ -215,21 +474,53 @@ func (m *Metadata) Copyright() string        { return m.jstr("copyright", "\n")
 func (m *Metadata) Website() string          { return m.jstr("contact", "\n") }
 func (m *Metadata) OriginalFilename() string { return "" } // FIXME
 
-func (m *Metadata) jstr(key, split string) string {
-	return strings.Join(m.raw[key], split)
-}
-func (m *Metadata) fint(key string) int {
-	v, ok := m.raw[key]
+// TrackGain and AlbumGain return the ReplayGain values in the tags
+// REPLAYGAIN_TRACK_GAIN, REPLAYGAIN_TRACK_PEAK, and so on.
+func (m *Metadata) TrackGain() (gain, peak float64, ok bool) { return m.gain("track") }
+func (m *Metadata) AlbumGain() (gain, peak float64, ok bool) { return m.gain("album") }
+
+func (m *Metadata) gain(kind string) (gain, peak float64, ok bool) {
+	gain, ok = m.ffloat("replaygain_" + kind + "_gain")
 	if !ok {
-		return 0
+		return 0, 0, false
 	}
-	i, err := strconv.Atoi(v[0])
-	if err != nil {
-		return 0
+	peak, _ = m.ffloat("replaygain_" + kind + "_peak")
+	return gain, peak, true
+}
+
+// MusicBrainz returns the identifiers in the tags that are written by
+// MusicBrainz Picard.
+func (m *Metadata) MusicBrainz() audio.MusicBrainzIDs {
+	return audio.MusicBrainzIDs{
+		Recording:    m.jstr("musicbrainz_trackid", ""),
+		Track:        m.jstr("musicbrainz_releasetrackid", ""),
+		Release:      m.jstr("musicbrainz_albumid", ""),
+		ReleaseGroup: m.jstr("musicbrainz_releasegroupid", ""),
+		Work:         m.jstr("musicbrainz_workid", ""),
+		Artists:      m.raw["musicbrainz_artistid"],
+		AlbumArtists: m.raw["musicbrainz_albumartistid"],
 	}
-	return i
 }
 
+// Lyrics returns the LYRICS tag, or else the UNSYNCEDLYRICS tag.
+func (m *Metadata) Lyrics() string {
+	if s := m.jstr("lyrics", "\n"); s != "" {
+		return s
+	}
+	return m.jstr("unsyncedlyrics", "\n")
+}
+
+var (
+	_ = audio.PictureReader(new(Metadata))
+	_ = audio.ReplayGainReader(new(Metadata))
+	_ = audio.MusicBrainzReader(new(Metadata))
+	_ = audio.LyricsReader(new(Metadata))
+)
+
+func (m *Metadata) jstr(key, split string) string     { return vorbis.Comments(m.raw).Join(key, split) }
+func (m *Metadata) fint(key string) int               { return vorbis.Comments(m.raw).Int(key) }
+func (m *Metadata) ffloat(key string) (float64, bool) { return vorbis.Comments(m.raw).Float(key) }
+
 // Metadata Block Header {{{
 
 // readBlockHeader reads 4 bytes.
@@ -239,24 +530,46 @@ func readBlockHeader(r io.Reader) (blockHeader, error) {
 }
 
 type blockHeader uint32
-type blockType int16
+
+// BlockType is the type of a metadata block.
+type BlockType int16
 
 const (
-	streamInfoBlock = iota
-	paddingBlock
-	applicationBlock
-	seektableBlock
-	vorbisCommentBlock
-	cuesheetBlock
-	pictureBlock
-
-	invalidBlock blockType = 127
+	StreamInfoBlock BlockType = iota
+	PaddingBlock
+	ApplicationBlock
+	SeekTableBlock
+	VorbisCommentBlock
+	CueSheetBlock
+	PictureBlock
+
+	InvalidBlock BlockType = 127
 )
 
 func (h blockHeader) IsLast() bool    { return h&0x80000000 != 0 }           // true only when bit 0 is set
-func (h blockHeader) Type() blockType { return blockType((h >> 24) & 0x7F) } // the type is in bit 1:8
+func (h blockHeader) Type() BlockType { return BlockType((h >> 24) & 0x7F) } // the type is in bit 1:8
 func (h blockHeader) Length() int64   { return int64(h & 0x00FFFFFF) }       // the last 24 bits
-func (h blockHeader) IsValid() bool   { return h.Type() != invalidBlock }    // this is the only thing that can be invalid
+func (h blockHeader) IsValid() bool   { return h.Type() != InvalidBlock }    // this is the only thing that can be invalid
+
+var blockTypeNames = []string{
+	"STREAMINFO",
+	"PADDING",
+	"APPLICATION",
+	"SEEKTABLE",
+	"VORBIS_COMMENT",
+	"CUESHEET",
+	"PICTURE",
+}
+
+func (t BlockType) String() string {
+	if t >= 0 && int(t) < len(blockTypeNames) {
+		return blockTypeNames[t]
+	}
+	if t == InvalidBlock {
+		return "INVALID"
+	}
+	return "BLOCK(" + strconv.Itoa(int(t)) + ")"
+}
 
 // }}}
 
@@ -372,6 +685,9 @@ type StreamInfo struct {
 // Duration returns the total duration of the stream, or zero if it is unknown.
 // This is calculated by TotalSamples*time.Second / SampleRate
 func (si *StreamInfo) Duration() time.Duration {
+	if si.SampleRate == 0 {
+		return 0
+	}
 	return time.Duration(si.TotalSamples) * time.Second / time.Duration(si.SampleRate)
 }
 
@@ -380,113 +696,356 @@ func (si *StreamInfo) Duration() time.Duration {
 // Metadata Block: PADDING {{{
 
 func readPaddingBlock(r io.Reader, h blockHeader) error {
-	_, err := readBytes(r, int(h.Length()))
-	return err
+	return skipBytes(r, h.Length(), streamSize(r))
 }
 
 // }}}
 
 // Metadata Block: APPLICATION {{{
 
-func readApplicationBlock(r io.Reader, h blockHeader) error {
-	// TODO: not implemented yet
-	_, err := readBytes(r, int(h.Length()))
-	return err
+/*
+Encoding format
+
+BITS DESCRIPTION
+==== ============================================================================
+  32 Registered application ID. (Visit the registration page to register an ID
+     with FLAC.)
+ n*8 Application data (n must be a multiple of 8)
+==== ============================================================================
+*/
+func readApplicationBlock(r io.Reader, h blockHeader) (*Application, error) {
+	if h.Length() < 4 {
+		return nil, ErrInvalidStream
+	}
+	id, err := readString(r, 4)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readBytes(r, int(h.Length())-4)
+	if err != nil {
+		return nil, err
+	}
+
+	a := Application{
+		ID:   ApplicationID(id),
+		Data: data,
+	}
+	if f, ok := ApplicationReaders[a.ID]; ok {
+		// Malformed data does not make the stream invalid, the block
+		// is kept as it is.
+		if a.Value, a.Err = f(data); a.Err != nil {
+			a.Value = nil
+		}
+	}
+	return &a, nil
 }
 
-// }}}
+// ApplicationID is the registered 4-byte ID of an application block.
+type ApplicationID string
 
-// Metadata Block: SEEKTABLE {{{
+const (
+	RIFFApplication ApplicationID = "riff" // Foreign RIFF/WAVE metadata
+	AIFFApplication ApplicationID = "aiff" // Foreign AIFF metadata
+)
 
-func readSeekTableBlock(r io.Reader, h blockHeader) error {
-	// TODO: not implemented yet
-	_, err := readBytes(r, int(h.Length()))
-	return err
+// ApplicationReaders contains functions that decode the data of application
+// blocks with a known ID. The result is stored in Application.Value, and an
+// error in Application.Err.
+var ApplicationReaders = map[ApplicationID]func([]byte) (interface{}, error){
+	RIFFApplication: func(p []byte) (interface{}, error) { return readForeignChunks(p, false) },
+	AIFFApplication: func(p []byte) (interface{}, error) { return readForeignChunks(p, true) },
+}
+
+// Application is an application block, which contains data for third-party
+// applications.
+type Application struct {
+	// ID is the registered application ID.
+	ID ApplicationID
+
+	// Data is the application data, without the ID.
+	Data []byte
+
+	// Value is the decoded application data, if a function for the ID
+	// is registered in ApplicationReaders, otherwise it is nil.
+	Value interface{}
+
+	// Err is the error of the function in ApplicationReaders if the data
+	// could not be decoded, in which case Value is nil.
+	Err error
+}
+
+// ForeignChunk is a chunk of a RIFF or AIFF file, as stored by
+// flac --keep-foreign-metadata. The RIFF, RF64 and FORM headers are stored
+// with the form type as data. The chunk with the audio data (data or SSND)
+// is stored without data, only its size is kept.
+type ForeignChunk struct {
+	ID   string
+	Size uint32
+	Data []byte
+}
+
+// readForeignChunks reads the RIFF or AIFF chunks contained in p.
+// RIFF sizes are little-endian, AIFF sizes are big-endian.
+func readForeignChunks(p []byte, bigEndian bool) ([]ForeignChunk, error) {
+	var cs []ForeignChunk
+	for len(p) > 0 {
+		if len(p) < 8 {
+			return nil, ErrInvalidStream
+		}
+		c := ForeignChunk{ID: string(p[:4])}
+		if bigEndian {
+			c.Size = uint32(p[4])<<24 | uint32(p[5])<<16 | uint32(p[6])<<8 | uint32(p[7])
+		} else {
+			c.Size = uint32(p[7])<<24 | uint32(p[6])<<16 | uint32(p[5])<<8 | uint32(p[4])
+		}
+		p = p[8:]
+
+		n := int(c.Size)
+		switch c.ID {
+		case "RIFF", "RF64", "FORM":
+			n = 4
+		case "data", "SSND":
+			n = 0
+		}
+		if n > len(p) {
+			return nil, ErrInvalidStream
+		}
+		c.Data, p = p[:n], p[n:]
+		if n%2 == 1 && len(p) > 0 {
+			p = p[1:] // chunks are padded to an even size
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
 }
 
 // }}}
 
-// Metadata Block: VORBIS_COMMENT {{{
+// Metadata Block: SEEKTABLE {{{
 
 /*
-Comment encoding
+Encoding format
+
+The number of seek points is implied by the metadata header 'length' field,
+i.e. equal to length / 18.
+
+SEEKPOINT
+
+BITS DESCRIPTION
+==== ============================================================================
+  64 Sample number of first sample in the target frame, or 0xFFFFFFFFFFFFFFFF
+     for a placeholder point.
+  64 Offset (in bytes) from the first byte of the first frame header to the
+     first byte of the target frame's header.
+  16 Number of samples in the target frame.
+==== ============================================================================
+
+Notes:
+
+    For placeholder points, the second and third field values are undefined.
+    Seek points within a table must be sorted in ascending order by sample
+    number. Seek points within a table must be unique by sample number, with
+    the exception of placeholder points.
+*/
+func readSeekTableBlock(r io.Reader, h blockHeader) (SeekTable, error) {
+	if h.Length()%18 != 0 {
+		return nil, ErrInvalidStream
+	}
+
+	st := make(SeekTable, h.Length()/18)
+	for i := range st {
+		p := &st[i]
+		var err error
+		p.SampleNumber, err = readUint64(r)
+		if err != nil {
+			return nil, err
+		}
+		p.Offset, err = readUint64(r)
+		if err != nil {
+			return nil, err
+		}
+		p.FrameSamples, err = readUint16(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
 
-The comment header logically is a list of eight-bit-clean vectors; the number
-of vectors is bounded to 2^32-1 and the length of each vector is limited to
-2^32-1 bytes. The vector length is encoded; the vector contents themselves are
-not null terminated. In addition to the vector list, there is a single vector
-for vendor name (also 8 bit clean, length encoded in 32 bits). For example, the
-1.0 release of libvorbis set the vendor string to "Xiph.Org libVorbis
-I 20020717".
+// placeholderPoint is the sample number of a placeholder seek point.
+const placeholderPoint = 0xFFFFFFFFFFFFFFFF
 
-The comment header is decoded as follows:
+// SeekPoint is a point in the stream that can be seeked to.
+type SeekPoint struct {
+	// SampleNumber is the number of the first sample in the target frame.
+	SampleNumber uint64
 
-    1) [vendor_length] = read an unsigned integer of 32 bits
-    2) [vendor_string] = read a UTF-8 vector as [vendor_length] octets
-    3) [user_comment_list_length] = read an unsigned integer of 32 bits
-    4) iterate [user_comment_list_length] times {
+	// Offset is the offset in bytes from the first byte of the first frame
+	// header to the first byte of the target frame header.
+	Offset uint64
 
-         5) [length] = read an unsigned integer of 32 bits
-         6) this iteration's user comment = read a UTF-8 vector as [length] octets
+	// FrameSamples is the number of samples in the target frame.
+	FrameSamples uint16
+}
 
-       }
+// IsPlaceholder returns true if the seek point is a placeholder, in which
+// case the other fields are undefined.
+func (p SeekPoint) IsPlaceholder() bool { return p.SampleNumber == placeholderPoint }
+
+// SeekTable is a list of seek points, sorted in ascending order by sample
+// number. Placeholder points come at the end.
+type SeekTable []SeekPoint
+
+// Lookup returns the last seek point that comes at or before sample.
+// If there is no such point, false is returned.
+func (st SeekTable) Lookup(sample uint64) (SeekPoint, bool) {
+	var (
+		sp SeekPoint
+		ok bool
+	)
+	for _, p := range st {
+		if p.IsPlaceholder() || p.SampleNumber > sample {
+			break
+		}
+		sp, ok = p, true
+	}
+	return sp, ok
+}
 
-    7) [framing_bit] = read a single bit as boolean
-    8) if ( [framing_bit] unset or end of packet ) then ERROR
-    9) done.
+// }}}
+
+// Metadata Block: VORBIS_COMMENT {{{
 
-Content vector format
+// readVorbisCommentBlock reads the comments in a VORBIS_COMMENT block, which
+// has no framing bit; see vorbis.ParseComments.
+func readVorbisCommentBlock(data []byte) (map[string][]string, error) {
+	c, n, err := vorbis.ParseComments(data)
+	if err != nil || n != len(data) {
+		return nil, ErrInvalidStream
+	}
+	return c, nil
+}
 
-The comment vectors are structured similarly to a UNIX environment variable.
-That is, comment fields consist of a field name and a corresponding value and
-look like:
+// }}}
 
-    comment[0]="ARTIST=me";
-    comment[1]="TITLE=the sound of Vorbis";
+// Metadata Block: CUESHEET {{{
 
-- A case-insensitive field name that may consist of ASCII 0x20 through 0x7D,
-  0x3D ('=') excluded. ASCII 0x41 through 0x5A inclusive (A-Z) is to be
-  considered equivalent to ASCII 0x61 through 0x7A inclusive (a-z).
-- The field name is immediately followed by ASCII 0x3D ('='); this equals
-  sign is used to terminate the field name.
-- 0x3D is followed by the 8 bit clean UTF-8 encoded value of the field
-  contents to the end of the field.
+/*
+Encoding format
+
+BITS DESCRIPTION
+==== ============================================================================
+ 128*8 Media catalog number, in ASCII printable characters 0x20-0x7e, padded
+     with NUL characters. For CD-DA, this is a thirteen digit number.
+  64 The number of lead-in samples. This field is only meaningful for CD-DA
+     cuesheets; for other uses it should be 0.
+   1 1 if the CUESHEET corresponds to a Compact Disc, else 0.
+ 7+258*8 Reserved. All bits must be set to zero.
+   8 The number of tracks. Must be at least 1 (because of the requisite lead-out
+     track). For CD-DA, this number must be no more than 100 (99 regular tracks
+     and one lead-out track).
+   * CUESHEET_TRACK+ One or more tracks. The last track in a CUESHEET block
+     must be the lead-out track.
+==== ============================================================================
+
+CUESHEET_TRACK
+
+BITS DESCRIPTION
+==== ============================================================================
+  64 Track offset in samples, relative to the beginning of the FLAC audio
+     stream. It is the offset to the first index point of the track.
+   8 Track number. A track number of 0 is not allowed to avoid conflicting with
+     the CD-DA spec, which reserves this for the lead-in. For CD-DA the number
+     must be 1-99, or 170 for the lead-out; for non-CD-DA, the track number
+     must be 255 for the lead-out.
+ 12*8 Track ISRC. This is a 12-digit alphanumeric code, or all NUL characters
+     to indicate that there is no ISRC.
+   1 The track type: 0 for audio, 1 for non-audio.
+   1 The pre-emphasis flag: 0 for no pre-emphasis, 1 for pre-emphasis.
+ 6+13*8 Reserved. All bits must be set to zero.
+   8 The number of track index points. There must be at least one index in
+     every track except the lead-out track, which must have zero.
+   * CUESHEET_TRACK_INDEX+ For all tracks except the lead-out track, one or
+     more track index points.
+==== ============================================================================
+
+CUESHEET_TRACK_INDEX
+
+BITS DESCRIPTION
+==== ============================================================================
+  64 Offset in samples, relative to the track offset, of the index point.
+   8 The index point number. For CD-DA, the first index must be 0 or 1, and
+     subsequent indices must be increasing by 1.
+ 3*8 Reserved. All bits must be set to zero.
+==== ============================================================================
 */
-func readVorbisCommentBlock(r io.Reader, h blockHeader) (map[string][]string, error) {
-	tags := make(map[string][]string)
+func readCuesheetBlock(r io.Reader, h blockHeader) (*CueSheet, error) {
+	cs := CueSheet{}
 
-	// Read vendor length and vendor string
-	vl, err := readUint32LE(r)
+	// Read media catalog number (128*8), lead-in (64), and CD flag (1)
+	mcn, err := readBytes(r, 128)
 	if err != nil {
 		return nil, err
 	}
-	vs, err := readString(r, int(vl))
+	cs.MediaCatalogNumber = trimNUL(mcn)
+	cs.LeadIn, err = readUint64(r)
 	if err != nil {
 		return nil, err
 	}
-	// ~ is not allowed, so there will be no conflicts
-	tags["~vendor"] = []string{vs}
-
-	// Read user comment list length and the tags
-	n, err := readUint32LE(r)
+	p, err := readBytes(r, 259)
 	if err != nil {
 		return nil, err
 	}
+	cs.IsCD = p[0]&0x80 != 0
 
-	// Now I can calculate how many bytes we will at least read
-	bytes := 4 + len(vs) + 4 + int(n)*4
-	for i := uint32(0); i < n; i++ {
-		k, v, err := readVorbisCommentEntry(r)
+	// Read the tracks
+	n, err := readUint8(r)
+	if err != nil {
+		return nil, err
+	}
+	bytes := 396
+	cs.Tracks = make([]CueTrack, n)
+	for i := range cs.Tracks {
+		t := &cs.Tracks[i]
+		t.Offset, err = readUint64(r)
+		if err != nil {
+			return nil, err
+		}
+		t.Number, err = readUint8(r)
 		if err != nil {
 			return nil, err
 		}
-		bytes += len(k) + len(v) + 1
+		isrc, err := readBytes(r, 12)
+		if err != nil {
+			return nil, err
+		}
+		t.ISRC = trimNUL(isrc)
+		p, err := readBytes(r, 14)
+		if err != nil {
+			return nil, err
+		}
+		t.IsAudio = p[0]&0x80 == 0
+		t.PreEmphasis = p[0]&0x40 != 0
 
-		k = strings.ToLower(k)
-		if _, ok := tags[k]; ok {
-			tags[k] = append(tags[k], v)
-		} else {
-			tags[k] = []string{v}
+		k, err := readUint8(r)
+		if err != nil {
+			return nil, err
+		}
+		bytes += 36 + int(k)*12
+		t.Indices = make([]CueIndex, k)
+		for j := range t.Indices {
+			x := &t.Indices[j]
+			x.Offset, err = readUint64(r)
+			if err != nil {
+				return nil, err
+			}
+			x.Number, err = readUint8(r)
+			if err != nil {
+				return nil, err
+			}
+			if _, err = readBytes(r, 3); err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -494,46 +1053,246 @@ func readVorbisCommentBlock(r io.Reader, h blockHeader) (map[string][]string, er
 		return nil, ErrInvalidStream
 	}
 
-	return tags, nil
+	return &cs, nil
 }
 
-func readVorbisCommentEntry(r io.Reader) (k, v string, err error) {
-	z, err := readUint32LE(r)
-	if err != nil {
-		return "", "", err
-	}
-	s, err := readString(r, int(z))
-	if err != nil {
-		return "", "", err
+// trimNUL returns the string in p up to the first NUL character.
+func trimNUL(p []byte) string {
+	for i, c := range p {
+		if c == 0 {
+			return string(p[:i])
+		}
 	}
+	return string(p)
+}
+
+// CueSheet stores the track and index information of a cue sheet, which is
+// typically used to store the table of contents of a CD.
+type CueSheet struct {
+	// MediaCatalogNumber is the media catalog number, which for CD-DA is
+	// a thirteen digit number.
+	MediaCatalogNumber string
+
+	// LeadIn is the number of lead-in samples, which is only meaningful
+	// for CD-DA cue sheets.
+	LeadIn uint64
+
+	// IsCD is true if the cue sheet corresponds to a Compact Disc.
+	IsCD bool
+
+	// Tracks contains all the tracks, the last of which is the lead-out track.
+	Tracks []CueTrack
+}
+
+// CueTrack is a track in a cue sheet.
+type CueTrack struct {
+	// Offset is the track offset in samples, relative to the beginning
+	// of the audio stream.
+	Offset uint64
+
+	// Number is the track number. The lead-out track has the number 170
+	// for CD-DA and 255 otherwise.
+	Number uint8
+
+	// ISRC is the International Standard Recording Code of the track,
+	// or the empty string if there is none.
+	ISRC string
 
-	// Split tag on first = sign. I could also double check that key does
-	// indeed only contain the given fields, but meh.
-	i := strings.IndexByte(s, '=')
-	if i < 0 {
-		return "", "", ErrInvalidStream
+	// IsAudio is true if the track contains audio.
+	IsAudio bool
+
+	// PreEmphasis is true if the track has pre-emphasis.
+	PreEmphasis bool
+
+	// Indices contains the index points of the track. The lead-out track
+	// has no index points.
+	Indices []CueIndex
+}
+
+// Start returns the offset of the track in samples, relative to the
+// beginning of the audio stream. This is the position of index point 1 if
+// it exists, so that any pre-gap (index point 0) is not included.
+func (t *CueTrack) Start() uint64 {
+	for _, x := range t.Indices {
+		if x.Number == 1 {
+			return t.Offset + x.Offset
+		}
 	}
-	return s[:i], s[i+1:], nil
+	return t.Offset
 }
 
-// }}}
+// CueIndex is an index point of a cue sheet track.
+type CueIndex struct {
+	// Offset is the offset in samples, relative to the track offset.
+	Offset uint64
 
-// Metadata Block: CUESHEET {{{
+	// Number is the index point number.
+	Number uint8
+}
 
-func readCuesheetBlock(r io.Reader, h blockHeader) error {
-	// TODO: not implemented yet
-	_, err := readBytes(r, int(h.Length()))
-	return err
+// VirtualTrack is a track in a stream that contains an entire disc.
+type VirtualTrack struct {
+	Number uint8
+	ISRC   string
+
+	// Start is the first sample of the track, and Samples is the
+	// number of samples that belong to the track.
+	Start   uint64
+	Samples uint64
+
+	// Offset and Duration are Start and Samples as time durations.
+	Offset   time.Duration
+	Duration time.Duration
+}
+
+// VirtualTracks returns the tracks in the cue sheet, excluding the lead-out
+// track, with their position and length in the stream. Each track lasts until
+// the next track starts, so pre-gaps belong to the previous track.
+func (cs *CueSheet) VirtualTracks(sampleRate uint32) []VirtualTrack {
+	if len(cs.Tracks) < 2 {
+		return nil
+	}
+	vts := make([]VirtualTrack, len(cs.Tracks)-1)
+	for i := range vts {
+		t := &cs.Tracks[i]
+		start, end := t.Start(), cs.Tracks[i+1].Start()
+		if end < start {
+			end = start
+		}
+		vts[i] = VirtualTrack{
+			Number:   t.Number,
+			ISRC:     t.ISRC,
+			Start:    start,
+			Samples:  end - start,
+			Offset:   samplesToDuration(start, sampleRate),
+			Duration: samplesToDuration(end-start, sampleRate),
+		}
+	}
+	return vts
+}
+
+func samplesToDuration(n uint64, sampleRate uint32) time.Duration {
+	if sampleRate == 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second / time.Duration(sampleRate)
 }
 
 // }}}
 
 // Metadata Block: PICTURE {{{
 
-func readPictureBlock(r io.Reader, h blockHeader) error {
-	// TODO: not implemented yet
-	_, err := readBytes(r, int(h.Length()))
-	return err
+/*
+Encoding format
+
+BITS DESCRIPTION
+==== ============================================================================
+  32 The picture type according to the ID3v2 APIC frame (see PictureType).
+  32 The length of the MIME type string in bytes.
+ n*8 The MIME type string, in printable ASCII characters 0x20-0x7e. The MIME
+     type may also be --> to signify that the data part is a URL of the
+     picture instead of the picture data itself.
+  32 The length of the description string in bytes.
+ n*8 The description of the picture, in UTF-8.
+  32 The width of the picture in pixels.
+  32 The height of the picture in pixels.
+  32 The color depth of the picture in bits-per-pixel.
+  32 For indexed-color pictures (e.g. GIF), the number of colors used, or 0
+     for non-indexed pictures.
+  32 The length of the picture data in bytes.
+ n*8 The binary picture data.
+==== ============================================================================
+*/
+func readPictureBlock(r io.Reader, h blockHeader) (*Picture, error) {
+	p := Picture{}
+
+	t, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	p.Type = PictureType(t)
+
+	// Read MIME type and description, whose lengths are checked against
+	// the rest of the block before anything is allocated.
+	left := h.Length() - 8
+	n, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if int64(n) > left {
+		return nil, ErrInvalidStream
+	}
+	left -= int64(n) + 4
+	p.MIMEType, err = readString(r, int(n))
+	if err != nil {
+		return nil, err
+	}
+	n, err = readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if int64(n) > left {
+		return nil, ErrInvalidStream
+	}
+	p.Description, err = readString(r, int(n))
+	if err != nil {
+		return nil, err
+	}
+
+	// Read width (32), height (32), depth (32), and colors (32)
+	for _, v := range []*uint32{&p.Width, &p.Height, &p.Depth, &p.Colors} {
+		*v, err = readUint32(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Read picture data
+	n, err = readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	bytes := 32 + len(p.MIMEType) + len(p.Description) + int(n)
+	if int(h.Length()) != bytes {
+		return nil, ErrInvalidStream
+	}
+	p.Data, err = readBytes(r, int(n))
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
 }
 
+// Picture and PictureType are defined in the audio package, so that Metadata
+// implements audio.PictureReader.
+type (
+	Picture     = audio.Picture
+	PictureType = audio.PictureType
+)
+
+const (
+	PictureOther             = audio.PictureOther
+	PictureFileIcon          = audio.PictureFileIcon
+	PictureOtherFileIcon     = audio.PictureOtherFileIcon
+	PictureFrontCover        = audio.PictureFrontCover
+	PictureBackCover         = audio.PictureBackCover
+	PictureLeaflet           = audio.PictureLeaflet
+	PictureMedia             = audio.PictureMedia
+	PictureLeadArtist        = audio.PictureLeadArtist
+	PictureArtist            = audio.PictureArtist
+	PictureConductor         = audio.PictureConductor
+	PictureBand              = audio.PictureBand
+	PictureComposer          = audio.PictureComposer
+	PictureLyricist          = audio.PictureLyricist
+	PictureRecordingLocation = audio.PictureRecordingLocation
+	PictureDuringRecording   = audio.PictureDuringRecording
+	PictureDuringPerformance = audio.PictureDuringPerformance
+	PictureScreenCapture     = audio.PictureScreenCapture
+	PictureFish              = audio.PictureFish
+	PictureIllustration      = audio.PictureIllustration
+	PictureBandLogo          = audio.PictureBandLogo
+	PicturePublisherLogo     = audio.PicturePublisherLogo
+)
+
 // }}}
@@ -6,15 +6,21 @@ package flac
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/goulash/audio"
 	"github.com/stretchr/testify/assert"
 )
 
 const (
-	testWAV       = "test.wav"
+	testWAV       = "../wav/test.wav"
 	testFile      = "test.flac"
 	testCover     = "cover.jpg"
 	testPerformer = "performer.jpg"
@@ -57,13 +63,13 @@ func TestReadBlockHeader(z *testing.T) {
 		In   []byte
 		Out  uint32
 		Last bool
-		Type blockType
+		Type BlockType
 		Size int64
 		Err  error
 	}{
-		{[]byte{0x0, 0x0, 0x0, 0x22}, 34, false, streamInfoBlock, 34, nil},
-		{[]byte{0x80, 0x0, 0x0, 0x0}, 2147483648, true, streamInfoBlock, 0, nil},
-		{[]byte{0x80, 0x0, 0x0}, 0, false, streamInfoBlock, 0, ErrUnexpectedEOF},
+		{[]byte{0x0, 0x0, 0x0, 0x22}, 34, false, StreamInfoBlock, 34, nil},
+		{[]byte{0x80, 0x0, 0x0, 0x0}, 2147483648, true, StreamInfoBlock, 0, nil},
+		{[]byte{0x80, 0x0, 0x0}, 0, false, StreamInfoBlock, 0, ErrUnexpectedEOF},
 	}
 
 	for _, t := range tests {
@@ -98,3 +104,450 @@ func TestFile(z *testing.T) {
 	assert.Equal(si.TotalSamples, ti.TotalSamples)
 	assert.Equal(string(si.MD5Sum), hex.EncodeToString(ti.MD5Sum))
 }
+
+func TestAudioReadMetadataFrom(z *testing.T) {
+	assert := assert.New(z)
+	data, err := ioutil.ReadFile(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+	m, err := audio.ReadMetadataFrom(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+	fm, err := audio.ReadMetadata(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(audio.FLAC, m.Encoding())
+	assert.Equal(fm.Title(), m.Title())
+	assert.Equal(fm.Length(), m.Length())
+	assert.Equal(fm.EncodingBitrate(), m.EncodingBitrate())
+
+	// Some encoders put an ID3v2 tag in front of the stream.
+	id3 := append([]byte("ID3\x04\x00\x00\x00\x00\x00\x04...."), data...)
+	m, err = audio.ReadMetadataFrom(bytes.NewReader(id3))
+	if assert.Nil(err) {
+		assert.Equal(audio.FLAC, m.Encoding())
+		assert.Equal(fm.Title(), m.Title())
+	}
+
+	_, err = audio.ReadMetadataFrom(bytes.NewReader([]byte("MAC \x96\x0f\x00\x00")))
+	assert.Equal(audio.ErrUnsupported, err)
+}
+
+func TestProperties(z *testing.T) {
+	assert := assert.New(z)
+	m, err := ReadFileMetadata(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+	var p audio.Properties = m
+	assert.Equal(44100, p.SampleRate())
+	assert.Equal(2, p.NumChannels())
+	assert.Equal(16, p.BitsPerSample())
+	assert.Equal(int64(16536), p.TotalSamples())
+	assert.Equal(m.Length(), p.Duration())
+	assert.Equal(audio.Lossless, p.BitrateMode())
+	assert.Equal(audio.Stereo, p.ChannelLayout())
+
+	m.Set("WAVEFORMATEXTENSIBLE_CHANNEL_MASK", "0x0003")
+	assert.Equal(audio.FrontLeft|audio.FrontRight, m.ChannelLayout())
+	m.Set("WAVEFORMATEXTENSIBLE_CHANNEL_MASK", "0x0004")
+	assert.Equal(audio.Mono, m.ChannelLayout())
+
+	// The number of samples is unknown in streamed encodes.
+	m.SetFileSize(1 << 20)
+	m.info.TotalSamples = 0
+	assert.Equal(time.Duration(0), m.Duration())
+	assert.Equal(-1, m.EncodingBitrate())
+	m.info.SampleRate = 0
+	assert.Equal(time.Duration(0), m.Duration())
+	assert.Equal(-1, m.EncodingBitrate())
+}
+
+func TestPictures(z *testing.T) {
+	assert := assert.New(z)
+	m, err := ReadFileMetadata(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+	ps := m.Pictures()
+	if !assert.Len(ps, 2) {
+		return
+	}
+
+	tests := []struct {
+		Type PictureType
+		File string
+	}{
+		{PictureFrontCover, testCover},
+		{PictureArtist, testPerformer},
+	}
+	for i, t := range tests {
+		data, err := ioutil.ReadFile(t.File)
+		if !assert.Nil(err) {
+			return
+		}
+		p := ps[i]
+		assert.Equal(t.Type, p.Type)
+		assert.Equal("image/jpeg", p.MIMEType)
+		assert.Equal(t.File, p.Description)
+		assert.Equal(uint32(512), p.Width)
+		assert.Equal(uint32(512), p.Height)
+		assert.False(p.IsURL())
+		assert.Equal(data, p.Data)
+	}
+}
+
+func TestExtensions(z *testing.T) {
+	assert := assert.New(z)
+	am, err := audio.ReadMetadata(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+	pr, ok := am.(audio.PictureReader)
+	if assert.True(ok) {
+		ps := pr.Pictures()
+		if assert.Len(ps, 2) {
+			assert.Equal(audio.PictureFrontCover, ps[0].Type)
+		}
+	}
+	m := am.(*Metadata)
+
+	_, _, ok = m.TrackGain()
+	assert.False(ok)
+	m.Set("REPLAYGAIN_TRACK_GAIN", "-7.89 dB")
+	m.Set("REPLAYGAIN_TRACK_PEAK", "0.988159")
+	m.Set("REPLAYGAIN_ALBUM_GAIN", "+1.5 dB")
+	gain, peak, ok := m.TrackGain()
+	assert.True(ok)
+	assert.Equal(-7.89, gain)
+	assert.Equal(0.988159, peak)
+	gain, peak, ok = m.AlbumGain()
+	assert.True(ok)
+	assert.Equal(1.5, gain)
+	assert.Equal(0.0, peak)
+
+	ids := m.MusicBrainz()
+	assert.True(ids.IsZero())
+	m.Set("MUSICBRAINZ_TRACKID", "2b5e8f2e-0c1a-4e57-8a3c-0d6b4b2f0c11")
+	m.Set("MUSICBRAINZ_ALBUMID", "7d7f3c0b-5d7b-4a25-9f43-7c1b0b0c2f6e")
+	m.Set("MUSICBRAINZ_ARTISTID", "a1", "a2")
+	ids = m.MusicBrainz()
+	assert.False(ids.IsZero())
+	assert.Equal("2b5e8f2e-0c1a-4e57-8a3c-0d6b4b2f0c11", ids.Recording)
+	assert.Equal("7d7f3c0b-5d7b-4a25-9f43-7c1b0b0c2f6e", ids.Release)
+	assert.Equal([]string{"a1", "a2"}, ids.Artists)
+	assert.Equal("", ids.Track)
+
+	assert.Equal("", m.Lyrics())
+	m.Set("UNSYNCEDLYRICS", "la la la")
+	assert.Equal("la la la", m.Lyrics())
+	m.Set("LYRICS", "first line", "second line")
+	assert.Equal("first line\nsecond line", m.Lyrics())
+}
+
+func TestReadCuesheetBlock(z *testing.T) {
+	assert := assert.New(z)
+
+	var buf bytes.Buffer
+	mcn := make([]byte, 128)
+	copy(mcn, "1234567890123")
+	buf.Write(mcn)
+	binary.Write(&buf, binary.BigEndian, uint64(88200))
+	buf.Write(append([]byte{0x80}, make([]byte, 258)...))
+	buf.WriteByte(3)
+	track := func(offset uint64, num uint8, isrc string, flags byte, indices ...uint64) {
+		binary.Write(&buf, binary.BigEndian, offset)
+		buf.WriteByte(num)
+		p := make([]byte, 12)
+		copy(p, isrc)
+		buf.Write(p)
+		buf.Write(append([]byte{flags}, make([]byte, 13)...))
+		buf.WriteByte(byte(len(indices)))
+		for i, x := range indices {
+			binary.Write(&buf, binary.BigEndian, x)
+			buf.Write([]byte{byte(i), 0, 0, 0})
+		}
+	}
+	track(0, 1, "USABC1600001", 0x00, 0, 588)
+	track(44100, 2, "", 0x40, 0, 1176)
+	track(132300, 170, "", 0x00)
+
+	h := blockHeader(uint32(CueSheetBlock)<<24 | uint32(buf.Len()))
+	cs, err := readCuesheetBlock(&buf, h)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal("1234567890123", cs.MediaCatalogNumber)
+	assert.Equal(uint64(88200), cs.LeadIn)
+	assert.True(cs.IsCD)
+	if !assert.Len(cs.Tracks, 3) {
+		return
+	}
+	assert.Equal("USABC1600001", cs.Tracks[0].ISRC)
+	assert.False(cs.Tracks[0].PreEmphasis)
+	assert.True(cs.Tracks[1].PreEmphasis)
+	assert.True(cs.Tracks[1].IsAudio)
+	assert.Len(cs.Tracks[2].Indices, 0)
+
+	vts := cs.VirtualTracks(44100)
+	if !assert.Len(vts, 2) {
+		return
+	}
+	assert.Equal(uint8(1), vts[0].Number)
+	assert.Equal(uint64(588), vts[0].Start)
+	assert.Equal(uint64(45276-588), vts[0].Samples)
+	assert.Equal(uint64(45276), vts[1].Start)
+	assert.Equal(uint64(132300-45276), vts[1].Samples)
+	assert.Equal(time.Duration(45276)*time.Second/44100, vts[1].Offset)
+
+	_, err = readCuesheetBlock(bytes.NewReader(make([]byte, 300)), h)
+	assert.Equal(ErrUnexpectedEOF, err)
+}
+
+func TestSeekTable(z *testing.T) {
+	assert := assert.New(z)
+	m, err := ReadFileMetadata(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(SeekTable{{0, 0, 4096}}, m.SeekTable())
+	assert.Equal(int64(131806), m.AudioOffset())
+
+	st := SeekTable{
+		{0, 0, 4096},
+		{4096, 9000, 4096},
+		{8192, 17000, 4096},
+		{placeholderPoint, 0, 0},
+	}
+	tests := []struct {
+		Sample uint64
+		Point  SeekPoint
+		Ok     bool
+	}{
+		{0, st[0], true},
+		{4095, st[0], true},
+		{4096, st[1], true},
+		{100000, st[2], true},
+	}
+	for _, t := range tests {
+		p, ok := st.Lookup(t.Sample)
+		assert.Equal(t.Point, p)
+		assert.Equal(t.Ok, ok)
+	}
+	_, ok := SeekTable{{4096, 9000, 4096}}.Lookup(10)
+	assert.False(ok)
+	assert.True(st[3].IsPlaceholder())
+
+	m.seek = st
+	off, first := m.SeekTime(150 * time.Millisecond) // sample 6615
+	assert.Equal(int64(131806+9000), off)
+	assert.Equal(uint64(4096), first)
+}
+
+func TestReadApplicationBlock(z *testing.T) {
+	assert := assert.New(z)
+
+	tests := []struct {
+		In       []byte
+		ID       ApplicationID
+		Value    interface{}
+		Err      error
+		ValueErr error
+	}{
+		{[]byte("riffRIFF\x24\x00\x01\x00WAVE"), RIFFApplication, []ForeignChunk{{"RIFF", 0x10024, []byte("WAVE")}}, nil, nil},
+		{[]byte("riffLIST\x03\x00\x00\x00abc\x00data\x00\x10\x00\x00"), RIFFApplication, []ForeignChunk{{"LIST", 3, []byte("abc")}, {"data", 0x1000, []byte{}}}, nil, nil},
+		{[]byte("aiffCOMT\x00\x00\x00\x02ab"), AIFFApplication, []ForeignChunk{{"COMT", 2, []byte("ab")}}, nil, nil},
+		{[]byte("riffLIST\x10\x00\x00\x00abc"), RIFFApplication, nil, nil, ErrInvalidStream},
+		{[]byte("xyzwdata"), ApplicationID("xyzw"), nil, nil, nil},
+		{[]byte("xyz"), "", nil, ErrInvalidStream, nil},
+	}
+	for _, t := range tests {
+		h := blockHeader(uint32(ApplicationBlock)<<24 | uint32(len(t.In)))
+		a, err := readApplicationBlock(bytes.NewReader(t.In), h)
+		assert.Equal(t.Err, err)
+		if err != nil {
+			continue
+		}
+		assert.Equal(t.ID, a.ID)
+		assert.Equal(t.In[4:], a.Data)
+		assert.Equal(t.ValueErr, a.Err)
+		if t.Value == nil {
+			assert.Nil(a.Value)
+		} else {
+			assert.Equal(t.Value, a.Value)
+		}
+	}
+}
+
+func TestReadPictureBlockLengths(z *testing.T) {
+	tests := [][]byte{
+		// MIME type length larger than the block
+		[]byte("\x00\x00\x00\x03\xFF\xFF\xFF\xFFimage/png"),
+		// description length larger than the rest of the block
+		[]byte("\x00\x00\x00\x03\x00\x00\x00\x03png\x7F\xFF\xFF\xFFabc"),
+		// data length larger than the rest of the block
+		[]byte("\x00\x00\x00\x03\x00\x00\x00\x00\x00\x00\x00\x00" +
+			"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+			"\x00\x00\x01\x00ab"),
+	}
+	for i, in := range tests {
+		h := blockHeader(uint32(PictureBlock)<<24 | uint32(len(in)))
+		if _, err := readPictureBlock(bytes.NewReader(in), h); err != ErrInvalidStream {
+			z.Errorf("test %d: got error %v, want %v", i, err, ErrInvalidStream)
+		}
+	}
+}
+
+// countingReader counts the bytes that are read.
+type countingReader struct {
+	io.ReadSeeker
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestReadMetadataBlocks(z *testing.T) {
+	assert := assert.New(z)
+	data, err := ioutil.ReadFile(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+	all, err := ReadMetadata(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+
+	// Seeking past the pictures and padding
+	r := &countingReader{ReadSeeker: bytes.NewReader(data)}
+	m, err := ReadMetadataBlocks(r, LoadVorbisComment)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.True(r.n < 1024, "read %d bytes", r.n)
+	assert.Equal(all.Raw(), m.Raw())
+	assert.Equal(all.StreamInfo(), m.StreamInfo())
+	assert.Equal(all.AudioOffset(), m.AudioOffset())
+	assert.Nil(m.Pictures())
+	assert.Nil(m.SeekTable())
+
+	// Reading past the blocks
+	m, err = ReadMetadataBlocks(bytes.NewBuffer(data), LoadPicture)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Nil(m.Raw())
+	assert.Equal(all.Pictures(), m.Pictures())
+
+	m, err = ReadMetadataAt(bytes.NewReader(data), LoadSeekTable)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(all.SeekTable(), m.SeekTable())
+	assert.Equal(all.AudioOffset(), m.AudioOffset())
+
+	_, err = ReadMetadataBlocks(bytes.NewBuffer(data[:300]), LoadSeekTable)
+	assert.True(errors.Is(err, ErrUnexpectedEOF))
+
+	// The last block is truncated, whether it is skipped by seeking or by
+	// reading.
+	short := data[:all.AudioOffset()-1]
+	_, err = ReadMetadataBlocks(bytes.NewReader(short), LoadVorbisComment)
+	assert.True(errors.Is(err, ErrUnexpectedEOF))
+	_, err = ReadMetadataBlocks(bytes.NewBuffer(short), LoadVorbisComment)
+	assert.True(errors.Is(err, ErrUnexpectedEOF))
+	m, err = ReadMetadataLenient(bytes.NewReader(short), LoadVorbisComment)
+	if assert.Nil(err) {
+		assert.Len(m.Problems(), 1)
+	}
+
+	// The last block is a picture that is not loaded.
+	pic := append(append([]byte(nil), data[:42]...), 0x80|byte(PictureBlock), 0, 0x03, 0xE8)
+	pic = append(pic, make([]byte, 10)...)
+	for _, r := range []io.Reader{bytes.NewReader(pic), bytes.NewBuffer(pic)} {
+		_, err = ReadMetadataBlocks(r, LoadVorbisComment)
+		assert.True(errors.Is(err, ErrUnexpectedEOF))
+	}
+}
+
+func TestParseError(z *testing.T) {
+	assert := assert.New(z)
+	data, err := ioutil.ReadFile(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+	all, err := ReadMetadata(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+
+	// The blocks are: STREAMINFO at 4, SEEKTABLE at 42, VORBIS_COMMENT at 64,
+	// and the pictures and padding after that.
+	_, err = ReadMetadata(bytes.NewReader([]byte("RIFF")))
+	var pe *ParseError
+	if assert.True(errors.As(err, &pe)) {
+		assert.Equal(-1, pe.Index)
+		assert.True(errors.Is(err, ErrInvalidStream))
+	}
+
+	_, err = ReadMetadata(bytes.NewReader(data[:50]))
+	if assert.True(errors.As(err, &pe)) {
+		assert.Equal(ParseError{Offset: 42, Block: SeekTableBlock, Index: 1, Err: ErrUnexpectedEOF}, *pe)
+		assert.Equal("SEEKTABLE block 1 at offset 42: unexpected EOF", pe.Error())
+	}
+
+	// Corrupt the vorbis comment vendor length, so that it extends beyond the block.
+	bad := append([]byte(nil), data...)
+	bad[71] = 0x7F
+	_, err = ReadMetadata(bytes.NewReader(bad))
+	if assert.True(errors.As(err, &pe)) {
+		assert.Equal(ParseError{Offset: 64, Block: VorbisCommentBlock, Index: 2, Err: ErrInvalidStream}, *pe)
+	}
+
+	m, err := ReadMetadataLenient(bytes.NewReader(bad), LoadAll)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Len(m.Problems(), 1)
+	assert.Nil(m.Raw())
+	assert.Equal(all.Pictures(), m.Pictures())
+	assert.Equal(all.AudioOffset(), m.AudioOffset())
+
+	m, err = ReadMetadataLenient(bytes.NewReader(data[:1000]), LoadAll)
+	if !assert.Nil(err) {
+		return
+	}
+	if assert.Len(m.Problems(), 1) {
+		assert.True(errors.Is(m.Problems()[0], ErrUnexpectedEOF))
+		assert.Equal(PictureBlock, m.Problems()[0].Block)
+	}
+	assert.Equal(all.Raw(), m.Raw())
+	assert.Nil(m.Pictures())
+
+	_, err = ReadMetadataLenient(bytes.NewReader(data[:20]), LoadAll)
+	assert.True(errors.Is(err, ErrUnexpectedEOF))
+
+	// The header of the block after the stream info is missing.
+	m, err = ReadMetadataLenient(bytes.NewReader(data[:42]), LoadAll)
+	if assert.Nil(err) && assert.Len(m.Problems(), 1) {
+		assert.Equal("block 1 at offset 42: unexpected EOF", m.Problems()[0].Error())
+	}
+
+	// A reader that fails is not taken for a truncated stream.
+	errRead := errors.New("read failed")
+	_, err = ReadMetadataLenient(io.MultiReader(bytes.NewReader(data[:42]), &failingReader{errRead}), LoadAll)
+	if assert.True(errors.As(err, &pe)) {
+		assert.Equal(ParseError{Offset: 42, Block: -1, Index: 1, Err: errRead}, *pe)
+	}
+}
+
+// failingReader fails every read with err.
+type failingReader struct{ err error }
+
+func (r *failingReader) Read(p []byte) (int, error) { return 0, r.err }
@@ -6,44 +6,93 @@ package flac
 
 import (
 	"io"
+	"io/ioutil"
 	"unsafe"
 )
 
 // TODO: We should see if this is all really as performant as
 // I think it is...
 
+// eof returns ErrUnexpectedEOF if err is due to the end of the stream, and
+// otherwise err, so that the failure of a reader is not taken for a
+// truncated stream.
+func eof(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrUnexpectedEOF
+	}
+	return err
+}
+
 func readBytes(r io.Reader, n int) ([]byte, error) {
 	buf := make([]byte, n)
-	n, err := r.Read(buf)
-	if n != n || err != nil {
-		return nil, ErrUnexpectedEOF
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return nil, eof(err)
 	}
 	return buf, nil
 }
 
+// skipBytes discards the next n bytes of r, by seeking if possible. The size
+// of r is given if it is known, or -1, so that skipping past the end of a
+// seekable stream is ErrUnexpectedEOF, the same as when the bytes are read.
+func skipBytes(r io.Reader, n, size int64) error {
+	if s, ok := r.(io.Seeker); ok && size >= 0 {
+		if pos, err := s.Seek(0, io.SeekCurrent); err == nil {
+			if pos+n > size {
+				return ErrUnexpectedEOF
+			}
+			if _, err := s.Seek(n, io.SeekCurrent); err == nil {
+				return nil
+			}
+		}
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, n); err != nil {
+		return eof(err)
+	}
+	return nil
+}
+
+// streamSize returns the size of r if it is an io.Seeker, and otherwise -1.
+// The position of r is not changed.
+func streamSize(r io.Reader) int64 {
+	s, ok := r.(io.Seeker)
+	if !ok {
+		return -1
+	}
+	pos, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	size, err := s.Seek(0, io.SeekEnd)
+	if _, serr := s.Seek(pos, io.SeekStart); err != nil || serr != nil {
+		return -1
+	}
+	return size
+}
+
 func readString(r io.Reader, n int) (string, error) {
 	buf := make([]byte, n)
-	rn, err := r.Read(buf)
+	rn, err := io.ReadFull(r, buf)
 	if rn != n || err != nil {
-		return "", ErrUnexpectedEOF
+		return "", eof(err)
 	}
 	return string(buf), nil
 }
 
 func readUint8(r io.Reader) (uint8, error) {
 	buf := make([]byte, 1)
-	n, err := r.Read(buf)
+	n, err := io.ReadFull(r, buf)
 	if n != 1 || err != nil {
-		return 0, ErrUnexpectedEOF
+		return 0, eof(err)
 	}
 	return uint8(buf[0]), nil
 }
 
 func readUint16(r io.Reader) (uint16, error) {
 	buf := make([]byte, 2)
-	n, err := r.Read(buf)
+	n, err := io.ReadFull(r, buf)
 	if n != 2 || err != nil {
-		return 0, ErrUnexpectedEOF
+		return 0, eof(err)
 	}
 	buf[0], buf[1] = buf[1], buf[0]
 	return *(*uint16)(unsafe.Pointer(&buf[0])), nil
@@ -51,9 +100,9 @@ func readUint16(r io.Reader) (uint16, error) {
 
 func readUint24(r io.Reader) (uint32, error) {
 	buf := make([]byte, 4)
-	n, err := r.Read(buf[1:])
+	n, err := io.ReadFull(r, buf[1:])
 	if n != 3 || err != nil {
-		return 0, ErrUnexpectedEOF
+		return 0, eof(err)
 	}
 	buf[0], buf[1], buf[2], buf[3] = buf[3], buf[2], buf[1], buf[0]
 	return *(*uint32)(unsafe.Pointer(&buf[0])), nil
@@ -61,9 +110,9 @@ func readUint24(r io.Reader) (uint32, error) {
 
 func readUint32(r io.Reader) (uint32, error) {
 	buf := make([]byte, 4)
-	n, err := r.Read(buf)
+	n, err := io.ReadFull(r, buf)
 	if n != 4 || err != nil {
-		return 0, ErrUnexpectedEOF
+		return 0, eof(err)
 	}
 	buf[0], buf[1], buf[2], buf[3] = buf[3], buf[2], buf[1], buf[0]
 	return *(*uint32)(unsafe.Pointer(&buf[0])), nil
@@ -71,9 +120,9 @@ func readUint32(r io.Reader) (uint32, error) {
 
 func readUint48(r io.Reader) (uint64, error) {
 	buf := make([]byte, 8)
-	n, err := r.Read(buf[2:])
+	n, err := io.ReadFull(r, buf[2:])
 	if n != 6 || err != nil {
-		return 0, ErrUnexpectedEOF
+		return 0, eof(err)
 	}
 	buf[0], buf[1], buf[2], buf[3], buf[4], buf[5], buf[6], buf[7] = buf[7], buf[6], buf[5], buf[4], buf[3], buf[2], buf[1], buf[0]
 	return *(*uint64)(unsafe.Pointer(&buf[0])), nil
@@ -81,9 +130,9 @@ func readUint48(r io.Reader) (uint64, error) {
 
 func readUint64(r io.Reader) (uint64, error) {
 	buf := make([]byte, 8)
-	n, err := r.Read(buf)
+	n, err := io.ReadFull(r, buf)
 	if n != 8 || err != nil {
-		return 0, ErrUnexpectedEOF
+		return 0, eof(err)
 	}
 	buf[0], buf[1], buf[2], buf[3], buf[4], buf[5], buf[6], buf[7] = buf[7], buf[6], buf[5], buf[4], buf[3], buf[2], buf[1], buf[0]
 	return *(*uint64)(unsafe.Pointer(&buf[0])), nil
@@ -91,45 +140,65 @@ func readUint64(r io.Reader) (uint64, error) {
 
 func readUint16LE(r io.Reader) (uint16, error) {
 	buf := make([]byte, 2)
-	n, err := r.Read(buf)
+	n, err := io.ReadFull(r, buf)
 	if n != 2 || err != nil {
-		return 0, ErrUnexpectedEOF
+		return 0, eof(err)
 	}
 	return *(*uint16)(unsafe.Pointer(&buf[0])), nil
 }
 
 func readUint24LE(r io.Reader) (uint32, error) {
 	buf := make([]byte, 4)
-	n, err := r.Read(buf[1:])
+	n, err := io.ReadFull(r, buf[1:])
 	if n != 3 || err != nil {
-		return 0, ErrUnexpectedEOF
+		return 0, eof(err)
 	}
 	return *(*uint32)(unsafe.Pointer(&buf[0])), nil
 }
 
 func readUint32LE(r io.Reader) (uint32, error) {
 	buf := make([]byte, 4)
-	n, err := r.Read(buf)
+	n, err := io.ReadFull(r, buf)
 	if n != 4 || err != nil {
-		return 0, ErrUnexpectedEOF
+		return 0, eof(err)
 	}
 	return *(*uint32)(unsafe.Pointer(&buf[0])), nil
 }
 
 func readUint48LE(r io.Reader) (uint64, error) {
 	buf := make([]byte, 8)
-	n, err := r.Read(buf[2:])
+	n, err := io.ReadFull(r, buf[2:])
 	if n != 6 || err != nil {
-		return 0, ErrUnexpectedEOF
+		return 0, eof(err)
 	}
 	return *(*uint64)(unsafe.Pointer(&buf[0])), nil
 }
 
 func readUint64LE(r io.Reader) (uint64, error) {
 	buf := make([]byte, 8)
-	n, err := r.Read(buf)
+	n, err := io.ReadFull(r, buf)
 	if n != 8 || err != nil {
-		return 0, ErrUnexpectedEOF
+		return 0, eof(err)
 	}
 	return *(*uint64)(unsafe.Pointer(&buf[0])), nil
 }
+
+func appendUint16(p []byte, v uint16) []byte {
+	return append(p, byte(v>>8), byte(v))
+}
+
+func appendUint24(p []byte, v uint32) []byte {
+	return append(p, byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint32(p []byte, v uint32) []byte {
+	return append(p, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(p []byte, v uint64) []byte {
+	return appendUint32(appendUint32(p, uint32(v>>32)), uint32(v))
+}
+
+func appendUint32LE(p []byte, v uint32) []byte {
+	return append(p, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
@@ -0,0 +1,205 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package flac
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io"
+	"os"
+	"time"
+
+	"github.com/goulash/audio"
+)
+
+// OpVerify is the operation that Verify records in audio.DefaultMetrics.
+const OpVerify = "verify"
+
+// VerifyReport is the result of verifying a FLAC stream.
+type VerifyReport struct {
+	// StreamInfo is the stream info of the verified stream.
+	StreamInfo *StreamInfo
+
+	// Frames is the number of frames that could be decoded, and Samples
+	// is the number of inter-channel samples in these frames.
+	Frames  int
+	Samples uint64
+
+	// MD5Sum is the MD5 signature of the decoded audio data.
+	MD5Sum []byte
+
+	// Corrupt contains the corrupt regions of the stream in order.
+	Corrupt []CorruptFrame
+}
+
+// MD5Unset returns true if the stream info does not contain an MD5
+// signature, in which case the audio data cannot be compared.
+func (r *VerifyReport) MD5Unset() bool {
+	for _, c := range r.StreamInfo.MD5Sum {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MD5Match returns true if the MD5 signature of the decoded audio data
+// matches the signature in the stream info.
+func (r *VerifyReport) MD5Match() bool {
+	return bytes.Equal(r.StreamInfo.MD5Sum, r.MD5Sum)
+}
+
+// OK returns true if no corrupt frames were found, the expected number of
+// samples was decoded, and the MD5 signature matches (if it is set).
+func (r *VerifyReport) OK() bool {
+	if len(r.Corrupt) != 0 {
+		return false
+	}
+	if r.StreamInfo.TotalSamples != 0 && r.StreamInfo.TotalSamples != r.Samples {
+		return false
+	}
+	return r.MD5Unset() || r.MD5Match()
+}
+
+// CorruptFrame is a region of the stream that is corrupt. This is either a
+// single frame whose CRC-16 does not match, or a region that could not be
+// decoded at all, which extends up to the next frame that could be found.
+type CorruptFrame struct {
+	// Offset is the position of the region in bytes, and Size is its length.
+	Offset int64
+	Size   int64
+
+	// FirstSample is the first sample in the region, and Samples is the
+	// number of inter-channel samples that the region covers.
+	FirstSample uint64
+	Samples     uint64
+
+	// Start and End are the time range that the region covers.
+	Start time.Duration
+	End   time.Duration
+
+	// Err is the reason why the region is corrupt: ErrChecksum if a CRC
+	// does not match, ErrInvalidStream if a frame could not be decoded, and
+	// ErrUnexpectedEOF if the stream is truncated.
+	Err error
+}
+
+// VerifyFile verifies the FLAC file at path; see Verify.
+func VerifyFile(path string) (*VerifyReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Verify(f)
+}
+
+// Verify decodes the entire stream, checks the CRC-8 and CRC-16 of every
+// frame, and calculates the MD5 signature of the decoded audio data.
+// When a frame cannot be decoded, the stream is searched for the next frame,
+// which is why r needs to be seekable.
+//
+// An error is only returned if the metadata cannot be read or if reading
+// fails; corrupt frames are listed in the report.
+func Verify(r io.ReadSeeker) (*VerifyReport, error) {
+	start := time.Now()
+	rep, err := verify(r)
+	addStat(&Stats.Verify, start)
+	audio.DefaultMetrics.Observe(audio.FLAC, OpVerify, time.Since(start), err)
+	return rep, err
+}
+
+func verify(r io.ReadSeeker) (*VerifyReport, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	si := d.StreamInfo()
+	rep := &VerifyReport{StreamInfo: si}
+
+	var (
+		h    = md5.New()
+		buf  []byte
+		bad  *CorruptFrame // the current region of undecodable frames
+		next uint64        // the next sample that is expected
+	)
+	for {
+		off := d.br.offset()
+		f, err := d.ReadFrame()
+		if f != nil {
+			if bad != nil {
+				rep.addRegion(bad, f.Offset, f.FirstSample)
+				bad = nil
+			}
+			if err == ErrChecksum {
+				rep.addRegion(&CorruptFrame{
+					Offset:      f.Offset,
+					FirstSample: f.FirstSample,
+					Err:         err,
+				}, f.Offset+f.Size, f.FirstSample+uint64(f.BlockSize))
+			}
+			rep.Frames++
+			rep.Samples += uint64(f.BlockSize)
+			next = f.FirstSample + uint64(f.BlockSize)
+			buf = appendPCM(buf[:0], f.Channels, int(f.BlockSize), f.BitsPerSample)
+			h.Write(buf)
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != ErrChecksum && err != ErrInvalidStream && err != ErrUnexpectedEOF {
+			return nil, err
+		}
+
+		if bad == nil {
+			bad = &CorruptFrame{Offset: off, FirstSample: next, Err: err}
+		}
+		if err := d.resync(r, off+1); err != nil {
+			if err == ErrUnexpectedEOF {
+				break
+			}
+			return nil, err
+		}
+	}
+
+	if bad != nil {
+		// The corrupt region extends to the end of the stream.
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		last := bad.FirstSample
+		if si.TotalSamples > last {
+			last = si.TotalSamples
+		}
+		rep.addRegion(bad, end, last)
+	}
+	rep.MD5Sum = h.Sum(nil)
+	return rep, nil
+}
+
+// addRegion completes the region c, which ends before the given byte offset
+// and sample, and adds it to the report.
+func (r *VerifyReport) addRegion(c *CorruptFrame, offset int64, sample uint64) {
+	c.Size = offset - c.Offset
+	if sample > c.FirstSample {
+		c.Samples = sample - c.FirstSample
+	}
+	c.Start = samplesToDuration(c.FirstSample, r.StreamInfo.SampleRate)
+	c.End = samplesToDuration(c.FirstSample+c.Samples, r.StreamInfo.SampleRate)
+	r.Corrupt = append(r.Corrupt, *c)
+}
+
+// resync positions the decoder at the next frame sync code after offset.
+func (d *Decoder) resync(r io.ReadSeeker, offset int64) error {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	d.br = newBitReader(r)
+	d.br.bytes = offset
+	d.pos = 0
+	return d.br.sync()
+}
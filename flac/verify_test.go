@@ -0,0 +1,110 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package flac
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(z *testing.T) {
+	assert := assert.New(z)
+
+	rep, err := VerifyFile(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.True(rep.OK())
+	assert.True(rep.MD5Match())
+	assert.Equal(5, rep.Frames)
+	assert.Equal(uint64(16536), rep.Samples)
+	assert.Len(rep.Corrupt, 0)
+
+	data, err := ioutil.ReadFile(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+	m, err := ReadMetadata(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+
+	// Find the frame offsets by decoding
+	d, err := NewDecoder(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+	var frames []FrameHeader
+	for {
+		f, err := d.ReadFrame()
+		if err != nil {
+			break
+		}
+		frames = append(frames, f.FrameHeader)
+	}
+	if !assert.Len(frames, 5) {
+		return
+	}
+	assert.Equal(m.AudioOffset(), frames[0].Offset)
+
+	corrupt := func(f func(p []byte) []byte) *VerifyReport {
+		p := append([]byte(nil), data...)
+		rep, err := Verify(bytes.NewReader(f(p)))
+		if !assert.Nil(err) {
+			return nil
+		}
+		assert.False(rep.OK())
+		return rep
+	}
+
+	// A flipped bit in the audio data of the second frame
+	rep = corrupt(func(p []byte) []byte {
+		p[frames[1].Offset+frames[1].Size/2] ^= 0x10
+		return p
+	})
+	if assert.Len(rep.Corrupt, 1) {
+		c := rep.Corrupt[0]
+		assert.Equal(ErrChecksum, c.Err)
+		assert.Equal(frames[1].Offset, c.Offset)
+		assert.Equal(frames[1].Size, c.Size)
+		assert.Equal(uint64(4096), c.FirstSample)
+		assert.Equal(uint64(4096), c.Samples)
+		assert.Equal(time.Duration(4096)*time.Second/44100, c.Start)
+	}
+	assert.Equal(5, rep.Frames)
+	assert.False(rep.MD5Match())
+
+	// A broken header of the third frame
+	rep = corrupt(func(p []byte) []byte {
+		p[frames[2].Offset+2] ^= 0xFF
+		return p
+	})
+	if assert.Len(rep.Corrupt, 1) {
+		c := rep.Corrupt[0]
+		assert.Equal(frames[2].Offset, c.Offset)
+		assert.Equal(frames[2].Size, c.Size)
+		assert.Equal(uint64(8192), c.FirstSample)
+		assert.Equal(uint64(4096), c.Samples)
+	}
+	assert.Equal(4, rep.Frames)
+
+	// A truncated file
+	rep = corrupt(func(p []byte) []byte {
+		return p[:frames[4].Offset+10]
+	})
+	if assert.Len(rep.Corrupt, 1) {
+		c := rep.Corrupt[0]
+		assert.Equal(ErrUnexpectedEOF, c.Err)
+		assert.Equal(frames[4].Offset, c.Offset)
+		assert.Equal(int64(10), c.Size)
+		assert.Equal(uint64(16384), c.FirstSample)
+		assert.Equal(uint64(152), c.Samples)
+	}
+	assert.Equal(uint64(16384), rep.Samples)
+}
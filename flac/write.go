@@ -0,0 +1,426 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package flac
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/goulash/audio"
+	"github.com/goulash/audio/vorbis"
+)
+
+// DefaultPadding is the number of bytes of padding that is written after
+// the metadata blocks when a file has to be rewritten, so that later
+// changes can be written in place.
+const DefaultPadding = 8192
+
+var ErrBlockTooLarge = errors.New("metadata block too large")
+
+// maxBlockSize is the largest size of the data of a metadata block.
+const maxBlockSize = 0xFFFFFF
+
+// Metadata Mutation {{{
+
+// Set sets the Vorbis comment key to the given values, replacing any
+// existing values. If no values are given, the key is removed.
+func (m *Metadata) Set(key string, values ...string) {
+	key = strings.ToLower(key)
+	if len(values) == 0 {
+		delete(m.raw, key)
+		m.loaded |= LoadVorbisComment
+		return
+	}
+	if m.raw == nil {
+		m.raw = make(map[string][]string)
+	}
+	m.raw[key] = values
+	m.loaded |= LoadVorbisComment
+}
+
+// Add appends a value to the Vorbis comment key.
+func (m *Metadata) Add(key, value string) {
+	key = strings.ToLower(key)
+	m.Set(key, append(m.raw[key], value)...)
+}
+
+// Del removes the Vorbis comment key.
+func (m *Metadata) Del(key string) { m.Set(key) }
+
+var _ = audio.MutableMetadata(new(Metadata))
+
+func (m *Metadata) SetTitle(s string)       { m.sstr("title", s) }
+func (m *Metadata) SetAlbum(s string)       { m.sstr("album", s) }
+func (m *Metadata) SetArtist(s string)      { m.sstr("artist", s) }
+func (m *Metadata) SetAlbumArtist(s string) { m.sstr("albumartist", s) }
+func (m *Metadata) SetComposer(s string)    { m.sstr("composer", s) }
+func (m *Metadata) SetYear(n int)           { m.sint("date", n) }
+func (m *Metadata) SetGenre(s string)       { m.sstr("genre", s) }
+func (m *Metadata) SetComment(s string)     { m.sstr("description", s) }
+func (m *Metadata) SetCopyright(s string)   { m.sstr("copyright", s) }
+func (m *Metadata) SetWebsite(s string)     { m.sstr("contact", s) }
+func (m *Metadata) SetEncodedBy(s string)   { m.sstr("encoded-by", s) }
+
+func (m *Metadata) SetTrack(n, total int) {
+	m.sint("tracknumber", n)
+	m.sint("tracktotal", total)
+}
+
+func (m *Metadata) SetDisc(n, total int) {
+	m.sint("discnumber", n)
+	m.sint("disctotal", total)
+}
+
+// sstr sets the key to s, or removes it if s is empty.
+func (m *Metadata) sstr(key, s string) {
+	if s == "" {
+		m.Del(key)
+		return
+	}
+	m.Set(key, s)
+}
+
+// sint sets the key to n, or removes it if n is 0.
+func (m *Metadata) sint(key string, n int) {
+	if n == 0 {
+		m.Del(key)
+		return
+	}
+	m.Set(key, strconv.Itoa(n))
+}
+
+func (m *Metadata) SetPictures(ps []Picture) {
+	m.pics = ps
+	m.loaded |= LoadPicture
+}
+
+func (m *Metadata) SetCueSheet(cs *CueSheet) {
+	m.cue = cs
+	m.loaded |= LoadCueSheet
+}
+
+func (m *Metadata) SetSeekTable(st SeekTable) {
+	m.seek = st
+	m.loaded |= LoadSeekTable
+}
+
+func (m *Metadata) SetApplications(as []Application) {
+	m.apps = as
+	m.loaded |= LoadApplication
+}
+
+// }}}
+
+// WriteFileMetadata writes the metadata blocks of m to the FLAC file at path,
+// replacing the metadata blocks that are there. The audio frames are not
+// touched.
+//
+// Blocks that were neither loaded (see ReadMetadataBlocks) nor set in m are
+// kept as they are in the file.
+//
+// If the new metadata blocks fit in the space taken up by the old blocks,
+// including padding, only this region of the file is rewritten and the
+// remaining space is filled with padding. Otherwise, the file is rewritten
+// to a temporary file with DefaultPadding bytes of padding, which then
+// replaces the original file.
+func WriteFileMetadata(path string, m *Metadata) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	cur, err := ReadMetadataBlocks(f, ^m.loaded)
+	if err != nil {
+		return err
+	}
+	buf, err := encodeMetadata(mergeMetadata(m, cur))
+	if err != nil {
+		return err
+	}
+
+	// Write in place if there is exactly enough space, or enough space
+	// for a padding block, which has a header of 4 bytes.
+	size := int64(len(buf))
+	if free := cur.bytes - size; free == 0 || free >= 4 {
+		buf = markLastBlock(appendPadding(buf, free))
+		if _, err := f.WriteAt(buf, 0); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		if m.fsize != 0 {
+			m.fsize = fi.Size()
+		}
+		m.bytes = cur.bytes
+		return nil
+	}
+
+	buf = markLastBlock(appendPaddingBlock(buf, DefaultPadding))
+	if err := rewriteFile(f, buf, cur.bytes); err != nil {
+		return err
+	}
+	if m.fsize != 0 {
+		m.fsize = fi.Size() + int64(len(buf)) - cur.bytes
+	}
+	m.bytes = int64(len(buf))
+	return nil
+}
+
+// rewriteFile writes the header followed by the rest of f from offset to
+// a temporary file, which then replaces f.
+func rewriteFile(f *os.File, header []byte, offset int64) (err error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	path := f.Name()
+	t, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			t.Close()
+			os.Remove(t.Name())
+		}
+	}()
+
+	if _, err = t.Write(header); err != nil {
+		return err
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err = io.Copy(t, f); err != nil {
+		return err
+	}
+	if err = t.Chmod(fi.Mode()); err != nil {
+		return err
+	}
+	if err = t.Sync(); err != nil {
+		return err
+	}
+	if err = t.Close(); err != nil {
+		return err
+	}
+	return os.Rename(t.Name(), path)
+}
+
+// mergeMetadata returns a copy of m with the blocks that are not loaded
+// taken from cur.
+func mergeMetadata(m, cur *Metadata) *Metadata {
+	x := *m
+	if m.loaded&LoadApplication == 0 {
+		x.apps = cur.apps
+	}
+	if m.loaded&LoadSeekTable == 0 {
+		x.seek = cur.seek
+	}
+	if m.loaded&LoadVorbisComment == 0 {
+		x.raw = cur.raw
+	}
+	if m.loaded&LoadCueSheet == 0 {
+		x.cue = cur.cue
+	}
+	if m.loaded&LoadPicture == 0 {
+		x.pics = cur.pics
+	}
+	if m.loaded&LoadUnknown == 0 {
+		x.other = cur.other
+	}
+	return &x
+}
+
+// WriteMetadata writes the stream marker and the metadata blocks of m to w,
+// followed by a padding block of the given size if padding is positive.
+// The audio frames can be written directly afterwards.
+func WriteMetadata(w io.Writer, m *Metadata, padding int) error {
+	buf, err := encodeMetadata(m)
+	if err != nil {
+		return err
+	}
+	if padding > 0 {
+		buf = appendPaddingBlock(buf, padding)
+	}
+	_, err = w.Write(markLastBlock(buf))
+	return err
+}
+
+// encodeMetadata returns the stream marker and all metadata blocks of m,
+// except padding. The last block is not marked as such.
+func encodeMetadata(m *Metadata) ([]byte, error) {
+	if m.info == nil {
+		return nil, ErrInvalidStream
+	}
+
+	buf := []byte("fLaC")
+	blocks := []rawBlock{
+		{StreamInfoBlock, encodeStreamInfoBlock(m.info)},
+	}
+	add := func(typ BlockType, data []byte) {
+		blocks = append(blocks, rawBlock{typ, data})
+	}
+	if len(m.seek) > 0 {
+		add(SeekTableBlock, encodeSeekTableBlock(m.seek))
+	}
+	if m.raw != nil {
+		add(VorbisCommentBlock, vorbis.Comments(m.raw).Bytes())
+	}
+	if m.cue != nil {
+		add(CueSheetBlock, encodeCuesheetBlock(m.cue))
+	}
+	for i := range m.apps {
+		add(ApplicationBlock, encodeApplicationBlock(&m.apps[i]))
+	}
+	for i := range m.pics {
+		add(PictureBlock, encodePictureBlock(&m.pics[i]))
+	}
+	blocks = append(blocks, m.other...)
+
+	for _, b := range blocks {
+		if len(b.data) > maxBlockSize {
+			return nil, ErrBlockTooLarge
+		}
+		buf = append(buf, byte(b.typ))
+		buf = appendUint24(buf, uint32(len(b.data)))
+		buf = append(buf, b.data...)
+	}
+	return buf, nil
+}
+
+// markLastBlock sets the last-metadata-block flag on the last block in buf,
+// which must start with the stream marker.
+func markLastBlock(buf []byte) []byte {
+	i := 4
+	for {
+		n := 4 + int(uint32(buf[i+1])<<16|uint32(buf[i+2])<<8|uint32(buf[i+3]))
+		if i+n >= len(buf) {
+			break
+		}
+		i += n
+	}
+	buf[i] |= 0x80
+	return buf
+}
+
+// appendPadding appends padding blocks that take up exactly n bytes, which
+// must be 0 or at least 4. Since the size of a block is limited to 24 bits,
+// large amounts of padding are split into several blocks.
+func appendPadding(buf []byte, n int64) []byte {
+	for n > 0 {
+		k := n - 4
+		if k > maxBlockSize {
+			k = maxBlockSize
+			if r := n - 4 - k; r < 4 {
+				// Leave enough space for the header of the next block.
+				k -= 4
+			}
+		}
+		buf = appendPaddingBlock(buf, int(k))
+		n -= 4 + k
+	}
+	return buf
+}
+
+func appendPaddingBlock(buf []byte, n int) []byte {
+	buf = append(buf, byte(PaddingBlock))
+	buf = appendUint24(buf, uint32(n))
+	return append(buf, make([]byte, n)...)
+}
+
+func encodeStreamInfoBlock(si *StreamInfo) []byte {
+	p := make([]byte, 0, 34)
+	p = appendUint16(p, si.MinBlockSize)
+	p = appendUint16(p, si.MaxBlockSize)
+	p = appendUint24(p, si.MinFrameSize)
+	p = appendUint24(p, si.MaxFrameSize)
+	x := uint64(si.SampleRate)<<44 |
+		uint64(si.NumChannels-1)<<41 |
+		uint64(si.BitsPerSample-1)<<36 |
+		si.TotalSamples&0x0FFFFFFFFF
+	p = appendUint64(p, x)
+	md5 := make([]byte, 16)
+	copy(md5, si.MD5Sum)
+	return append(p, md5...)
+}
+
+func encodeSeekTableBlock(st SeekTable) []byte {
+	p := make([]byte, 0, 18*len(st))
+	for _, sp := range st {
+		p = appendUint64(p, sp.SampleNumber)
+		p = appendUint64(p, sp.Offset)
+		p = appendUint16(p, sp.FrameSamples)
+	}
+	return p
+}
+
+func encodeCuesheetBlock(cs *CueSheet) []byte {
+	var p []byte
+	p = appendPadded(p, cs.MediaCatalogNumber, 128)
+	p = appendUint64(p, cs.LeadIn)
+	flags := make([]byte, 259)
+	if cs.IsCD {
+		flags[0] = 0x80
+	}
+	p = append(p, flags...)
+	p = append(p, byte(len(cs.Tracks)))
+	for _, t := range cs.Tracks {
+		p = appendUint64(p, t.Offset)
+		p = append(p, t.Number)
+		p = appendPadded(p, t.ISRC, 12)
+		flags := make([]byte, 14)
+		if !t.IsAudio {
+			flags[0] |= 0x80
+		}
+		if t.PreEmphasis {
+			flags[0] |= 0x40
+		}
+		p = append(p, flags...)
+		p = append(p, byte(len(t.Indices)))
+		for _, x := range t.Indices {
+			p = appendUint64(p, x.Offset)
+			p = append(p, x.Number, 0, 0, 0)
+		}
+	}
+	return p
+}
+
+// appendPadded appends s truncated or padded with NUL characters to n bytes.
+func appendPadded(p []byte, s string, n int) []byte {
+	b := make([]byte, n)
+	copy(b, s)
+	return append(p, b...)
+}
+
+func encodeApplicationBlock(a *Application) []byte {
+	p := appendPadded(nil, string(a.ID), 4)
+	return append(p, a.Data...)
+}
+
+func encodePictureBlock(pic *Picture) []byte {
+	var p []byte
+	p = appendUint32(p, uint32(pic.Type))
+	p = appendUint32(p, uint32(len(pic.MIMEType)))
+	p = append(p, pic.MIMEType...)
+	p = appendUint32(p, uint32(len(pic.Description)))
+	p = append(p, pic.Description...)
+	p = appendUint32(p, pic.Width)
+	p = appendUint32(p, pic.Height)
+	p = appendUint32(p, pic.Depth)
+	p = appendUint32(p, pic.Colors)
+	p = appendUint32(p, uint32(len(pic.Data)))
+	return append(p, pic.Data...)
+}
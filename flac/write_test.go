@@ -0,0 +1,242 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package flac
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goulash/audio"
+	"github.com/stretchr/testify/assert"
+)
+
+// copyTestFile copies the test file into a temporary directory.
+func copyTestFile(z *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "flac")
+	if err != nil {
+		z.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(testFile)
+	if err != nil {
+		z.Fatal(err)
+	}
+	path := filepath.Join(dir, testFile)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		z.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestWriteFileMetadata(z *testing.T) {
+	assert := assert.New(z)
+	path, cleanup := copyTestFile(z)
+	defer cleanup()
+
+	orig, err := ioutil.ReadFile(path)
+	if !assert.Nil(err) {
+		return
+	}
+	m, err := ReadFileMetadata(path)
+	if !assert.Nil(err) {
+		return
+	}
+	audio := orig[m.AudioOffset():]
+
+	// Small changes fit into the padding
+	m.Set("title", "Flow Away My Love (Remix)")
+	m.Add("artist", "Ms. Duck")
+	m.Del("contact")
+	if !assert.Nil(WriteFileMetadata(path, m)) {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(len(orig), len(data))
+	assert.Equal(audio, data[m.AudioOffset():])
+
+	n, err := ReadFileMetadata(path)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal("Flow Away My Love (Remix)", n.Title())
+	assert.Equal([]string{"Mr. Duck", "Ms. Duck"}, n.Raw()["artist"])
+	assert.Equal("", n.Website())
+	assert.Equal(m.Raw()["~vendor"], n.Raw()["~vendor"])
+	assert.Equal(m.Pictures(), n.Pictures())
+	assert.Equal(m.SeekTable(), n.SeekTable())
+	assert.Equal(m.StreamInfo(), n.StreamInfo())
+
+	// Large changes require the file to be rewritten
+	ps := append(n.Pictures(), Picture{
+		Type:     PictureBackCover,
+		MIMEType: "image/jpeg",
+		Data:     make([]byte, 20000),
+	})
+	n.SetPictures(ps)
+	if !assert.Nil(WriteFileMetadata(path, n)) {
+		return
+	}
+	data, err = ioutil.ReadFile(path)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(audio, data[n.AudioOffset():])
+
+	o, err := ReadFileMetadata(path)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(n.AudioOffset(), o.AudioOffset())
+	assert.Equal(ps, o.Pictures())
+	assert.Equal(n.Raw(), o.Raw())
+	assert.Equal(n.EncodingBitrate(), o.EncodingBitrate())
+}
+
+func TestWritePartialMetadata(z *testing.T) {
+	assert := assert.New(z)
+	path, cleanup := copyTestFile(z)
+	defer cleanup()
+
+	all, err := ReadFileMetadata(path)
+	if !assert.Nil(err) {
+		return
+	}
+	m, err := ReadFileMetadataBlocks(path, LoadVorbisComment)
+	if !assert.Nil(err) {
+		return
+	}
+	m.Set("title", "Only the Title")
+	if !assert.Nil(WriteFileMetadata(path, m)) {
+		return
+	}
+
+	n, err := ReadFileMetadata(path)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal("Only the Title", n.Title())
+	assert.Equal(all.Pictures(), n.Pictures())
+	assert.Equal(all.SeekTable(), n.SeekTable())
+	assert.Equal(all.AudioOffset(), n.AudioOffset())
+}
+
+func TestWriteLargePadding(z *testing.T) {
+	assert := assert.New(z)
+	path, cleanup := copyTestFile(z)
+	defer cleanup()
+
+	m, err := ReadFileMetadata(path)
+	if !assert.Nil(err) {
+		return
+	}
+	ps := m.Pictures()
+	for i := 0; i < 2; i++ {
+		m.SetPictures(append(m.Pictures(), Picture{
+			Type:     PictureOther,
+			MIMEType: "image/png",
+			Data:     make([]byte, 9<<20),
+		}))
+	}
+	if !assert.Nil(WriteFileMetadata(path, m)) {
+		return
+	}
+
+	// Removing the pictures frees more than a padding block can hold.
+	m.SetPictures(ps)
+	if !assert.Nil(WriteFileMetadata(path, m)) {
+		return
+	}
+	n, err := ReadFileMetadata(path)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(m.AudioOffset(), n.AudioOffset())
+	assert.Equal(ps, n.Pictures())
+	assert.Equal(m.Raw(), n.Raw())
+	assert.Equal(m.StreamInfo(), n.StreamInfo())
+
+	for _, free := range []int64{4, maxBlockSize + 4, maxBlockSize + 5, maxBlockSize + 8, maxBlockSize + 9, 3*maxBlockSize + 20} {
+		buf := appendPadding(nil, free)
+		assert.Equal(free, int64(len(buf)), "free %d", free)
+		for i := 0; i < len(buf); {
+			n := int(buf[i+1])<<16 | int(buf[i+2])<<8 | int(buf[i+3])
+			assert.Equal(byte(PaddingBlock), buf[i])
+			i += 4 + n
+			assert.True(i <= len(buf))
+		}
+	}
+}
+
+func TestWriteChangedFile(z *testing.T) {
+	assert := assert.New(z)
+	path, cleanup := copyTestFile(z)
+	defer cleanup()
+
+	m, err := ReadFileMetadata(path)
+	if !assert.Nil(err) {
+		return
+	}
+
+	// The file is changed after m is read, so that neither the size of the
+	// metadata nor the size of the audio are the sizes that m was read with.
+	o, err := ReadFileMetadata(path)
+	if !assert.Nil(err) {
+		return
+	}
+	o.SetPictures([]Picture{{Type: PictureOther, MIMEType: "image/png", Data: make([]byte, 100000)}})
+	if !assert.Nil(WriteFileMetadata(path, o)) {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if !assert.Nil(err) {
+		return
+	}
+	f.Write(make([]byte, 1000))
+	f.Close()
+
+	m.SetPictures([]Picture{{Type: PictureOther, MIMEType: "image/png", Data: make([]byte, 200000)}})
+	if !assert.Nil(WriteFileMetadata(path, m)) {
+		return
+	}
+	fi, err := os.Stat(path)
+	if assert.Nil(err) {
+		assert.Equal(fi.Size(), m.fsize)
+	}
+}
+
+func TestAudioWriteMetadata(z *testing.T) {
+	assert := assert.New(z)
+	path, cleanup := copyTestFile(z)
+	defer cleanup()
+
+	all, err := ReadFileMetadata(path)
+	if !assert.Nil(err) {
+		return
+	}
+	err = audio.WriteMetadata(path, func(m audio.MutableMetadata) {
+		m.SetTitle("Written Through Audio")
+		m.SetTrack(2, 0)
+		m.SetGenre("")
+	})
+	if !assert.Nil(err) {
+		return
+	}
+
+	n, err := ReadFileMetadata(path)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal("Written Through Audio", n.Title())
+	tn, tt := n.Track()
+	assert.Equal(2, tn)
+	assert.Equal(0, tt)
+	assert.Equal("", n.Genre())
+	assert.Equal(all.Artist(), n.Artist())
+	assert.Equal(all.Pictures(), n.Pictures())
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2016, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package audio
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Format describes an audio format, which is registered with RegisterFormat.
+// Everything except the name, the codec, and a way to identify the format is
+// optional.
+type Format struct {
+	// Name is the name of the format, such as "flac".
+	Name string
+
+	// Codec is the codec that Identify returns for the format.
+	Codec Codec
+
+	// Magic contains the prefixes that identify the format, where each
+	// "?" matches any one byte.
+	Magic []string
+
+	// Sniff identifies the format if none of the magic prefixes match.
+	// It reads from the beginning of the stream in r.
+	Sniff func(r io.ReadSeeker) (bool, error)
+
+	// Extensions contains the file extensions of the format, including
+	// the dot, with the preferred extension first.
+	Extensions []string
+
+	// MIMETypes contains the MIME types of the format, with the preferred
+	// MIME type first.
+	MIMETypes []string
+
+	// ReadMetadata reads the metadata of the stream in r.
+	ReadMetadata func(r io.ReadSeeker) (Metadata, error)
+
+	// WriteMetadata reads the metadata of the file, applies the changes
+	// to it, and writes it back to the file.
+	WriteMetadata func(file string, changes func(MutableMetadata)) error
+
+	// NewDecoder returns a decoder for the stream in r.
+	NewDecoder func(r io.Reader) (Decoder, error)
+}
+
+// Decoder decodes an audio stream into PCM samples.
+type Decoder interface {
+	SampleRate() int    // The number of inter-channel samples per second
+	NumChannels() int   // The number of channels
+	BitsPerSample() int // The number of significant bits of each sample
+
+	// Read reads interleaved samples into p, and returns the number of
+	// samples read. At the end of the stream, it returns 0, io.EOF.
+	Read(p []int32) (int, error)
+}
+
+var (
+	formatsMu     sync.Mutex
+	atomicFormats atomic.Value
+)
+
+// RegisterFormat registers a format for use by Identify, ReadMetadata,
+// WriteMetadata, and NewDecoder. It replaces any format that has been
+// registered for the same codec, so that a package that implements a
+// format can take over from a built-in format. RegisterFormat is usually
+// called by an init function in the package that implements the format.
+//
+// Formats are tried in the order in which they are first registered.
+func RegisterFormat(f Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	formats, _ := atomicFormats.Load().([]Format)
+	formats = append([]Format(nil), formats...)
+	for i := range formats {
+		if formats[i].Codec == f.Codec {
+			formats[i] = f
+			atomicFormats.Store(formats)
+			return
+		}
+	}
+	atomicFormats.Store(append(formats, f))
+}
+
+// lookupFormat returns the format that is registered for the codec.
+func lookupFormat(c Codec) (Format, bool) {
+	formats, _ := atomicFormats.Load().([]Format)
+	for _, f := range formats {
+		if f.Codec == c {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+// match reports whether the magic prefix matches b.
+func match(magic string, b []byte) bool {
+	if len(magic) > len(b) {
+		return false
+	}
+	for i, c := range b[:len(magic)] {
+		if magic[i] != c && magic[i] != '?' {
+			return false
+		}
+	}
+	return true
+}
+
+// identify returns the format of the stream in r, and the offset at which
+// the stream of the format starts. An ID3v2 tag at the beginning is skipped,
+// since some encoders put one in front of formats other than MP3; if nothing
+// is identified after the tags, the stream is identified from the beginning
+// again. Formats that read the ID3v2 tag themselves, which have "ID3" as
+// magic, always start at offset 0.
+//
+// A stream that cannot be identified otherwise but ends with an ID3v1 tag
+// is identified as MP3, since the first frame may come after junk.
+func identify(r io.ReadSeeker) (Format, int64, error) {
+	formats, _ := atomicFormats.Load().([]Format)
+
+	offsets := []int64{0}
+	for {
+		b, err := readHeader(r, offsets[len(offsets)-1], 10)
+		if err != nil {
+			return Format{}, 0, err
+		}
+		if len(b) < 10 || string(b[:3]) != "ID3" {
+			break
+		}
+		offsets = append(offsets, offsets[len(offsets)-1]+id3v2Size(b))
+	}
+
+	for i := len(offsets) - 1; i >= 0; i-- {
+		b, err := readHeader(r, offsets[i], 64)
+		if err != nil {
+			return Format{}, 0, err
+		}
+		for _, f := range formats {
+			ok := false
+			for _, m := range f.Magic {
+				if match(m, b) {
+					ok = true
+					break
+				}
+			}
+			if !ok && f.Sniff != nil {
+				if _, err := r.Seek(offsets[i], io.SeekStart); err != nil {
+					return Format{}, 0, err
+				}
+				ok, err = f.Sniff(&offsetReader{r, offsets[i]})
+				if err != nil {
+					return Format{}, 0, err
+				}
+			}
+			if ok {
+				if readsID3(f) {
+					return f, 0, nil
+				}
+				return f, offsets[i], nil
+			}
+		}
+	}
+
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return Format{}, 0, err
+	}
+	if end >= 128 {
+		b, err := readHeader(r, end-128, 3)
+		if err != nil {
+			return Format{}, 0, err
+		}
+		if string(b) == "TAG" {
+			f, _ := lookupFormat(MP3)
+			return f, 0, nil
+		}
+	}
+	return Format{}, 0, nil
+}
+
+// readsID3 reports whether the format reads a leading ID3v2 tag itself.
+func readsID3(f Format) bool {
+	for _, m := range f.Magic {
+		if m == "ID3" {
+			return true
+		}
+	}
+	return false
+}
+
+// readHeader reads up to n bytes at the offset.
+func readHeader(r io.ReadSeeker, offset int64, n int) ([]byte, error) {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	n, err := io.ReadFull(r, b)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return b[:n], nil
+}
+
+// offsetReader makes the stream in r appear to start at the offset.
+type offsetReader struct {
+	io.ReadSeeker
+	offset int64
+}
+
+func (r *offsetReader) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekStart {
+		offset += r.offset
+	}
+	n, err := r.ReadSeeker.Seek(offset, whence)
+	return n - r.offset, err
+}
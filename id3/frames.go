@@ -0,0 +1,485 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package id3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/goulash/audio"
+)
+
+var errInvalidFrame = errors.New("frame is invalid")
+
+// Frame is a frame of an ID3v2 tag. The frames that this package decodes
+// have a type of their own, and all other frames are a *RawFrame.
+type Frame interface {
+	// ID returns the frame ID, such as "TIT2". The IDs of tags of version
+	// 2.2 are converted to the IDs of later versions, except for frames
+	// that have no equivalent, which keep their three character ID.
+	ID() string
+}
+
+// RawFrame is a frame that is not decoded, because its ID is unknown,
+// it is encrypted, or its content is invalid.
+type RawFrame struct {
+	FrameID string
+
+	// Flags are the flags of the frame. Only the status flags are kept,
+	// unless the frame is encrypted or grouped, in which case Data is the
+	// content as it is stored, including the group ID, the encryption
+	// method and the data length that precede it.
+	Flags FrameFlags
+	Data  []byte
+}
+
+// TextFrame is a text information frame, such as "TIT2", except "TXXX".
+type TextFrame struct {
+	FrameID string
+	Text    []string // the values, of which version 2.3 supports only one
+}
+
+// UserTextFrame is a "TXXX" frame, which is a text frame with a description.
+type UserTextFrame struct {
+	Description string
+	Text        []string
+}
+
+// URLFrame is a URL link frame, such as "WOAR", except "WXXX".
+type URLFrame struct {
+	FrameID string
+	URL     string
+}
+
+// UserURLFrame is a "WXXX" frame, which is a URL link with a description.
+type UserURLFrame struct {
+	Description string
+	URL         string
+}
+
+// CommentFrame is a "COMM" frame.
+type CommentFrame struct {
+	Language    string // the ISO 639-2 code of the language, such as "eng"
+	Description string
+	Text        string
+}
+
+// LyricsFrame is a "USLT" frame, which contains unsynchronised lyrics.
+type LyricsFrame struct {
+	Language    string
+	Description string
+	Text        string
+}
+
+// PictureFrame is an "APIC" frame. The width, height, depth and colors of
+// the picture are not stored.
+type PictureFrame struct {
+	audio.Picture
+}
+
+// PopularimeterFrame is a "POPM" frame, which contains the rating and the
+// play counter of a user.
+type PopularimeterFrame struct {
+	Email   string
+	Rating  byte // from 1 (worst) to 255 (best), or 0 if unknown
+	Counter uint64
+}
+
+// UniqueFileIDFrame is a "UFID" frame, which identifies the recording in
+// the database of the owner.
+type UniqueFileIDFrame struct {
+	Owner      string // a URL of the owner, such as "http://musicbrainz.org"
+	Identifier []byte
+}
+
+// ChapterFrame is a "CHAP" frame, which describes a chapter of the audio.
+// It contains frames of its own, such as a "TIT2" frame with the title of
+// the chapter.
+type ChapterFrame struct {
+	ElementID string // unique in the tag
+
+	// Start and End are the times at which the chapter starts and ends,
+	// with a resolution of milliseconds. StartOffset and EndOffset are
+	// the byte offsets from the beginning of the audio, or 0xFFFFFFFF if
+	// the times are to be used instead.
+	Start       time.Duration
+	End         time.Duration
+	StartOffset uint32
+	EndOffset   uint32
+
+	Frames []Frame
+}
+
+// TOCFrame is a "CTOC" frame, which is a table of contents of chapters or
+// of other tables of contents.
+type TOCFrame struct {
+	ElementID string
+	TopLevel  bool     // the root of the tables of contents
+	Ordered   bool     // the children are in order
+	Children  []string // the element IDs of the children
+
+	Frames []Frame
+}
+
+func (f *RawFrame) ID() string           { return f.FrameID }
+func (f *TextFrame) ID() string          { return f.FrameID }
+func (f *UserTextFrame) ID() string      { return "TXXX" }
+func (f *URLFrame) ID() string           { return f.FrameID }
+func (f *UserURLFrame) ID() string       { return "WXXX" }
+func (f *CommentFrame) ID() string       { return "COMM" }
+func (f *LyricsFrame) ID() string        { return "USLT" }
+func (f *PictureFrame) ID() string       { return "APIC" }
+func (f *PopularimeterFrame) ID() string { return "POPM" }
+func (f *UniqueFileIDFrame) ID() string  { return "UFID" }
+func (f *ChapterFrame) ID() string       { return "CHAP" }
+func (f *TOCFrame) ID() string           { return "CTOC" }
+
+// Frame Decoding {{{
+
+// decodeFrame decodes the content of the frame with the given ID.
+func (d *decoder) decodeFrame(id string, b []byte) (Frame, error) {
+	switch {
+	case id == "TXXX":
+		enc, b, err := textEncoding(b)
+		if err != nil {
+			return nil, err
+		}
+		desc, b := splitString(enc, b)
+		return &UserTextFrame{Description: desc, Text: decodeStrings(enc, b)}, nil
+	case id[0] == 'T':
+		enc, b, err := textEncoding(b)
+		if err != nil {
+			return nil, err
+		}
+		return &TextFrame{FrameID: id, Text: decodeStrings(enc, b)}, nil
+	case id == "WXXX":
+		enc, b, err := textEncoding(b)
+		if err != nil {
+			return nil, err
+		}
+		desc, b := splitString(enc, b)
+		url, _ := splitString(0, b)
+		return &UserURLFrame{Description: desc, URL: url}, nil
+	case id[0] == 'W':
+		url, _ := splitString(0, b)
+		return &URLFrame{FrameID: id, URL: url}, nil
+	case id == "COMM", id == "USLT":
+		enc, b, err := textEncoding(b)
+		if err != nil || len(b) < 3 {
+			return nil, errInvalidFrame
+		}
+		lang := strings.TrimRight(string(b[:3]), "\x00 ")
+		desc, b := splitString(enc, b[3:])
+		text := decodeString(enc, b)
+		if id == "USLT" {
+			return &LyricsFrame{Language: lang, Description: desc, Text: text}, nil
+		}
+		return &CommentFrame{Language: lang, Description: desc, Text: text}, nil
+	case id == "APIC":
+		return decodeAPIC(b)
+	case id == "POPM":
+		email, b := splitString(0, b)
+		f := &PopularimeterFrame{Email: email}
+		if len(b) > 0 {
+			f.Rating = b[0]
+			for _, c := range b[1:] {
+				f.Counter = f.Counter<<8 | uint64(c)
+			}
+		}
+		return f, nil
+	case id == "UFID":
+		owner, b := splitString(0, b)
+		return &UniqueFileIDFrame{Owner: owner, Identifier: append([]byte(nil), b...)}, nil
+	case id == "CHAP":
+		return d.decodeCHAP(b)
+	case id == "CTOC":
+		return d.decodeCTOC(b)
+	default:
+		return &RawFrame{FrameID: id, Data: b}, nil
+	}
+}
+
+/*
+decodeAPIC decodes an attached picture frame.
+
+Encoding format
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    1 Text encoding
+    n MIME type, in ISO-8859-1 and terminated
+    1 Picture type
+    n Description, terminated
+    n Picture data
+===== ===========================================================================
+
+In version 2.2, the MIME type is replaced by an image format of 3 characters,
+such as "JPG"; see decodePIC.
+*/
+func decodeAPIC(b []byte) (*PictureFrame, error) {
+	enc, b, err := textEncoding(b)
+	if err != nil {
+		return nil, err
+	}
+	mime, b := splitString(0, b)
+	if len(b) < 1 {
+		return nil, errInvalidFrame
+	}
+	typ := audio.PictureType(b[0])
+	desc, b := splitString(enc, b[1:])
+	return &PictureFrame{audio.Picture{
+		Type:        typ,
+		MIMEType:    mime,
+		Description: desc,
+		Data:        append([]byte(nil), b...),
+	}}, nil
+}
+
+// decodePIC decodes an attached picture frame of version 2.2, which has an
+// image format instead of a MIME type.
+func decodePIC(b []byte) (*PictureFrame, error) {
+	if len(b) < 5 {
+		return nil, errInvalidFrame
+	}
+	var mime string
+	switch format := strings.ToUpper(string(b[1:4])); format {
+	case "JPG":
+		mime = "image/jpeg"
+	case "-->":
+		mime = format
+	default:
+		mime = "image/" + strings.ToLower(format)
+	}
+	p := append([]byte{b[0]}, mime...)
+	p = append(p, 0)
+	return decodeAPIC(append(p, b[4:]...))
+}
+
+/*
+decodeCHAP decodes a chapter frame, which contains frames of its own.
+
+Encoding format
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    n Element ID, in ISO-8859-1 and terminated
+    4 Start time in milliseconds
+    4 End time in milliseconds
+    4 Start offset in bytes, or 0xFFFFFFFF
+    4 End offset in bytes, or 0xFFFFFFFF
+    n Frames (optional)
+===== ===========================================================================
+*/
+func (d *decoder) decodeCHAP(b []byte) (*ChapterFrame, error) {
+	id, b := splitString(0, b)
+	if len(b) < 16 {
+		return nil, errInvalidFrame
+	}
+	f := &ChapterFrame{
+		ElementID:   id,
+		Start:       time.Duration(binary.BigEndian.Uint32(b)) * time.Millisecond,
+		End:         time.Duration(binary.BigEndian.Uint32(b[4:])) * time.Millisecond,
+		StartOffset: binary.BigEndian.Uint32(b[8:]),
+		EndOffset:   binary.BigEndian.Uint32(b[12:]),
+	}
+	f.Frames, _ = d.subframes(b[16:])
+	return f, nil
+}
+
+/*
+decodeCTOC decodes a table of contents frame, which contains frames of its own.
+
+Encoding format
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    n Element ID, in ISO-8859-1 and terminated
+    1 Flags: 0x02 top-level, 0x01 ordered
+    1 Number of children
+    n Element IDs of the children, in ISO-8859-1 and terminated
+    n Frames (optional)
+===== ===========================================================================
+*/
+func (d *decoder) decodeCTOC(b []byte) (*TOCFrame, error) {
+	id, b := splitString(0, b)
+	if len(b) < 2 {
+		return nil, errInvalidFrame
+	}
+	f := &TOCFrame{
+		ElementID: id,
+		TopLevel:  b[0]&0x02 != 0,
+		Ordered:   b[0]&0x01 != 0,
+	}
+	n := int(b[1])
+	b = b[2:]
+	for i := 0; i < n; i++ {
+		if len(b) == 0 {
+			return nil, errInvalidFrame
+		}
+		var child string
+		child, b = splitString(0, b)
+		f.Children = append(f.Children, child)
+	}
+	f.Frames, _ = d.subframes(b)
+	return f, nil
+}
+
+// subframes decodes the frames that are embedded in another frame. They have
+// already been resynchronised with the frame that contains them.
+func (d *decoder) subframes(b []byte) ([]Frame, int) {
+	x := decoder{version: d.version}
+	return x.frames(b)
+}
+
+// }}}
+
+// Text Encoding {{{
+
+// The text encodings of frames. Version 2.4 added UTF-16BE and UTF-8.
+const (
+	encLatin1  = 0
+	encUTF16   = 1 // UTF-16 with a byte order mark
+	encUTF16BE = 2
+	encUTF8    = 3
+)
+
+// textEncoding returns the text encoding at the beginning of b and the rest
+// of b.
+func textEncoding(b []byte) (byte, []byte, error) {
+	if len(b) == 0 || b[0] > encUTF8 {
+		return 0, nil, errInvalidFrame
+	}
+	return b[0], b[1:], nil
+}
+
+// terminator returns the string terminator of the encoding.
+func terminator(enc byte) []byte {
+	if enc == encUTF16 || enc == encUTF16BE {
+		return []byte{0, 0}
+	}
+	return []byte{0}
+}
+
+// splitString returns the terminated string at the beginning of b and the
+// rest of b after the terminator. Without a terminator, all of b is the
+// string.
+func splitString(enc byte, b []byte) (string, []byte) {
+	t := terminator(enc)
+	for i := 0; i+len(t) <= len(b); i += len(t) {
+		if bytes.Equal(b[i:i+len(t)], t) {
+			return decodeString(enc, b[:i]), b[i+len(t):]
+		}
+	}
+	return decodeString(enc, b), nil
+}
+
+// decodeStrings returns the strings in b, which are separated by terminators.
+// A terminator at the end is ignored.
+func decodeStrings(enc byte, b []byte) []string {
+	var ss []string
+	for len(b) > 0 {
+		var s string
+		s, b = splitString(enc, b)
+		ss = append(ss, s)
+	}
+	if len(ss) == 0 {
+		ss = []string{""}
+	}
+	return ss
+}
+
+// decodeString decodes b, ignoring any terminators at the end.
+func decodeString(enc byte, b []byte) string {
+	switch enc {
+	case encLatin1:
+		b = bytes.TrimRight(b, "\x00")
+		rs := make([]rune, len(b))
+		for i, c := range b {
+			rs[i] = rune(c)
+		}
+		return string(rs)
+	case encUTF16, encUTF16BE:
+		bigEndian := enc == encUTF16BE
+		if len(b) >= 2 {
+			switch {
+			case b[0] == 0xFF && b[1] == 0xFE:
+				bigEndian, b = false, b[2:]
+			case b[0] == 0xFE && b[1] == 0xFF:
+				bigEndian, b = true, b[2:]
+			}
+		}
+		u := make([]uint16, len(b)/2)
+		for i := range u {
+			if bigEndian {
+				u[i] = binary.BigEndian.Uint16(b[2*i:])
+			} else {
+				u[i] = binary.LittleEndian.Uint16(b[2*i:])
+			}
+		}
+		for len(u) > 0 && u[len(u)-1] == 0 {
+			u = u[:len(u)-1]
+		}
+		return string(utf16.Decode(u))
+	default:
+		return strings.TrimRight(string(b), "\x00")
+	}
+}
+
+// encoding returns the text encoding in which version writes the strings:
+// UTF-8 for version 2.4, and otherwise ISO-8859-1 if possible, or else
+// UTF-16.
+func encoding(version byte, ss ...string) byte {
+	if version == 4 {
+		return encUTF8
+	}
+	for _, s := range ss {
+		for _, r := range s {
+			if r > 0xFF {
+				return encUTF16
+			}
+		}
+	}
+	return encLatin1
+}
+
+// appendString appends s in the encoding, followed by a terminator if term
+// is true. Characters that cannot be represented in ISO-8859-1 are replaced
+// by question marks.
+func appendString(b []byte, enc byte, s string, term bool) []byte {
+	switch enc {
+	case encLatin1:
+		for _, r := range s {
+			if r > 0xFF {
+				r = '?'
+			}
+			b = append(b, byte(r))
+		}
+	case encUTF16:
+		b = append(b, 0xFF, 0xFE)
+		for _, u := range utf16.Encode([]rune(s)) {
+			b = append(b, byte(u), byte(u>>8))
+		}
+	case encUTF16BE:
+		for _, u := range utf16.Encode([]rune(s)) {
+			b = append(b, byte(u>>8), byte(u))
+		}
+	default:
+		if !utf8.ValidString(s) {
+			s = strings.ToValidUTF8(s, "�")
+		}
+		b = append(b, s...)
+	}
+	if term {
+		b = append(b, terminator(enc)...)
+	}
+	return b
+}
+
+// }}}
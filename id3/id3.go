@@ -0,0 +1,520 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+// Package id3 implements reading and writing ID3v2 tags of versions 2.2, 2.3
+// and 2.4, and ID3v1 tags. Frames that this package does not understand are
+// kept as they are, so that a tag can be read and written again without
+// losing information.
+//
+// Reference
+//
+//  http://id3.org/id3v2-00
+//  http://id3.org/id3v2.3.0
+//  http://id3.org/id3v2.4.0-structure
+//  http://id3.org/id3v2.4.0-frames
+//  http://id3.org/id3v2-chapters-1.0
+//  http://id3.org/ID3v1
+package id3
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+var (
+	ErrNoTag              = errors.New("no ID3 tag")
+	ErrInvalidTag         = errors.New("tag is invalid")
+	ErrUnsupportedVersion = errors.New("ID3 version unsupported")
+	ErrTagTooLarge        = errors.New("tag too large")
+	ErrInvalidFrameID     = errors.New("frame ID is invalid")
+)
+
+// maxTagSize is the largest size that a synchsafe integer can express, which
+// limits the size of a tag and of a frame.
+const maxTagSize = 1<<28 - 1
+
+// Tag is an ID3v2 tag.
+type Tag struct {
+	// Version is the major version of the tag, which is 2, 3 or 4, and
+	// Revision is the revision, which is usually 0.
+	Version  byte
+	Revision byte
+
+	// Unsynchronisation is true if the tag is unsynchronised, so that it
+	// contains no false MPEG frame syncs. Experimental is the experimental
+	// indicator, and Footer is true if the tag ends with a footer, which
+	// only version 2.4 supports.
+	Unsynchronisation bool
+	Experimental      bool
+	Footer            bool
+
+	// Extended is the extended header, or nil if there is none.
+	Extended *ExtendedHeader
+
+	// Frames are the frames in the order in which they are stored.
+	Frames []Frame
+
+	// Size is the size of the tag in the stream that it was read from,
+	// including the header and the footer, and Padding is the size of the
+	// padding in it. Both are 0 for a new tag.
+	Size    int64
+	Padding int
+}
+
+// ExtendedHeader is the optional extended header of a tag of version 2.3
+// or 2.4. When a tag is written, the CRC is calculated if HasCRC is true.
+type ExtendedHeader struct {
+	HasCRC bool
+	CRC    uint32 // the CRC-32 of the frames, as read
+
+	// Update is true if the tag is an update of an earlier tag in the
+	// stream, and Restrictions are the tag restrictions if HasRestrictions
+	// is true. Both are only supported by version 2.4.
+	Update          bool
+	HasRestrictions bool
+	Restrictions    byte
+}
+
+// ReadFile reads the ID3v2 tag at the beginning of the file at path.
+func ReadFile(path string) (*Tag, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+/*
+Read reads the ID3v2 tag at the current position of r. It returns ErrNoTag if
+there is no tag there.
+
+Encoding format
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    3 "ID3"
+    1 Major version
+    1 Revision
+    1 Flags: 0x80 unsynchronisation, 0x40 extended header (compression in
+      version 2.2), 0x20 experimental, 0x10 footer
+    4 Size of the tag, excluding the header and the footer
+    n Extended header (optional)
+    n Frames
+    n Padding (optional)
+   10 Footer, which is the header with the ID "3DI" (optional)
+===== ===========================================================================
+
+The size is a synchsafe integer, of which only the lower 7 bits of each byte
+are used. All integers are big-endian.
+*/
+func Read(r io.Reader) (*Tag, error) {
+	h := make([]byte, 10)
+	if _, err := io.ReadFull(r, h); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrNoTag
+	} else if err != nil {
+		return nil, err
+	}
+	if string(h[:3]) != "ID3" || h[3] == 0xFF || h[4] == 0xFF || !isSynchsafe(h[6:]) {
+		return nil, ErrNoTag
+	}
+	t := &Tag{Version: h[3], Revision: h[4]}
+	flags := h[5]
+	if t.Version < 2 || t.Version > 4 || t.Version == 2 && flags&0x40 != 0 {
+		// Compression of version 2.2 was never defined.
+		return nil, ErrUnsupportedVersion
+	}
+	size := synchsafe(h[6:])
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, ErrInvalidTag
+	}
+	t.Size = 10 + int64(size)
+	t.Unsynchronisation = flags&0x80 != 0
+	t.Experimental = t.Version > 2 && flags&0x20 != 0
+	if t.Version == 4 && flags&0x10 != 0 {
+		t.Footer = true
+		t.Size += 10
+		if _, err := io.CopyN(ioutil.Discard, r, 10); err != nil {
+			return nil, ErrInvalidTag
+		}
+	}
+
+	if t.Unsynchronisation && t.Version < 4 {
+		// Version 2.4 unsynchronises each frame instead.
+		body = removeUnsync(body)
+	}
+	if t.Version > 2 && flags&0x40 != 0 {
+		n, err := t.readExtendedHeader(body)
+		if err != nil {
+			return nil, err
+		}
+		body = body[n:]
+	}
+	d := decoder{version: t.Version, unsync: t.Unsynchronisation}
+	t.Frames, t.Padding = d.frames(body)
+	return t, nil
+}
+
+/*
+readExtendedHeader reads the extended header at the beginning of b and
+returns its size.
+
+Encoding format (version 2.3)
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    4 Size of the extended header, excluding this field: 6 or 10
+    2 Flags: 0x8000 CRC present
+    4 Size of the padding
+    4 CRC-32 (optional)
+===== ===========================================================================
+
+Encoding format (version 2.4)
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    4 Size of the extended header, as a synchsafe integer
+    1 Number of flag bytes: 1
+    1 Flags: 0x40 update, 0x20 CRC present, 0x10 restrictions
+    1 Size of the data of the update flag: 0
+    6 Size of the data of the CRC flag, 5, and the CRC-32 as a 35-bit
+      synchsafe integer (optional)
+    2 Size of the data of the restrictions flag, 1, and the restrictions
+      (optional)
+===== ===========================================================================
+*/
+func (t *Tag) readExtendedHeader(b []byte) (int, error) {
+	if len(b) < 6 {
+		return 0, ErrInvalidTag
+	}
+	x := new(ExtendedHeader)
+	var n int
+	if t.Version == 3 {
+		n = 4 + int(binary.BigEndian.Uint32(b))
+		if n > len(b) || n < 10 {
+			return 0, ErrInvalidTag
+		}
+		if b[4]&0x80 != 0 && n >= 14 {
+			x.HasCRC = true
+			x.CRC = binary.BigEndian.Uint32(b[10:])
+		}
+		t.Extended = x
+		return n, nil
+	}
+
+	n = int(synchsafe(b))
+	if n > len(b) || n < 5+int(b[4]) || b[4] == 0 {
+		return 0, ErrInvalidTag
+	}
+	flags := b[5]
+	p := b[4+1+int(b[4]) : n]
+	data := func() []byte {
+		if len(p) == 0 || int(p[0]) >= len(p) {
+			return nil
+		}
+		d := p[1 : 1+p[0]]
+		p = p[1+p[0]:]
+		return d
+	}
+	if flags&0x40 != 0 {
+		x.Update = true
+		data()
+	}
+	if flags&0x20 != 0 {
+		if d := data(); len(d) == 5 {
+			x.HasCRC = true
+			x.CRC = uint32(d[0])<<28 | synchsafe(d[1:])
+		}
+	}
+	if flags&0x10 != 0 {
+		if d := data(); len(d) == 1 {
+			x.HasRestrictions = true
+			x.Restrictions = d[0]
+		}
+	}
+	t.Extended = x
+	return n, nil
+}
+
+// FrameFlags are the flags of a frame, in the layout of version 2.4.
+type FrameFlags uint16
+
+const (
+	FlagTagAlterPreservation  FrameFlags = 0x4000 // discard the frame if the tag is altered
+	FlagFileAlterPreservation FrameFlags = 0x2000 // discard the frame if the audio is altered
+	FlagReadOnly              FrameFlags = 0x1000
+	FlagGrouping              FrameFlags = 0x0040 // the content starts with a group ID
+	FlagCompression           FrameFlags = 0x0008 // the content is compressed with zlib
+	FlagEncryption            FrameFlags = 0x0004 // the content starts with the encryption method
+	FlagUnsynchronisation     FrameFlags = 0x0002
+	FlagDataLength            FrameFlags = 0x0001 // the content starts with its decoded size
+
+	statusFlags = FlagTagAlterPreservation | FlagFileAlterPreservation | FlagReadOnly
+)
+
+// fromV23Flags converts the frame flags of version 2.3 to those of 2.4.
+// The decompressed size of compressed frames becomes the data length.
+func fromV23Flags(f uint16) FrameFlags {
+	var x FrameFlags
+	x |= FrameFlags(f>>1) & statusFlags
+	if f&0x0080 != 0 {
+		x |= FlagCompression | FlagDataLength
+	}
+	if f&0x0040 != 0 {
+		x |= FlagEncryption
+	}
+	if f&0x0020 != 0 {
+		x |= FlagGrouping
+	}
+	return x
+}
+
+// toV23Flags converts the frame flags of version 2.4 to those of 2.3.
+func toV23Flags(x FrameFlags) uint16 {
+	f := uint16(x&statusFlags) << 1
+	if x&FlagCompression != 0 {
+		f |= 0x0080
+	}
+	if x&FlagEncryption != 0 {
+		f |= 0x0040
+	}
+	if x&FlagGrouping != 0 {
+		f |= 0x0020
+	}
+	return f
+}
+
+// decoder decodes the frames of a tag.
+type decoder struct {
+	version byte
+	unsync  bool // the tag of version 2.4 is unsynchronised
+}
+
+/*
+frames decodes the frames in b, and returns them with the size of the padding
+that follows them. Anything after the first frame with an invalid ID or size
+is treated as padding.
+
+Encoding format (version 2.3 and 2.4)
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    4 Frame ID, consisting of the characters A-Z and 0-9
+    4 Size of the frame, excluding the header; a synchsafe integer in 2.4
+    2 Flags
+    n Content
+===== ===========================================================================
+
+Encoding format (version 2.2)
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    3 Frame ID
+    3 Size of the frame, excluding the header
+    n Content
+===== ===========================================================================
+*/
+func (d *decoder) frames(b []byte) ([]Frame, int) {
+	var frames []Frame
+	for len(b) > 0 {
+		id, size, flags, ok := d.header(b)
+		if !ok {
+			break
+		}
+		b = b[d.headerSize():]
+		f := d.frame(id, flags, b[:size])
+		if f != nil {
+			frames = append(frames, f)
+		}
+		b = b[size:]
+	}
+	return frames, len(b)
+}
+
+func (d *decoder) headerSize() int {
+	if d.version == 2 {
+		return 6
+	}
+	return 10
+}
+
+// header parses the frame header at the beginning of b, and returns false if
+// it is not followed by enough data for the frame.
+func (d *decoder) header(b []byte) (id string, size int, flags FrameFlags, ok bool) {
+	n := d.headerSize()
+	if len(b) < n {
+		return "", 0, 0, false
+	}
+	switch d.version {
+	case 2:
+		id = string(b[:3])
+		size = int(b[3])<<16 | int(b[4])<<8 | int(b[5])
+	case 3:
+		id = string(b[:4])
+		size = int(binary.BigEndian.Uint32(b[4:]))
+		flags = fromV23Flags(binary.BigEndian.Uint16(b[8:]))
+	case 4:
+		id = string(b[:4])
+		size = int(synchsafe(b[4:]))
+		flags = FrameFlags(binary.BigEndian.Uint16(b[8:]))
+		// Some encoders write sizes that are not synchsafe.
+		plain := int(binary.BigEndian.Uint32(b[4:]))
+		if !isSynchsafe(b[4:8]) || !d.followed(b[n:], size) && d.followed(b[n:], plain) {
+			size = plain
+		}
+	}
+	if !validID(id) || size > len(b)-n {
+		return "", 0, 0, false
+	}
+	return id, size, flags, true
+}
+
+// followed returns true if the content of a frame of the given size at the
+// beginning of b is followed by another frame, padding, or the end.
+func (d *decoder) followed(b []byte, size int) bool {
+	if size > len(b) {
+		return false
+	}
+	b = b[size:]
+	if len(b) < d.headerSize() {
+		return len(b) == 0 || b[0] == 0
+	}
+	return b[0] == 0 || validID(string(b[:4]))
+}
+
+// frame decodes the content of a frame. Frames that cannot be decoded are
+// returned as a RawFrame, and frames of version 2.2 without an equivalent
+// in later versions are returned with their original ID.
+func (d *decoder) frame(id string, flags FrameFlags, data []byte) Frame {
+	if d.version == 2 {
+		if v3, ok := v22IDs[id]; ok {
+			if id == "PIC" {
+				if f, err := decodePIC(data); err == nil {
+					return f
+				}
+			}
+			id = v3
+		} else {
+			return &RawFrame{FrameID: id, Data: data}
+		}
+	}
+	if d.version == 4 && (d.unsync || flags&FlagUnsynchronisation != 0) {
+		data = removeUnsync(data)
+		flags &^= FlagUnsynchronisation
+	}
+	if flags&(FlagGrouping|FlagEncryption) != 0 {
+		return &RawFrame{FrameID: id, Flags: flags, Data: data}
+	}
+	content := data
+	if flags&FlagDataLength != 0 {
+		if len(content) < 4 {
+			return &RawFrame{FrameID: id, Flags: flags, Data: data}
+		}
+		content = content[4:]
+	}
+	if flags&FlagCompression != 0 {
+		zr, err := zlib.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return &RawFrame{FrameID: id, Flags: flags, Data: data}
+		}
+		content, err = ioutil.ReadAll(zr)
+		if err != nil {
+			return &RawFrame{FrameID: id, Flags: flags, Data: data}
+		}
+	}
+	f, err := d.decodeFrame(id, content)
+	if err != nil {
+		return &RawFrame{FrameID: id, Flags: flags & statusFlags, Data: content}
+	}
+	if r, ok := f.(*RawFrame); ok {
+		r.Flags = flags & statusFlags
+	}
+	return f
+}
+
+// validID returns true if id consists of the characters A-Z and 0-9.
+func validID(id string) bool {
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return len(id) > 0
+}
+
+// synchsafe returns the integer in the first 4 bytes of b, of which only
+// the lower 7 bits of each byte are used.
+func synchsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7F)<<21 | uint32(b[1]&0x7F)<<14 | uint32(b[2]&0x7F)<<7 | uint32(b[3]&0x7F)
+}
+
+func isSynchsafe(b []byte) bool {
+	return b[0]|b[1]|b[2]|b[3] < 0x80
+}
+
+func appendSynchsafe(b []byte, n uint32) []byte {
+	return append(b, byte(n>>21&0x7F), byte(n>>14&0x7F), byte(n>>7&0x7F), byte(n&0x7F))
+}
+
+// removeUnsync reverses the unsynchronisation scheme, which inserts a zero
+// byte after every 0xFF byte that is followed by a byte that is either zero
+// or has the upper three bits set.
+func removeUnsync(b []byte) []byte {
+	if bytes.IndexByte(b, 0xFF) < 0 {
+		return b
+	}
+	p := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		p = append(p, b[i])
+		if b[i] == 0xFF && i+1 < len(b) && b[i+1] == 0 {
+			i++
+		}
+	}
+	return p
+}
+
+// addUnsync applies the unsynchronisation scheme to b. A zero byte is also
+// added if b ends with 0xFF, since the next byte is unknown.
+func addUnsync(b []byte) []byte {
+	if bytes.IndexByte(b, 0xFF) < 0 {
+		return b
+	}
+	p := make([]byte, 0, len(b)+len(b)/64+1)
+	for i := 0; i < len(b); i++ {
+		p = append(p, b[i])
+		if b[i] == 0xFF && (i+1 == len(b) || b[i+1] == 0 || b[i+1] >= 0xE0) {
+			p = append(p, 0)
+		}
+	}
+	return p
+}
+
+// v22IDs are the frame IDs of version 2.3 that correspond to the frame IDs
+// of version 2.2.
+var v22IDs = map[string]string{
+	"BUF": "RBUF", "CNT": "PCNT", "COM": "COMM", "CRA": "AENC", "ETC": "ETCO",
+	"EQU": "EQUA", "GEO": "GEOB", "IPL": "IPLS", "LNK": "LINK", "MCI": "MCDI",
+	"MLL": "MLLT", "PIC": "APIC", "POP": "POPM", "REV": "RVRB", "RVA": "RVAD",
+	"SLT": "SYLT", "STC": "SYTC", "TAL": "TALB", "TBP": "TBPM", "TCM": "TCOM",
+	"TCO": "TCON", "TCR": "TCOP", "TDA": "TDAT", "TDY": "TDLY", "TEN": "TENC",
+	"TFT": "TFLT", "TIM": "TIME", "TKE": "TKEY", "TLA": "TLAN", "TLE": "TLEN",
+	"TMT": "TMED", "TOA": "TOPE", "TOF": "TOFN", "TOL": "TOLY", "TOR": "TORY",
+	"TOT": "TOAL", "TP1": "TPE1", "TP2": "TPE2", "TP3": "TPE3", "TP4": "TPE4",
+	"TPA": "TPOS", "TPB": "TPUB", "TRC": "TSRC", "TRD": "TRDA", "TRK": "TRCK",
+	"TSI": "TSIZ", "TSS": "TSSE", "TT1": "TIT1", "TT2": "TIT2", "TT3": "TIT3",
+	"TXT": "TEXT", "TXX": "TXXX", "TYE": "TYER", "UFI": "UFID", "ULT": "USLT",
+	"WAF": "WOAF", "WAR": "WOAR", "WAS": "WOAS", "WCM": "WCOM", "WCP": "WCOP",
+	"WPB": "WPUB", "WXX": "WXXX",
+
+	// Frames that iTunes writes.
+	"TCP": "TCMP", "TST": "TSOT", "TSA": "TSOA", "TSP": "TSOP", "TS2": "TSO2",
+	"TSC": "TSOC",
+}
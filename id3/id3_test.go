@@ -0,0 +1,388 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package id3
+
+import (
+	"bytes"
+	"compress/zlib"
+	"hash/crc32"
+	"testing"
+	"time"
+
+	"github.com/goulash/audio"
+	"github.com/stretchr/testify/assert"
+)
+
+// tag returns a tag with the header fields and the body.
+func tag(version, flags byte, body []byte) []byte {
+	b := append([]byte("ID3"), version, 0, flags)
+	b = appendSynchsafe(b, uint32(len(body)))
+	return append(b, body...)
+}
+
+// frame22 returns a frame of version 2.2.
+func frame22(id string, data []byte) []byte {
+	n := len(data)
+	b := append([]byte(id), byte(n>>16), byte(n>>8), byte(n))
+	return append(b, data...)
+}
+
+// frame23 returns a frame of version 2.3.
+func frame23(id string, flags uint16, data []byte) []byte {
+	b := appendUint32([]byte(id), uint32(len(data)))
+	b = append(b, byte(flags>>8), byte(flags))
+	return append(b, data...)
+}
+
+// frame24 returns a frame of version 2.4 with a synchsafe size.
+func frame24(id string, flags FrameFlags, data []byte) []byte {
+	b := appendSynchsafe([]byte(id), uint32(len(data)))
+	b = append(b, byte(flags>>8), byte(flags))
+	return append(b, data...)
+}
+
+func TestRead22(z *testing.T) {
+	assert := assert.New(z)
+
+	var body []byte
+	body = append(body, frame22("TT2", []byte("\x00Title"))...)
+	body = append(body, frame22("TCO", []byte("\x00(17)"))...)
+	body = append(body, frame22("PIC", []byte("\x00PNG\x03cover\x00\x89PNG"))...)
+	body = append(body, frame22("XYZ", []byte{1, 2, 3})...)
+	body = append(body, make([]byte, 10)...)
+
+	t, err := Read(bytes.NewReader(tag(2, 0, body)))
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(byte(2), t.Version)
+	assert.Equal(int64(10+len(body)), t.Size)
+	assert.Equal(10, t.Padding)
+	assert.Equal("Title", t.Text("TIT2"))
+	assert.Equal("Rock", t.Genre())
+	if ps := t.Pictures(); assert.Len(ps, 1) {
+		assert.Equal("image/png", ps[0].MIMEType)
+		assert.Equal(audio.PictureFrontCover, ps[0].Type)
+		assert.Equal("cover", ps[0].Description)
+		assert.Equal([]byte("\x89PNG"), ps[0].Data)
+	}
+	assert.Equal(&RawFrame{FrameID: "XYZ", Data: []byte{1, 2, 3}}, t.Frame("XYZ"))
+
+	// Version 2.2 is written as 2.3, with the unknown frame kept as an
+	// experimental frame.
+	buf, err := Encode(t, 0)
+	if !assert.Nil(err) {
+		return
+	}
+	u, err := Read(bytes.NewReader(buf))
+	if assert.Nil(err) {
+		assert.Equal(byte(3), u.Version)
+		assert.Equal("Title", u.Text("TIT2"))
+		assert.Len(u.Pictures(), 1)
+		assert.Nil(u.Frame("XYZ"))
+		assert.Equal(&RawFrame{FrameID: "XXYZ", Data: []byte{1, 2, 3}}, u.Frame("XXYZ"))
+		assert.Len(u.Frames, 4)
+	}
+
+	_, err = Encode(&Tag{Frames: []Frame{&RawFrame{FrameID: "xyz!"}}}, 0)
+	assert.Equal(ErrInvalidFrameID, err)
+}
+
+func TestRead23(z *testing.T) {
+	assert := assert.New(z)
+
+	var zbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	zw.Write([]byte("\x00Compressed"))
+	zw.Close()
+	compressed := appendUint32(nil, 11)
+	compressed = append(compressed, zbuf.Bytes()...)
+
+	var frames []byte
+	frames = append(frames, frame23("TIT2", 0, []byte("\x01\xFF\xFET\x00i\x00t\x00l\x00e\x00"))...)
+	frames = append(frames, frame23("TALB", 0x0080, compressed)...)
+	frames = append(frames, frame23("TPE1", 0x0040, []byte{0x80, 1, 2})...)
+	frames = append(frames, frame23("ABCD", 0x8000, []byte{0xFF, 0xE0, 0xFF})...)
+
+	ext := []byte{0, 0, 0, 10, 0x80, 0, 0, 0, 0, 4}
+	ext = appendUint32(ext, crc32.ChecksumIEEE(frames))
+	body := append(ext, frames...)
+	body = addUnsync(append(body, 0, 0, 0, 0))
+
+	t, err := Read(bytes.NewReader(tag(3, 0xC0, body)))
+	if !assert.Nil(err) {
+		return
+	}
+	assert.True(t.Unsynchronisation)
+	if assert.NotNil(t.Extended) {
+		assert.True(t.Extended.HasCRC)
+		assert.Equal(crc32.ChecksumIEEE(frames), t.Extended.CRC)
+	}
+	assert.Equal(4, t.Padding)
+	assert.Equal("Title", t.Text("TIT2"))
+	assert.Equal("Compressed", t.Text("TALB"))
+	assert.Equal(&RawFrame{FrameID: "TPE1", Flags: FlagEncryption, Data: []byte{0x80, 1, 2}}, t.Frame("TPE1"))
+	assert.Equal(&RawFrame{FrameID: "ABCD", Flags: FlagTagAlterPreservation, Data: []byte{0xFF, 0xE0, 0xFF}}, t.Frame("ABCD"))
+
+	// The frames that are not understood are written as they are.
+	buf, err := Encode(t, 16)
+	if !assert.Nil(err) {
+		return
+	}
+	u, err := Read(bytes.NewReader(buf))
+	if assert.Nil(err) {
+		assert.Equal(t.Frames, u.Frames)
+		assert.Equal(16, u.Padding)
+		assert.True(u.Unsynchronisation)
+		if assert.NotNil(u.Extended) {
+			assert.True(u.Extended.HasCRC)
+		}
+	}
+}
+
+func TestRead24(z *testing.T) {
+	assert := assert.New(z)
+
+	var body []byte
+	body = append(body, frame24("TIT2", 0, []byte("\x03Title"))...)
+	body = append(body, frame24("TPE1", 0, []byte("\x03One\x00Two"))...)
+	body = append(body, frame24("TALB", FlagUnsynchronisation|FlagDataLength, []byte("\x00\x00\x00\x04\x00\xFF\x00\xE0x"))...)
+	// iTunes writes sizes that are not synchsafe.
+	text := append([]byte{3}, bytes.Repeat([]byte("a"), 200)...)
+	b := appendUint32([]byte("TCOM"), uint32(len(text)))
+	body = append(body, append(append(b, 0, 0), text...)...)
+	body = append(body, make([]byte, 8)...)
+
+	h := tag(4, 0x10, body)
+	data := append(h, "3DI"...)
+	data = append(data, h[3:10]...)
+	data = append(data, "rest"...)
+
+	r := bytes.NewReader(data)
+	t, err := Read(r)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.True(t.Footer)
+	assert.Equal(int64(20+len(body)), t.Size)
+	assert.Equal(4, r.Len())
+	assert.Equal("Title", t.Text("TIT2"))
+	assert.Equal([]string{"One", "Two"}, t.Frame("TPE1").(*TextFrame).Text)
+	assert.Equal("ÿàx", t.Text("TALB"))
+	assert.Equal(string(text[1:]), t.Text("TCOM"))
+	assert.Equal(8, t.Padding)
+
+	t.Unsynchronisation = true
+	buf, err := Encode(t, 100)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal("3DI", string(buf[len(buf)-10:len(buf)-7]))
+	u, err := Read(bytes.NewReader(buf))
+	if assert.Nil(err) {
+		assert.Equal(t.Frames, u.Frames)
+		assert.Equal(0, u.Padding)
+	}
+}
+
+func TestReadInvalid(z *testing.T) {
+	tests := []struct {
+		Data []byte
+		Err  error
+	}{
+		{nil, ErrNoTag},
+		{[]byte("TAG"), ErrNoTag},
+		{[]byte("ID3\x04\x00\x00\x00\x00\x00\x80"), ErrNoTag},
+		{[]byte("ID3\x05\x00\x00\x00\x00\x00\x00"), ErrUnsupportedVersion},
+		{[]byte("ID3\x02\x00\x40\x00\x00\x00\x00"), ErrUnsupportedVersion},
+		{[]byte("ID3\x04\x00\x00\x00\x00\x00\x10TIT2"), ErrInvalidTag},
+		{tag(4, 0x40, []byte{0, 0, 0, 6, 0}), ErrInvalidTag},
+		{tag(3, 0x40, []byte{0, 0, 0, 2, 0, 0}), ErrInvalidTag},
+	}
+	for i, tt := range tests {
+		if _, err := Read(bytes.NewReader(tt.Data)); err != tt.Err {
+			z.Errorf("test %d: got error %v, want %v", i, err, tt.Err)
+		}
+	}
+}
+
+func TestFrames(z *testing.T) {
+	assert := assert.New(z)
+
+	frames := []Frame{
+		&TextFrame{FrameID: "TIT2", Text: []string{"Ünïcödé"}},
+		&UserTextFrame{Description: "REPLAYGAIN_TRACK_GAIN", Text: []string{"-6.50 dB"}},
+		&UserTextFrame{Description: "replaygain_track_peak", Text: []string{"0.988"}},
+		&URLFrame{FrameID: "WOAR", URL: "http://example.com"},
+		&UserURLFrame{Description: "shop", URL: "http://example.com/shop"},
+		&CommentFrame{Language: "eng", Description: "note", Text: "described"},
+		&CommentFrame{Language: "eng", Text: "comment"},
+		&LyricsFrame{Language: "deu", Text: "la la la"},
+		&PictureFrame{audio.Picture{
+			MIMEType:    "image/jpeg",
+			Type:        audio.PictureBackCover,
+			Description: "back",
+			Data:        []byte{0xFF, 0xD8, 0xFF, 0x00},
+		}},
+		&PopularimeterFrame{Email: "me@example.com", Rating: 196, Counter: 1 << 33},
+		&UniqueFileIDFrame{Owner: "http://musicbrainz.org", Identifier: []byte("recording")},
+		&UserTextFrame{Description: "MusicBrainz Artist Id", Text: []string{"a1"}},
+		&TOCFrame{ElementID: "toc", TopLevel: true, Ordered: true, Children: []string{"ch1", "ch2"}},
+		&ChapterFrame{
+			ElementID:   "ch1",
+			Start:       0,
+			End:         90 * time.Second,
+			StartOffset: 0xFFFFFFFF,
+			EndOffset:   0xFFFFFFFF,
+			Frames:      []Frame{&TextFrame{FrameID: "TIT2", Text: []string{"One"}}},
+		},
+		&ChapterFrame{ElementID: "ch2", Start: 90 * time.Second, End: 3 * time.Minute},
+	}
+	for _, version := range []byte{3, 4} {
+		for _, unsync := range []bool{false, true} {
+			buf, err := Encode(&Tag{Version: version, Unsynchronisation: unsync, Frames: frames}, 0)
+			if !assert.Nil(err) {
+				continue
+			}
+			t, err := Read(bytes.NewReader(buf))
+			if !assert.Nil(err) {
+				continue
+			}
+			assert.Equal(frames, t.Frames, "version %d, unsynchronisation %v", version, unsync)
+		}
+	}
+
+	t := &Tag{Frames: frames}
+	assert.Equal("comment", t.Comment())
+	assert.Equal("la la la", t.Lyrics())
+	assert.Equal("http://example.com", t.URL("WOAR"))
+	gain, peak, ok := t.TrackGain()
+	assert.True(ok)
+	assert.Equal(-6.5, gain)
+	assert.Equal(0.988, peak)
+	_, _, ok = t.AlbumGain()
+	assert.False(ok)
+	ids := t.MusicBrainz()
+	assert.Equal("recording", ids.Recording)
+	assert.Equal([]string{"a1"}, ids.Artists)
+
+	// Version 2.3 only supports multiple values by joining them.
+	multi := []Frame{&TextFrame{FrameID: "TPE1", Text: []string{"One", "Two"}}}
+	for version, want := range map[byte][]string{3: {"One/Two"}, 4: {"One", "Two"}} {
+		buf, err := Encode(&Tag{Version: version, Frames: multi}, 0)
+		if !assert.Nil(err) {
+			continue
+		}
+		t, err := Read(bytes.NewReader(buf))
+		if assert.Nil(err) && assert.Len(t.Frames, 1) {
+			assert.Equal(want, t.Frames[0].(*TextFrame).Text)
+		}
+	}
+}
+
+func TestSetters(z *testing.T) {
+	assert := assert.New(z)
+
+	t := &Tag{Version: 3}
+	t.SetText("TIT2", "Title")
+	t.SetYear(1999)
+	t.SetTrack(3, 12)
+	t.SetDisc(1, 0)
+	t.SetComment("first")
+	t.SetComment("second")
+	t.Add(&CommentFrame{Language: "eng", Description: "other", Text: "kept"})
+	t.SetUserText("Extra", "x")
+	assert.Equal("Title", t.Text("TIT2"))
+	assert.Equal(1999, t.Year())
+	assert.Equal("1999", t.Text("TYER"))
+	n, total := t.Track()
+	assert.Equal([]int{3, 12}, []int{n, total})
+	n, total = t.Disc()
+	assert.Equal([]int{1, 0}, []int{n, total})
+	assert.Equal("second", t.Comment())
+	assert.Len(t.FramesByID("COMM"), 2)
+	assert.Equal([]string{"x"}, t.UserText("extra"))
+
+	t.SetText("TIT2")
+	t.SetYear(0)
+	t.SetComment("")
+	t.SetUserText("EXTRA")
+	assert.Nil(t.Frame("TIT2"))
+	assert.Nil(t.Frame("TYER"))
+	assert.Nil(t.UserText("extra"))
+	assert.Equal("kept", t.Comment())
+}
+
+func TestGenre(z *testing.T) {
+	tests := []struct {
+		Text  []string
+		Genre string
+	}{
+		{[]string{"Rock"}, "Rock"},
+		{[]string{"17"}, "Rock"},
+		{[]string{"(17)"}, "Rock"},
+		{[]string{"(17)Heavy Rock"}, "Heavy Rock"},
+		{[]string{"(RX)"}, "Remix"},
+		{[]string{"((Parenthesised)"}, "(Parenthesised)"},
+		{[]string{"8", "Swing"}, "Jazz/Swing"},
+		{[]string{"255"}, "255"},
+	}
+	for _, tt := range tests {
+		t := &Tag{Frames: []Frame{&TextFrame{FrameID: "TCON", Text: tt.Text}}}
+		if g := t.Genre(); g != tt.Genre {
+			z.Errorf("genre of %q: got %q, want %q", tt.Text, g, tt.Genre)
+		}
+	}
+}
+
+func TestUnsync(z *testing.T) {
+	tests := []struct {
+		In, Out []byte
+	}{
+		{[]byte{1, 2, 3}, []byte{1, 2, 3}},
+		{[]byte{0xFF, 0xE0}, []byte{0xFF, 0, 0xE0}},
+		{[]byte{0xFF, 0x00}, []byte{0xFF, 0, 0}},
+		{[]byte{0xFF, 0x10}, []byte{0xFF, 0x10}},
+		{[]byte{1, 0xFF}, []byte{1, 0xFF, 0}},
+	}
+	for _, tt := range tests {
+		if b := addUnsync(tt.In); !bytes.Equal(b, tt.Out) {
+			z.Errorf("addUnsync(% x): got % x, want % x", tt.In, b, tt.Out)
+		}
+		if b := removeUnsync(tt.Out); !bytes.Equal(b, tt.In) {
+			z.Errorf("removeUnsync(% x): got % x, want % x", tt.Out, b, tt.In)
+		}
+	}
+}
+
+func TestExtendedHeader24(z *testing.T) {
+	assert := assert.New(z)
+
+	t := &Tag{
+		Extended: &ExtendedHeader{HasCRC: true, Update: true, HasRestrictions: true, Restrictions: 0x42},
+		Frames:   []Frame{&TextFrame{FrameID: "TIT2", Text: []string{"Title"}}},
+	}
+	buf, err := Encode(t, 32)
+	if !assert.Nil(err) {
+		return
+	}
+	u, err := Read(bytes.NewReader(buf))
+	if !assert.Nil(err) {
+		return
+	}
+	if assert.NotNil(u.Extended) {
+		x := u.Extended
+		assert.True(x.Update)
+		assert.True(x.HasRestrictions)
+		assert.Equal(byte(0x42), x.Restrictions)
+		assert.True(x.HasCRC)
+		crc := crc32.NewIEEE()
+		crc.Write(buf[10+15:])
+		assert.Equal(crc.Sum32(), x.CRC)
+	}
+	assert.Equal(t.Frames, u.Frames)
+	assert.Equal(32, u.Padding)
+	assert.Equal(int64(len(buf)), u.Size)
+}
@@ -0,0 +1,345 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package id3
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/goulash/audio"
+)
+
+// The methods in this file give access to the frames of a tag by their
+// meaning. Frames that can occur more than once, such as "TXXX", are found
+// by their description, which is compared case-insensitively.
+
+// Frame returns the first frame with the ID, or nil.
+func (t *Tag) Frame(id string) Frame {
+	for _, f := range t.Frames {
+		if f.ID() == id {
+			return f
+		}
+	}
+	return nil
+}
+
+// FramesByID returns all frames with the ID.
+func (t *Tag) FramesByID(id string) []Frame {
+	var fs []Frame
+	for _, f := range t.Frames {
+		if f.ID() == id {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+// Add appends the frame to the tag.
+func (t *Tag) Add(f Frame) { t.Frames = append(t.Frames, f) }
+
+// Remove removes all frames with the ID.
+func (t *Tag) Remove(id string) { t.removeIf(func(f Frame) bool { return f.ID() == id }) }
+
+func (t *Tag) removeIf(match func(Frame) bool) {
+	fs := t.Frames[:0]
+	for _, f := range t.Frames {
+		if !match(f) {
+			fs = append(fs, f)
+		}
+	}
+	for i := len(fs); i < len(t.Frames); i++ {
+		t.Frames[i] = nil
+	}
+	t.Frames = fs
+}
+
+// replace replaces the first frame that matches by f, and removes the other
+// frames that match. If f is nil, all of them are removed.
+func (t *Tag) replace(f Frame, match func(Frame) bool) {
+	i := -1
+	for j, g := range t.Frames {
+		if match(g) {
+			i = j
+			break
+		}
+	}
+	if i >= 0 && f != nil {
+		t.Frames[i] = f
+	}
+	t.removeIf(func(g Frame) bool { return g != f && match(g) })
+	if i < 0 && f != nil {
+		t.Add(f)
+	}
+}
+
+// Text returns the values of the first text frame with the ID, joined by
+// "/", or the empty string.
+func (t *Tag) Text(id string) string {
+	if f, ok := t.Frame(id).(*TextFrame); ok {
+		return strings.Join(f.Text, "/")
+	}
+	return ""
+}
+
+// SetText replaces the text frames with the ID by a frame with the values.
+// If there are no values or only an empty one, the frames are removed.
+func (t *Tag) SetText(id string, values ...string) {
+	var f Frame
+	if len(values) > 1 || len(values) == 1 && values[0] != "" {
+		f = &TextFrame{FrameID: id, Text: values}
+	}
+	t.replace(f, func(g Frame) bool { return g.ID() == id })
+}
+
+// UserText returns the values of the TXXX frame with the description.
+func (t *Tag) UserText(description string) []string {
+	for _, f := range t.Frames {
+		if f, ok := f.(*UserTextFrame); ok && strings.EqualFold(f.Description, description) {
+			return f.Text
+		}
+	}
+	return nil
+}
+
+// SetUserText replaces the TXXX frames with the description by a frame with
+// the values, or removes them if there are no values.
+func (t *Tag) SetUserText(description string, values ...string) {
+	var f Frame
+	if len(values) > 0 {
+		f = &UserTextFrame{Description: description, Text: values}
+	}
+	t.replace(f, func(g Frame) bool {
+		u, ok := g.(*UserTextFrame)
+		return ok && strings.EqualFold(u.Description, description)
+	})
+}
+
+// URL returns the URL of the first URL link frame with the ID.
+func (t *Tag) URL(id string) string {
+	if f, ok := t.Frame(id).(*URLFrame); ok {
+		return f.URL
+	}
+	return ""
+}
+
+// SetURL replaces the URL link frames with the ID, or removes them if url
+// is empty.
+func (t *Tag) SetURL(id, url string) {
+	var f Frame
+	if url != "" {
+		f = &URLFrame{FrameID: id, URL: url}
+	}
+	t.replace(f, func(g Frame) bool { return g.ID() == id })
+}
+
+// Comment returns the text of the first comment without a description, or
+// else of the first comment.
+func (t *Tag) Comment() string {
+	var first *CommentFrame
+	for _, f := range t.Frames {
+		if f, ok := f.(*CommentFrame); ok {
+			if f.Description == "" {
+				return f.Text
+			}
+			if first == nil {
+				first = f
+			}
+		}
+	}
+	if first != nil {
+		return first.Text
+	}
+	return ""
+}
+
+// SetComment replaces the comments without a description, or removes them
+// if s is empty.
+func (t *Tag) SetComment(s string) {
+	var f Frame
+	if s != "" {
+		f = &CommentFrame{Language: "eng", Text: s}
+	}
+	t.replace(f, func(g Frame) bool {
+		c, ok := g.(*CommentFrame)
+		return ok && c.Description == ""
+	})
+}
+
+// Lyrics returns the text of the first USLT frame.
+func (t *Tag) Lyrics() string {
+	if f, ok := t.Frame("USLT").(*LyricsFrame); ok {
+		return f.Text
+	}
+	return ""
+}
+
+// Pictures returns the pictures of the APIC frames.
+func (t *Tag) Pictures() []audio.Picture {
+	var ps []audio.Picture
+	for _, f := range t.Frames {
+		if f, ok := f.(*PictureFrame); ok {
+			ps = append(ps, f.Picture)
+		}
+	}
+	return ps
+}
+
+// Year returns the year of the recording time (TDRC) of version 2.4, or of
+// the year (TYER) of earlier versions.
+func (t *Tag) Year() int {
+	for _, id := range []string{"TDRC", "TYER"} {
+		if s := t.Text(id); len(s) >= 4 {
+			if y, err := strconv.Atoi(s[:4]); err == nil {
+				return y
+			}
+		}
+	}
+	return 0
+}
+
+// SetYear sets the recording time of version 2.4 or the year of earlier
+// versions, or removes them if n is 0.
+func (t *Tag) SetYear(n int) {
+	id, other := "TDRC", "TYER"
+	if t.Version == 2 || t.Version == 3 {
+		id, other = other, id
+	}
+	t.Remove(other)
+	if n == 0 {
+		t.Remove(id)
+		return
+	}
+	t.SetText(id, strconv.Itoa(n))
+}
+
+// Genre returns the content type (TCON). References to the ID3v1 genres,
+// such as "(17)" or "17", are replaced by the names of the genres.
+func (t *Tag) Genre() string {
+	f, ok := t.Frame("TCON").(*TextFrame)
+	if !ok {
+		return ""
+	}
+	gs := make([]string, 0, len(f.Text))
+	for _, s := range f.Text {
+		gs = append(gs, genreName(s))
+	}
+	return strings.Join(gs, "/")
+}
+
+// genreName replaces a reference to an ID3v1 genre in s by its name. In
+// version 2.3, the reference is in parentheses and may be followed by a
+// refinement, which is used instead if there is one.
+func genreName(s string) string {
+	if strings.HasPrefix(s, "(") && !strings.HasPrefix(s, "((") {
+		if i := strings.IndexByte(s, ')'); i > 0 {
+			if i+1 < len(s) {
+				return s[i+1:]
+			}
+			s = s[1:i]
+		}
+	}
+	switch s {
+	case "RX":
+		return "Remix"
+	case "CR":
+		return "Cover"
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n < len(Genres) {
+		return Genres[n]
+	}
+	return strings.Replace(s, "((", "(", 1)
+}
+
+// Track returns the track number and the total number of tracks of the
+// TRCK frame, which has the form "n/total".
+func (t *Tag) Track() (int, int) { return parsePosition(t.Text("TRCK")) }
+
+// Disc returns the disc number and the total number of discs of the TPOS
+// frame.
+func (t *Tag) Disc() (int, int) { return parsePosition(t.Text("TPOS")) }
+
+func (t *Tag) SetTrack(n, total int) { t.SetText("TRCK", formatPosition(n, total)) }
+func (t *Tag) SetDisc(n, total int)  { t.SetText("TPOS", formatPosition(n, total)) }
+
+func parsePosition(s string) (int, int) {
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		n, _ := strconv.Atoi(strings.TrimSpace(s))
+		return n, 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(s[:i]))
+	total, _ := strconv.Atoi(strings.TrimSpace(s[i+1:]))
+	return n, total
+}
+
+func formatPosition(n, total int) string {
+	switch {
+	case n == 0 && total == 0:
+		return ""
+	case total == 0:
+		return strconv.Itoa(n)
+	default:
+		return strconv.Itoa(n) + "/" + strconv.Itoa(total)
+	}
+}
+
+// TrackGain and AlbumGain return the ReplayGain values in the TXXX frames
+// REPLAYGAIN_TRACK_GAIN, REPLAYGAIN_TRACK_PEAK, and so on.
+func (t *Tag) TrackGain() (gain, peak float64, ok bool) { return t.gain("track") }
+func (t *Tag) AlbumGain() (gain, peak float64, ok bool) { return t.gain("album") }
+
+func (t *Tag) gain(kind string) (gain, peak float64, ok bool) {
+	gain, ok = t.float("replaygain_" + kind + "_gain")
+	if !ok {
+		return 0, 0, false
+	}
+	peak, _ = t.float("replaygain_" + kind + "_peak")
+	return gain, peak, true
+}
+
+// float returns the number in the TXXX frame, ignoring a unit such as " dB".
+func (t *Tag) float(description string) (float64, bool) {
+	v := t.UserText(description)
+	if len(v) == 0 {
+		return 0, false
+	}
+	fs := strings.Fields(v[0])
+	if len(fs) == 0 {
+		return 0, false
+	}
+	x, err := strconv.ParseFloat(fs[0], 64)
+	if err != nil {
+		return 0, false
+	}
+	return x, true
+}
+
+// MusicBrainz returns the identifiers in the frames that are written by
+// MusicBrainz Picard: the recording in a UFID frame, and the others in
+// TXXX frames.
+func (t *Tag) MusicBrainz() audio.MusicBrainzIDs {
+	ids := audio.MusicBrainzIDs{
+		Track:        strings.Join(t.UserText("MusicBrainz Release Track Id"), "/"),
+		Release:      strings.Join(t.UserText("MusicBrainz Album Id"), "/"),
+		ReleaseGroup: strings.Join(t.UserText("MusicBrainz Release Group Id"), "/"),
+		Work:         strings.Join(t.UserText("MusicBrainz Work Id"), "/"),
+		Artists:      t.UserText("MusicBrainz Artist Id"),
+		AlbumArtists: t.UserText("MusicBrainz Album Artist Id"),
+	}
+	for _, f := range t.FramesByID("UFID") {
+		if f, ok := f.(*UniqueFileIDFrame); ok && f.Owner == "http://musicbrainz.org" {
+			ids.Recording = string(f.Identifier)
+			break
+		}
+	}
+	return ids
+}
+
+var (
+	_ = audio.PictureReader(new(Tag))
+	_ = audio.ReplayGainReader(new(Tag))
+	_ = audio.MusicBrainzReader(new(Tag))
+	_ = audio.LyricsReader(new(Tag))
+)
@@ -0,0 +1,193 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package id3
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// V1Tag is an ID3v1 or ID3v1.1 tag, which takes up the last 128 bytes of
+// a file. The strings are stored in ISO-8859-1.
+type V1Tag struct {
+	Title   string
+	Artist  string
+	Album   string
+	Year    int
+	Comment string
+	Track   int  // only in ID3v1.1, or 0
+	Genre   byte // an index into Genres, or 255 if there is no genre
+}
+
+// ReadV1 reads the ID3v1 tag at the end of the stream in r. It returns
+// ErrNoTag if there is none.
+func ReadV1(r io.ReadSeeker) (*V1Tag, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if end < 128 {
+		return nil, ErrNoTag
+	}
+	if _, err := r.Seek(end-128, io.SeekStart); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 128)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return ParseV1(b)
+}
+
+/*
+ParseV1 parses the 128 bytes of an ID3v1 tag.
+
+Encoding format
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    3 "TAG"
+   30 Title
+   30 Artist
+   30 Album
+    4 Year
+   30 Comment; in ID3v1.1, the last two bytes are a zero byte and the track
+    1 Genre
+===== ===========================================================================
+
+All strings are padded with zero bytes or spaces.
+*/
+func ParseV1(b []byte) (*V1Tag, error) {
+	if len(b) != 128 || string(b[:3]) != "TAG" {
+		return nil, ErrNoTag
+	}
+	str := func(b []byte) string {
+		if i := strings.IndexByte(string(b), 0); i >= 0 {
+			b = b[:i]
+		}
+		return strings.TrimRight(decodeString(encLatin1, b), " ")
+	}
+	t := &V1Tag{
+		Title:  str(b[3:33]),
+		Artist: str(b[33:63]),
+		Album:  str(b[63:93]),
+		Genre:  b[127],
+	}
+	t.Year, _ = strconv.Atoi(strings.TrimSpace(str(b[93:97])))
+	comment := b[97:127]
+	if comment[28] == 0 && comment[29] != 0 {
+		t.Track = int(comment[29])
+		comment = comment[:28]
+	}
+	t.Comment = str(comment)
+	return t, nil
+}
+
+// Bytes returns the 128 bytes of the tag. Strings that are too long are
+// truncated. The tag is written as ID3v1.1 if it has a track number.
+func (t *V1Tag) Bytes() []byte {
+	b := make([]byte, 128)
+	copy(b, "TAG")
+	put := func(p []byte, s string) {
+		copy(p, appendString(nil, encLatin1, s, false))
+	}
+	put(b[3:33], t.Title)
+	put(b[33:63], t.Artist)
+	put(b[63:93], t.Album)
+	if t.Year > 0 && t.Year < 10000 {
+		put(b[93:97], strconv.Itoa(t.Year))
+	}
+	if t.Track > 0 && t.Track < 256 {
+		put(b[97:125], t.Comment)
+		b[126] = byte(t.Track)
+	} else {
+		put(b[97:127], t.Comment)
+	}
+	b[127] = t.Genre
+	return b
+}
+
+// GenreName returns the name of the genre, or the empty string.
+func (t *V1Tag) GenreName() string {
+	if int(t.Genre) < len(Genres) {
+		return Genres[t.Genre]
+	}
+	return ""
+}
+
+// SetGenreName sets the genre to the ID3v1 genre with the name, which is
+// compared case-insensitively, or to no genre if there is none.
+func (t *V1Tag) SetGenreName(s string) {
+	t.Genre = 255
+	for i, g := range Genres {
+		if strings.EqualFold(g, s) {
+			t.Genre = byte(i)
+			return
+		}
+	}
+}
+
+// WriteV1File writes the ID3v1 tag t to the end of the file at path,
+// replacing the ID3v1 tag that is there, if any. If t is nil, the tag is
+// removed.
+func WriteV1File(path string, t *V1Tag) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := ReadV1(f); err == nil {
+		end -= 128
+	} else if err != ErrNoTag {
+		return err
+	}
+	if t == nil {
+		return f.Truncate(end)
+	}
+	if _, err := f.WriteAt(t.Bytes(), end); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Genres are the genres of ID3v1, including the extensions of Winamp.
+var Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge",
+	"Hip-Hop", "Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B",
+	"Rap", "Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska",
+	"Death Metal", "Pranks", "Soundtrack", "Euro-Techno", "Ambient",
+	"Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance", "Classical",
+	"Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"Alternative Rock", "Bass", "Soul", "Punk", "Space", "Meditative",
+	"Instrumental Pop", "Instrumental Rock", "Ethnic", "Gothic", "Darkwave",
+	"Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap",
+	"Pop/Funk", "Jungle", "Native American", "Cabaret", "New Wave",
+	"Psychedelic", "Rave", "Showtunes", "Trailer", "Lo-Fi", "Tribal",
+	"Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll",
+	"Hard Rock",
+
+	// Winamp extensions
+	"Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion", "Bebop",
+	"Latin", "Revival", "Celtic", "Bluegrass", "Avantgarde", "Gothic Rock",
+	"Progressive Rock", "Psychedelic Rock", "Symphonic Rock", "Slow Rock",
+	"Big Band", "Chorus", "Easy Listening", "Acoustic", "Humour", "Speech",
+	"Chanson", "Opera", "Chamber Music", "Sonata", "Symphony", "Booty Bass",
+	"Primus", "Porn Groove", "Satire", "Slow Jam", "Club", "Tango", "Samba",
+	"Folklore", "Ballad", "Power Ballad", "Rhythmic Soul", "Freestyle",
+	"Duet", "Punk Rock", "Drum Solo", "A Cappella", "Euro-House",
+	"Dance Hall", "Goa", "Drum & Bass", "Club-House", "Hardcore Techno",
+	"Terror", "Indie", "BritPop", "Negerpunk", "Polsk Punk", "Beat",
+	"Christian Gangsta Rap", "Heavy Metal", "Black Metal", "Crossover",
+	"Contemporary Christian", "Christian Rock", "Merengue", "Salsa",
+	"Thrash Metal", "Anime", "JPop", "Synthpop",
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package id3
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestV1(z *testing.T) {
+	assert := assert.New(z)
+
+	tests := []V1Tag{
+		{Title: "Title", Artist: "Artist", Album: "Album", Year: 1999, Comment: "Comment", Genre: 17},
+		{Title: "Tïtle", Comment: "ID3v1.1", Track: 12, Genre: 255},
+		{Comment: "A comment that is exactly 30 c", Genre: 0},
+	}
+	for _, tt := range tests {
+		b := tt.Bytes()
+		assert.Len(b, 128)
+		t, err := ParseV1(b)
+		if assert.Nil(err) {
+			assert.Equal(tt, *t)
+		}
+	}
+
+	t := &V1Tag{Title: "A title that is longer than thirty characters", Genre: 255}
+	u, err := ParseV1(t.Bytes())
+	if assert.Nil(err) {
+		assert.Equal(t.Title[:30], u.Title)
+	}
+
+	// Strings padded with spaces instead of zero bytes.
+	b := bytes.Repeat([]byte(" "), 128)
+	copy(b, "TAGTitle")
+	b[127] = 8
+	if u, err := ParseV1(b); assert.Nil(err) {
+		assert.Equal("Title", u.Title)
+		assert.Equal("", u.Comment)
+		assert.Equal("Jazz", u.GenreName())
+	}
+
+	_, err = ParseV1(make([]byte, 128))
+	assert.Equal(ErrNoTag, err)
+
+	t.SetGenreName("hip-hop")
+	assert.Equal(byte(7), t.Genre)
+	t.SetGenreName("Unknown")
+	assert.Equal(byte(255), t.Genre)
+	assert.Equal("", t.GenreName())
+}
+
+func TestWriteV1File(z *testing.T) {
+	assert := assert.New(z)
+
+	dir, err := ioutil.TempDir("", "id3")
+	if !assert.Nil(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.mp3")
+	if !assert.Nil(ioutil.WriteFile(path, []byte("audio"), 0644)) {
+		return
+	}
+
+	t := &V1Tag{Title: "One", Genre: 255}
+	assert.Nil(WriteV1File(path, t))
+	t.Title = "Two"
+	assert.Nil(WriteV1File(path, t))
+	data, _ := ioutil.ReadFile(path)
+	assert.Len(data, 5+128)
+	if u, err := ReadV1(bytes.NewReader(data)); assert.Nil(err) {
+		assert.Equal("Two", u.Title)
+	}
+
+	assert.Nil(WriteV1File(path, nil))
+	data, _ = ioutil.ReadFile(path)
+	assert.Equal([]byte("audio"), data)
+}
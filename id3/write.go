@@ -0,0 +1,402 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package id3
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultPadding is the number of bytes of padding that is written after
+// the frames when a file has to be rewritten, so that later changes can be
+// written in place.
+const DefaultPadding = 4096
+
+// WriteFile writes the tag t to the beginning of the file at path,
+// replacing the ID3v2 tag that is there, if any. The rest of the file is
+// not touched.
+//
+// If the new tag fits in the space taken up by the old tag, only this
+// region of the file is rewritten and the remaining space is filled with
+// padding. Otherwise, the file is rewritten to a temporary file with
+// DefaultPadding bytes of padding, which then replaces the original file.
+// The Size and Padding of t are updated.
+//
+// See Encode for how a tag of version 2.2 is written.
+func WriteFile(path string, t *Tag) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var size int64
+	if cur, err := Read(f); err == nil {
+		size = cur.Size
+	} else if err != ErrNoTag {
+		return err
+	}
+
+	buf, err := Encode(t, 0)
+	if err != nil {
+		return err
+	}
+	if free := size - int64(len(buf)); free == 0 || free > 0 && !t.Footer {
+		if free > 0 {
+			if buf, err = Encode(t, int(free)); err != nil {
+				return err
+			}
+		}
+		if _, err := f.WriteAt(buf, 0); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		t.Size, t.Padding = size, int(free)
+		return nil
+	}
+
+	padding := DefaultPadding
+	if t.Footer {
+		padding = 0
+	}
+	if buf, err = Encode(t, padding); err != nil {
+		return err
+	}
+	if err := rewriteFile(f, buf, size); err != nil {
+		return err
+	}
+	t.Size, t.Padding = int64(len(buf)), padding
+	return nil
+}
+
+// rewriteFile writes the header followed by the rest of f from offset to
+// a temporary file, which then replaces f.
+func rewriteFile(f *os.File, header []byte, offset int64) (err error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	path := f.Name()
+	t, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			t.Close()
+			os.Remove(t.Name())
+		}
+	}()
+
+	if _, err = t.Write(header); err != nil {
+		return err
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err = io.Copy(t, f); err != nil {
+		return err
+	}
+	if err = t.Chmod(fi.Mode()); err != nil {
+		return err
+	}
+	if err = t.Sync(); err != nil {
+		return err
+	}
+	if err = t.Close(); err != nil {
+		return err
+	}
+	return os.Rename(t.Name(), path)
+}
+
+// Write writes the tag t to w, followed by the given number of bytes of
+// padding.
+func Write(w io.Writer, t *Tag, padding int) error {
+	buf, err := Encode(t, padding)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+// Encode returns the tag t, followed by the given number of bytes of
+// padding. A tag of version 2.2 is written as version 2.3, since version
+// 2.2 is obsolete, and frames that have no equivalent in version 2.3 are
+// written as experimental frames, whose ID is prefixed with X. A tag
+// without a version is written as version 2.4. A frame whose ID is not
+// valid in the version written is an ErrInvalidFrameID.
+//
+// Text is written in UTF-8 in version 2.4, and in ISO-8859-1 or UTF-16 in
+// version 2.3, which only supports multiple values in text frames by
+// joining them with "/". A tag with a footer has no padding.
+func Encode(t *Tag, padding int) ([]byte, error) {
+	e := encoder{version: t.Version}
+	switch t.Version {
+	case 0, 4:
+		e.version = 4
+	case 2, 3:
+		e.version = 3
+	default:
+		return nil, ErrUnsupportedVersion
+	}
+	footer := t.Footer && e.version == 4
+	if footer || padding < 0 {
+		padding = 0
+	}
+	e.unsync = t.Unsynchronisation && e.version == 4
+
+	frames, err := e.frames(t.Frames)
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	if t.Extended != nil {
+		body = e.extendedHeader(t.Extended, frames, padding)
+	}
+	body = append(body, frames...)
+	if t.Unsynchronisation && e.version == 3 {
+		body = addUnsync(body)
+	}
+	if len(body)+padding > maxTagSize {
+		return nil, ErrTagTooLarge
+	}
+
+	var flags byte
+	if t.Unsynchronisation {
+		flags |= 0x80
+	}
+	if t.Extended != nil {
+		flags |= 0x40
+	}
+	if t.Experimental {
+		flags |= 0x20
+	}
+	if footer {
+		flags |= 0x10
+	}
+	h := append([]byte("ID3"), e.version, 0, flags)
+	h = appendSynchsafe(h, uint32(len(body)+padding))
+	buf := make([]byte, 0, len(h)+len(body)+padding+len(h))
+	buf = append(buf, h...)
+	buf = append(buf, body...)
+	buf = append(buf, make([]byte, padding)...)
+	if footer {
+		buf = append(buf, "3DI"...)
+		buf = append(buf, h[3:]...)
+	}
+	return buf, nil
+}
+
+// encoder encodes the frames of a tag of version 2.3 or 2.4.
+type encoder struct {
+	version byte
+	unsync  bool // unsynchronise each frame of version 2.4
+}
+
+// extendedHeader returns the extended header, with the CRC of the frames
+// if it has one. See readExtendedHeader for the format.
+func (e *encoder) extendedHeader(x *ExtendedHeader, frames []byte, padding int) []byte {
+	if e.version == 3 {
+		b := []byte{0, 0, 0, 6, 0, 0}
+		if x.HasCRC {
+			b[3], b[4] = 10, 0x80
+		}
+		b = appendUint32(b, uint32(padding))
+		if x.HasCRC {
+			b = appendUint32(b, crc32.ChecksumIEEE(frames))
+		}
+		return b
+	}
+
+	var flags byte
+	var data []byte
+	if x.Update {
+		flags |= 0x40
+		data = append(data, 0)
+	}
+	if x.HasCRC {
+		flags |= 0x20
+		crc := crc32.NewIEEE()
+		crc.Write(frames)
+		crc.Write(make([]byte, padding))
+		v := crc.Sum32()
+		data = append(data, 5, byte(v>>28))
+		data = appendSynchsafe(data, v)
+	}
+	if x.HasRestrictions {
+		flags |= 0x10
+		data = append(data, 1, x.Restrictions)
+	}
+	b := appendSynchsafe(nil, uint32(6+len(data)))
+	b = append(b, 1, flags)
+	return append(b, data...)
+}
+
+// frames returns the encoded frames.
+func (e *encoder) frames(fs []Frame) ([]byte, error) {
+	var buf []byte
+	for _, f := range fs {
+		id := f.ID()
+		if len(id) == 3 && validID(id) {
+			// A frame of version 2.2 without an equivalent is kept as
+			// an experimental frame, whose ID starts with X.
+			id = "X" + id
+		}
+		if len(id) != 4 || !validID(id) {
+			return nil, ErrInvalidFrameID
+		}
+		body, flags, err := e.frame(f)
+		if err != nil {
+			return nil, err
+		}
+		if e.unsync {
+			body = addUnsync(body)
+			flags |= FlagUnsynchronisation
+		}
+		if len(body) > maxTagSize {
+			return nil, ErrTagTooLarge
+		}
+		buf = append(buf, id...)
+		if e.version == 4 {
+			buf = appendSynchsafe(buf, uint32(len(body)))
+			buf = append(buf, byte(flags>>8), byte(flags))
+		} else {
+			buf = appendUint32(buf, uint32(len(body)))
+			f := toV23Flags(flags)
+			buf = append(buf, byte(f>>8), byte(f))
+		}
+		buf = append(buf, body...)
+	}
+	return buf, nil
+}
+
+// frame returns the content and the flags of the frame f.
+func (e *encoder) frame(f Frame) ([]byte, FrameFlags, error) {
+	var b []byte
+	switch f := f.(type) {
+	case *RawFrame:
+		return f.Data, f.Flags &^ FlagUnsynchronisation, nil
+	case *TextFrame:
+		b = e.text(nil, "", false, f.Text)
+	case *UserTextFrame:
+		b = e.text(nil, f.Description, true, f.Text)
+	case *URLFrame:
+		b = appendString(nil, encLatin1, f.URL, false)
+	case *UserURLFrame:
+		enc := encoding(e.version, f.Description)
+		b = append(b, enc)
+		b = appendString(b, enc, f.Description, true)
+		b = appendString(b, encLatin1, f.URL, false)
+	case *CommentFrame:
+		b = e.langText(f.Language, f.Description, f.Text)
+	case *LyricsFrame:
+		b = e.langText(f.Language, f.Description, f.Text)
+	case *PictureFrame:
+		enc := encoding(e.version, f.Description)
+		b = append(b, enc)
+		b = appendString(b, encLatin1, f.MIMEType, true)
+		b = append(b, byte(f.Type))
+		b = appendString(b, enc, f.Description, true)
+		b = append(b, f.Data...)
+	case *PopularimeterFrame:
+		b = appendString(nil, encLatin1, f.Email, true)
+		b = append(b, f.Rating)
+		if f.Counter > 0xFFFFFFFF {
+			b = appendUint32(b, uint32(f.Counter>>32))
+		}
+		b = appendUint32(b, uint32(f.Counter))
+	case *UniqueFileIDFrame:
+		b = appendString(nil, encLatin1, f.Owner, true)
+		b = append(b, f.Identifier...)
+	case *ChapterFrame:
+		b = appendString(nil, encLatin1, f.ElementID, true)
+		b = appendUint32(b, uint32(f.Start/time.Millisecond))
+		b = appendUint32(b, uint32(f.End/time.Millisecond))
+		b = appendUint32(b, f.StartOffset)
+		b = appendUint32(b, f.EndOffset)
+		sub, err := e.subframes(f.Frames)
+		if err != nil {
+			return nil, 0, err
+		}
+		b = append(b, sub...)
+	case *TOCFrame:
+		b = appendString(nil, encLatin1, f.ElementID, true)
+		var flags byte
+		if f.TopLevel {
+			flags |= 0x02
+		}
+		if f.Ordered {
+			flags |= 0x01
+		}
+		if len(f.Children) > 0xFF {
+			return nil, 0, ErrTagTooLarge
+		}
+		b = append(b, flags, byte(len(f.Children)))
+		for _, c := range f.Children {
+			b = appendString(b, encLatin1, c, true)
+		}
+		sub, err := e.subframes(f.Frames)
+		if err != nil {
+			return nil, 0, err
+		}
+		b = append(b, sub...)
+	default:
+		return nil, 0, errInvalidFrame
+	}
+	return b, 0, nil
+}
+
+// subframes returns the encoded frames that are embedded in another frame.
+// They are not unsynchronised, since the frame that contains them is.
+func (e *encoder) subframes(fs []Frame) ([]byte, error) {
+	x := *e
+	x.unsync = false
+	return x.frames(fs)
+}
+
+// text returns the content of a text frame with the values, which has a
+// description if desc is true.
+func (e *encoder) text(b []byte, description string, desc bool, values []string) []byte {
+	if e.version == 3 && len(values) > 1 {
+		values = []string{strings.Join(values, "/")}
+	}
+	enc := encoding(e.version, append([]string{description}, values...)...)
+	b = append(b, enc)
+	if desc {
+		b = appendString(b, enc, description, true)
+	}
+	for i, v := range values {
+		b = appendString(b, enc, v, i < len(values)-1)
+	}
+	return b
+}
+
+// langText returns the content of a comment or lyrics frame.
+func (e *encoder) langText(lang, description, text string) []byte {
+	enc := encoding(e.version, description, text)
+	b := []byte{enc}
+	l := []byte("XXX")
+	if lang != "" {
+		copy(l, lang)
+	}
+	b = append(b, l...)
+	b = appendString(b, enc, description, true)
+	return appendString(b, enc, text, false)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var p [4]byte
+	binary.BigEndian.PutUint32(p[:], v)
+	return append(b, p[:]...)
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package id3
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFile(z *testing.T) {
+	assert := assert.New(z)
+
+	dir, err := ioutil.TempDir("", "id3")
+	if !assert.Nil(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.mp3")
+	audio := []byte("\xFF\xFB\x90\x00audio")
+	if !assert.Nil(ioutil.WriteFile(path, audio, 0644)) {
+		return
+	}
+
+	// A file without a tag is rewritten.
+	t := &Tag{Version: 3}
+	t.SetText("TIT2", "Title")
+	t.Add(&RawFrame{FrameID: "PRIV", Data: []byte("owner\x00data")})
+	if !assert.Nil(WriteFile(path, t)) {
+		return
+	}
+	assert.Equal(DefaultPadding, t.Padding)
+	size := t.Size
+	data, _ := ioutil.ReadFile(path)
+	assert.Equal(size+int64(len(audio)), int64(len(data)))
+	assert.Equal(audio, data[size:])
+
+	// A tag that fits is written in place.
+	u, err := ReadFile(path)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(t.Frames, u.Frames)
+	u.SetText("TALB", "Album")
+	if !assert.Nil(WriteFile(path, u)) {
+		return
+	}
+	assert.Equal(size, u.Size)
+	assert.True(u.Padding < DefaultPadding)
+	data, _ = ioutil.ReadFile(path)
+	assert.Equal(size+int64(len(audio)), int64(len(data)))
+	assert.Equal(audio, data[size:])
+
+	// A tag that does not fit is rewritten.
+	u.Add(&RawFrame{FrameID: "PRIV", Data: make([]byte, 2*DefaultPadding)})
+	if !assert.Nil(WriteFile(path, u)) {
+		return
+	}
+	assert.True(u.Size > size)
+	v, err := ReadFile(path)
+	if assert.Nil(err) {
+		assert.Equal("Title", v.Text("TIT2"))
+		assert.Equal("Album", v.Text("TALB"))
+		assert.Len(v.FramesByID("PRIV"), 2)
+		assert.Equal(u.Size, v.Size)
+	}
+	data, _ = ioutil.ReadFile(path)
+	assert.Equal(audio, data[u.Size:])
+}
@@ -0,0 +1,282 @@
+// Copyright (c) 2016, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// The built-in formats can be identified, but only some of them have a
+// metadata reader, which uses github.com/dhowden/tag. Packages such as
+// flac register a format for the same codec to replace a built-in one.
+func init() {
+	RegisterFormat(Format{
+		Name:       "flac",
+		Codec:      FLAC,
+		Magic:      []string{"fLaC"},
+		Extensions: []string{".flac"},
+		MIMETypes:  []string{"audio/flac", "audio/x-flac"},
+	})
+	RegisterFormat(Format{
+		Name:       "wav",
+		Codec:      WAV,
+		Magic:      []string{"RIFF????WAVE", "RF64????WAVE", "BW64????WAVE"},
+		Extensions: []string{".wav", ".wave"},
+		MIMETypes:  []string{"audio/wav", "audio/x-wav", "audio/vnd.wave"},
+	})
+	RegisterFormat(Format{
+		Name:       "ape",
+		Codec:      APE,
+		Magic:      []string{"MAC "},
+		Extensions: []string{".ape"},
+		MIMETypes:  []string{"audio/x-ape", "audio/ape"},
+	})
+	RegisterFormat(Format{
+		Name:       "ofr",
+		Codec:      OFR,
+		Magic:      []string{"OFR "},
+		Extensions: []string{".ofr", ".ofs"},
+		MIMETypes:  []string{"audio/x-optimfrog"},
+	})
+	RegisterFormat(Format{
+		Name:       "tak",
+		Codec:      TAK,
+		Magic:      []string{"tBaK"},
+		Extensions: []string{".tak"},
+		MIMETypes:  []string{"audio/x-tak"},
+	})
+	RegisterFormat(Format{
+		Name:       "wv",
+		Codec:      WV,
+		Magic:      []string{"wvpk"},
+		Extensions: []string{".wv"},
+		MIMETypes:  []string{"audio/x-wavpack", "audio/wavpack"},
+	})
+	RegisterFormat(Format{
+		Name:       "tta",
+		Codec:      TTA,
+		Magic:      []string{"TTA1"},
+		Extensions: []string{".tta"},
+		MIMETypes:  []string{"audio/x-tta", "audio/tta"},
+	})
+	RegisterFormat(Format{
+		Name:       "wma",
+		Codec:      WMA,
+		Sniff:      sniffASF(WMA),
+		Extensions: []string{".wma"},
+		MIMETypes:  []string{"audio/x-ms-wma"},
+	})
+	RegisterFormat(Format{
+		Name:       "wmal",
+		Codec:      WMAL,
+		Sniff:      sniffASF(WMAL),
+		Extensions: []string{".wma"},
+		MIMETypes:  []string{"audio/x-ms-wma"},
+	})
+	RegisterFormat(Format{
+		Name:         "ogg",
+		Codec:        OGG,
+		Magic:        []string{"OggS"},
+		Extensions:   []string{".ogg", ".oga", ".opus"},
+		MIMETypes:    []string{"audio/ogg", "audio/vorbis", "audio/opus"},
+		ReadMetadata: tagReader(OGG),
+	})
+	RegisterFormat(Format{
+		Name:         "m4b",
+		Codec:        M4B,
+		Magic:        []string{"????ftypM4B "},
+		Extensions:   []string{".m4b"},
+		MIMETypes:    []string{"audio/mp4", "audio/x-m4b"},
+		ReadMetadata: tagReader(M4B),
+	})
+	RegisterFormat(Format{
+		Name:         "m4p",
+		Codec:        M4P,
+		Magic:        []string{"????ftypM4P "},
+		Extensions:   []string{".m4p"},
+		MIMETypes:    []string{"audio/mp4", "audio/x-m4p"},
+		ReadMetadata: tagReader(M4P),
+	})
+	RegisterFormat(Format{
+		Name:         "m4a",
+		Codec:        M4A,
+		Magic:        []string{"????ftyp"},
+		Extensions:   []string{".m4a", ".mp4"},
+		MIMETypes:    []string{"audio/mp4", "audio/x-m4a"},
+		ReadMetadata: tagReader(M4A),
+	})
+	RegisterFormat(Format{
+		// ALAC is identified as M4A, and only told apart when reading
+		// the metadata, so it has no magic.
+		Name:         "alac",
+		Codec:        ALAC,
+		Extensions:   []string{".m4a"},
+		MIMETypes:    []string{"audio/mp4"},
+		ReadMetadata: tagReader(ALAC),
+	})
+	RegisterFormat(Format{
+		Name:         "mp3",
+		Codec:        MP3,
+		Magic:        []string{"ID3"},
+		Sniff:        sniffHeader(4, isMPEGAudio),
+		Extensions:   []string{".mp3"},
+		MIMETypes:    []string{"audio/mpeg", "audio/mp3"},
+		ReadMetadata: tagReader(MP3),
+	})
+	RegisterFormat(Format{
+		Name:       "aac",
+		Codec:      AAC,
+		Magic:      []string{"ADIF"},
+		Sniff:      sniffHeader(2, isADTS),
+		Extensions: []string{".aac"},
+		MIMETypes:  []string{"audio/aac", "audio/aacp"},
+	})
+}
+
+// sniffHeader returns a sniff function that calls f with the first n bytes
+// of the stream.
+func sniffHeader(n int, f func([]byte) bool) func(io.ReadSeeker) (bool, error) {
+	return func(r io.ReadSeeker) (bool, error) {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return false, nil
+			}
+			return false, err
+		}
+		return f(b), nil
+	}
+}
+
+// id3v2Size returns the size of the ID3v2 tag that b starts with, including
+// the 10 byte header and the footer, if there is one.
+func id3v2Size(b []byte) int64 {
+	n := int64(b[6]&0x7F)<<21 | int64(b[7]&0x7F)<<14 | int64(b[8]&0x7F)<<7 | int64(b[9]&0x7F)
+	if b[5]&0x10 != 0 {
+		n += 10
+	}
+	return 10 + n
+}
+
+// isMPEGAudio returns true if b starts with a valid MPEG Layer III frame header.
+func isMPEGAudio(b []byte) bool {
+	if b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return false
+	}
+	version := b[1] >> 3 & 0x03
+	layer := b[1] >> 1 & 0x03
+	bitrate := b[2] >> 4
+	rate := b[2] >> 2 & 0x03
+	return version != 1 && layer == 1 && bitrate != 0x0F && rate != 0x03
+}
+
+// isADTS returns true if b starts with the sync word of an AAC ADTS frame,
+// which has the layer set to 0.
+func isADTS(b []byte) bool {
+	return b[0] == 0xFF && b[1]&0xF6 == 0xF0
+}
+
+// ASF {{{
+
+// The GUIDs are stored in the mixed-endian form in which they appear
+// in the stream.
+var (
+	asfHeaderGUID           = []byte("\x30\x26\xB2\x75\x8E\x66\xCF\x11\xA6\xD9\x00\xAA\x00\x62\xCE\x6C")
+	asfStreamPropertiesGUID = []byte("\x91\x07\xDC\xB7\xB7\xA9\xCF\x11\x8E\xE6\x00\xC0\x0C\x20\x53\x65")
+	asfAudioMediaGUID       = []byte("\x40\x9E\x69\xF8\x4D\x5B\xCF\x11\xA8\xFD\x00\x80\x5F\x5C\x44\x2B")
+)
+
+// wmaLosslessFormat is the WAVEFORMATEX format tag of WMA Lossless.
+const wmaLosslessFormat = 0x0163
+
+// sniffASF returns a sniff function for the codec c, which is either WMA
+// or WMAL.
+func sniffASF(c Codec) func(io.ReadSeeker) (bool, error) {
+	return func(r io.ReadSeeker) (bool, error) {
+		b := make([]byte, 16)
+		if _, err := io.ReadFull(r, b); err != nil || !bytes.Equal(b, asfHeaderGUID) {
+			return false, nil
+		}
+		x, err := readASFCodec(r)
+		return x == c, err
+	}
+}
+
+/*
+readASFCodec reads the ASF header object, whose GUID has already been read,
+and looks at the audio streams to tell WMA Lossless from the other WMA codecs.
+
+Encoding format
+
+BYTES DESCRIPTION
+===== ===========================================================================
+   16 Header object GUID
+    8 Size of the header object, including the nested objects
+    4 Number of nested objects
+    2 Reserved
+  n*x Nested objects, each with a 16 byte GUID and an 8 byte size, of which
+      the stream properties object contains:
+   16 Stream type GUID, which is the audio media GUID for audio streams
+   16 Error correction type GUID
+    8 Time offset
+    4 Length of the type-specific data
+    4 Length of the error correction data
+    2 Flags
+    4 Reserved
+    n Type-specific data, which is a WAVEFORMATEX for audio streams and
+      starts with the 2 byte format tag
+===== ===========================================================================
+
+All integers are little-endian.
+*/
+func readASFCodec(r io.ReadSeeker) (Codec, error) {
+	var h struct {
+		Size     uint64
+		Objects  uint32
+		Reserved uint16
+	}
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return Unknown, nil
+	}
+
+	codec := Unknown
+	for i := uint32(0); i < h.Objects; i++ {
+		var o struct {
+			GUID [16]byte
+			Size uint64
+		}
+		if err := binary.Read(r, binary.LittleEndian, &o); err != nil || o.Size < 24 || o.Size > math.MaxInt64 {
+			break
+		}
+		n := int64(o.Size) - 24
+		if !bytes.Equal(o.GUID[:], asfStreamPropertiesGUID) || n < 56 {
+			if _, err := r.Seek(n, io.SeekCurrent); err != nil {
+				return Unknown, err
+			}
+			continue
+		}
+
+		p := make([]byte, 56)
+		if _, err := io.ReadFull(r, p); err != nil {
+			break
+		}
+		if _, err := r.Seek(n-56, io.SeekCurrent); err != nil {
+			return Unknown, err
+		}
+		if !bytes.Equal(p[:16], asfAudioMediaGUID) {
+			continue
+		}
+		if binary.LittleEndian.Uint16(p[54:]) == wmaLosslessFormat {
+			return WMAL, nil
+		}
+		codec = WMA
+	}
+	return codec, nil
+}
+
+// }}}
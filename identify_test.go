@@ -0,0 +1,167 @@
+// Copyright (c) 2016, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// asfHeader returns an ASF header object with one audio stream properties
+// object for the given format tag.
+func asfHeader(format uint16) []byte {
+	sp := append([]byte(nil), asfAudioMediaGUID...)
+	sp = append(sp, make([]byte, 16+8+4+4+2+4)...)
+	sp = append(sp, byte(format), byte(format>>8))
+	sp = append(sp, make([]byte, 16)...) // rest of WAVEFORMATEX
+
+	var buf bytes.Buffer
+	buf.Write(asfHeaderGUID)
+	binary.Write(&buf, binary.LittleEndian, uint64(30+24+len(sp)))
+	binary.Write(&buf, binary.LittleEndian, uint32(1))
+	buf.Write([]byte{1, 2})
+	buf.Write(asfStreamPropertiesGUID)
+	binary.Write(&buf, binary.LittleEndian, uint64(24+len(sp)))
+	buf.Write(sp)
+	return buf.Bytes()
+}
+
+func TestIdentifyFormat(z *testing.T) {
+	id3 := []byte("ID3\x04\x00\x00\x00\x00\x00\x04....")
+	v1 := append([]byte("TAG"), make([]byte, 125)...)
+	// An object is too large to be skipped.
+	huge := append([]byte(nil), asfHeaderGUID...)
+	huge = append(huge, 30, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 2)
+	huge = append(huge, make([]byte, 16)...)
+	huge = append(huge, 0, 0, 0, 0, 0, 0, 0, 0x80)
+	tests := []struct {
+		In     []byte
+		Out    Codec
+		Offset int64
+	}{
+		{[]byte(""), Unknown, 0},
+		{[]byte("fLaC\x00\x00\x00\x22"), FLAC, 0},
+		{[]byte("OggS\x00\x02"), OGG, 0},
+		{[]byte("RIFF\x24\x00\x00\x00WAVEfmt "), WAV, 0},
+		{[]byte("RIFF\x24\x00\x00\x00AVI LIST"), Unknown, 0},
+		{[]byte("RF64\xFF\xFF\xFF\xFFWAVEds64"), WAV, 0},
+		{[]byte("MAC \x96\x0f\x00\x00"), APE, 0},
+		{[]byte("OFR \x0f\x00\x00\x00"), OFR, 0},
+		{[]byte("tBaK\x00\x00\x00\x00"), TAK, 0},
+		{[]byte("wvpk\x00\x00\x00\x00"), WV, 0},
+		{[]byte("TTA1\x01\x00\x02\x00"), TTA, 0},
+		{append(id3, "TTA1\x01\x00\x02\x00"...), TTA, 14},
+		{append(id3, 0xFF, 0xFB, 0x90, 0x64), MP3, 0},
+		{[]byte{0xFF, 0xFB, 0x90, 0x64}, MP3, 0},
+		{[]byte{0xFF, 0xFB, 0xF0, 0x64}, Unknown, 0},
+		{[]byte{0xFF, 0xF1, 0x50, 0x80}, AAC, 0},
+		{[]byte("ADIF\x00\x00"), AAC, 0},
+		{asfHeader(0x0161), WMA, 0},
+		{asfHeader(0x0163), WMAL, 0},
+		{asfHeaderGUID, Unknown, 0},
+		{huge, Unknown, 0},
+		{append([]byte("junk"), v1...), MP3, 0},
+		{append(id3, v1...), MP3, 0},
+		{v1[:127], Unknown, 0},
+	}
+
+	for _, t := range tests {
+		f, off, err := identify(bytes.NewReader(t.In))
+		if err != nil || f.Codec != t.Out || off != t.Offset {
+			z.Errorf("identify(%q) = %v, %d, %v; expecting %v, %d", t.In, f.Codec, off, err, t.Out, t.Offset)
+		}
+	}
+}
+
+func TestIdentifyReader(z *testing.T) {
+	for _, t := range []struct {
+		In  []byte
+		Out Codec
+	}{
+		{[]byte("wvpk\x00\x00\x00\x00"), WV},
+		{[]byte("ID3\x04\x00\x00\x00\x00\x00\x00\xFF\xFB"), MP3},
+	} {
+		c, err := IdentifyReader(bytes.NewReader(t.In))
+		if err != nil || c != t.Out {
+			z.Errorf("IdentifyReader(%q) = %v, %v; expecting %v", t.In, c, err, t.Out)
+		}
+	}
+}
+
+func TestIdentify(z *testing.T) {
+	tests := map[string]Codec{
+		"flac/test.flac": FLAC,
+		"wav/test.wav":   WAV,
+	}
+	for k, v := range tests {
+		c, err := Identify(k)
+		if err != nil || c != v {
+			z.Errorf("Identify(%q) = %v, %v; expecting %v", k, c, err, v)
+		}
+	}
+}
+
+func TestRegisterFormat(z *testing.T) {
+	orig, _ := lookupFormat(TTA)
+	defer RegisterFormat(orig)
+
+	RegisterFormat(Format{
+		Name:  "tta",
+		Codec: TTA,
+		Magic: []string{"TTA?"},
+	})
+	f, _, err := identify(bytes.NewReader([]byte("TTA2\x01\x00")))
+	if err != nil || f.Codec != TTA || f.ReadMetadata != nil {
+		z.Errorf("identify(TTA2) = %v, %v; expecting %v", f.Codec, err, TTA)
+	}
+
+	_, err = ReadMetadataFrom(bytes.NewReader([]byte("TTA2\x01\x00")))
+	if err != ErrUnsupported {
+		z.Errorf("ReadMetadataFrom(TTA2) = %v; expecting %v", err, ErrUnsupported)
+	}
+}
+
+func TestReadMetadataOffset(z *testing.T) {
+	orig, _ := lookupFormat(TTA)
+	defer RegisterFormat(orig)
+
+	// The format must see its stream from the start, not the ID3v2 tag.
+	errOffset := errors.New("stream does not start with magic")
+	atMagic := func(r io.Reader) error {
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(r, b); err != nil || string(b) != "TTA1" {
+			return errOffset
+		}
+		return nil
+	}
+	RegisterFormat(Format{
+		Name:  "tta",
+		Codec: TTA,
+		Magic: []string{"TTA1"},
+		ReadMetadata: func(r io.ReadSeeker) (Metadata, error) {
+			if err := atMagic(r); err != nil {
+				return nil, err
+			}
+			if _, err := r.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return nil, atMagic(r)
+		},
+		NewDecoder: func(r io.Reader) (Decoder, error) {
+			return nil, atMagic(r)
+		},
+	})
+
+	in := []byte("ID3\x04\x00\x00\x00\x00\x00\x04....TTA1\x01\x00\x02\x00")
+	if _, err := ReadMetadataFrom(bytes.NewReader(in)); err != nil {
+		z.Errorf("ReadMetadataFrom(%q) = %v; expecting nil", in, err)
+	}
+	if _, err := NewDecoder(bytes.NewReader(in)); err != nil {
+		z.Errorf("NewDecoder(%q) = %v; expecting nil", in, err)
+	}
+}
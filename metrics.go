@@ -0,0 +1,218 @@
+// Copyright (c) 2016, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package audio
+
+import (
+	"bufio"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The operations that are recorded in DefaultMetrics by this package.
+// Format packages may record their own operations.
+const (
+	OpIdentify      = "identify"
+	OpReadMetadata  = "read_metadata"
+	OpWriteMetadata = "write_metadata"
+	OpNewDecoder    = "new_decoder"
+)
+
+// MetricBuckets are the upper bounds of the buckets of the timing histograms.
+// They must not be changed once metrics have been recorded.
+var MetricBuckets = []time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	1 * time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// DefaultMetrics contains the metrics of the operations of this package and
+// of the format packages. It is not published with expvar unless
+// PublishExpvar is called.
+var DefaultMetrics = NewMetrics()
+
+// PublishExpvar publishes DefaultMetrics with expvar under the given name,
+// such as "audio". Like expvar.Publish, it panics if the name is already
+// in use.
+func PublishExpvar(name string) {
+	expvar.Publish(name, DefaultMetrics)
+}
+
+// Metrics collects a timing histogram and an error count for each operation
+// and codec. It is safe for concurrent use.
+//
+// Metrics implements expvar.Var, and http.Handler, which serves the metrics
+// in the Prometheus text format.
+type Metrics struct {
+	mu  sync.Mutex
+	ops map[metricKey]*OpMetrics
+}
+
+type metricKey struct {
+	codec Codec
+	op    string
+}
+
+// OpMetrics contains the metrics of an operation on a codec.
+type OpMetrics struct {
+	Codec  Codec
+	Op     string
+	Count  uint64        // number of operations
+	Errors uint64        // number of operations that returned an error
+	Sum    time.Duration // total duration of the operations
+
+	// Buckets contains the number of operations for each bucket in
+	// MetricBuckets, and a last bucket for longer operations. The counts
+	// are not cumulative.
+	Buckets []uint64
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{ops: make(map[metricKey]*OpMetrics)}
+}
+
+// Observe records an operation on the codec that took d, and failed if err
+// is not nil.
+func (m *Metrics) Observe(c Codec, op string, d time.Duration, err error) {
+	i := sort.Search(len(MetricBuckets), func(i int) bool { return d <= MetricBuckets[i] })
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := metricKey{c, op}
+	x, ok := m.ops[k]
+	if !ok {
+		x = &OpMetrics{Codec: c, Op: op, Buckets: make([]uint64, len(MetricBuckets)+1)}
+		m.ops[k] = x
+	}
+	x.Count++
+	x.Sum += d
+	x.Buckets[i]++
+	if err != nil {
+		x.Errors++
+	}
+}
+
+// Snapshot returns a copy of the metrics, sorted by codec and operation.
+func (m *Metrics) Snapshot() []OpMetrics {
+	m.mu.Lock()
+	ms := make([]OpMetrics, 0, len(m.ops))
+	for _, x := range m.ops {
+		y := *x
+		y.Buckets = append([]uint64(nil), x.Buckets...)
+		ms = append(ms, y)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(ms, func(i, j int) bool {
+		if ms[i].Codec != ms[j].Codec {
+			return ms[i].Codec < ms[j].Codec
+		}
+		return ms[i].Op < ms[j].Op
+	})
+	return ms
+}
+
+// String returns the metrics as JSON, keyed by codec and operation, so that
+// Metrics implements expvar.Var.
+func (m *Metrics) String() string {
+	type op struct {
+		Count   uint64            `json:"count"`
+		Errors  uint64            `json:"errors"`
+		Seconds float64           `json:"sum_seconds"`
+		Buckets map[string]uint64 `json:"buckets"`
+	}
+	v := make(map[string]map[string]op)
+	for _, x := range m.Snapshot() {
+		c := codecLabel(x.Codec)
+		if v[c] == nil {
+			v[c] = make(map[string]op)
+		}
+		bs := make(map[string]uint64, len(x.Buckets))
+		for i, n := range x.Buckets {
+			bs[bucketLabel(i)] = n
+		}
+		v[c][x.Op] = op{x.Count, x.Errors, x.Sum.Seconds(), bs}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format as the
+// histogram audio_operation_duration_seconds and the counter
+// audio_operation_errors_total, with the labels codec and op.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	ms := m.Snapshot()
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "# HELP audio_operation_duration_seconds Duration of audio operations.")
+	fmt.Fprintln(bw, "# TYPE audio_operation_duration_seconds histogram")
+	for _, x := range ms {
+		labels := fmt.Sprintf("codec=%q,op=%q", codecLabel(x.Codec), x.Op)
+		var n uint64
+		for i, c := range x.Buckets {
+			n += c
+			fmt.Fprintf(bw, "audio_operation_duration_seconds_bucket{%s,le=%q} %d\n", labels, bucketLabel(i), n)
+		}
+		fmt.Fprintf(bw, "audio_operation_duration_seconds_sum{%s} %s\n", labels, formatFloat(x.Sum.Seconds()))
+		fmt.Fprintf(bw, "audio_operation_duration_seconds_count{%s} %d\n", labels, x.Count)
+	}
+
+	fmt.Fprintln(bw, "# HELP audio_operation_errors_total Number of audio operations that failed.")
+	fmt.Fprintln(bw, "# TYPE audio_operation_errors_total counter")
+	for _, x := range ms {
+		fmt.Fprintf(bw, "audio_operation_errors_total{codec=%q,op=%q} %d\n", codecLabel(x.Codec), x.Op, x.Errors)
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WritePrometheus(w)
+}
+
+// codecLabel returns the name of the codec in lower case, such as "flac".
+func codecLabel(c Codec) string {
+	if c == Unknown {
+		return "unknown"
+	}
+	return strings.ToLower(c.String())
+}
+
+// bucketLabel returns the upper bound of bucket i in seconds.
+func bucketLabel(i int) string {
+	if i >= len(MetricBuckets) {
+		return "+Inf"
+	}
+	return formatFloat(MetricBuckets[i].Seconds())
+}
+
+func formatFloat(x float64) string {
+	return strconv.FormatFloat(x, 'g', -1, 64)
+}
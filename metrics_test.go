@@ -0,0 +1,134 @@
+// Copyright (c) 2016, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package audio
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(z *testing.T) {
+	assert := assert.New(z)
+
+	m := NewMetrics()
+	m.Observe(FLAC, OpReadMetadata, 50*time.Microsecond, nil)
+	m.Observe(FLAC, OpReadMetadata, 3*time.Millisecond, errors.New("failed"))
+	m.Observe(FLAC, OpReadMetadata, time.Minute, nil)
+	m.Observe(Unknown, OpIdentify, time.Millisecond, nil)
+
+	ms := m.Snapshot()
+	if !assert.Len(ms, 2) {
+		return
+	}
+	assert.Equal(Unknown, ms[0].Codec)
+	x := ms[1]
+	assert.Equal(FLAC, x.Codec)
+	assert.Equal(OpReadMetadata, x.Op)
+	assert.Equal(uint64(3), x.Count)
+	assert.Equal(uint64(1), x.Errors)
+	assert.Equal(time.Minute+3050*time.Microsecond, x.Sum)
+	if assert.Len(x.Buckets, len(MetricBuckets)+1) {
+		assert.Equal(uint64(1), x.Buckets[0])
+		assert.Equal(uint64(1), x.Buckets[5])
+		assert.Equal(uint64(1), x.Buckets[len(MetricBuckets)])
+	}
+
+	// The snapshot is a copy.
+	ms[1].Buckets[0] = 100
+	assert.Equal(uint64(1), m.Snapshot()[1].Buckets[0])
+
+	var v map[string]map[string]struct {
+		Count   uint64            `json:"count"`
+		Errors  uint64            `json:"errors"`
+		Buckets map[string]uint64 `json:"buckets"`
+	}
+	if assert.Nil(json.Unmarshal([]byte(m.String()), &v)) {
+		assert.Equal(uint64(3), v["flac"]["read_metadata"].Count)
+		assert.Equal(uint64(1), v["flac"]["read_metadata"].Errors)
+		assert.Equal(uint64(1), v["flac"]["read_metadata"].Buckets["+Inf"])
+		assert.Equal(uint64(1), v["unknown"]["identify"].Count)
+	}
+
+	assert.Nil(expvar.Get("audio"))
+	PublishExpvar("audio")
+	assert.Equal(DefaultMetrics, expvar.Get("audio"))
+}
+
+func TestMetricsPrometheus(z *testing.T) {
+	assert := assert.New(z)
+
+	m := NewMetrics()
+	m.Observe(MP3, OpIdentify, 200*time.Microsecond, nil)
+	m.Observe(MP3, OpIdentify, 20*time.Second, errors.New("failed"))
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.True(strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain"))
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE audio_operation_duration_seconds histogram",
+		`audio_operation_duration_seconds_bucket{codec="mp3",op="identify",le="0.0001"} 0`,
+		`audio_operation_duration_seconds_bucket{codec="mp3",op="identify",le="0.00025"} 1`,
+		`audio_operation_duration_seconds_bucket{codec="mp3",op="identify",le="10"} 1`,
+		`audio_operation_duration_seconds_bucket{codec="mp3",op="identify",le="+Inf"} 2`,
+		`audio_operation_duration_seconds_sum{codec="mp3",op="identify"} 20.0002`,
+		`audio_operation_duration_seconds_count{codec="mp3",op="identify"} 2`,
+		"# TYPE audio_operation_errors_total counter",
+		`audio_operation_errors_total{codec="mp3",op="identify"} 1`,
+	} {
+		assert.Contains(body, line+"\n")
+	}
+}
+
+func TestMetricsConcurrent(z *testing.T) {
+	m := NewMetrics()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(c Codec) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.Observe(c, OpReadMetadata, time.Duration(j)*time.Millisecond, nil)
+				_ = m.String()
+			}
+		}(Codec(i % 3))
+	}
+	wg.Wait()
+
+	var n uint64
+	for _, x := range m.Snapshot() {
+		n += x.Count
+	}
+	if n != 800 {
+		z.Errorf("Count = %d; expecting 800", n)
+	}
+}
+
+func TestReadMetadataMetrics(z *testing.T) {
+	assert := assert.New(z)
+
+	before := opCount(MP3, OpReadMetadata)
+	_, err := ReadMetadataFrom(bytes.NewReader(mp3Frames(10, nil)))
+	assert.Nil(err)
+	assert.Equal(before+1, opCount(MP3, OpReadMetadata))
+}
+
+func opCount(c Codec, op string) uint64 {
+	for _, x := range DefaultMetrics.Snapshot() {
+		if x.Codec == c && x.Op == op {
+			return x.Count
+		}
+	}
+	return 0
+}
@@ -0,0 +1,233 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package mp3
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidHeader = errors.New("invalid frame header")
+
+// Version is the MPEG version of a frame. The values are those of the
+// version bits in the frame header.
+type Version uint8
+
+const (
+	MPEG25 Version = 0 // MPEG-2.5, an unofficial extension for low sample rates
+	MPEG2  Version = 2
+	MPEG1  Version = 3
+)
+
+func (v Version) String() string {
+	switch v {
+	case MPEG1:
+		return "MPEG-1"
+	case MPEG2:
+		return "MPEG-2"
+	case MPEG25:
+		return "MPEG-2.5"
+	default:
+		return "?"
+	}
+}
+
+// Layer is the MPEG audio layer of a frame.
+type Layer uint8
+
+const (
+	LayerI   Layer = 1
+	LayerII  Layer = 2
+	LayerIII Layer = 3
+)
+
+func (l Layer) String() string {
+	switch l {
+	case LayerI:
+		return "Layer I"
+	case LayerII:
+		return "Layer II"
+	case LayerIII:
+		return "Layer III"
+	default:
+		return "?"
+	}
+}
+
+// ChannelMode is the channel mode of a frame. The values are those of the
+// mode bits in the frame header.
+type ChannelMode uint8
+
+const (
+	Stereo      ChannelMode = 0
+	JointStereo ChannelMode = 1
+	DualChannel ChannelMode = 2 // two independent mono channels
+	Mono        ChannelMode = 3
+)
+
+func (m ChannelMode) String() string {
+	switch m {
+	case Stereo:
+		return "stereo"
+	case JointStereo:
+		return "joint stereo"
+	case DualChannel:
+		return "dual channel"
+	case Mono:
+		return "mono"
+	default:
+		return "?"
+	}
+}
+
+// bitrates are the bitrates in kbps by bitrate index, where 0 is the free
+// format and 15 is invalid.
+var bitrates = [5][16]int{
+	{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0}, // MPEG-1 Layer I
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},    // MPEG-1 Layer II
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},     // MPEG-1 Layer III
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},    // MPEG-2 and 2.5 Layer I
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},         // MPEG-2 and 2.5 Layer II and III
+}
+
+// sampleRates are the sample rates by version and sample rate index.
+var sampleRates = [4][3]int{
+	{11025, 12000, 8000},  // MPEG-2.5
+	{0, 0, 0},             // reserved
+	{22050, 24000, 16000}, // MPEG-2
+	{44100, 48000, 32000}, // MPEG-1
+}
+
+// FrameHeader is the header of an MPEG audio frame.
+type FrameHeader struct {
+	Version     Version
+	Layer       Layer
+	Protected   bool // a 16-bit CRC follows the header
+	Bitrate     int  // in kbps
+	SampleRate  int
+	Padding     bool // the frame has an extra slot
+	Private     bool
+	ChannelMode ChannelMode
+	ModeExt     uint8 // the mode extension of joint stereo
+	Copyright   bool
+	Original    bool
+	Emphasis    uint8
+}
+
+/*
+ParseFrameHeader parses the frame header at the beginning of b. Free format
+frames, which have no bitrate in the header, are not supported.
+
+Encoding format
+
+BITS DESCRIPTION
+==== ===========================================================================
+  11 Frame sync (all bits set)
+   2 Version: 0 is MPEG-2.5, 2 is MPEG-2, 3 is MPEG-1
+   2 Layer: 1 is Layer III, 2 is Layer II, 3 is Layer I
+   1 Protection bit, 0 if the header is followed by a CRC
+   4 Bitrate index
+   2 Sample rate index
+   1 Padding bit
+   1 Private bit
+   2 Channel mode
+   2 Mode extension
+   1 Copyright bit
+   1 Original bit
+   2 Emphasis
+==== ===========================================================================
+*/
+func ParseFrameHeader(b []byte) (FrameHeader, error) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return FrameHeader{}, ErrInvalidHeader
+	}
+	version := Version(b[1] >> 3 & 0x03)
+	layer := b[1] >> 1 & 0x03
+	bi := b[2] >> 4
+	si := b[2] >> 2 & 0x03
+	if version == 1 || layer == 0 || bi == 0 || bi == 0x0F || si == 0x03 {
+		return FrameHeader{}, ErrInvalidHeader
+	}
+	h := FrameHeader{
+		Version:     version,
+		Layer:       Layer(4 - layer),
+		Protected:   b[1]&0x01 == 0,
+		SampleRate:  sampleRates[version][si],
+		Padding:     b[2]&0x02 != 0,
+		Private:     b[2]&0x01 != 0,
+		ChannelMode: ChannelMode(b[3] >> 6),
+		ModeExt:     b[3] >> 4 & 0x03,
+		Copyright:   b[3]&0x08 != 0,
+		Original:    b[3]&0x04 != 0,
+		Emphasis:    b[3] & 0x03,
+	}
+	switch {
+	case version == MPEG1:
+		h.Bitrate = bitrates[h.Layer-1][bi]
+	case h.Layer == LayerI:
+		h.Bitrate = bitrates[3][bi]
+	default:
+		h.Bitrate = bitrates[4][bi]
+	}
+	return h, nil
+}
+
+// Samples returns the number of samples per channel in the frame.
+func (h *FrameHeader) Samples() int {
+	switch {
+	case h.Layer == LayerI:
+		return 384
+	case h.Layer == LayerIII && h.Version != MPEG1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+// Size returns the size of the frame in bytes, including the header.
+func (h *FrameHeader) Size() int {
+	var pad int
+	if h.Padding {
+		pad = 1
+	}
+	if h.Layer == LayerI {
+		// A slot of Layer I is 4 bytes.
+		return (12*h.Bitrate*1000/h.SampleRate + pad) * 4
+	}
+	return h.Samples()/8*h.Bitrate*1000/h.SampleRate + pad
+}
+
+// Duration returns the duration of the frame.
+func (h *FrameHeader) Duration() time.Duration {
+	return time.Duration(h.Samples()) * time.Second / time.Duration(h.SampleRate)
+}
+
+func (h *FrameHeader) NumChannels() int {
+	if h.ChannelMode == Mono {
+		return 1
+	}
+	return 2
+}
+
+// sideInfoSize returns the size of the Layer III side information that
+// follows the header and the CRC, after which the Xing header is found.
+func (h *FrameHeader) sideInfoSize() int {
+	switch {
+	case h.Version == MPEG1 && h.ChannelMode == Mono:
+		return 17
+	case h.Version == MPEG1:
+		return 32
+	case h.ChannelMode == Mono:
+		return 9
+	default:
+		return 17
+	}
+}
+
+// sameStream returns true if the frame g can follow the frame h in a
+// stream. The version, layer and sample rate must not change.
+func (h *FrameHeader) sameStream(g *FrameHeader) bool {
+	return h.Version == g.Version && h.Layer == g.Layer && h.SampleRate == g.SampleRate
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package mp3
+
+import "testing"
+
+func TestParseFrameHeader(z *testing.T) {
+	tests := []struct {
+		Header  []byte
+		Version Version
+		Layer   Layer
+		Bitrate int
+		Rate    int
+		Size    int
+		Samples int
+	}{
+		{[]byte{0xFF, 0xFB, 0x90, 0x64}, MPEG1, LayerIII, 128, 44100, 417, 1152},
+		{[]byte{0xFF, 0xFB, 0x92, 0x64}, MPEG1, LayerIII, 128, 44100, 418, 1152},
+		{[]byte{0xFF, 0xFB, 0xE4, 0xC0}, MPEG1, LayerIII, 320, 48000, 960, 1152},
+		{[]byte{0xFF, 0xF3, 0x84, 0xC4}, MPEG2, LayerIII, 64, 24000, 192, 576},
+		{[]byte{0xFF, 0xE3, 0x88, 0xC4}, MPEG25, LayerIII, 64, 8000, 576, 576},
+		{[]byte{0xFF, 0xFD, 0xC4, 0x00}, MPEG1, LayerII, 256, 48000, 768, 1152},
+		{[]byte{0xFF, 0xFF, 0x90, 0x00}, MPEG1, LayerI, 288, 44100, 312, 384},
+		{[]byte{0xFF, 0xF7, 0x90, 0x00}, MPEG2, LayerI, 144, 22050, 312, 384},
+	}
+	for _, t := range tests {
+		h, err := ParseFrameHeader(t.Header)
+		if err != nil {
+			z.Errorf("ParseFrameHeader(% x) = %v; expecting no error", t.Header, err)
+			continue
+		}
+		if h.Version != t.Version || h.Layer != t.Layer || h.Bitrate != t.Bitrate || h.SampleRate != t.Rate {
+			z.Errorf("ParseFrameHeader(% x) = %v %v %d kbps %d Hz; expecting %v %v %d kbps %d Hz",
+				t.Header, h.Version, h.Layer, h.Bitrate, h.SampleRate, t.Version, t.Layer, t.Bitrate, t.Rate)
+		}
+		if h.Size() != t.Size || h.Samples() != t.Samples {
+			z.Errorf("ParseFrameHeader(% x) has size %d and %d samples; expecting %d and %d",
+				t.Header, h.Size(), h.Samples(), t.Size, t.Samples)
+		}
+	}
+
+	invalid := [][]byte{
+		nil,
+		[]byte("ID3\x04"),
+		{0xFF, 0xFB, 0x90},
+		{0xFF, 0xFB, 0xF0, 0x00}, // bad bitrate
+		{0xFF, 0xFB, 0x00, 0x00}, // free format
+		{0xFF, 0xFB, 0x9C, 0x00}, // bad sample rate
+		{0xFF, 0xEB, 0x90, 0x00}, // reserved version
+		{0xFF, 0xF9, 0x90, 0x00}, // reserved layer
+	}
+	for _, b := range invalid {
+		if _, err := ParseFrameHeader(b); err != ErrInvalidHeader {
+			z.Errorf("ParseFrameHeader(% x) = %v; expecting ErrInvalidHeader", b, err)
+		}
+	}
+}
+
+func TestFrameHeaderFields(z *testing.T) {
+	h, err := ParseFrameHeader([]byte{0xFF, 0xFA, 0x93, 0x6D})
+	if err != nil {
+		z.Fatal(err)
+	}
+	if !h.Protected || !h.Padding || !h.Private || h.ChannelMode != JointStereo ||
+		h.ModeExt != 2 || !h.Copyright || !h.Original || h.Emphasis != 1 {
+		z.Errorf("ParseFrameHeader = %+v", h)
+	}
+	if h.NumChannels() != 2 {
+		z.Errorf("NumChannels() = %d; expecting 2", h.NumChannels())
+	}
+}
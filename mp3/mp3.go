@@ -0,0 +1,504 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+// Package mp3 implements reading the metadata of MPEG audio streams and
+// writing their ID3 tags. The duration is calculated exactly from the
+// number of frames, which is taken from the Xing, Info or VBRI header or
+// else counted, and the encoder delay and padding in the LAME tag.
+//
+// Reference
+//
+//  http://www.mp3-tech.org/programmer/frame_header.html
+//  http://gabriel.mp3-tech.org/mp3infotag.html
+package mp3
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/goulash/audio"
+	"github.com/goulash/audio/id3"
+)
+
+func init() {
+	audio.RegisterFormat(audio.Format{
+		Name:       "mp3",
+		Codec:      audio.MP3,
+		Magic:      []string{"ID3"},
+		Sniff:      sniff,
+		Extensions: []string{".mp3"},
+		MIMETypes:  []string{"audio/mpeg", "audio/mp3"},
+		ReadMetadata: func(r io.ReadSeeker) (audio.Metadata, error) {
+			m, err := ReadMetadata(r)
+			if err != nil {
+				return nil, err
+			}
+			return m, nil
+		},
+		WriteMetadata: func(path string, changes func(audio.MutableMetadata)) error {
+			m, err := ReadFileMetadata(path)
+			if err != nil {
+				return err
+			}
+			changes(m)
+			return WriteFileMetadata(path, m)
+		},
+	})
+}
+
+var ErrInvalidStream = errors.New("stream is invalid")
+
+// sniff returns true if a Layer III frame is found in r in the same way as
+// ReadMetadata searches for the first frame, which is the case for MP3
+// files without an ID3v2 tag, even if they start with junk.
+func sniff(r io.ReadSeeker) (bool, error) {
+	buf := make([]byte, searchSize+maxFrameSize)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	_, _, h, ok := searchFrame(buf[:n], n == len(buf))
+	return ok && h.Layer == LayerIII, nil
+}
+
+// searchSize is how far after the ID3v2 tag the first frame is searched
+// for, and maxFrameSize is larger than any frame that is supported.
+const (
+	searchSize   = 64 << 10
+	maxFrameSize = 4 << 10
+)
+
+// ReadFileMetadata reads the metadata of the MP3 file at path.
+func ReadFileMetadata(path string) (*Metadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadMetadata(f)
+}
+
+// ReadMetadata reads the tags and the frames of the stream in r. The first
+// ID3v2 tag at the beginning and the ID3v1 tag at the end of the stream are
+// read, and any other ID3v2 tags and an APEv2 tag at the end are skipped.
+//
+// If the first frame contains a Xing, Info or VBRI header, the number of
+// frames is taken from it. Otherwise, the headers of all frames are read
+// to count them, up to the first frame that is invalid or truncated.
+func ReadMetadata(r io.ReadSeeker) (*Metadata, error) {
+	m := &Metadata{id3: new(id3.Tag)}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	// A broken tag does not make the stream invalid.
+	if t, err := id3.Read(r); err == nil {
+		m.id3 = t
+	}
+	if t, err := id3.ReadV1(r); err == nil {
+		m.v1 = t
+	}
+
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	end, err := trimTrailingTags(r, size)
+	if err != nil {
+		return nil, err
+	}
+	start, err := skipID3v2(r)
+	if err != nil {
+		return nil, err
+	}
+	frame, err := m.findFrame(r, start, end)
+	if err != nil {
+		return nil, err
+	}
+	m.readInfoFrame(frame)
+
+	h := &m.header
+	if m.xing != nil || m.vbri != nil {
+		// The frame of the header contains no audio.
+		m.offset += int64(h.Size())
+	}
+	m.bytes = end - m.offset
+	switch {
+	case m.xing != nil && m.xing.Frames > 0:
+		m.frames = int64(m.xing.Frames)
+	case m.vbri != nil && m.vbri.Frames > 0:
+		m.frames = int64(m.vbri.Frames)
+	default:
+		if m.offset < end {
+			if _, err := r.Seek(m.offset, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		var vbr bool
+		m.frames, m.bytes, vbr, err = countFrames(io.LimitReader(r, end-m.offset), h)
+		if err != nil {
+			return nil, err
+		}
+		m.mode = audio.CBR
+		if vbr {
+			m.mode = audio.VBR
+		}
+	}
+
+	switch {
+	case m.lame != nil && m.lame.Method.Mode() != audio.UnknownBitrate:
+		m.mode = m.lame.Method.Mode()
+	case m.xing != nil && m.xing.Info:
+		m.mode = audio.CBR
+	case m.xing != nil || m.vbri != nil:
+		m.mode = audio.VBR
+	}
+	return m, nil
+}
+
+// trimTrailingTags returns the end of the audio data, which is before the
+// ID3v1 and APEv2 tags at the end of a stream of the given size.
+func trimTrailingTags(r io.ReadSeeker, size int64) (int64, error) {
+	end := size
+	b := make([]byte, 32)
+	if end >= 128 {
+		if _, err := r.Seek(end-128, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if _, err := io.ReadFull(r, b[:3]); err == nil && string(b[:3]) == "TAG" {
+			end -= 128
+		}
+	}
+	if end >= 32 {
+		if _, err := r.Seek(end-32, io.SeekStart); err != nil {
+			return 0, err
+		}
+		// The APEv2 footer contains the size of the tag without the
+		// header, and the flags say whether there is a header.
+		if _, err := io.ReadFull(r, b); err == nil && string(b[:8]) == "APETAGEX" {
+			n := int64(binary.LittleEndian.Uint32(b[12:]))
+			if binary.LittleEndian.Uint32(b[20:])&(1<<31) != 0 {
+				n += 32
+			}
+			if n <= end {
+				end -= n
+			}
+		}
+	}
+	return end, nil
+}
+
+// skipID3v2 returns the position after the ID3v2 tags at the beginning of
+// the stream, of which there may be more than one.
+func skipID3v2(r io.ReadSeeker) (int64, error) {
+	var start int64
+	b := make([]byte, 10)
+	for {
+		if _, err := r.Seek(start, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if _, err := io.ReadFull(r, b); err != nil || string(b[:3]) != "ID3" {
+			return start, nil
+		}
+		n := int64(b[6]&0x7F)<<21 | int64(b[7]&0x7F)<<14 | int64(b[8]&0x7F)<<7 | int64(b[9]&0x7F)
+		if b[5]&0x10 != 0 {
+			// The tag has a footer.
+			n += 10
+		}
+		start += 10 + n
+	}
+}
+
+// findFrame finds the first frame between start and end, allowing for some
+// junk before it, and returns its content. Since the frame sync is not
+// unique, the next frame must be valid as well, unless the stream ends.
+func (m *Metadata) findFrame(r io.ReadSeeker, start, end int64) ([]byte, error) {
+	if start >= end {
+		return nil, ErrInvalidStream
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	n := end - start
+	if n > searchSize+maxFrameSize {
+		n = searchSize + maxFrameSize
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	i, j, h, ok := searchFrame(buf, int64(len(buf)) < end-start)
+	if !ok {
+		return nil, ErrInvalidStream
+	}
+	m.header = h
+	m.offset = start + int64(i)
+	return buf[i:j], nil
+}
+
+// searchFrame returns the position i of the first frame within searchSize
+// bytes of buf that is followed by another frame of the same stream, and
+// the end j of the frame. If buf is not truncated, the frame may also end
+// at the end of buf.
+func searchFrame(buf []byte, truncated bool) (i, j int, h FrameHeader, ok bool) {
+	for ; i+4 <= len(buf) && i < searchSize; i++ {
+		var err error
+		h, err = ParseFrameHeader(buf[i:])
+		if err != nil {
+			continue
+		}
+		j = i + h.Size()
+		switch {
+		case j+4 <= len(buf):
+			g, err := ParseFrameHeader(buf[j:])
+			if err != nil || !h.sameStream(&g) {
+				continue
+			}
+		case truncated:
+			continue
+		default:
+			j = len(buf)
+		}
+		return i, j, h, true
+	}
+	return 0, 0, FrameHeader{}, false
+}
+
+// readInfoFrame reads the Xing or Info header and the LAME tag, or the VBRI
+// header, in the first frame if there is one.
+func (m *Metadata) readInfoFrame(frame []byte) {
+	h := &m.header
+	if h.Layer != LayerIII {
+		return
+	}
+	if i := 4 + h.sideInfoSize(); i < len(frame) {
+		if x, n := parseXingHeader(frame[i:]); x != nil {
+			m.xing = x
+			m.lame = parseLAMETag(frame[i+n:], h)
+			return
+		}
+	}
+	if len(frame) > 36 {
+		m.vbri = parseVBRIHeader(frame[36:])
+	}
+}
+
+// countFrames reads the frame headers in r, which are expected to belong
+// to the same stream as h, and returns the number of frames and their size.
+// It stops at the first frame that is invalid or truncated. The bitrate is
+// variable if it changes from one frame to the next.
+func countFrames(r io.Reader, h *FrameHeader) (frames, bytes int64, vbr bool, err error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(4)
+		if err == io.EOF {
+			return frames, bytes, vbr, nil
+		} else if err != nil {
+			return 0, 0, false, err
+		}
+		g, err := ParseFrameHeader(b)
+		if err != nil || !h.sameStream(&g) {
+			return frames, bytes, vbr, nil
+		}
+		n := g.Size()
+		if k, err := br.Discard(n); err == io.EOF {
+			return frames, bytes, vbr, nil
+		} else if err != nil {
+			return 0, 0, false, err
+		} else if k < n {
+			return frames, bytes, vbr, nil
+		}
+		frames++
+		bytes += int64(n)
+		if g.Bitrate != h.Bitrate {
+			vbr = true
+		}
+	}
+}
+
+// Metadata {{{
+
+// Metadata is the metadata of an MP3 stream. The tags are read from the
+// ID3v2 tag, and from the ID3v1 tag if they are not set there.
+type Metadata struct {
+	id3    *id3.Tag
+	v1     *id3.V1Tag  // or nil
+	header FrameHeader // of the first frame
+	xing   *XingHeader
+	vbri   *VBRIHeader
+	lame   *LAMETag
+	offset int64 // position of the first audio frame
+	bytes  int64 // size of the audio frames
+	frames int64 // number of audio frames
+	mode   audio.BitrateMode
+}
+
+// Tag returns the ID3v2 tag, which is empty if the stream has none, and
+// V1 returns the ID3v1 tag, or nil.
+func (m *Metadata) Tag() *id3.Tag  { return m.id3 }
+func (m *Metadata) V1() *id3.V1Tag { return m.v1 }
+
+// Header returns the header of the first frame, which is the frame of the
+// Xing, Info or VBRI header if there is one.
+func (m *Metadata) Header() *FrameHeader { return &m.header }
+
+func (m *Metadata) Xing() *XingHeader { return m.xing }
+func (m *Metadata) VBRI() *VBRIHeader { return m.vbri }
+func (m *Metadata) LAME() *LAMETag    { return m.lame }
+func (m *Metadata) Frames() int64     { return m.frames }
+
+// DataOffset returns the position of the first audio frame in the stream,
+// and DataSize returns the size of the audio frames in bytes.
+func (m *Metadata) DataOffset() int64 { return m.offset }
+func (m *Metadata) DataSize() int64   { return m.bytes }
+
+// EncoderDelay returns the number of samples that the encoder added at the
+// start of the stream, and EncoderPadding the number of samples that it
+// added at the end. Both are 0 if the stream has no LAME tag.
+func (m *Metadata) EncoderDelay() int {
+	if m.lame == nil {
+		return 0
+	}
+	return m.lame.Delay
+}
+func (m *Metadata) EncoderPadding() int {
+	if m.lame == nil {
+		return 0
+	}
+	return m.lame.Padding
+}
+
+func (m *Metadata) SampleRate() int                { return m.header.SampleRate }
+func (m *Metadata) NumChannels() int               { return m.header.NumChannels() }
+func (m *Metadata) BitsPerSample() int             { return 0 }
+func (m *Metadata) Duration() time.Duration        { return m.Length() }
+func (m *Metadata) BitrateMode() audio.BitrateMode { return m.mode }
+
+// TotalSamples returns the number of samples in the frames without the
+// encoder delay and padding, which is the number of samples that a gapless
+// decoder returns.
+func (m *Metadata) TotalSamples() int64 {
+	n := m.frames * int64(m.header.Samples())
+	if m.lame != nil && int64(m.lame.Delay+m.lame.Padding) < n {
+		n -= int64(m.lame.Delay + m.lame.Padding)
+	}
+	return n
+}
+
+func (m *Metadata) ChannelLayout() audio.ChannelLayout {
+	return audio.DefaultChannelLayout(m.NumChannels())
+}
+
+func (m *Metadata) Length() time.Duration {
+	return time.Duration(m.TotalSamples()) * time.Second / time.Duration(m.header.SampleRate)
+}
+func (m *Metadata) Encoding() audio.Codec { return audio.MP3 }
+
+// EncodingBitrate returns the bitrate of a CBR stream, and otherwise the
+// average bitrate of the audio frames, in kbps.
+func (m *Metadata) EncodingBitrate() int {
+	samples := m.frames * int64(m.header.Samples())
+	switch {
+	case m.mode == audio.CBR:
+		return m.header.Bitrate
+	case samples == 0:
+		return 0
+	}
+	return int(m.bytes * 8 * int64(m.header.SampleRate) / samples / 1000)
+}
+
+func (m *Metadata) Title() string            { return m.str("TIT2", m.fallback().Title) }
+func (m *Metadata) Album() string            { return m.str("TALB", m.fallback().Album) }
+func (m *Metadata) Artist() string           { return m.str("TPE1", m.fallback().Artist) }
+func (m *Metadata) AlbumArtist() string      { return m.id3.Text("TPE2") }
+func (m *Metadata) Composer() string         { return m.id3.Text("TCOM") }
+func (m *Metadata) Copyright() string        { return m.id3.Text("TCOP") }
+func (m *Metadata) Website() string          { return m.id3.URL("WOAR") }
+func (m *Metadata) EncodedBy() string        { return m.id3.Text("TENC") }
+func (m *Metadata) OriginalFilename() string { return m.id3.Text("TOFN") }
+
+func (m *Metadata) Genre() string {
+	if s := m.id3.Genre(); s != "" {
+		return s
+	}
+	return m.fallback().GenreName()
+}
+
+func (m *Metadata) Comment() string {
+	if s := m.id3.Comment(); s != "" {
+		return s
+	}
+	return m.fallback().Comment
+}
+
+func (m *Metadata) Year() int {
+	if y := m.id3.Year(); y != 0 {
+		return y
+	}
+	return m.fallback().Year
+}
+
+func (m *Metadata) Track() (int, int) {
+	if n, total := m.id3.Track(); n != 0 {
+		return n, total
+	}
+	return m.fallback().Track, 0
+}
+
+func (m *Metadata) Disc() (int, int) { return m.id3.Disc() }
+
+// EncoderSettings returns the encoder and the options of the LAME tag,
+// such as "LAME3.100 -V 2", or else the encoder settings in the tag.
+func (m *Metadata) EncoderSettings() string {
+	if m.lame != nil {
+		if o := m.lame.Options(); o != "" {
+			return m.lame.Encoder + " " + o
+		}
+	}
+	if s := m.id3.Text("TSSE"); s != "" {
+		return s
+	}
+	if m.lame != nil {
+		return m.lame.Encoder
+	}
+	return ""
+}
+
+func (m *Metadata) Pictures() []audio.Picture                { return m.id3.Pictures() }
+func (m *Metadata) TrackGain() (gain, peak float64, ok bool) { return m.id3.TrackGain() }
+func (m *Metadata) AlbumGain() (gain, peak float64, ok bool) { return m.id3.AlbumGain() }
+func (m *Metadata) MusicBrainz() audio.MusicBrainzIDs        { return m.id3.MusicBrainz() }
+func (m *Metadata) Lyrics() string                           { return m.id3.Lyrics() }
+
+// str returns the text of the ID3v2 frame if it is set, or else v1.
+func (m *Metadata) str(id, v1 string) string {
+	if s := m.id3.Text(id); s != "" {
+		return s
+	}
+	return v1
+}
+
+// noV1 is the ID3v1 tag of a stream without one.
+var noV1 = &id3.V1Tag{Genre: 255}
+
+// fallback returns the ID3v1 tag, or an empty tag.
+func (m *Metadata) fallback() *id3.V1Tag {
+	if m.v1 == nil {
+		return noV1
+	}
+	return m.v1
+}
+
+var (
+	_ = audio.Metadata(new(Metadata))
+	_ = audio.PictureReader(new(Metadata))
+	_ = audio.ReplayGainReader(new(Metadata))
+	_ = audio.MusicBrainzReader(new(Metadata))
+	_ = audio.LyricsReader(new(Metadata))
+)
+
+// }}}
@@ -0,0 +1,276 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package mp3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/goulash/audio"
+	"github.com/stretchr/testify/assert"
+)
+
+// frame returns an empty MPEG-1 Layer III frame at 44.1 kHz with the
+// bitrate index bi.
+func frame(bi byte, mode ChannelMode) []byte {
+	b := []byte{0xFF, 0xFB, bi << 4, byte(mode) << 6}
+	h, err := ParseFrameHeader(b)
+	if err != nil {
+		panic(err)
+	}
+	return append(b, make([]byte, h.Size()-4)...)
+}
+
+// frames returns n frames, where the bitrate index of frame i is bi[i%len(bi)].
+func frames(n int, bi ...byte) []byte {
+	var b []byte
+	for i := 0; i < n; i++ {
+		b = append(b, frame(bi[i%len(bi)], JointStereo)...)
+	}
+	return b
+}
+
+// lameFrame returns a frame at 128 kbps with a Xing or Info header with all
+// fields and a LAME tag.
+func lameFrame(id string, n int, method Method, bitrate, preset int) []byte {
+	f := frame(9, JointStereo)
+	x := f[4+32:]
+	copy(x, id)
+	binary.BigEndian.PutUint32(x[4:], 0x0F)
+	binary.BigEndian.PutUint32(x[8:], uint32(n))
+	binary.BigEndian.PutUint32(x[12:], 12345)
+	x[116+3] = 78
+
+	t := x[120:]
+	copy(t, "LAME3.100")
+	t[9] = byte(method)
+	t[10] = 195
+	t[20] = byte(bitrate)
+	t[21], t[22], t[23] = 0x24, 0x03, 0xE8 // 576 and 1000
+	binary.BigEndian.PutUint16(t[26:], uint16(preset))
+	return f
+}
+
+var id3v2 = []byte("ID3\x04\x00\x00\x00\x00\x00\x0A\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+
+func id3v1() []byte {
+	return append([]byte("TAG"), make([]byte, 125)...)
+}
+
+func TestLAME(z *testing.T) {
+	assert := assert.New(z)
+
+	data := append([]byte(nil), id3v2...)
+	data = append(data, lameFrame("Xing", 100, MethodVBRMTRH, 32, 480)...)
+	data = append(data, frames(100, 9, 10)...)
+	data = append(data, id3v1()...)
+
+	m, err := ReadMetadata(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+	if assert.NotNil(m.Xing()) {
+		x := m.Xing()
+		assert.False(x.Info)
+		assert.Equal(uint32(100), x.Frames)
+		assert.Equal(uint32(12345), x.Bytes)
+		assert.Len(x.TOC, 100)
+		assert.Equal(78, x.Quality)
+	}
+	if assert.NotNil(m.LAME()) {
+		t := m.LAME()
+		assert.Equal("LAME3.100", t.Encoder)
+		assert.Equal(MethodVBRMTRH, t.Method)
+		assert.Equal(19500, t.Lowpass)
+		assert.Equal(480, t.Preset)
+	}
+	assert.Nil(m.VBRI())
+	assert.Equal(int64(20+417), m.DataOffset())
+	assert.Equal(int64(50*417+50*522), m.DataSize())
+	assert.Equal(int64(100), m.Frames())
+	assert.Equal(576, m.EncoderDelay())
+	assert.Equal(1000, m.EncoderPadding())
+
+	var p audio.Properties = m
+	assert.Equal(44100, p.SampleRate())
+	assert.Equal(2, p.NumChannels())
+	assert.Equal(0, p.BitsPerSample())
+	assert.Equal(int64(100*1152-576-1000), p.TotalSamples())
+	assert.Equal(time.Duration(100*1152-576-1000)*time.Second/44100, p.Duration())
+	assert.Equal(audio.VBR, p.BitrateMode())
+	assert.Equal(audio.Stereo, p.ChannelLayout())
+	assert.Equal((50*417+50*522)*8*44100/(100*1152)/1000, m.EncodingBitrate())
+	assert.Equal("LAME3.100 -V 2", m.EncoderSettings())
+	assert.Equal(audio.MP3, m.Encoding())
+}
+
+func TestLAMEModes(z *testing.T) {
+	tests := []struct {
+		ID       string
+		Method   Method
+		Bitrate  int
+		Preset   int
+		Mode     audio.BitrateMode
+		Settings string
+	}{
+		{"Xing", MethodVBRMTRH, 32, 490, audio.VBR, "LAME3.100 -V 1"},
+		{"Xing", MethodVBROld, 32, 1002, audio.VBR, "LAME3.100 --preset extreme"},
+		{"Xing", MethodVBRMTRH, 32, 0, audio.VBR, "LAME3.100 --vbr-new"},
+		{"Xing", MethodABR, 160, 160, audio.ABR, "LAME3.100 --abr 160"},
+		{"Xing", MethodABR2Pass, 96, 0, audio.ABR, "LAME3.100 --abr 96"},
+		{"Info", MethodCBR, 255, 0, audio.CBR, "LAME3.100 -b 128"},
+		{"Info", MethodUnknown, 0, 0, audio.CBR, "LAME3.100"},
+		{"Xing", MethodUnknown, 0, 0, audio.VBR, "LAME3.100"},
+	}
+	for _, t := range tests {
+		data := append(lameFrame(t.ID, 10, t.Method, t.Bitrate, t.Preset), frames(10, 9)...)
+		m, err := ReadMetadata(bytes.NewReader(data))
+		if err != nil {
+			z.Errorf("ReadMetadata(%s %d) = %v; expecting no error", t.ID, t.Method, err)
+			continue
+		}
+		if m.BitrateMode() != t.Mode || m.EncoderSettings() != t.Settings {
+			z.Errorf("ReadMetadata(%s %d) has mode %v and settings %q; expecting %v and %q",
+				t.ID, t.Method, m.BitrateMode(), m.EncoderSettings(), t.Mode, t.Settings)
+		}
+	}
+}
+
+func TestVBRI(z *testing.T) {
+	assert := assert.New(z)
+
+	f := frame(9, JointStereo)
+	copy(f[36:], "VBRI")
+	binary.BigEndian.PutUint16(f[40:], 1)
+	binary.BigEndian.PutUint32(f[46:], 50*417)
+	binary.BigEndian.PutUint32(f[50:], 50)
+	data := append(f, frames(50, 9)...)
+
+	m, err := ReadMetadata(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+	if assert.NotNil(m.VBRI()) {
+		assert.Equal(uint16(1), m.VBRI().Version)
+		assert.Equal(uint32(50*417), m.VBRI().Bytes)
+	}
+	assert.Nil(m.Xing())
+	assert.Nil(m.LAME())
+	assert.Equal(int64(417), m.DataOffset())
+	assert.Equal(int64(50*1152), m.TotalSamples())
+	assert.Equal(audio.VBR, m.BitrateMode())
+	assert.Equal(0, m.EncoderDelay())
+}
+
+func TestCountFrames(z *testing.T) {
+	assert := assert.New(z)
+
+	// The frames of a stream without a header are counted, and the junk
+	// and the tags at the end are ignored.
+	ape := make([]byte, 64)
+	copy(ape[32:], "APETAGEX")
+	binary.LittleEndian.PutUint32(ape[32+12:], 32)
+	binary.LittleEndian.PutUint32(ape[32+20:], 1<<31)
+	data := append([]byte("junk"), frames(30, 9)...)
+	data = append(data, "more junk"...)
+	data = append(data, ape...)
+	data = append(data, id3v1()...)
+
+	m, err := ReadMetadata(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(int64(4), m.DataOffset())
+	assert.Equal(int64(30), m.Frames())
+	assert.Equal(int64(30*417), m.DataSize())
+	assert.Equal(audio.CBR, m.BitrateMode())
+	assert.Equal(128, m.EncodingBitrate())
+	assert.Equal(time.Duration(30*1152)*time.Second/44100, m.Length())
+	assert.Equal("", m.EncoderSettings())
+
+	// A truncated frame at the end is not counted.
+	data = frames(30, 9, 11)
+	m, err = ReadMetadata(bytes.NewReader(data[:len(data)-100]))
+	if assert.Nil(err) {
+		assert.Equal(int64(29), m.Frames())
+		assert.Equal(audio.VBR, m.BitrateMode())
+	}
+
+	// A mono stream at a lower sample rate.
+	data = nil
+	for i := 0; i < 20; i++ {
+		data = append(data, 0xFF, 0xF3, 0x84, 0xC4)
+		data = append(data, make([]byte, 188)...)
+	}
+	m, err = ReadMetadata(bytes.NewReader(data))
+	if assert.Nil(err) {
+		assert.Equal(MPEG2, m.Header().Version)
+		assert.Equal(int64(20), m.Frames())
+		assert.Equal(int64(20*576), m.TotalSamples())
+		assert.Equal(audio.Mono, m.ChannelLayout())
+		assert.Equal(64, m.EncodingBitrate())
+	}
+}
+
+func TestInvalid(z *testing.T) {
+	tests := [][]byte{
+		nil,
+		id3v2,
+		[]byte("ID3\x04\x00\x00\x00\x00\x7F\x7F"),
+		append(append([]byte(nil), id3v2...), make([]byte, 1000)...),
+		append([]byte("fLaC"), make([]byte, 100)...),
+	}
+	for _, t := range tests {
+		if _, err := ReadMetadata(bytes.NewReader(t)); err == nil {
+			z.Errorf("ReadMetadata(%q) = nil; expecting an error", t)
+		}
+	}
+}
+
+func TestAudioReadMetadataFrom(z *testing.T) {
+	assert := assert.New(z)
+	data := append(lameFrame("Info", 20, MethodCBR, 128, 0), frames(20, 9)...)
+
+	c, err := audio.IdentifyReader(bytes.NewReader(data))
+	if assert.Nil(err) {
+		assert.Equal(audio.MP3, c)
+	}
+	m, err := audio.ReadMetadataFrom(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+	assert.IsType(new(Metadata), m)
+	assert.Equal(audio.MP3, m.Encoding())
+	assert.Equal(int64(20*1152-1576), m.TotalSamples())
+	assert.Equal(audio.CBR, m.BitrateMode())
+	assert.Equal(128, m.EncodingBitrate())
+}
+
+func TestSniff(z *testing.T) {
+	tests := []struct {
+		In  []byte
+		Out bool
+	}{
+		{nil, false},
+		{frames(2, 9), true},
+		{append([]byte("junk"), frames(2, 9)...), true},
+		{append(make([]byte, searchSize), frames(2, 9)...), false},
+		{append(frames(1, 9)[:4], make([]byte, 500)...), false},
+		{append([]byte("fLaC"), make([]byte, 100)...), false},
+	}
+	for _, t := range tests {
+		ok, err := sniff(bytes.NewReader(t.In))
+		if err != nil || ok != t.Out {
+			z.Errorf("sniff(%q) = %v, %v; expecting %v", t.In, ok, err, t.Out)
+		}
+	}
+
+	c, err := audio.IdentifyReader(bytes.NewReader(append([]byte("junk"), frames(2, 9)...)))
+	if err != nil || c != audio.MP3 {
+		z.Errorf("IdentifyReader(junk) = %v, %v; expecting %v", c, err, audio.MP3)
+	}
+}
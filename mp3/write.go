@@ -0,0 +1,85 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package mp3
+
+import (
+	"github.com/goulash/audio"
+	"github.com/goulash/audio/id3"
+)
+
+// Metadata Mutation {{{
+
+// The setters change the ID3v2 tag, and the ID3v1 tag if the stream has
+// one. If the stream has no ID3v2 tag, a tag of version 2.4 is created.
+
+var _ = audio.MutableMetadata(new(Metadata))
+
+func (m *Metadata) SetTitle(s string) {
+	m.id3.SetText("TIT2", s)
+	m.setV1(func(t *id3.V1Tag) { t.Title = s })
+}
+
+func (m *Metadata) SetAlbum(s string) {
+	m.id3.SetText("TALB", s)
+	m.setV1(func(t *id3.V1Tag) { t.Album = s })
+}
+
+func (m *Metadata) SetArtist(s string) {
+	m.id3.SetText("TPE1", s)
+	m.setV1(func(t *id3.V1Tag) { t.Artist = s })
+}
+
+func (m *Metadata) SetYear(n int) {
+	m.id3.SetYear(n)
+	m.setV1(func(t *id3.V1Tag) { t.Year = n })
+}
+
+func (m *Metadata) SetGenre(s string) {
+	m.id3.SetText("TCON", s)
+	m.setV1(func(t *id3.V1Tag) { t.SetGenreName(s) })
+}
+
+func (m *Metadata) SetTrack(n, total int) {
+	m.id3.SetTrack(n, total)
+	m.setV1(func(t *id3.V1Tag) { t.Track = n })
+}
+
+func (m *Metadata) SetComment(s string) {
+	m.id3.SetComment(s)
+	m.setV1(func(t *id3.V1Tag) { t.Comment = s })
+}
+
+func (m *Metadata) SetAlbumArtist(s string) { m.id3.SetText("TPE2", s) }
+func (m *Metadata) SetComposer(s string)    { m.id3.SetText("TCOM", s) }
+func (m *Metadata) SetDisc(n, total int)    { m.id3.SetDisc(n, total) }
+func (m *Metadata) SetCopyright(s string)   { m.id3.SetText("TCOP", s) }
+func (m *Metadata) SetWebsite(s string)     { m.id3.SetURL("WOAR", s) }
+func (m *Metadata) SetEncodedBy(s string)   { m.id3.SetText("TENC", s) }
+
+func (m *Metadata) setV1(f func(*id3.V1Tag)) {
+	if m.v1 != nil {
+		f(m.v1)
+	}
+}
+
+// }}}
+
+// WriteFileMetadata writes the ID3v2 tag of m to the MP3 file at path, and
+// the ID3v1 tag if m has one; see id3.WriteFile and id3.WriteV1File. The
+// audio frames are not touched. If the stream had no ID3v2 tag and none
+// was set, none is written.
+func WriteFileMetadata(path string, m *Metadata) error {
+	size := m.id3.Size
+	if size > 0 || len(m.id3.Frames) > 0 {
+		if err := id3.WriteFile(path, m.id3); err != nil {
+			return err
+		}
+		m.offset += m.id3.Size - size
+	}
+	if m.v1 != nil {
+		return id3.WriteV1File(path, m.v1)
+	}
+	return nil
+}
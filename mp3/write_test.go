@@ -0,0 +1,147 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package mp3
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goulash/audio"
+	"github.com/goulash/audio/id3"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestFile writes data to a file in a temporary directory.
+func writeTestFile(z *testing.T, data []byte) (string, func()) {
+	dir, err := ioutil.TempDir("", "mp3")
+	if err != nil {
+		z.Fatal(err)
+	}
+	path := filepath.Join(dir, "test.mp3")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		z.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestTags(z *testing.T) {
+	assert := assert.New(z)
+
+	t := &id3.Tag{Version: 3}
+	t.SetText("TIT2", "Title")
+	t.SetText("TCON", "(8)")
+	t.SetTrack(0, 0)
+	t.Add(&id3.LyricsFrame{Language: "eng", Text: "la la la"})
+	t.SetUserText("REPLAYGAIN_ALBUM_GAIN", "-3.2 dB")
+	t.SetText("TSSE", "LAME 3.100")
+	tag, err := id3.Encode(t, 0)
+	if !assert.Nil(err) {
+		return
+	}
+	v1 := &id3.V1Tag{Title: "Old Title", Artist: "Artist", Year: 1999, Track: 4, Genre: 17}
+
+	data := append(tag, frames(10, 9)...)
+	data = append(data, v1.Bytes()...)
+	m, err := ReadMetadata(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(int64(len(tag)), m.DataOffset())
+	assert.Equal(int64(10), m.Frames())
+	assert.Equal("Title", m.Title())
+	assert.Equal("Artist", m.Artist())
+	assert.Equal("Jazz", m.Genre())
+	assert.Equal(1999, m.Year())
+	n, total := m.Track()
+	assert.Equal([]int{4, 0}, []int{n, total})
+	assert.Equal("la la la", m.Lyrics())
+	gain, _, ok := m.AlbumGain()
+	assert.True(ok)
+	assert.Equal(-3.2, gain)
+	assert.Equal("LAME 3.100", m.EncoderSettings())
+	if assert.NotNil(m.V1()) {
+		assert.Equal("Old Title", m.V1().Title)
+	}
+
+	// A stream without tags.
+	m, err = ReadMetadata(bytes.NewReader(frames(10, 9)))
+	if assert.Nil(err) {
+		assert.NotNil(m.Tag())
+		assert.Nil(m.V1())
+		assert.Equal("", m.Title())
+		assert.Equal("", m.Genre())
+		assert.Equal(0, m.Year())
+	}
+}
+
+func TestWriteFileMetadata(z *testing.T) {
+	assert := assert.New(z)
+
+	orig := append(frames(10, 9), id3v1()...)
+	path, cleanup := writeTestFile(z, orig)
+	defer cleanup()
+
+	m, err := ReadFileMetadata(path)
+	if !assert.Nil(err) {
+		return
+	}
+	m.SetTitle("Title")
+	m.SetAlbumArtist("Album Artist")
+	m.SetGenre("Rock")
+	m.SetTrack(3, 10)
+	if !assert.Nil(WriteFileMetadata(path, m)) {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(m.DataOffset(), m.Tag().Size)
+	assert.Equal(orig[:10*417], data[m.DataOffset():m.DataOffset()+10*417])
+
+	n, err := ReadFileMetadata(path)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(m.DataOffset(), n.DataOffset())
+	assert.Equal(int64(10), n.Frames())
+	assert.Equal("Title", n.Title())
+	assert.Equal("Album Artist", n.AlbumArtist())
+	assert.Equal("Rock", n.Genre())
+	tn, tt := n.Track()
+	assert.Equal([]int{3, 10}, []int{tn, tt})
+	if assert.NotNil(n.V1()) {
+		assert.Equal("Title", n.V1().Title)
+		assert.Equal(byte(17), n.V1().Genre)
+		assert.Equal(3, n.V1().Track)
+	}
+}
+
+func TestAudioWriteMetadata(z *testing.T) {
+	assert := assert.New(z)
+	path, cleanup := writeTestFile(z, frames(10, 9))
+	defer cleanup()
+
+	err := audio.WriteMetadata(path, func(m audio.MutableMetadata) {
+		m.SetTitle("Written Through Audio")
+		m.SetYear(2016)
+		m.SetWebsite("http://example.com")
+	})
+	if !assert.Nil(err) {
+		return
+	}
+	m, err := ReadFileMetadata(path)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal("Written Through Audio", m.Title())
+	assert.Equal(2016, m.Year())
+	assert.Equal("http://example.com", m.Website())
+	assert.Equal(int64(10), m.Frames())
+	assert.Nil(m.V1())
+}
@@ -0,0 +1,285 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package mp3
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/goulash/audio"
+)
+
+// XingHeader is the Xing or Info header, which encoders write into a frame
+// without audio at the beginning of the stream.
+type XingHeader struct {
+	// Info is true if the ID of the header is "Info" instead of "Xing",
+	// which LAME uses for streams with a constant bitrate.
+	Info bool
+
+	Frames  uint32 // the number of audio frames, or 0 if unknown
+	Bytes   uint32 // the size of the stream, including this frame, or 0 if unknown
+	TOC     []byte // the seek table of 100 entries, or nil
+	Quality int    // the VBR quality from 0 (best) to 100, or -1 if unknown
+}
+
+/*
+parseXingHeader parses the Xing or Info header at the beginning of b, and
+returns it with its size.
+
+Encoding format
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    4 "Xing" for VBR streams, or "Info" for CBR streams
+    4 Flags, where 0x1 means the frames field is present, 0x2 the bytes field,
+      0x4 the TOC field, and 0x8 the quality field
+    4 Number of frames (optional)
+    4 Number of bytes (optional)
+  100 Table of contents (optional)
+    4 Quality (optional)
+===== ===========================================================================
+
+All integers are big-endian.
+*/
+func parseXingHeader(b []byte) (*XingHeader, int) {
+	if len(b) < 8 {
+		return nil, 0
+	}
+	id := string(b[:4])
+	if id != "Xing" && id != "Info" {
+		return nil, 0
+	}
+	flags := binary.BigEndian.Uint32(b[4:])
+	x := &XingHeader{Info: id == "Info", Quality: -1}
+	n := 8
+	field := func(flag uint32, size int) []byte {
+		if flags&flag == 0 || len(b) < n+size {
+			return nil
+		}
+		n += size
+		return b[n-size : n]
+	}
+	if p := field(0x1, 4); p != nil {
+		x.Frames = binary.BigEndian.Uint32(p)
+	}
+	if p := field(0x2, 4); p != nil {
+		x.Bytes = binary.BigEndian.Uint32(p)
+	}
+	if p := field(0x4, 100); p != nil {
+		x.TOC = append([]byte(nil), p...)
+	}
+	if p := field(0x8, 4); p != nil {
+		x.Quality = int(binary.BigEndian.Uint32(p))
+	}
+	return x, n
+}
+
+// VBRIHeader is the header that the Fraunhofer encoder writes into a frame
+// without audio at the beginning of the stream.
+type VBRIHeader struct {
+	Version uint16
+	Delay   uint16 // the encoder delay
+	Quality uint16
+	Bytes   uint32 // the size of the stream
+	Frames  uint32 // the number of audio frames
+}
+
+/*
+parseVBRIHeader parses the VBRI header at the beginning of b, which is
+always 32 bytes after the frame header.
+
+Encoding format
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    4 "VBRI"
+    2 Version
+    2 Delay
+    2 Quality
+    4 Number of bytes
+    4 Number of frames
+    n Table of contents, which is not read
+===== ===========================================================================
+
+All integers are big-endian.
+*/
+func parseVBRIHeader(b []byte) *VBRIHeader {
+	if len(b) < 18 || string(b[:4]) != "VBRI" {
+		return nil
+	}
+	return &VBRIHeader{
+		Version: binary.BigEndian.Uint16(b[4:]),
+		Delay:   binary.BigEndian.Uint16(b[6:]),
+		Quality: binary.BigEndian.Uint16(b[8:]),
+		Bytes:   binary.BigEndian.Uint32(b[10:]),
+		Frames:  binary.BigEndian.Uint32(b[14:]),
+	}
+}
+
+// LAMETag is the extension of the Xing header that LAME and encoders based
+// on it write. It contains the encoder settings, and the encoder delay and
+// padding that are needed for gapless playback.
+type LAMETag struct {
+	Encoder  string // the encoder and its version, such as "LAME3.100"
+	Revision int    // the revision of the tag
+	Method   Method
+
+	// Lowpass is the frequency of the lowpass filter in Hz, or 0.
+	Lowpass int
+
+	// Bitrate is the target bitrate in kbps for ABR, the bitrate for CBR,
+	// and the minimum bitrate for VBR. It is at most 255 for ABR and VBR.
+	Bitrate int
+
+	// Delay is the number of samples that the encoder added at the start
+	// of the stream, and Padding is the number of samples that it added
+	// at the end to fill the last frame. Neither includes the delay of the
+	// decoder.
+	Delay   int
+	Padding int
+
+	// Preset is the LAME preset, or 0 if it is unknown. Values from 8 to
+	// 320 are ABR bitrates, 410 to 500 are -V 9 to -V 0, and values from
+	// 1000 are named presets.
+	Preset int
+
+	MusicLength uint32 // the size of the stream, including the Xing frame
+	MusicCRC    uint16 // the CRC-16 of the audio data
+}
+
+// Method is the bitrate method in a LAME tag.
+type Method uint8
+
+const (
+	MethodUnknown  Method = 0
+	MethodCBR      Method = 1
+	MethodABR      Method = 2
+	MethodVBROld   Method = 3 // --vbr-old
+	MethodVBRMTRH  Method = 4 // --vbr-new, the default VBR method
+	MethodVBRMT    Method = 5
+	MethodVBR4     Method = 6
+	MethodCBR2Pass Method = 8
+	MethodABR2Pass Method = 9
+)
+
+// Mode returns the bitrate mode of the method, or audio.UnknownBitrate.
+func (m Method) Mode() audio.BitrateMode {
+	switch m {
+	case MethodCBR, MethodCBR2Pass:
+		return audio.CBR
+	case MethodABR, MethodABR2Pass:
+		return audio.ABR
+	case MethodVBROld, MethodVBRMTRH, MethodVBRMT, MethodVBR4:
+		return audio.VBR
+	default:
+		return audio.UnknownBitrate
+	}
+}
+
+// lameEncoders are the prefixes of the encoder versions that are accepted,
+// since the tag has no ID of its own.
+var lameEncoders = []string{"LAME", "Lavf", "Lavc", "GOGO"}
+
+/*
+parseLAMETag parses the LAME tag at the beginning of b, which follows the
+Xing header. The header h is the header of the frame that contains it.
+
+Encoding format
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    9 Encoder version, such as "LAME3.100"
+    1 Tag revision (4 bits) and VBR method (4 bits)
+    1 Lowpass frequency in units of 100 Hz
+    4 Peak signal amplitude
+    2 Radio replay gain
+    2 Audiophile replay gain
+    1 Encoding flags (4 bits) and ATH type (4 bits)
+    1 Bitrate in kbps, where 255 means 255 or more
+    3 Encoder delay (12 bits) and padding (12 bits)
+    1 Noise shaping, stereo mode, unwise settings and source sample rate
+    1 MP3 gain
+    2 Surround info (5 bits) and preset (11 bits)
+    4 Music length
+    2 Music CRC
+    2 CRC of the first 190 bytes of the frame
+===== ===========================================================================
+
+All integers are big-endian.
+*/
+func parseLAMETag(b []byte, h *FrameHeader) *LAMETag {
+	if len(b) < 36 || !hasPrefix(string(b[:4]), lameEncoders) {
+		return nil
+	}
+	t := &LAMETag{
+		Encoder:     strings.TrimRight(string(b[:9]), "\x00 "),
+		Revision:    int(b[9] >> 4),
+		Method:      Method(b[9] & 0x0F),
+		Lowpass:     int(b[10]) * 100,
+		Bitrate:     int(b[20]),
+		Delay:       int(b[21])<<4 | int(b[22]>>4),
+		Padding:     int(b[22]&0x0F)<<8 | int(b[23]),
+		Preset:      int(binary.BigEndian.Uint16(b[26:]) & 0x07FF),
+		MusicLength: binary.BigEndian.Uint32(b[28:]),
+		MusicCRC:    binary.BigEndian.Uint16(b[32:]),
+	}
+	if t.Method.Mode() == audio.CBR {
+		// LAME writes the tag of a CBR stream into a frame of the same
+		// bitrate, which is not limited to 255.
+		t.Bitrate = h.Bitrate
+	}
+	return t
+}
+
+func hasPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// lamePresets are the options of the named LAME presets.
+var lamePresets = map[int]string{
+	1000: "--preset r3mix",
+	1001: "--preset standard",
+	1002: "--preset extreme",
+	1003: "--preset insane",
+	1004: "--preset fast standard",
+	1005: "--preset fast extreme",
+	1006: "--preset medium",
+	1007: "--preset fast medium",
+}
+
+// Options returns the LAME command line options that correspond to the
+// preset, or to the method and bitrate if the preset is unknown, such as
+// "-V 2", "--preset extreme", "--abr 128" or "-b 320". It returns the empty
+// string if neither is known.
+func (t *LAMETag) Options() string {
+	switch p := t.Preset; {
+	case p >= 410 && p <= 500 && p%10 == 0:
+		return "-V " + strconv.Itoa((500-p)/10)
+	case lamePresets[p] != "":
+		return lamePresets[p]
+	case p >= 8 && p <= 320 && t.Method.Mode() == audio.CBR:
+		return "-b " + strconv.Itoa(p)
+	case p >= 8 && p <= 320:
+		return "--abr " + strconv.Itoa(p)
+	}
+	switch t.Method {
+	case MethodCBR, MethodCBR2Pass:
+		return "-b " + strconv.Itoa(t.Bitrate)
+	case MethodABR, MethodABR2Pass:
+		return "--abr " + strconv.Itoa(t.Bitrate)
+	case MethodVBROld:
+		return "--vbr-old"
+	case MethodVBRMTRH, MethodVBRMT, MethodVBR4:
+		return "--vbr-new"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,415 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+// Package ogg implements reading Ogg streams and the metadata of the Vorbis
+// and Opus streams in them. The duration is calculated exactly from the
+// granule position of the last page of the stream.
+//
+// Reference
+//
+//  https://xiph.org/ogg/doc/framing.html
+//  https://xiph.org/vorbis/doc/Vorbis_I_spec.html
+//  https://tools.ietf.org/html/rfc7845
+package ogg
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/goulash/audio"
+	"github.com/goulash/audio/vorbis"
+)
+
+func init() {
+	audio.RegisterFormat(audio.Format{
+		Name:       "ogg",
+		Codec:      audio.OGG,
+		Magic:      []string{"OggS"},
+		Extensions: []string{".ogg", ".oga", ".opus"},
+		MIMETypes:  []string{"audio/ogg", "audio/vorbis", "audio/opus"},
+		ReadMetadata: func(r io.ReadSeeker) (audio.Metadata, error) {
+			m, err := ReadMetadata(r)
+			if err != nil {
+				return nil, err
+			}
+			return m, nil
+		},
+	})
+}
+
+var (
+	ErrInvalidStream    = errors.New("stream is invalid")
+	ErrUnsupportedCodec = errors.New("codec unsupported")
+)
+
+// searchSize is the size of the blocks in which the last page is searched
+// for from the end of the stream.
+const searchSize = 4 * maxPageSize
+
+// opusSampleRate is the sample rate of the granule position of Opus streams,
+// whatever the sample rate of the input was.
+const opusSampleRate = 48000
+
+// ReadFileMetadata reads the metadata of the Ogg file at path.
+func ReadFileMetadata(path string) (*Metadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadMetadata(f)
+}
+
+// ReadMetadata reads the headers of the first Vorbis or Opus stream in r,
+// the granule position of its first audio page, and the granule position
+// of its last page, which is searched for from the end of the stream. Other
+// logical streams, such as a Skeleton stream, are skipped. ErrUnsupportedCodec is returned if there is no Vorbis or
+// Opus stream.
+func ReadMetadata(r io.ReadSeeker) (*Metadata, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	cr := &countingReader{r: r}
+	br := bufio.NewReader(cr)
+	pr := NewReader(br)
+	m := new(Metadata)
+
+	// The first pages of all logical streams come before any other pages.
+	var p *Packet
+	var err error
+	for m.vorbis == nil && m.opus == nil {
+		if p, err = pr.ReadPacket(); err != nil {
+			return nil, invalid(err)
+		}
+		if !p.First {
+			return nil, ErrUnsupportedCodec
+		}
+		switch {
+		case isVorbisHeader(p.Data, 1):
+			m.vorbis, err = parseVorbisHeader(p.Data)
+		case bytes.HasPrefix(p.Data, []byte("OpusHead")):
+			m.opus, err = parseOpusHeader(p.Data)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	m.serial = p.Serial
+
+	// The comment header, and the setup header of Vorbis, end the last
+	// page of the headers.
+	if p, err = nextPacket(pr, m.serial); err != nil {
+		return nil, err
+	}
+	if m.vorbis != nil {
+		m.comments, err = parseVorbisComments(p.Data)
+	} else {
+		m.comments, err = parseOpusComments(p.Data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var modes []bool
+	if m.vorbis != nil {
+		if p, err = nextPacket(pr, m.serial); err != nil {
+			return nil, err
+		}
+		if !isVorbisHeader(p.Data, 5) {
+			return nil, ErrInvalidStream
+		}
+		modes = vorbis.ParseModes(p.Data)
+	}
+
+	// The audio starts on the page after the headers. The granule position
+	// of the first audio page may not be the number of samples on it if the
+	// stream does not start at 0, such as a stream that is recorded from a
+	// live stream.
+	audio := cr.n - int64(br.Buffered())
+	samples, prev, found := int64(0), 0, false
+	for !found {
+		pg, err := ReadPage(br)
+		if err == ErrChecksum {
+			continue
+		} else if err != nil {
+			break
+		}
+		if pg.Serial != m.serial {
+			continue
+		}
+		pr.readPage(pg)
+		for _, p := range pr.packets {
+			if m.vorbis != nil {
+				n := vorbisBlockSize(m.vorbis, modes, p.Data)
+				if n == 0 {
+					found = true
+					break
+				}
+				if prev > 0 {
+					samples += int64(prev/4 + n/4)
+				}
+				prev = n
+			} else {
+				samples += int64(opusSamples(p.Data))
+			}
+			if p.Granule != -1 {
+				if m.start = p.Granule - samples; m.start < 0 {
+					m.start = 0
+				}
+				found = true
+				break
+			}
+		}
+		pr.packets = nil
+	}
+
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	var end int64
+	if m.granule, end, err = lastGranule(r, size, m.serial); err != nil {
+		return nil, err
+	}
+	if end > audio {
+		m.size = end - audio
+	}
+	return m, nil
+}
+
+// nextPacket returns the next packet of the logical stream.
+func nextPacket(pr *Reader, serial uint32) (*Packet, error) {
+	for {
+		p, err := pr.ReadPacket()
+		if err != nil {
+			return nil, invalid(err)
+		}
+		if p.Serial == serial {
+			return p, nil
+		}
+	}
+}
+
+// countingReader counts the bytes that are read.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// invalid returns ErrInvalidStream if the stream ended, and otherwise err.
+func invalid(err error) error {
+	if err == io.EOF {
+		return ErrInvalidStream
+	}
+	return err
+}
+
+// lastGranule returns the granule position of the last page of the logical
+// stream that has one, and the offset of the end of the page, or 0 if there
+// is none, in the stream of the given size. Pages that are truncated or
+// fail the CRC check are skipped.
+func lastGranule(r io.ReadSeeker, size int64, serial uint32) (granule, offset int64, err error) {
+	buf := make([]byte, searchSize)
+	capture := []byte("OggS")
+	for end := size; end > 0; {
+		start := end - searchSize
+		if start < 0 {
+			start = 0
+		}
+		b := buf[:end-start]
+		if _, err := r.Seek(start, io.SeekStart); err != nil {
+			return 0, 0, err
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, 0, err
+		}
+		for i := bytes.LastIndex(b, capture); i >= 0; i = bytes.LastIndex(b[:i], capture) {
+			p, err := ReadPage(bytes.NewReader(b[i:]))
+			if err == nil && p.Serial == serial && p.Granule != -1 {
+				return p.Granule, start + int64(i+p.Size()), nil
+			}
+		}
+		if start == 0 {
+			break
+		}
+		// A page that starts in this block may end in the next one.
+		end = start + maxPageSize
+	}
+	return 0, 0, nil
+}
+
+// Metadata {{{
+
+// Metadata is the metadata of a Vorbis or Opus stream in an Ogg stream.
+type Metadata struct {
+	serial   uint32
+	vorbis   *VorbisHeader // or nil
+	opus     *OpusHeader   // or nil
+	comments vorbis.Comments
+	granule  int64 // of the last page
+	start    int64 // granule position at which the stream starts
+	size     int64 // from the first audio page to the end of the last page
+}
+
+// Serial returns the serial number of the logical stream.
+func (m *Metadata) Serial() uint32 { return m.serial }
+
+// Vorbis returns the identification header of a Vorbis stream, and Opus
+// the identification header of an Opus stream; the other is nil.
+func (m *Metadata) Vorbis() *VorbisHeader { return m.vorbis }
+func (m *Metadata) Opus() *OpusHeader     { return m.opus }
+
+func (m *Metadata) Comments() vorbis.Comments { return m.comments }
+func (m *Metadata) Granule() int64            { return m.granule }
+
+// SampleRate returns the sample rate of a Vorbis stream, and 48 kHz for an
+// Opus stream, which is always decoded at this rate.
+func (m *Metadata) SampleRate() int {
+	if m.opus != nil {
+		return opusSampleRate
+	}
+	return m.vorbis.SampleRate
+}
+
+func (m *Metadata) NumChannels() int {
+	if m.opus != nil {
+		return m.opus.Channels
+	}
+	return m.vorbis.Channels
+}
+
+func (m *Metadata) BitsPerSample() int      { return 0 }
+func (m *Metadata) Duration() time.Duration { return m.Length() }
+
+// TotalSamples returns the granule position of the last page, which is the
+// number of samples in the stream, without the pre-skip of Opus streams. If
+// the stream does not start at granule position 0, the granule position of
+// the first audio page minus the samples on it is subtracted.
+func (m *Metadata) TotalSamples() int64 {
+	n := m.granule - m.start
+	if m.opus != nil {
+		n -= int64(m.opus.PreSkip)
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// BitrateMode returns CBR for Vorbis streams whose minimum, nominal and
+// maximum bitrates are equal, and VBR otherwise.
+func (m *Metadata) BitrateMode() audio.BitrateMode {
+	h := m.vorbis
+	if h != nil && h.BitrateNominal > 0 && h.BitrateMinimum == h.BitrateNominal && h.BitrateMaximum == h.BitrateNominal {
+		return audio.CBR
+	}
+	return audio.VBR
+}
+
+func (m *Metadata) ChannelLayout() audio.ChannelLayout {
+	return audio.DefaultChannelLayout(m.NumChannels())
+}
+
+func (m *Metadata) Length() time.Duration {
+	return time.Duration(m.TotalSamples()) * time.Second / time.Duration(m.SampleRate())
+}
+func (m *Metadata) Encoding() audio.Codec { return audio.OGG }
+
+// EncodingBitrate returns the average bitrate in kbps of the pages from the
+// first audio page to the last page of the stream, so that the headers do
+// not count, or the nominal bitrate of a Vorbis stream without audio.
+func (m *Metadata) EncodingBitrate() int {
+	n := m.TotalSamples()
+	if n == 0 {
+		if m.vorbis != nil {
+			return m.vorbis.BitrateNominal / 1000
+		}
+		return 0
+	}
+	return int(m.size * 8 * int64(m.SampleRate()) / n / 1000)
+}
+
+func (m *Metadata) Title() string            { return m.comments.Join("title", "/") }
+func (m *Metadata) Album() string            { return m.comments.Join("album", "/") }
+func (m *Metadata) AlbumArtist() string      { return m.comments.Join("albumartist", "/") }
+func (m *Metadata) Artist() string           { return m.comments.Join("artist", "/") }
+func (m *Metadata) Composer() string         { return m.comments.Join("composer", "/") }
+func (m *Metadata) Year() int                { return m.comments.Int("date") }
+func (m *Metadata) Genre() string            { return m.comments.Join("genre", "/") }
+func (m *Metadata) Comment() string          { return m.comments.Join("description", "\n") }
+func (m *Metadata) Copyright() string        { return m.comments.Join("copyright", "\n") }
+func (m *Metadata) Website() string          { return m.comments.Join("contact", "\n") }
+func (m *Metadata) EncodedBy() string        { return m.comments.Join("encoded-by", "/") }
+func (m *Metadata) OriginalFilename() string { return "" }
+
+func (m *Metadata) Track() (int, int) {
+	return m.comments.Int("tracknumber"), m.comments.Int("tracktotal")
+}
+
+func (m *Metadata) Disc() (int, int) {
+	return m.comments.Int("discnumber"), m.comments.Int("disctotal")
+}
+
+// EncoderSettings returns the ENCODER tag, or else the vendor string, which
+// names the library that encoded the stream.
+func (m *Metadata) EncoderSettings() string {
+	if s := m.comments.Join("encoder", "/"); s != "" {
+		return s
+	}
+	return m.comments.Vendor()
+}
+
+// TrackGain and AlbumGain return the ReplayGain values in the tags
+// REPLAYGAIN_TRACK_GAIN, REPLAYGAIN_TRACK_PEAK, and so on.
+func (m *Metadata) TrackGain() (gain, peak float64, ok bool) { return m.gain("track") }
+func (m *Metadata) AlbumGain() (gain, peak float64, ok bool) { return m.gain("album") }
+
+func (m *Metadata) gain(kind string) (gain, peak float64, ok bool) {
+	gain, ok = m.comments.Float("replaygain_" + kind + "_gain")
+	if !ok {
+		return 0, 0, false
+	}
+	peak, _ = m.comments.Float("replaygain_" + kind + "_peak")
+	return gain, peak, true
+}
+
+// MusicBrainz returns the identifiers in the tags that are written by
+// MusicBrainz Picard.
+func (m *Metadata) MusicBrainz() audio.MusicBrainzIDs {
+	return audio.MusicBrainzIDs{
+		Recording:    m.comments.Join("musicbrainz_trackid", ""),
+		Track:        m.comments.Join("musicbrainz_releasetrackid", ""),
+		Release:      m.comments.Join("musicbrainz_albumid", ""),
+		ReleaseGroup: m.comments.Join("musicbrainz_releasegroupid", ""),
+		Work:         m.comments.Join("musicbrainz_workid", ""),
+		Artists:      m.comments["musicbrainz_artistid"],
+		AlbumArtists: m.comments["musicbrainz_albumartistid"],
+	}
+}
+
+// Lyrics returns the LYRICS tag, or else the UNSYNCEDLYRICS tag.
+func (m *Metadata) Lyrics() string {
+	if s := m.comments.Join("lyrics", "\n"); s != "" {
+		return s
+	}
+	return m.comments.Join("unsyncedlyrics", "\n")
+}
+
+var (
+	_ = audio.Metadata(new(Metadata))
+	_ = audio.ReplayGainReader(new(Metadata))
+	_ = audio.MusicBrainzReader(new(Metadata))
+	_ = audio.LyricsReader(new(Metadata))
+)
+
+// }}}
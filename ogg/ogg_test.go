@@ -0,0 +1,308 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package ogg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/goulash/audio"
+	"github.com/goulash/audio/vorbis"
+	"github.com/stretchr/testify/assert"
+)
+
+// vorbisHeader returns an identification header packet.
+func vorbisHeader(channels, rate, max, nominal, min int) []byte {
+	b := append([]byte("\x01vorbis"), make([]byte, 23)...)
+	b[11] = byte(channels)
+	binary.LittleEndian.PutUint32(b[12:], uint32(rate))
+	binary.LittleEndian.PutUint32(b[16:], uint32(int32(max)))
+	binary.LittleEndian.PutUint32(b[20:], uint32(int32(nominal)))
+	binary.LittleEndian.PutUint32(b[24:], uint32(int32(min)))
+	b[28] = 0xB8
+	b[29] = 1
+	return b
+}
+
+func opusHeader(channels, preskip int) []byte {
+	b := append([]byte("OpusHead"), 1, byte(channels), 0, 0, 0x80, 0xBB, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint16(b[10:], uint16(preskip))
+	return b
+}
+
+var testComments = vorbis.Comments{
+	vorbis.VendorKey:        {"Xiph.Org libVorbis I 20200704"},
+	"title":                 {"Dawn Chorus"},
+	"artist":                {"Field Recordist"},
+	"date":                  {"2016"},
+	"tracknumber":           {"3"},
+	"tracktotal":            {"12"},
+	"replaygain_track_gain": {"-3.5 dB"},
+	"replaygain_track_peak": {"0.9"},
+	"musicbrainz_artistid":  {"a1"},
+}
+
+// audioPages returns n pages of the stream with 100 bytes of audio each,
+// the granule positions of which increase by step. The audio is an Opus
+// packet of 5 frames of 20 ms.
+func audioPages(serial uint32, n int, step int64) []byte {
+	var b []byte
+	for i := 1; i <= n; i++ {
+		var flags byte
+		if i == n {
+			flags = Last
+		}
+		packet := make([]byte, 100)
+		packet[0], packet[1] = 19<<3|3, 5
+		b = append(b, page(flags, int64(i)*step, serial, uint32(2+i), false, packet)...)
+	}
+	return b
+}
+
+func TestVorbis(z *testing.T) {
+	assert := assert.New(z)
+
+	comments := append([]byte("\x03vorbis"), testComments.Bytes()...)
+	comments = append(comments, 1)
+	var data []byte
+	data = append(data, page(First, 0, 5, 0, false, vorbisHeader(2, 44100, -1, 128000, -1))...)
+	data = append(data, page(0, 0, 5, 1, false, comments, []byte("\x05vorbis setup"))...)
+	data = append(data, audioPages(5, 10, 4410)...)
+
+	m, err := ReadMetadata(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Nil(m.Opus())
+	if assert.NotNil(m.Vorbis()) {
+		h := m.Vorbis()
+		assert.Equal(2, h.Channels)
+		assert.Equal(44100, h.SampleRate)
+		assert.Equal(0, h.BitrateMaximum)
+		assert.Equal(128000, h.BitrateNominal)
+		assert.Equal(256, h.BlockSize0)
+		assert.Equal(2048, h.BlockSize1)
+	}
+	assert.Equal(uint32(5), m.Serial())
+	assert.Equal(int64(44100), m.Granule())
+
+	var p audio.Properties = m
+	assert.Equal(44100, p.SampleRate())
+	assert.Equal(2, p.NumChannels())
+	assert.Equal(int64(44100), p.TotalSamples())
+	assert.Equal(time.Second, p.Duration())
+	assert.Equal(audio.VBR, p.BitrateMode())
+	assert.Equal(audio.Stereo, p.ChannelLayout())
+	// The headers do not count towards the bitrate.
+	assert.Equal(10*(headerSize+1+100)*8/1000, m.EncodingBitrate())
+
+	assert.Equal("Dawn Chorus", m.Title())
+	assert.Equal("Field Recordist", m.Artist())
+	assert.Equal(2016, m.Year())
+	n, total := m.Track()
+	assert.Equal([]int{3, 12}, []int{n, total})
+	assert.Equal("Xiph.Org libVorbis I 20200704", m.EncoderSettings())
+	gain, peak, ok := m.TrackGain()
+	assert.True(ok)
+	assert.Equal(-3.5, gain)
+	assert.Equal(0.9, peak)
+	assert.Equal([]string{"a1"}, m.MusicBrainz().Artists)
+
+	c, err := audio.IdentifyReader(bytes.NewReader(data))
+	if assert.Nil(err) {
+		assert.Equal(audio.OGG, c)
+	}
+	md, err := audio.ReadMetadataFrom(bytes.NewReader(data))
+	if assert.Nil(err) {
+		assert.IsType(new(Metadata), md)
+	}
+}
+
+func TestOpus(z *testing.T) {
+	assert := assert.New(z)
+
+	tags := append([]byte("OpusTags"), testComments.Bytes()...)
+	var data []byte
+	// A Skeleton stream is skipped.
+	data = append(data, page(First, 0, 1, 0, false, []byte("fishead\x00"))...)
+	data = append(data, page(First, 0, 2, 0, false, opusHeader(1, 312))...)
+	data = append(data, page(Last, 0, 1, 1, false, nil)...)
+	data = append(data, page(0, 0, 2, 1, false, tags)...)
+	data = append(data, audioPages(2, 50, 4800)...)
+	// The last page is truncated.
+	data = append(data, page(0, 1<<20, 2, 100, false, make([]byte, 100))[:50]...)
+
+	m, err := ReadMetadata(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Nil(m.Vorbis())
+	if assert.NotNil(m.Opus()) {
+		assert.Equal(312, m.Opus().PreSkip)
+		assert.Equal(48000, m.Opus().InputSampleRate)
+	}
+	assert.Equal(48000, m.SampleRate())
+	assert.Equal(1, m.NumChannels())
+	assert.Equal(int64(50*4800-312), m.TotalSamples())
+	assert.Equal(time.Duration(50*4800-312)*time.Second/48000, m.Duration())
+	assert.Equal(audio.Mono, m.ChannelLayout())
+	assert.Equal("Dawn Chorus", m.Title())
+}
+
+// vorbisSetup returns a setup header packet that ends with modes of the
+// given block flags, after bytes that stand in for the codebooks.
+func vorbisSetup(flags ...bool) []byte {
+	var b []byte
+	n := uint(0)
+	put := func(v uint32, bits uint) {
+		for i := uint(0); i < bits; i++ {
+			if n%8 == 0 {
+				b = append(b, 0)
+			}
+			b[len(b)-1] |= byte(v>>i&1) << (n % 8)
+			n++
+		}
+	}
+	for i := 0; i < 16; i++ {
+		put(0xFF, 8)
+	}
+	put(uint32(len(flags)-1), 6)
+	for i, f := range flags {
+		if f {
+			put(1, 1)
+		} else {
+			put(0, 1)
+		}
+		put(0, 16)
+		put(0, 16)
+		put(uint32(i), 8)
+	}
+	put(1, 1)
+	return append([]byte("\x05vorbis"), b...)
+}
+
+func TestStartOffset(z *testing.T) {
+	assert := assert.New(z)
+
+	// A Vorbis stream that starts at 100000 has a short block and two long
+	// blocks on its first audio page, which make 64+512 + 512+512 samples.
+	// The cover in the comments does not count towards the bitrate.
+	comments := vorbis.Comments{
+		vorbis.VendorKey:         {"x"},
+		"metadata_block_picture": {string(make([]byte, 60000))},
+	}
+	header := append([]byte("\x03vorbis"), comments.Bytes()...)
+	header = append(header, 1)
+	var data []byte
+	data = append(data, page(First, 0, 1, 0, false, vorbisHeader(2, 44100, 0, 0, 0))...)
+	data = append(data, page(0, -1, 1, 1, true, header[:200*255])...)
+	data = append(data, page(Continued, 0, 1, 2, false, header[200*255:], vorbisSetup(false, true))...)
+	first := page(0, 101600, 1, 3, false, []byte{0}, []byte{2}, []byte{2})
+	last := page(Last, 101600+44100, 1, 4, false, make([]byte, 1000))
+	data = append(data, first...)
+	data = append(data, last...)
+
+	m, err := ReadMetadata(bytes.NewReader(data))
+	if assert.Nil(err) {
+		assert.Equal(int64(44100+1600), m.TotalSamples())
+		assert.Equal((len(first)+len(last))*8*44100/(44100+1600)/1000, m.EncodingBitrate())
+	}
+
+	// An Opus stream that starts at 48000 has 20 ms on its first page.
+	data = nil
+	data = append(data, page(First, 0, 1, 0, false, opusHeader(2, 312))...)
+	data = append(data, page(0, 0, 1, 1, false, []byte("OpusTags\x00\x00\x00\x00\x00\x00\x00\x00"))...)
+	data = append(data, page(0, 48000+960, 1, 2, false, []byte{19 << 3})...)
+	data = append(data, page(Last, 96000, 1, 3, false, []byte{19 << 3})...)
+	m, err = ReadMetadata(bytes.NewReader(data))
+	if assert.Nil(err) {
+		assert.Equal(int64(48000-312), m.TotalSamples())
+	}
+}
+
+// countingReadSeeker counts the bytes that are read.
+type countingReadSeeker struct {
+	io.ReadSeeker
+	n int
+}
+
+func (r *countingReadSeeker) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestReadMetadataSize(z *testing.T) {
+	assert := assert.New(z)
+
+	// Only the beginning and the end of a long stream are read.
+	var data []byte
+	data = append(data, page(First, 0, 1, 0, false, opusHeader(2, 0))...)
+	data = append(data, page(0, 0, 1, 1, false, []byte("OpusTags\x00\x00\x00\x00\x00\x00\x00\x00"))...)
+	audio := len(data)
+	for i := 1; i <= 200; i++ {
+		data = append(data, page(0, int64(i)*48000, 1, uint32(1+i), false, []byte{19<<3 | 3, 50}, make([]byte, 60000))...)
+	}
+	// Another stream after the last page does not count.
+	end := len(data)
+	data = append(data, page(First|Last, 0, 2, 0, false, make([]byte, 1000))...)
+
+	r := &countingReadSeeker{ReadSeeker: bytes.NewReader(data)}
+	m, err := ReadMetadata(r)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.True(r.n < len(data)/10, "read %d bytes", r.n)
+	assert.Equal(int64(200*48000), m.TotalSamples())
+	assert.Equal((end-audio)*8/200/1000, m.EncodingBitrate())
+}
+
+func TestLastGranule(z *testing.T) {
+	assert := assert.New(z)
+
+	// The last page of the stream is far from the end of the stream,
+	// after which another stream is chained.
+	var data []byte
+	data = append(data, page(First, 0, 1, 0, false, vorbisHeader(1, 8000, 0, 0, 0))...)
+	data = append(data, audioPages(1, 3, 1000)...)
+	end := int64(len(data))
+	data = append(data, page(First, 0, 2, 0, false, vorbisHeader(1, 8000, 0, 0, 0))...)
+	for i := 0; i < 3*searchSize/(255*255); i++ {
+		data = append(data, page(0, 100, 2, uint32(1+i), false, make([]byte, 255*254))...)
+	}
+
+	g, off, err := lastGranule(bytes.NewReader(data), int64(len(data)), 1)
+	if assert.Nil(err) {
+		assert.Equal(int64(3000), g)
+		assert.Equal(end, off)
+	}
+	g, off, err = lastGranule(bytes.NewReader(data), int64(len(data)), 3)
+	if assert.Nil(err) {
+		assert.Equal(int64(0), g)
+		assert.Equal(int64(0), off)
+	}
+}
+
+func TestReadMetadataInvalid(z *testing.T) {
+	tests := []struct {
+		Data []byte
+		Err  error
+	}{
+		{nil, ErrInvalidStream},
+		{page(First, 0, 1, 0, false, []byte("\x7FFLAC")), ErrInvalidStream},
+		{append(page(First, 0, 1, 0, false, []byte("\x7FFLAC")), page(0, 0, 1, 1, false, []byte("x"))...), ErrUnsupportedCodec},
+		{page(First, 0, 1, 0, false, vorbisHeader(0, 44100, 0, 0, 0)), ErrInvalidStream},
+		{page(First, 0, 1, 0, false, vorbisHeader(2, 44100, 0, 0, 0)), ErrInvalidStream},
+		{append(page(First, 0, 1, 0, false, vorbisHeader(2, 44100, 0, 0, 0)), page(0, 0, 1, 1, false, []byte("\x03vorbis"))...), vorbis.ErrInvalidComment},
+	}
+	for i, tt := range tests {
+		if _, err := ReadMetadata(bytes.NewReader(tt.Data)); err != tt.Err {
+			z.Errorf("test %d: got error %v, want %v", i, err, tt.Err)
+		}
+	}
+}
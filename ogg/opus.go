@@ -0,0 +1,96 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package ogg
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/goulash/audio/vorbis"
+)
+
+// OpusHeader is the identification header of an Opus stream.
+type OpusHeader struct {
+	Version         int
+	Channels        int
+	PreSkip         int // samples at 48 kHz to discard at the beginning
+	InputSampleRate int // sample rate of the original input, or 0
+	OutputGain      int // in 1/256 dB
+	MappingFamily   int
+}
+
+/*
+parseOpusHeader parses the identification header packet of an Opus stream.
+
+Encoding format
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    8 "OpusHead"
+    1 Version: 1; the upper 4 bits are the major version
+    1 Number of channels
+    2 Pre-skip
+    4 Input sample rate
+    2 Output gain, signed
+    1 Channel mapping family
+    n Channel mapping table (optional)
+===== ===========================================================================
+
+All integers are little-endian.
+*/
+func parseOpusHeader(b []byte) (*OpusHeader, error) {
+	if len(b) < 19 || !bytes.HasPrefix(b, []byte("OpusHead")) || b[8]>>4 != 0 || b[9] == 0 {
+		return nil, ErrInvalidStream
+	}
+	return &OpusHeader{
+		Version:         int(b[8]),
+		Channels:        int(b[9]),
+		PreSkip:         int(binary.LittleEndian.Uint16(b[10:])),
+		InputSampleRate: int(binary.LittleEndian.Uint32(b[12:])),
+		OutputGain:      int(int16(binary.LittleEndian.Uint16(b[16:]))),
+		MappingFamily:   int(b[18]),
+	}, nil
+}
+
+// parseOpusComments parses the comment header packet of an Opus stream,
+// which starts with "OpusTags" and has no framing bit.
+func parseOpusComments(b []byte) (vorbis.Comments, error) {
+	if !bytes.HasPrefix(b, []byte("OpusTags")) {
+		return nil, ErrInvalidStream
+	}
+	c, _, err := vorbis.ParseComments(b[8:])
+	return c, err
+}
+
+// opusSamples returns the number of samples at 48 kHz in the Opus packet b,
+// which is given by the configuration in the upper 5 bits of the TOC byte,
+// and the number of frames in the lower 2 bits, or in the next byte if they
+// are 3. It returns 0 for an invalid packet.
+func opusSamples(b []byte) int {
+	if len(b) == 0 {
+		return 0
+	}
+	config := b[0] >> 3
+	var size int
+	switch {
+	case config < 12: // SILK: 10, 20, 40 or 60 ms
+		size = []int{480, 960, 1920, 2880}[config%4]
+	case config < 16: // Hybrid: 10 or 20 ms
+		size = []int{480, 960}[config%2]
+	default: // CELT: 2.5, 5, 10 or 20 ms
+		size = []int{120, 240, 480, 960}[config%4]
+	}
+	frames := 1
+	switch b[0] & 3 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(b) < 2 {
+			return 0
+		}
+		frames = int(b[1] & 0x3F)
+	}
+	return size * frames
+}
@@ -0,0 +1,111 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package ogg
+
+import "io"
+
+// Packet is a packet of a logical stream.
+type Packet struct {
+	Serial uint32
+	Data   []byte
+
+	// Granule is the granule position of the page on which the packet
+	// ends if it is the last packet that ends there, and -1 otherwise.
+	Granule int64
+
+	// First is true for the first packet of a logical stream, and Last
+	// for the last packet.
+	First bool
+	Last  bool
+}
+
+// Reader reads the packets of the logical streams in an Ogg stream, which
+// may be multiplexed or chained.
+type Reader struct {
+	r       io.Reader
+	partial map[uint32][]byte // packets that continue on the next page
+	first   map[uint32]bool   // streams of which no packet has been returned
+	seq     map[uint32]uint32 // sequence numbers of the last pages
+	packets []*Packet         // packets of the current page
+}
+
+// NewReader returns a Reader that reads pages from r, starting at the
+// current position.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r:       r,
+		partial: make(map[uint32][]byte),
+		first:   make(map[uint32]bool),
+		seq:     make(map[uint32]uint32),
+	}
+}
+
+// ReadPacket returns the next packet, in the order in which the packets end
+// in the stream. It returns io.EOF at the end of the stream, and an error if
+// a page is invalid. A packet that continues on a page that is missing,
+// which is noticed by a gap in the sequence numbers, is dropped.
+func (r *Reader) ReadPacket() (*Packet, error) {
+	for len(r.packets) == 0 {
+		p, err := ReadPage(r.r)
+		if err != nil {
+			return nil, err
+		}
+		r.readPage(p)
+	}
+	pkt := r.packets[0]
+	r.packets = r.packets[1:]
+	return pkt, nil
+}
+
+// readPage splits the page into packets, which are appended to r.packets,
+// and stores the packet that continues on the next page.
+func (r *Reader) readPage(p *Page) {
+	if p.Flags&First != 0 {
+		r.first[p.Serial] = true
+		delete(r.partial, p.Serial)
+		delete(r.seq, p.Serial)
+	}
+	buf, ok := r.partial[p.Serial]
+	delete(r.partial, p.Serial)
+	if seq, found := r.seq[p.Serial]; found && p.Sequence != seq+1 {
+		buf, ok = nil, false
+	}
+	r.seq[p.Serial] = p.Sequence
+	if p.Flags&Continued == 0 {
+		buf, ok = nil, true
+	}
+
+	var last *Packet
+	data := p.Data
+	n := 0
+	for _, s := range p.Segments {
+		n += int(s)
+		if s == 255 {
+			continue
+		}
+		if ok {
+			last = r.packet(p.Serial, append(buf, data[:n]...))
+		}
+		buf, ok, data, n = nil, true, data[n:], 0
+	}
+	if n > 0 && ok && p.Flags&Last == 0 {
+		r.partial[p.Serial] = append(buf, data[:n]...)
+	}
+	if last != nil {
+		last.Granule = p.Granule
+		last.Last = p.Flags&Last != 0
+	}
+	if p.Flags&Last != 0 {
+		delete(r.first, p.Serial)
+		delete(r.seq, p.Serial)
+	}
+}
+
+func (r *Reader) packet(serial uint32, data []byte) *Packet {
+	pkt := &Packet{Serial: serial, Data: data, Granule: -1, First: r.first[serial]}
+	delete(r.first, serial)
+	r.packets = append(r.packets, pkt)
+	return pkt
+}
@@ -0,0 +1,150 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package ogg
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	ErrInvalidPage = errors.New("page is invalid")
+	ErrChecksum    = errors.New("page checksum mismatch")
+)
+
+// Page flags
+const (
+	Continued byte = 0x01 // the first packet continues from the previous page
+	First     byte = 0x02 // the first page of a logical stream
+	Last      byte = 0x04 // the last page of a logical stream
+)
+
+// headerSize is the size of a page header without the segment table, and
+// maxPageSize is the largest possible size of a page.
+const (
+	headerSize  = 27
+	maxPageSize = headerSize + 255 + 255*255
+)
+
+// Page is a page of an Ogg stream.
+type Page struct {
+	Flags    byte
+	Granule  int64  // granule position, or -1 if no packet ends on the page
+	Serial   uint32 // serial number of the logical stream
+	Sequence uint32 // sequence number of the page in the logical stream
+	Segments []byte // segment table, which contains the lacing values
+	Data     []byte
+}
+
+/*
+ReadPage reads the page at the current position of r, and checks its CRC.
+
+Encoding format
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    4 Capture pattern "OggS"
+    1 Stream structure version: 0
+    1 Header type flags: 0x01 continued packet, 0x02 first page, 0x04 last page
+    8 Granule position
+    4 Stream serial number
+    4 Page sequence number
+    4 CRC checksum of the page, calculated with this field set to zero
+    1 Number of segments
+    n Segment table, with the size of each segment
+    n Data
+===== ===========================================================================
+
+All integers are little-endian. A packet consists of consecutive segments,
+and ends with a segment of less than 255 bytes. A packet whose size is a
+multiple of 255 ends with a segment of 0 bytes.
+*/
+func ReadPage(r io.Reader) (*Page, error) {
+	var h [headerSize + 255]byte
+	if _, err := io.ReadFull(r, h[:headerSize]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, ErrInvalidPage
+		}
+		return nil, err
+	}
+	if string(h[:4]) != "OggS" || h[4] != 0 {
+		return nil, ErrInvalidPage
+	}
+	n := int(h[26])
+	if _, err := io.ReadFull(r, h[headerSize:headerSize+n]); err != nil {
+		return nil, ErrInvalidPage
+	}
+	size := 0
+	for _, s := range h[headerSize : headerSize+n] {
+		size += int(s)
+	}
+
+	// Only the size of the page is allocated, which is usually much less
+	// than maxPageSize.
+	b := make([]byte, headerSize+n+size)
+	copy(b, h[:headerSize+n])
+	if _, err := io.ReadFull(r, b[headerSize+n:]); err != nil {
+		return nil, ErrInvalidPage
+	}
+
+	sum := binary.LittleEndian.Uint32(b[22:])
+	b[22], b[23], b[24], b[25] = 0, 0, 0, 0
+	if crc(b) != sum {
+		return nil, ErrChecksum
+	}
+	return &Page{
+		Flags:    b[5],
+		Granule:  int64(binary.LittleEndian.Uint64(b[6:])),
+		Serial:   binary.LittleEndian.Uint32(b[14:]),
+		Sequence: binary.LittleEndian.Uint32(b[18:]),
+		Segments: b[headerSize : headerSize+n],
+		Data:     b[headerSize+n:],
+	}, nil
+}
+
+// Size returns the size of the encoded page.
+func (p *Page) Size() int { return headerSize + len(p.Segments) + len(p.Data) }
+
+// Bytes returns the encoded page with its CRC.
+func (p *Page) Bytes() []byte {
+	b := make([]byte, headerSize, p.Size())
+	copy(b, "OggS")
+	b[5] = p.Flags
+	binary.LittleEndian.PutUint64(b[6:], uint64(p.Granule))
+	binary.LittleEndian.PutUint32(b[14:], p.Serial)
+	binary.LittleEndian.PutUint32(b[18:], p.Sequence)
+	b[26] = byte(len(p.Segments))
+	b = append(b, p.Segments...)
+	b = append(b, p.Data...)
+	binary.LittleEndian.PutUint32(b[22:], crc(b))
+	return b
+}
+
+// crcTable is the table of the CRC that Ogg uses, which has the polynomial
+// 0x04C11DB7 and is calculated MSB first, without initial or final XOR.
+var crcTable = func() [256]uint32 {
+	var t [256]uint32
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04C11DB7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+func crc(b []byte) uint32 {
+	var c uint32
+	for _, x := range b {
+		c = c<<8 ^ crcTable[byte(c>>24)^x]
+	}
+	return c
+}
@@ -0,0 +1,150 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package ogg
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lacing returns the segment table of the packets, the last of which is
+// continued on the next page if open is true.
+func lacing(open bool, packets ...[]byte) ([]byte, []byte) {
+	var segs, data []byte
+	for i, p := range packets {
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			segs = append(segs, 255)
+		}
+		if !open || i < len(packets)-1 {
+			segs = append(segs, byte(n))
+		} else if n > 0 {
+			panic("open packet must be a multiple of 255 bytes")
+		}
+		data = append(data, p...)
+	}
+	return segs, data
+}
+
+func page(flags byte, granule int64, serial, seq uint32, open bool, packets ...[]byte) []byte {
+	segs, data := lacing(open, packets...)
+	p := &Page{Flags: flags, Granule: granule, Serial: serial, Sequence: seq, Segments: segs, Data: data}
+	return p.Bytes()
+}
+
+func TestCRC(z *testing.T) {
+	// CRC-32/CKSUM without the final XOR.
+	assert.Equal(z, uint32(0x765E7680^0xFFFFFFFF), crc([]byte("123456789")))
+}
+
+func TestReadPage(z *testing.T) {
+	assert := assert.New(z)
+
+	b := page(First, 0, 7, 0, false, []byte("hello"), make([]byte, 255))
+	p, err := ReadPage(bytes.NewReader(b))
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(First, p.Flags)
+	assert.Equal(int64(0), p.Granule)
+	assert.Equal(uint32(7), p.Serial)
+	assert.Equal([]byte{5, 255, 0}, p.Segments)
+	assert.Len(p.Data, 260)
+	assert.Equal(len(b), p.Size())
+	assert.Equal(b, p.Bytes())
+
+	c := append([]byte(nil), b...)
+	c[len(c)-1] ^= 1
+	_, err = ReadPage(bytes.NewReader(c))
+	assert.Equal(ErrChecksum, err)
+
+	_, err = ReadPage(bytes.NewReader(b[:len(b)-1]))
+	assert.Equal(ErrInvalidPage, err)
+	_, err = ReadPage(bytes.NewReader([]byte("OggT" + string(b[4:]))))
+	assert.Equal(ErrInvalidPage, err)
+	_, err = ReadPage(bytes.NewReader(nil))
+	assert.Equal(io.EOF, err)
+}
+
+func TestReadPacket(z *testing.T) {
+	assert := assert.New(z)
+
+	long := bytes.Repeat([]byte("x"), 2*255)
+	var data []byte
+	data = append(data, page(First, 0, 1, 0, false, []byte("a1"))...)
+	data = append(data, page(First, 0, 2, 0, false, []byte("b1"))...)
+	data = append(data, page(0, 10, 1, 1, false, []byte("a2"), []byte("a3"))...)
+	data = append(data, page(0, -1, 2, 1, true, long[:255])...)
+	data = append(data, page(Continued, 20, 2, 2, false, long[255:], []byte("b3"))...)
+	data = append(data, page(Last, 30, 1, 2, false, []byte("a4"))...)
+	// A continued packet of which the beginning is missing is dropped.
+	data = append(data, page(Continued|Last, 40, 2, 4, false, []byte("lost"), []byte("b4"))...)
+
+	want := []struct {
+		Serial  uint32
+		Data    string
+		Granule int64
+		First   bool
+		Last    bool
+	}{
+		{1, "a1", 0, true, false},
+		{2, "b1", 0, true, false},
+		{1, "a2", -1, false, false},
+		{1, "a3", 10, false, false},
+		{2, string(long), -1, false, false},
+		{2, "b3", 20, false, false},
+		{1, "a4", 30, false, true},
+		{2, "b4", 40, false, true},
+	}
+	r := NewReader(bytes.NewReader(data))
+	for i, w := range want {
+		p, err := r.ReadPacket()
+		if !assert.Nil(err, "packet %d", i) {
+			return
+		}
+		assert.Equal(w.Serial, p.Serial, "packet %d", i)
+		assert.Equal(w.Data, string(p.Data), "packet %d", i)
+		assert.Equal(w.Granule, p.Granule, "packet %d", i)
+		assert.Equal(w.First, p.First, "packet %d", i)
+		assert.Equal(w.Last, p.Last, "packet %d", i)
+	}
+	_, err := r.ReadPacket()
+	assert.Equal(io.EOF, err)
+}
+
+func TestReadPacketGap(z *testing.T) {
+	assert := assert.New(z)
+
+	// The packet that begins on page 1 ends on page 2, which is missing,
+	// so the beginning of page 3 continues another packet.
+	long := bytes.Repeat([]byte("x"), 255)
+	var data []byte
+	data = append(data, page(First, -1, 1, 0, true, long)...)
+	data = append(data, page(Continued, 30, 1, 2, false, []byte("rest"), []byte("a3"))...)
+	data = append(data, page(Last, 40, 1, 3, false, []byte("a4"))...)
+
+	r := NewReader(bytes.NewReader(data))
+	for _, w := range []string{"a3", "a4"} {
+		p, err := r.ReadPacket()
+		if !assert.Nil(err) {
+			return
+		}
+		assert.Equal(w, string(p.Data))
+	}
+	_, err := r.ReadPacket()
+	assert.Equal(io.EOF, err)
+}
+
+func TestReadPageSize(z *testing.T) {
+	// The page is not allocated with the largest possible size.
+	b := page(0, 0, 1, 0, false, make([]byte, 100))
+	p, err := ReadPage(bytes.NewReader(b))
+	if err != nil || cap(p.Data) > len(b) {
+		z.Errorf("ReadPage = %v; expecting a page of %d bytes", err, len(b))
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package ogg
+
+import (
+	"encoding/binary"
+
+	"github.com/goulash/audio/vorbis"
+)
+
+// VorbisHeader is the identification header of a Vorbis stream. The
+// bitrates are in bits per second, and 0 if they are not set.
+type VorbisHeader struct {
+	Version        uint32
+	Channels       int
+	SampleRate     int
+	BitrateMaximum int
+	BitrateNominal int
+	BitrateMinimum int
+	BlockSize0     int
+	BlockSize1     int
+}
+
+/*
+parseVorbisHeader parses the identification header packet of a Vorbis stream.
+
+Encoding format
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    1 Packet type: 1
+    6 "vorbis"
+    4 Vorbis version: 0
+    1 Number of channels
+    4 Sample rate
+    4 Maximum bitrate, signed
+    4 Nominal bitrate, signed
+    4 Minimum bitrate, signed
+    1 Block sizes: the exponent of block size 0 in the lower 4 bits, and of
+      block size 1 in the upper 4 bits
+    1 Framing flag: 1
+===== ===========================================================================
+
+All integers are little-endian.
+*/
+func parseVorbisHeader(b []byte) (*VorbisHeader, error) {
+	if len(b) < 30 || !isVorbisHeader(b, 1) {
+		return nil, ErrInvalidStream
+	}
+	bitrate := func(b []byte) int {
+		if n := int32(binary.LittleEndian.Uint32(b)); n > 0 {
+			return int(n)
+		}
+		return 0
+	}
+	h := &VorbisHeader{
+		Version:        binary.LittleEndian.Uint32(b[7:]),
+		Channels:       int(b[11]),
+		SampleRate:     int(binary.LittleEndian.Uint32(b[12:])),
+		BitrateMaximum: bitrate(b[16:]),
+		BitrateNominal: bitrate(b[20:]),
+		BitrateMinimum: bitrate(b[24:]),
+		BlockSize0:     1 << (b[28] & 0x0F),
+		BlockSize1:     1 << (b[28] >> 4),
+	}
+	if h.Version != 0 || h.Channels == 0 || h.SampleRate == 0 || b[29]&1 == 0 {
+		return nil, ErrInvalidStream
+	}
+	return h, nil
+}
+
+// parseVorbisComments parses the comment header packet of a Vorbis stream,
+// which starts with the packet type 3 and "vorbis", and ends with the
+// framing bit.
+func parseVorbisComments(b []byte) (vorbis.Comments, error) {
+	if !isVorbisHeader(b, 3) {
+		return nil, ErrInvalidStream
+	}
+	c, n, err := vorbis.ParseComments(b[7:])
+	if err != nil {
+		return nil, err
+	}
+	if 7+n >= len(b) || b[7+n]&1 == 0 {
+		return nil, ErrInvalidStream
+	}
+	return c, nil
+}
+
+func isVorbisHeader(b []byte, typ byte) bool {
+	return len(b) >= 7 && b[0] == typ && string(b[1:7]) == "vorbis"
+}
+
+// vorbisBlockSize returns the block size of the audio packet b, or 0 if it
+// is not an audio packet of one of the modes.
+func vorbisBlockSize(h *VorbisHeader, modes []bool, b []byte) int {
+	flag, ok := vorbis.BlockFlag(modes, b)
+	switch {
+	case !ok:
+		return 0
+	case flag:
+		return h.BlockSize1
+	default:
+		return h.BlockSize0
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2016, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package audio
+
+// PictureType is the type of a picture, as defined by the ID3v2 APIC frame.
+type PictureType uint32
+
+const (
+	PictureOther             PictureType = iota // Other
+	PictureFileIcon                             // 32x32 pixels file icon (PNG only)
+	PictureOtherFileIcon                        // Other file icon
+	PictureFrontCover                           // Cover (front)
+	PictureBackCover                            // Cover (back)
+	PictureLeaflet                              // Leaflet page
+	PictureMedia                                // Media (e.g. label side of CD)
+	PictureLeadArtist                           // Lead artist/lead performer/soloist
+	PictureArtist                               // Artist/performer
+	PictureConductor                            // Conductor
+	PictureBand                                 // Band/Orchestra
+	PictureComposer                             // Composer
+	PictureLyricist                             // Lyricist/text writer
+	PictureRecordingLocation                    // Recording Location
+	PictureDuringRecording                      // During recording
+	PictureDuringPerformance                    // During performance
+	PictureScreenCapture                        // Movie/video screen capture
+	PictureFish                                 // A bright coloured fish
+	PictureIllustration                         // Illustration
+	PictureBandLogo                             // Band/artist logotype
+	PicturePublisherLogo                        // Publisher/Studio logotype
+)
+
+var pictureTypeNames = [...]string{
+	"Other",
+	"File Icon",
+	"Other File Icon",
+	"Front Cover",
+	"Back Cover",
+	"Leaflet",
+	"Media",
+	"Lead Artist",
+	"Artist",
+	"Conductor",
+	"Band",
+	"Composer",
+	"Lyricist",
+	"Recording Location",
+	"During Recording",
+	"During Performance",
+	"Screen Capture",
+	"Fish",
+	"Illustration",
+	"Band Logo",
+	"Publisher Logo",
+}
+
+func (t PictureType) String() string {
+	if int(t) < len(pictureTypeNames) {
+		return pictureTypeNames[t]
+	}
+	return "?"
+}
+
+// Picture is a picture embedded in the stream, such as the cover art.
+type Picture struct {
+	// Type is the picture type, such as PictureFrontCover.
+	Type PictureType
+
+	// MIMEType is the MIME type of the picture data, such as "image/jpeg".
+	// If it is "-->", then Data contains a URL of the picture instead.
+	MIMEType string
+
+	// Description is a UTF-8 description of the picture.
+	Description string
+
+	// Width and Height are the dimensions of the picture in pixels.
+	Width  uint32
+	Height uint32
+
+	// Depth is the color depth of the picture in bits-per-pixel.
+	Depth uint32
+
+	// Colors is the number of colors used for indexed-color pictures,
+	// or 0 for non-indexed pictures.
+	Colors uint32
+
+	// Data is the binary picture data.
+	Data []byte
+}
+
+// IsURL returns true if the picture data is a URL instead of the
+// picture itself.
+func (p *Picture) IsURL() bool { return p.MIMEType == "-->" }
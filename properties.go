@@ -0,0 +1,735 @@
+// Copyright (c) 2016, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/goulash/audio/vorbis"
+)
+
+// streamProperties are the properties of a stream that are read by the
+// functions in this file.
+type streamProperties struct {
+	length time.Duration
+	bytes  int64 // number of bytes of audio data
+
+	sampleRate int
+	channels   int
+	bits       int   // 0 for lossy codecs
+	samples    int64 // number of inter-channel samples
+	mode       BitrateMode
+}
+
+// totalSamples returns the number of samples, which is calculated from the
+// length if it is not known exactly.
+func (p streamProperties) totalSamples() int64 {
+	if p.samples > 0 || p.sampleRate <= 0 {
+		return p.samples
+	}
+	return int64(p.length.Seconds()*float64(p.sampleRate) + 0.5)
+}
+
+// bitrate returns the average bitrate in kbps, or -1 if it is unknown.
+func (p streamProperties) bitrate() int {
+	ms := int64(p.length / time.Millisecond)
+	if ms <= 0 || p.bytes <= 0 {
+		return -1
+	}
+	return int(p.bytes * 8 / ms)
+}
+
+// MP3 {{{
+
+var mp3Bitrates = [2][16]int{
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}, // MPEG-1
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},     // MPEG-2 and 2.5
+}
+
+var mp3SampleRates = [4][3]int{
+	{11025, 12000, 8000},  // MPEG-2.5
+	{0, 0, 0},             // reserved
+	{22050, 24000, 16000}, // MPEG-2
+	{44100, 48000, 32000}, // MPEG-1
+}
+
+// mp3Frame is a parsed MPEG Layer III frame header.
+type mp3Frame struct {
+	mpeg1      bool
+	mono       bool
+	bitrate    int // in kbps
+	sampleRate int
+	size       int // in bytes, including the header
+}
+
+func (f *mp3Frame) samples() int {
+	if f.mpeg1 {
+		return 1152
+	}
+	return 576
+}
+
+// parseMP3Frame parses the 4 byte frame header in b.
+func parseMP3Frame(b []byte) (mp3Frame, bool) {
+	if !isMPEGAudio(b) {
+		return mp3Frame{}, false
+	}
+	version := b[1] >> 3 & 0x03
+	f := mp3Frame{
+		mpeg1:      version == 3,
+		mono:       b[3]>>6 == 3,
+		sampleRate: mp3SampleRates[version][b[2]>>2&0x03],
+	}
+	if f.mpeg1 {
+		f.bitrate = mp3Bitrates[0][b[2]>>4]
+	} else {
+		f.bitrate = mp3Bitrates[1][b[2]>>4]
+	}
+	if f.bitrate == 0 {
+		// Free format streams are not supported.
+		return mp3Frame{}, false
+	}
+	f.size = f.samples() / 8 * f.bitrate * 1000 / f.sampleRate
+	if b[2]&0x02 != 0 {
+		f.size++
+	}
+	return f, true
+}
+
+// sideInfoSize returns the size of the side information that follows the
+// frame header, after which the Xing header is found.
+func (f *mp3Frame) sideInfoSize() int {
+	switch {
+	case f.mpeg1 && f.mono:
+		return 17
+	case f.mpeg1:
+		return 32
+	case f.mono:
+		return 9
+	default:
+		return 17
+	}
+}
+
+// readMP3Properties finds the first frame after the ID3v2 tag. The number
+// of frames is taken from a Xing, Info, or VBRI header in this frame if
+// there is one; otherwise, the stream is assumed to have a constant bitrate.
+func readMP3Properties(r io.ReadSeeker) (streamProperties, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return streamProperties{}, err
+	}
+	end := size
+	if size >= 128 {
+		if _, err := r.Seek(-128, io.SeekEnd); err != nil {
+			return streamProperties{}, err
+		}
+		b := make([]byte, 3)
+		if _, err := io.ReadFull(r, b); err == nil && string(b) == "TAG" {
+			end -= 128
+		}
+	}
+
+	var start int64
+	b := make([]byte, 10)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return streamProperties{}, err
+	}
+	if _, err := io.ReadFull(r, b); err == nil && string(b[:3]) == "ID3" {
+		start = id3v2Size(b)
+	}
+
+	// Search for the first frame, allowing for some junk before it.
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return streamProperties{}, err
+	}
+	buf := make([]byte, 64*1024)
+	n, _ := io.ReadFull(r, buf)
+	buf = buf[:n]
+	for i := 0; i+4 <= len(buf); i++ {
+		f, ok := parseMP3Frame(buf[i:])
+		if !ok {
+			continue
+		}
+		// Check the next frame, since the sync word is not unique.
+		if j := i + f.size; j+4 <= len(buf) {
+			if _, ok := parseMP3Frame(buf[j:]); !ok {
+				continue
+			}
+		}
+		p := streamProperties{
+			bytes:      end - start - int64(i),
+			sampleRate: f.sampleRate,
+			channels:   2,
+			mode:       CBR,
+		}
+		if f.mono {
+			p.channels = 1
+		}
+		frame := buf[i:]
+		if len(frame) > f.size {
+			frame = frame[:f.size]
+		}
+		if frames, n, vbr, ok := readXingHeader(frame, &f); ok {
+			p.samples = int64(frames) * int64(f.samples())
+			p.length = time.Duration(p.samples) * time.Second / time.Duration(f.sampleRate)
+			if n > 0 {
+				p.bytes = n
+			}
+			if vbr {
+				p.mode = VBR
+			}
+			return p, nil
+		}
+		p.length = time.Duration(p.bytes*8) * time.Millisecond / time.Duration(f.bitrate)
+		return p, nil
+	}
+	return streamProperties{}, ErrInvalidStream
+}
+
+/*
+readXingHeader returns the number of frames, and the number of bytes if known,
+from the Xing or Info header, or the VBRI header, in the first frame. The
+stream has a variable bitrate unless the header is an Info header.
+
+Encoding format (Xing and Info)
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    4 "Xing" for VBR streams, or "Info" for CBR streams
+    4 Flags, where 0x1 means the frames field is present, and 0x2 means
+      the bytes field is present
+    4 Number of frames (optional)
+    4 Number of bytes (optional)
+===== ===========================================================================
+
+Encoding format (VBRI, always 32 bytes after the frame header)
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    4 "VBRI"
+    2 Version
+    2 Delay
+    2 Quality
+    4 Number of bytes
+    4 Number of frames
+===== ===========================================================================
+
+All integers are big-endian.
+*/
+func readXingHeader(frame []byte, f *mp3Frame) (frames uint32, bytes int64, vbr, ok bool) {
+	if i := 4 + f.sideInfoSize(); len(frame) >= i+8 {
+		p := frame[i:]
+		if tag := string(p[:4]); tag == "Xing" || tag == "Info" {
+			flags := binary.BigEndian.Uint32(p[4:])
+			p = p[8:]
+			if flags&0x1 == 0 || len(p) < 4 {
+				return 0, 0, false, false
+			}
+			frames = binary.BigEndian.Uint32(p)
+			if flags&0x2 != 0 && len(p) >= 8 {
+				bytes = int64(binary.BigEndian.Uint32(p[4:]))
+			}
+			return frames, bytes, tag == "Xing", frames > 0
+		}
+	}
+	if len(frame) >= 36+18 && string(frame[36:40]) == "VBRI" {
+		p := frame[36:]
+		bytes = int64(binary.BigEndian.Uint32(p[10:]))
+		frames = binary.BigEndian.Uint32(p[14:])
+		return frames, bytes, true, frames > 0
+	}
+	return 0, 0, false, false
+}
+
+// }}}
+
+// MP4 {{{
+
+// readMP4Properties reads the duration from the movie header atom (mvhd) in
+// the movie atom (moov), and the number of bytes of audio data from the media
+// data atom (mdat). The format of the first audio track is read from its
+// sample description atom (stsd), and the number of samples from its media
+// header atom (mdhd).
+func readMP4Properties(r io.ReadSeeker) (streamProperties, error) {
+	var p streamProperties
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return p, err
+	}
+
+	var (
+		mdat            bool
+		scale, duration uint64 // of the last media header
+	)
+	for p.length == 0 || !mdat || p.sampleRate == 0 {
+		name, size, err := readMP4Atom(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return p, err
+		}
+		switch name {
+		case "moov", "trak", "mdia", "minf", "stbl":
+			// Descend into the container atom.
+			continue
+		case "mvhd":
+			s, d, err := readMP4Header(r, size)
+			if err != nil {
+				return p, err
+			}
+			p.length = time.Duration(d) * time.Second / time.Duration(s)
+			continue
+		case "mdhd":
+			scale, duration, err = readMP4Header(r, size)
+			if err != nil {
+				return p, err
+			}
+			continue
+		case "stsd":
+			if p.sampleRate != 0 {
+				break
+			}
+			if err := readSTSD(r, size, &p); err != nil {
+				return p, err
+			}
+			if p.sampleRate != 0 && scale != 0 {
+				p.samples = int64(duration * uint64(p.sampleRate) / scale)
+			}
+			continue
+		case "mdat":
+			mdat = true
+			p.bytes = size
+			if size < 0 {
+				cur, err := r.Seek(0, io.SeekCurrent)
+				if err != nil {
+					return p, err
+				}
+				end, err := r.Seek(0, io.SeekEnd)
+				if err != nil {
+					return p, err
+				}
+				p.bytes = end - cur
+			}
+		}
+		if size < 0 {
+			break
+		}
+		if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+			return p, err
+		}
+	}
+	if p.length == 0 {
+		return p, ErrInvalidStream
+	}
+	return p, nil
+}
+
+// readMP4Atom reads an atom header and returns its name and the size of its
+// content, which is -1 if the atom extends to the end of the stream.
+func readMP4Atom(r io.Reader) (string, int64, error) {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return "", 0, ErrInvalidStream
+		}
+		return "", 0, err
+	}
+	name := string(b[4:])
+	size := int64(binary.BigEndian.Uint32(b))
+	switch size {
+	case 0:
+		return name, -1, nil
+	case 1:
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", 0, ErrInvalidStream
+		}
+		size = int64(binary.BigEndian.Uint64(b)) - 16
+	default:
+		size -= 8
+	}
+	if size < 0 {
+		return "", 0, ErrInvalidStream
+	}
+	return name, size, nil
+}
+
+/*
+readMP4Header reads the time scale and the duration from the movie header
+atom (mvhd) or the media header atom (mdhd), which start in the same way.
+
+Encoding format
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    1 Version
+    3 Flags
+  4/8 Creation time (8 bytes if the version is 1)
+  4/8 Modification time (8 bytes if the version is 1)
+    4 Time scale, the number of time units per second
+  4/8 Duration in time units (8 bytes if the version is 1)
+    n Other fields
+===== ===========================================================================
+*/
+func readMP4Header(r io.Reader, size int64) (scale, d uint64, err error) {
+	if size < 20 || size > 1024 {
+		return 0, 0, ErrInvalidStream
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, 0, ErrInvalidStream
+	}
+	if b[0] == 1 {
+		if size < 32 {
+			return 0, 0, ErrInvalidStream
+		}
+		scale = uint64(binary.BigEndian.Uint32(b[20:]))
+		d = binary.BigEndian.Uint64(b[24:])
+	} else {
+		scale = uint64(binary.BigEndian.Uint32(b[12:]))
+		d = uint64(binary.BigEndian.Uint32(b[16:]))
+	}
+	if scale == 0 {
+		return 0, 0, ErrInvalidStream
+	}
+	return scale, d, nil
+}
+
+/*
+readSTSD reads the format of the first entry in the sample description atom
+if it is an audio sample entry of AAC (mp4a) or ALAC (alac). Other entries
+are ignored.
+
+Encoding format
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    1 Version
+    3 Flags
+    4 Number of entries
+    4 Size of the first entry
+    4 Format of the first entry, such as "mp4a" or "alac"
+    6 Reserved
+    2 Data reference index
+    2 Version
+    2 Revision level
+    4 Vendor
+    2 Number of channels
+    2 Sample size in bits
+    2 Compression ID
+    2 Packet size
+    4 Sample rate as a 16.16 fixed-point number
+    n Extensions, which are atoms
+===== ===========================================================================
+
+The sample rate only has 16 bits for the integer part, so the ALAC
+extension atom (alac) contains the actual values: after the version and
+flags (4 bytes), the frame length (4 bytes), and the compatible version (1
+byte) follow the bit depth (1 byte), three tuning parameters (3 bytes), the
+number of channels (1 byte), the maximum run (2 bytes), the maximum frame
+size (4 bytes), the average bitrate (4 bytes), and the sample rate (4 bytes).
+*/
+func readSTSD(r io.Reader, size int64, p *streamProperties) error {
+	if size < 8 || size > 64*1024 {
+		return ErrInvalidStream
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return ErrInvalidStream
+	}
+	if binary.BigEndian.Uint32(b[4:]) == 0 {
+		return nil
+	}
+	e := b[8:]
+	if n := binary.BigEndian.Uint32(e); uint64(n) <= uint64(len(e)) {
+		e = e[:n]
+	}
+	if len(e) < 36 {
+		return nil
+	}
+	switch string(e[4:8]) {
+	case "mp4a":
+		// The sample size of lossy codecs is meaningless.
+	case "alac":
+		p.mode = Lossless
+	default:
+		return nil
+	}
+	if p.mode == Lossless {
+		p.bits = int(binary.BigEndian.Uint16(e[26:]))
+	}
+	p.channels = int(binary.BigEndian.Uint16(e[24:]))
+	p.sampleRate = int(binary.BigEndian.Uint16(e[32:]))
+
+	if a := e[36:]; p.mode == Lossless && len(a) >= 12+24 && string(a[4:8]) == "alac" {
+		c := a[12:]
+		p.bits = int(c[5])
+		p.channels = int(c[9])
+		p.sampleRate = int(binary.BigEndian.Uint32(c[20:]))
+	}
+	return nil
+}
+
+// }}}
+
+// OGG {{{
+
+/*
+readOGGProperties reads the sample rate from the identification header in
+the first page, and the granule position from the last page of the stream,
+which is the number of samples for Vorbis and Opus.
+
+Encoding format (page header)
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    4 "OggS"
+    1 Version
+    1 Header type
+    8 Granule position
+    4 Serial number
+    4 Page sequence number
+    4 CRC checksum
+    1 Number of segments
+    n Segment table
+===== ===========================================================================
+
+The Vorbis identification header starts with "\x01vorbis", followed by the
+version (4 bytes), the number of channels (1 byte), the sample rate (4
+bytes), and the maximum, nominal, and minimum bitrates (4 bytes each); the
+bitrate is constant if they are all the same. The Opus identification header
+starts with "OpusHead", followed by the version (1 byte), the number of
+channels (1 byte), and the pre-skip (2 bytes); the granule position of Opus is
+always at 48 kHz.
+
+If the stream does not start at granule position 0, the granule position at
+which it starts is subtracted; see oggStartGranule.
+
+All integers are little-endian.
+*/
+func readOGGProperties(r io.ReadSeeker) (streamProperties, error) {
+	var p streamProperties
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return p, err
+	}
+	b := make([]byte, 27+255+20)
+	n, err := io.ReadFull(r, b)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return p, ErrInvalidStream
+	}
+	b = b[:n]
+	if n < 27 || string(b[:4]) != "OggS" || n < 27+int(b[26]) {
+		return p, ErrInvalidStream
+	}
+	serial := binary.LittleEndian.Uint32(b[14:])
+	packet := b[27+int(b[26]):]
+
+	var rate, skip uint64
+	var samples func(b []byte) (n int, audio bool)
+	p.mode = VBR
+	switch {
+	case len(packet) >= 16 && string(packet[:7]) == "\x01vorbis":
+		p.channels = int(packet[11])
+		rate = uint64(binary.LittleEndian.Uint32(packet[12:]))
+		if len(packet) >= 28 {
+			max := binary.LittleEndian.Uint32(packet[16:])
+			if max != 0 && max == binary.LittleEndian.Uint32(packet[20:]) && max == binary.LittleEndian.Uint32(packet[24:]) {
+				p.mode = CBR
+			}
+		}
+		if len(packet) >= 29 {
+			samples = vorbisSamples(1<<(packet[28]&0x0F), 1<<(packet[28]>>4))
+		}
+	case len(packet) >= 12 && string(packet[:8]) == "OpusHead":
+		p.channels = int(packet[9])
+		rate = 48000
+		skip = uint64(binary.LittleEndian.Uint16(packet[10:]))
+		headers := 1
+		samples = func(b []byte) (int, bool) {
+			if headers > 0 {
+				headers--
+				return 0, false
+			}
+			return opusSamples(b), true
+		}
+	default:
+		return p, ErrInvalidStream
+	}
+	if rate == 0 {
+		return p, ErrInvalidStream
+	}
+
+	var start uint64
+	if samples != nil {
+		end := int64(27 + int(b[26]))
+		for _, n := range b[27:end] {
+			end += int64(n)
+		}
+		if _, err := r.Seek(end, io.SeekStart); err != nil {
+			return p, err
+		}
+		start = oggStartGranule(r, serial, samples)
+	}
+
+	// The last page is at most 65307 bytes long.
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return p, err
+	}
+	off := size - 65307
+	if off < 0 {
+		off = 0
+	}
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return p, err
+	}
+	tail := make([]byte, size-off)
+	if _, err := io.ReadFull(r, tail); err != nil {
+		return p, ErrInvalidStream
+	}
+	for i := len(tail) - 27; i >= 0; i-- {
+		if tail[i] != 'O' || !bytes.HasPrefix(tail[i:], []byte("OggS")) {
+			continue
+		}
+		if binary.LittleEndian.Uint32(tail[i+14:]) != serial {
+			continue
+		}
+		granule := binary.LittleEndian.Uint64(tail[i+6:])
+		if granule == ^uint64(0) || granule < skip+start {
+			continue
+		}
+		p.sampleRate = int(rate)
+		p.samples = int64(granule - skip - start)
+		p.length = time.Duration(p.samples) * time.Second / time.Duration(rate)
+		p.bytes = size
+		return p, nil
+	}
+	return p, ErrInvalidStream
+}
+
+// oggStartGranule returns the granule position at which the stream with the
+// serial number starts, reading the pages that follow the first page from r.
+// This is the granule position of the first page that ends an audio packet,
+// less the samples of the audio packets up to and including it. The samples
+// function is called with every packet after the identification header, and
+// returns the number of samples in it, or -1 if they are unknown, and whether
+// it is an audio packet. If the start cannot be determined, 0 is returned.
+func oggStartGranule(r io.Reader, serial uint32, samples func([]byte) (int, bool)) uint64 {
+	br := bufio.NewReader(r)
+	var h [27 + 255]byte
+	var packet []byte
+	var total uint64
+	for {
+		if _, err := io.ReadFull(br, h[:27]); err != nil || string(h[:4]) != "OggS" {
+			return 0
+		}
+		segments := h[27 : 27+int(h[26])]
+		if _, err := io.ReadFull(br, segments); err != nil {
+			return 0
+		}
+		size := 0
+		for _, n := range segments {
+			size += int(n)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return 0
+		}
+		if binary.LittleEndian.Uint32(h[14:]) != serial {
+			continue
+		}
+		if h[5]&0x01 == 0 {
+			packet = packet[:0]
+		}
+		audio := false
+		for _, n := range segments {
+			packet, data = append(packet, data[:n]...), data[n:]
+			if n == 255 {
+				continue
+			}
+			k, ok := samples(packet)
+			packet = packet[:0]
+			if !ok {
+				continue
+			} else if k < 0 {
+				return 0
+			}
+			total += uint64(k)
+			audio = true
+		}
+		granule := binary.LittleEndian.Uint64(h[6:])
+		if audio && granule != ^uint64(0) {
+			if granule < total {
+				return 0
+			}
+			return granule - total
+		}
+	}
+}
+
+// vorbisSamples returns a function for oggStartGranule that returns the
+// number of samples in the packets of a Vorbis stream with the given block
+// sizes. The first audio packet has no samples, and every other has a quarter
+// of the block size of itself and of the packet before it.
+func vorbisSamples(size0, size1 int) func([]byte) (int, bool) {
+	var modes []bool
+	headers, prev := 2, 0
+	return func(b []byte) (int, bool) {
+		if headers > 0 {
+			if headers--; headers == 0 {
+				modes = vorbis.ParseModes(b)
+			}
+			return 0, false
+		}
+		flag, ok := vorbis.BlockFlag(modes, b)
+		if !ok {
+			return -1, true
+		}
+		size := size0
+		if flag {
+			size = size1
+		}
+		n := 0
+		if prev > 0 {
+			n = prev/4 + size/4
+		}
+		prev = size
+		return n, true
+	}
+}
+
+// opusSamples returns the number of samples at 48 kHz in the Opus packet b,
+// which is given by the configuration and the frame count in its first byte.
+func opusSamples(b []byte) int {
+	if len(b) == 0 {
+		return 0
+	}
+	config := b[0] >> 3
+	var size int
+	switch {
+	case config < 12: // SILK: 10, 20, 40 or 60 ms
+		size = []int{480, 960, 1920, 2880}[config%4]
+	case config < 16: // Hybrid: 10 or 20 ms
+		size = []int{480, 960}[config%2]
+	default: // CELT: 2.5, 5, 10 or 20 ms
+		size = []int{120, 240, 480, 960}[config%4]
+	}
+	frames := 1
+	switch b[0] & 3 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(b) < 2 {
+			return 0
+		}
+		frames = int(b[1] & 0x3F)
+	}
+	return size * frames
+}
+
+// }}}
@@ -0,0 +1,189 @@
+// Copyright (c) 2016, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mp3Frames returns n frames of MPEG-1 Layer III at 128 kbps and 44.1 kHz,
+// each 417 bytes long. If xing is not nil, it is put in the first frame.
+func mp3Frames(n int, xing []byte) []byte {
+	var buf []byte
+	for i := 0; i < n; i++ {
+		f := make([]byte, 417)
+		copy(f, []byte{0xFF, 0xFB, 0x90, 0x00})
+		if i == 0 {
+			copy(f[36:], xing)
+		}
+		buf = append(buf, f...)
+	}
+	return buf
+}
+
+func TestReadMP3Properties(z *testing.T) {
+	assert := assert.New(z)
+
+	// Constant bitrate, with an ID3v2 tag and an ID3v1 tag.
+	data := append([]byte("ID3\x03\x00\x00\x00\x00\x00\x05hello"), mp3Frames(10, nil)...)
+	data = append(data, append([]byte("TAG"), make([]byte, 125)...)...)
+	p, err := readMP3Properties(bytes.NewReader(data))
+	if assert.Nil(err) {
+		assert.Equal(int64(4170), p.bytes)
+		assert.Equal(260625*time.Microsecond, p.length)
+		assert.Equal(128, p.bitrate())
+		assert.Equal(44100, p.sampleRate)
+		assert.Equal(2, p.channels)
+		assert.Equal(CBR, p.mode)
+		assert.Equal(int64(11494), p.totalSamples())
+	}
+
+	// Variable bitrate with a Xing header.
+	xing := []byte("Xing\x00\x00\x00\x03\x00\x00\x00\x64\x00\x00\xA2\xE4")
+	p, err = readMP3Properties(bytes.NewReader(mp3Frames(2, xing)))
+	if assert.Nil(err) {
+		assert.Equal(int64(41700), p.bytes)
+		assert.Equal(100*1152*time.Second/44100, p.length)
+		assert.Equal(127, p.bitrate())
+		assert.Equal(int64(100*1152), p.totalSamples())
+		assert.Equal(VBR, p.mode)
+	}
+
+	// Constant bitrate with an Info header.
+	info := append([]byte("Info"), xing[4:]...)
+	p, err = readMP3Properties(bytes.NewReader(mp3Frames(2, info)))
+	if assert.Nil(err) {
+		assert.Equal(CBR, p.mode)
+	}
+
+	_, err = readMP3Properties(bytes.NewReader([]byte("not an mp3 file")))
+	assert.Equal(ErrInvalidStream, err)
+}
+
+func mp4Atom(name string, content []byte) []byte {
+	b := make([]byte, 8, 8+len(content))
+	binary.BigEndian.PutUint32(b, uint32(8+len(content)))
+	copy(b[4:], name)
+	return append(b, content...)
+}
+
+func TestReadMP4Properties(z *testing.T) {
+	assert := assert.New(z)
+
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 44100)
+	binary.BigEndian.PutUint32(mvhd[16:], 441000)
+	head := append(mp4Atom("ftyp", []byte("M4A \x00\x00\x00\x00")), mp4Atom("mdat", make([]byte, 160000))...)
+	data := append(head, mp4Atom("moov", append(mp4Atom("mvhd", mvhd), mp4Atom("trak", nil)...))...)
+
+	p, err := readMP4Properties(bytes.NewReader(data))
+	if assert.Nil(err) {
+		assert.Equal(10*time.Second, p.length)
+		assert.Equal(int64(160000), p.bytes)
+		assert.Equal(128, p.bitrate())
+		assert.Equal(0, p.sampleRate)
+	}
+
+	// An ALAC track, whose sample rate is too high for the sample entry.
+	entry := make([]byte, 28)
+	binary.BigEndian.PutUint16(entry[16:], 2)
+	binary.BigEndian.PutUint16(entry[18:], 16)
+	config := make([]byte, 28)
+	config[9] = 24
+	config[13] = 2
+	binary.BigEndian.PutUint32(config[24:], 96000)
+	stsd := append([]byte{0, 0, 0, 0, 0, 0, 0, 1}, mp4Atom("alac", append(entry, mp4Atom("alac", config)...))...)
+	trak := mp4Atom("trak", mp4Atom("mdia", append(mp4Atom("mdhd", mvhd), mp4Atom("minf", mp4Atom("stbl", mp4Atom("stsd", stsd)))...)))
+	data = append(head[:len(head):len(head)], mp4Atom("moov", append(mp4Atom("mvhd", mvhd), trak...))...)
+	p, err = readMP4Properties(bytes.NewReader(data))
+	if assert.Nil(err) {
+		assert.Equal(10*time.Second, p.length)
+		assert.Equal(96000, p.sampleRate)
+		assert.Equal(2, p.channels)
+		assert.Equal(24, p.bits)
+		assert.Equal(int64(960000), p.samples)
+		assert.Equal(Lossless, p.mode)
+	}
+
+	_, err = readMP4Properties(bytes.NewReader(mp4Atom("ftyp", nil)))
+	assert.Equal(ErrInvalidStream, err)
+}
+
+func oggPage(typ byte, granule uint64, packet []byte) []byte {
+	b := append([]byte("OggS"), 0, typ)
+	b = append(b, make([]byte, 8+4+4+4)...)
+	binary.LittleEndian.PutUint64(b[6:], granule)
+	binary.LittleEndian.PutUint32(b[14:], 1234)
+	b = append(b, 1, byte(len(packet)))
+	return append(b, packet...)
+}
+
+func TestReadOGGProperties(z *testing.T) {
+	assert := assert.New(z)
+
+	vorbis := []byte("\x01vorbis\x00\x00\x00\x00\x02\x44\xAC\x00\x00\x00\x00\x00\x00\x00\xF4\x01\x00\x00\x00\x00\x00\xB8\x01")
+	data := oggPage(2, 0, vorbis)
+	data = append(data, make([]byte, 1000)...)
+	data = append(data, oggPage(4, 441000, []byte("last"))...)
+	p, err := readOGGProperties(bytes.NewReader(data))
+	if assert.Nil(err) {
+		assert.Equal(10*time.Second, p.length)
+		assert.Equal(44100, p.sampleRate)
+		assert.Equal(2, p.channels)
+		assert.Equal(int64(441000), p.samples)
+		assert.Equal(VBR, p.mode)
+	}
+
+	opus := []byte("OpusHead\x01\x02\x38\x01\x80\xBB\x00\x00\x00\x00\x00")
+	data = oggPage(2, 0, opus)
+	data = append(data, oggPage(4, 480312, []byte("last"))...)
+	p, err = readOGGProperties(bytes.NewReader(data))
+	if assert.Nil(err) {
+		assert.Equal(10*time.Second, p.length)
+		assert.Equal(48000, p.sampleRate)
+		assert.Equal(2, p.channels)
+		assert.Equal(int64(480000), p.samples)
+	}
+
+	// A stream that starts at granule position 96000, with a CELT packet of
+	// 960 samples on the first audio page.
+	data = oggPage(2, 0, opus)
+	data = append(data, oggPage(0, 0, []byte("OpusTags"))...)
+	data = append(data, oggPage(0, 96960, []byte{0xF8})...)
+	data = append(data, oggPage(4, 576312, []byte("last"))...)
+	p, err = readOGGProperties(bytes.NewReader(data))
+	if assert.Nil(err) {
+		assert.Equal(10*time.Second, p.length)
+		assert.Equal(int64(480000), p.samples)
+	}
+
+	_, err = readOGGProperties(bytes.NewReader(oggPage(2, 0, []byte("\x80theora"))))
+	assert.Equal(ErrInvalidStream, err)
+}
+
+func TestReadTagMetadata(z *testing.T) {
+	assert := assert.New(z)
+
+	m, err := ReadMetadataFrom(bytes.NewReader(mp3Frames(10, nil)))
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(MP3, m.Encoding())
+	assert.Equal(128, m.EncodingBitrate())
+	assert.Equal(260625*time.Microsecond, m.Length())
+	assert.Equal("", m.Title())
+	assert.Equal("", m.Copyright())
+	assert.Equal(44100, m.SampleRate())
+	assert.Equal(2, m.NumChannels())
+	assert.Equal(0, m.BitsPerSample())
+	assert.Equal(m.Length(), m.Duration())
+	assert.Equal(CBR, m.BitrateMode())
+	assert.Equal(Stereo, m.ChannelLayout())
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2016, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package audio
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// SymlinkPolicy determines how Scan treats symbolic links.
+type SymlinkPolicy int
+
+const (
+	SkipSymlinks   SymlinkPolicy = iota // ignore all symbolic links
+	FollowFiles                         // follow links to files, but not to directories
+	FollowSymlinks                      // follow all links, visiting each directory once
+)
+
+// ScanOptions configures Scan. The zero value scans all files with
+// runtime.NumCPU() workers and skips symbolic links.
+type ScanOptions struct {
+	// Workers is the number of files that are read concurrently.
+	Workers int
+
+	// Include contains glob patterns (see filepath.Match) of the files to
+	// scan; if it is empty, all files are scanned. Exclude contains glob
+	// patterns of files and directories to skip. Patterns are matched
+	// against the base name and against the slash-separated path relative
+	// to the root.
+	Include []string
+	Exclude []string
+
+	// Symlinks determines how symbolic links are treated.
+	Symlinks SymlinkPolicy
+
+	// IdentifyOnly skips reading the metadata.
+	IdentifyOnly bool
+}
+
+// ScanResult is the result of scanning a single file.
+type ScanResult struct {
+	Path     string
+	Codec    Codec
+	Metadata Metadata // nil if there is an error or IdentifyOnly is set
+
+	// Err is the error that occurred when reading the file or directory at
+	// Path. If the metadata of the codec cannot be read, it is ErrUnsupported.
+	Err error
+}
+
+// Scan walks the directory tree at root and identifies and reads the metadata
+// of every file, using a pool of workers. The results are sent on the returned
+// channel in no particular order, which is closed when the scan is complete or
+// ctx is cancelled. Files whose codec cannot be identified are left out;
+// directories that cannot be read are sent with an error.
+func Scan(ctx context.Context, root string, opts *ScanOptions) <-chan ScanResult {
+	if opts == nil {
+		opts = &ScanOptions{}
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	out := make(chan ScanResult, workers)
+	paths := make(chan string, workers)
+	s := &scanner{
+		ctx:     ctx,
+		opts:    opts,
+		root:    root,
+		out:     out,
+		visited: make(map[string]bool),
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for p := range paths {
+				if ctx.Err() != nil {
+					continue
+				}
+				if r, ok := s.scanFile(p); ok {
+					s.send(r)
+				}
+			}
+		}()
+	}
+	go func() {
+		s.walk(root, paths)
+		close(paths)
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+type scanner struct {
+	ctx     context.Context
+	opts    *ScanOptions
+	root    string
+	out     chan<- ScanResult
+	visited map[string]bool // directories that have been walked, by real path
+}
+
+// send sends the result unless the scan has been cancelled, and returns
+// false if it has.
+func (s *scanner) send(r ScanResult) bool {
+	select {
+	case s.out <- r:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// walk sends the paths of the files to scan in the directory dir and its
+// subdirectories, and returns false if the scan has been cancelled.
+func (s *scanner) walk(dir string, paths chan<- string) bool {
+	if s.opts.Symlinks == FollowSymlinks {
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return s.send(ScanResult{Path: dir, Err: err})
+		}
+		if s.visited[real] {
+			return true
+		}
+		s.visited[real] = true
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return s.send(ScanResult{Path: dir, Err: err})
+	}
+	for _, fi := range fis {
+		path := filepath.Join(dir, fi.Name())
+		if s.matchAny(s.opts.Exclude, path) {
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if s.opts.Symlinks == SkipSymlinks {
+				continue
+			}
+			fi, err = os.Stat(path)
+			if err != nil {
+				if !s.send(ScanResult{Path: path, Err: err}) {
+					return false
+				}
+				continue
+			}
+			if fi.IsDir() && s.opts.Symlinks != FollowSymlinks {
+				continue
+			}
+		}
+
+		if fi.IsDir() {
+			if !s.walk(path, paths) {
+				return false
+			}
+			continue
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		if len(s.opts.Include) > 0 && !s.matchAny(s.opts.Include, path) {
+			continue
+		}
+		select {
+		case paths <- path:
+		case <-s.ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny returns true if any of the patterns matches the base name of
+// path or the path relative to the root.
+func (s *scanner) matchAny(patterns []string, path string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	base := filepath.Base(path)
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil {
+		rel = path
+	}
+	rel = filepath.ToSlash(rel)
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// scanFile identifies and reads the file, and returns false if the file
+// should be left out of the results.
+func (s *scanner) scanFile(path string) (ScanResult, bool) {
+	r := ScanResult{Path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		r.Err = err
+		return r, true
+	}
+	defer f.Close()
+
+	format, offset, err := identifyFormat(f)
+	if err != nil {
+		r.Err = err
+		return r, true
+	}
+	r.Codec = format.Codec
+	if r.Codec == Unknown {
+		return r, false
+	}
+	if s.opts.IdentifyOnly {
+		return r, true
+	}
+	r.Metadata, r.Err = readFormatMetadata(f, format, offset)
+	return r, true
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2016, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package audio
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// makeLibrary creates a directory tree for scanning and returns its path.
+func makeLibrary(z *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "audio")
+	if err != nil {
+		z.Fatal(err)
+	}
+	files := map[string][]byte{
+		"lib/a/one.mp3":       mp3Frames(10, nil),
+		"lib/a/cover.jpg":     []byte("\xFF\xD8\xFF\xE0\x00\x10JFIF"),
+		"lib/a/notes.txt":     []byte("some notes"),
+		"lib/b/two.tta":       []byte("TTA1\x01\x00\x02\x00"),
+		"lib/b/three.mp3":     mp3Frames(5, nil),
+		"lib/b/.hidden/x.mp3": mp3Frames(1, nil),
+		"outside/five.tta":    []byte("TTA1\x01\x00\x02\x00"),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			z.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			z.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"lib/a/linked.mp3": "../b/three.mp3",
+		"lib/c":            "../outside",
+		"outside/up":       "../lib", // a cycle when following lib/c
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			z.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "lib"), func() { os.RemoveAll(dir) }
+}
+
+func scanPaths(root string, opts *ScanOptions) ([]string, []ScanResult) {
+	var paths []string
+	var results []ScanResult
+	for r := range Scan(context.Background(), root, opts) {
+		rel, _ := filepath.Rel(root, r.Path)
+		paths = append(paths, filepath.ToSlash(rel))
+		results = append(results, r)
+	}
+	sort.Strings(paths)
+	return paths, results
+}
+
+func TestScan(z *testing.T) {
+	assert := assert.New(z)
+	root, cleanup := makeLibrary(z)
+	defer cleanup()
+
+	paths, results := scanPaths(root, &ScanOptions{Workers: 3, Exclude: []string{".*"}})
+	assert.Equal([]string{"a/one.mp3", "b/three.mp3", "b/two.tta"}, paths)
+	for _, r := range results {
+		switch r.Codec {
+		case MP3:
+			assert.Nil(r.Err)
+			if assert.NotNil(r.Metadata) {
+				assert.Equal(128, r.Metadata.EncodingBitrate())
+			}
+		case TTA:
+			assert.Equal(ErrUnsupported, r.Err)
+		default:
+			z.Errorf("unexpected codec %v for %s", r.Codec, r.Path)
+		}
+	}
+
+	paths, _ = scanPaths(root, &ScanOptions{
+		Include:      []string{"*.mp3"},
+		Exclude:      []string{"b/.hidden"},
+		Symlinks:     FollowFiles,
+		IdentifyOnly: true,
+	})
+	assert.Equal([]string{"a/linked.mp3", "a/one.mp3", "b/three.mp3"}, paths)
+
+	// The link to the directory outside is followed, but the link back
+	// into the library is not, since it has already been visited.
+	paths, _ = scanPaths(root, &ScanOptions{
+		Include:      []string{"*.tta"},
+		Symlinks:     FollowSymlinks,
+		IdentifyOnly: true,
+	})
+	assert.Equal([]string{"b/two.tta", "c/five.tta"}, paths)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for r := range Scan(ctx, root, nil) {
+		_ = r
+	}
+
+	_, results = scanPaths(filepath.Join(root, "missing"), nil)
+	if assert.Len(results, 1) {
+		assert.True(os.IsNotExist(results[0].Err))
+	}
+}
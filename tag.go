@@ -0,0 +1,128 @@
+// Copyright (c) 2016, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package audio
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dhowden/tag"
+)
+
+// tagReader returns a metadata reader that uses github.com/dhowden/tag
+// for the codec c.
+func tagReader(c Codec) func(io.ReadSeeker) (Metadata, error) {
+	return func(r io.ReadSeeker) (Metadata, error) {
+		m, err := readTagMetadata(r, c)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+}
+
+// tagMetadata adapts the metadata read by github.com/dhowden/tag to
+// Metadata. The properties are determined from the stream, since they are
+// not part of the tags.
+type tagMetadata struct {
+	tag.Metadata
+
+	codec Codec
+	props streamProperties
+}
+
+// readTagMetadata reads the tags and the stream properties of r, which is
+// a stream of the given codec. A stream without tags is not an error.
+func readTagMetadata(r io.ReadSeeker, c Codec) (*tagMetadata, error) {
+	m, err := tag.ReadFrom(r)
+	if err == tag.ErrNoTagsFound {
+		m = noTags{}
+	} else if err != nil {
+		return nil, err
+	}
+	if m.FileType() == tag.ALAC {
+		c = ALAC
+	}
+
+	var p streamProperties
+	switch c {
+	case MP3:
+		p, err = readMP3Properties(r)
+	case M4A, M4B, M4P, ALAC:
+		p, err = readMP4Properties(r)
+	case OGG:
+		p, err = readOGGProperties(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c == M4A && p.mode == Lossless {
+		c = ALAC
+	}
+	return &tagMetadata{
+		Metadata: m,
+		codec:    c,
+		props:    p,
+	}, nil
+}
+
+func (m *tagMetadata) Copyright() string        { return m.raw("TCOP", "TCR", "copyright", "cprt") }
+func (m *tagMetadata) Website() string          { return m.raw("WOAR", "WAR", "contact", "website") }
+func (m *tagMetadata) EncodedBy() string        { return m.raw("TENC", "TEN", "encoded-by", "encodedby") }
+func (m *tagMetadata) EncoderSettings() string  { return m.raw("TSSE", "TSS", "encoder", "\xa9too") }
+func (m *tagMetadata) OriginalFilename() string { return m.raw("TOFN", "TOF") }
+func (m *tagMetadata) Length() time.Duration    { return m.props.length }
+func (m *tagMetadata) Encoding() Codec          { return m.codec }
+func (m *tagMetadata) EncodingBitrate() int     { return m.props.bitrate() }
+
+func (m *tagMetadata) SampleRate() int              { return m.props.sampleRate }
+func (m *tagMetadata) NumChannels() int             { return m.props.channels }
+func (m *tagMetadata) BitsPerSample() int           { return m.props.bits }
+func (m *tagMetadata) TotalSamples() int64          { return m.props.totalSamples() }
+func (m *tagMetadata) Duration() time.Duration      { return m.props.length }
+func (m *tagMetadata) BitrateMode() BitrateMode     { return m.props.mode }
+func (m *tagMetadata) ChannelLayout() ChannelLayout { return DefaultChannelLayout(m.props.channels) }
+
+// raw returns the first of the raw tags that is set. The keys differ between
+// ID3v2.3/4, ID3v2.2, Vorbis comments, and MP4 atoms.
+func (m *tagMetadata) raw(keys ...string) string {
+	raw := m.Raw()
+	for _, k := range keys {
+		var s string
+		switch v := raw[k].(type) {
+		case string:
+			s = v
+		case []string:
+			s = strings.Join(v, "\n")
+		case fmt.Stringer:
+			s = v.String()
+		}
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// noTags is the tag.Metadata of a stream without tags.
+type noTags struct{}
+
+func (noTags) Format() tag.Format          { return tag.UnknownFormat }
+func (noTags) FileType() tag.FileType      { return tag.UnknownFileType }
+func (noTags) Title() string               { return "" }
+func (noTags) Album() string               { return "" }
+func (noTags) Artist() string              { return "" }
+func (noTags) AlbumArtist() string         { return "" }
+func (noTags) Composer() string            { return "" }
+func (noTags) Year() int                   { return 0 }
+func (noTags) Genre() string               { return "" }
+func (noTags) Track() (int, int)           { return 0, 0 }
+func (noTags) Disc() (int, int)            { return 0, 0 }
+func (noTags) Picture() *tag.Picture       { return nil }
+func (noTags) Lyrics() string              { return "" }
+func (noTags) Comment() string             { return "" }
+func (noTags) Raw() map[string]interface{} { return nil }
@@ -0,0 +1,204 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+// Package vorbis implements the Vorbis comment format, which is used for
+// the tags of FLAC, Ogg Vorbis and Opus streams, and reads the modes of the
+// setup header of Vorbis streams, which determine the number of samples in
+// their packets.
+//
+// Reference
+//
+//  https://www.xiph.org/vorbis/doc/v-comment.html
+package vorbis
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidComment = errors.New("comment is invalid")
+
+// VendorKey is the key of the vendor string in Comments. Since ~ is not
+// allowed in field names, it does not conflict with any field.
+const VendorKey = "~vendor"
+
+// Comments are the values of the fields of a Vorbis comment, by their
+// field names in lower case, and the vendor string under VendorKey.
+type Comments map[string][]string
+
+/*
+ParseComments parses the comments at the beginning of b and returns them with
+the number of bytes that they take up. Codecs that add a framing bit or other
+data after the comments have to check it themselves.
+
+Comment encoding
+
+The comment header logically is a list of eight-bit-clean vectors; the number
+of vectors is bounded to 2^32-1 and the length of each vector is limited to
+2^32-1 bytes. The vector length is encoded; the vector contents themselves are
+not null terminated. In addition to the vector list, there is a single vector
+for vendor name (also 8 bit clean, length encoded in 32 bits). For example, the
+1.0 release of libvorbis set the vendor string to "Xiph.Org libVorbis
+I 20020717".
+
+The comment header is decoded as follows:
+
+    1) [vendor_length] = read an unsigned integer of 32 bits
+    2) [vendor_string] = read a UTF-8 vector as [vendor_length] octets
+    3) [user_comment_list_length] = read an unsigned integer of 32 bits
+    4) iterate [user_comment_list_length] times {
+
+         5) [length] = read an unsigned integer of 32 bits
+         6) this iteration's user comment = read a UTF-8 vector as [length] octets
+
+       }
+
+    7) [framing_bit] = read a single bit as boolean
+    8) if ( [framing_bit] unset or end of packet ) then ERROR
+    9) done.
+
+Content vector format
+
+The comment vectors are structured similarly to a UNIX environment variable.
+That is, comment fields consist of a field name and a corresponding value and
+look like:
+
+    comment[0]="ARTIST=me";
+    comment[1]="TITLE=the sound of Vorbis";
+
+- A case-insensitive field name that may consist of ASCII 0x20 through 0x7D,
+  0x3D ('=') excluded. ASCII 0x41 through 0x5A inclusive (A-Z) is to be
+  considered equivalent to ASCII 0x61 through 0x7A inclusive (a-z).
+- The field name is immediately followed by ASCII 0x3D ('='); this equals
+  sign is used to terminate the field name.
+- 0x3D is followed by the 8 bit clean UTF-8 encoded value of the field
+  contents to the end of the field.
+
+All integers are little-endian.
+*/
+func ParseComments(b []byte) (Comments, int, error) {
+	var n int
+	vector := func() (string, bool) {
+		if len(b)-n < 4 {
+			return "", false
+		}
+		z := uint64(binary.LittleEndian.Uint32(b[n:]))
+		n += 4
+		if uint64(len(b)-n) < z {
+			return "", false
+		}
+		s := string(b[n : n+int(z)])
+		n += int(z)
+		return s, true
+	}
+
+	c := make(Comments)
+	vs, ok := vector()
+	if !ok || len(b)-n < 4 {
+		return nil, 0, ErrInvalidComment
+	}
+	c[VendorKey] = []string{vs}
+	count := binary.LittleEndian.Uint32(b[n:])
+	n += 4
+	for i := uint32(0); i < count; i++ {
+		s, ok := vector()
+		if !ok {
+			return nil, 0, ErrInvalidComment
+		}
+		// Split the comment on the first = sign. The characters of
+		// the field name are not checked.
+		j := strings.IndexByte(s, '=')
+		if j < 0 {
+			return nil, 0, ErrInvalidComment
+		}
+		k := strings.ToLower(s[:j])
+		c[k] = append(c[k], s[j+1:])
+	}
+	return c, n, nil
+}
+
+// Bytes returns the encoded comments, without a framing bit. The fields
+// are written with upper-case names in sorted order, so that the output
+// is deterministic.
+func (c Comments) Bytes() []byte {
+	keys := make([]string, 0, len(c))
+	n := 0
+	for k, vs := range c {
+		if k == VendorKey {
+			continue
+		}
+		keys = append(keys, k)
+		n += len(vs)
+	}
+	sort.Strings(keys)
+
+	vendor := c.Vendor()
+	p := appendUint32(nil, uint32(len(vendor)))
+	p = append(p, vendor...)
+	p = appendUint32(p, uint32(n))
+	for _, k := range keys {
+		uk := strings.ToUpper(k)
+		for _, v := range c[k] {
+			p = appendUint32(p, uint32(len(uk)+1+len(v)))
+			p = append(p, uk...)
+			p = append(p, '=')
+			p = append(p, v...)
+		}
+	}
+	return p
+}
+
+// Vendor returns the vendor string, which usually names the encoder.
+func (c Comments) Vendor() string {
+	if vs := c[VendorKey]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// Join returns the values of the field joined by sep.
+func (c Comments) Join(key, sep string) string {
+	return strings.Join(c[key], sep)
+}
+
+// Int returns the first value of the field as an integer, or 0 if it is
+// not one.
+func (c Comments) Int(key string) int {
+	v := c[key]
+	if len(v) == 0 {
+		return 0
+	}
+	i, err := strconv.Atoi(v[0])
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+// Float returns the number in the first value of the field, ignoring a
+// unit such as " dB".
+func (c Comments) Float(key string) (float64, bool) {
+	v := c[key]
+	if len(v) == 0 {
+		return 0, false
+	}
+	fs := strings.Fields(v[0])
+	if len(fs) == 0 {
+		return 0, false
+	}
+	x, err := strconv.ParseFloat(fs[0], 64)
+	if err != nil {
+		return 0, false
+	}
+	return x, true
+}
+
+func appendUint32(p []byte, v uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return append(p, b[:]...)
+}
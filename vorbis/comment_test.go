@@ -0,0 +1,63 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package vorbis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComments(z *testing.T) {
+	assert := assert.New(z)
+
+	c := Comments{
+		VendorKey:               {"Xiph.Org libVorbis I 20200704"},
+		"title":                 {"Dawn Chorus"},
+		"artist":                {"One", "Two"},
+		"tracknumber":           {"3"},
+		"replaygain_track_gain": {"-6.50 dB"},
+	}
+	b := c.Bytes()
+	d, n, err := ParseComments(append(b, 1))
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(len(b), n)
+	assert.Equal(c, d)
+	assert.Equal("Xiph.Org libVorbis I 20200704", d.Vendor())
+	assert.Equal("One/Two", d.Join("artist", "/"))
+	assert.Equal(3, d.Int("tracknumber"))
+	assert.Equal(0, d.Int("title"))
+	assert.Equal(0, d.Int("missing"))
+	x, ok := d.Float("replaygain_track_gain")
+	assert.True(ok)
+	assert.Equal(-6.5, x)
+	_, ok = d.Float("missing")
+	assert.False(ok)
+
+	// Field names are case-insensitive.
+	b = []byte("\x00\x00\x00\x00\x02\x00\x00\x00\x07\x00\x00\x00Genre=A\x07\x00\x00\x00GENRE=B")
+	if d, _, err := ParseComments(b); assert.Nil(err) {
+		assert.Equal([]string{"A", "B"}, d["genre"])
+		assert.Equal("", d.Vendor())
+	}
+}
+
+func TestParseCommentsInvalid(z *testing.T) {
+	tests := [][]byte{
+		nil,
+		[]byte("\x05\x00\x00\x00abc"),
+		[]byte("\x00\x00\x00\x00"),
+		[]byte("\x00\x00\x00\x00\x01\x00\x00\x00"),
+		[]byte("\x00\x00\x00\x00\x01\x00\x00\x00\x05\x00\x00\x00TITLE"),
+		[]byte("\x00\x00\x00\x00\x01\x00\x00\x00\xFF\xFF\xFF\xFFTITLE=x"),
+	}
+	for i, b := range tests {
+		if _, _, err := ParseComments(b); err != ErrInvalidComment {
+			z.Errorf("test %d: got error %v, want %v", i, err, ErrInvalidComment)
+		}
+	}
+}
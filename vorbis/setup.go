@@ -0,0 +1,96 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package vorbis
+
+/*
+ParseModes returns the block flags of the modes at the end of the setup
+header packet b of a Vorbis stream, or nil if they cannot be found. Since the
+codebooks, floors and residues before them are not parsed, the modes are read
+backwards from the framing bit, as long as they look valid.
+
+Encoding format (end of the setup header, in bits, LSB first)
+
+ BITS DESCRIPTION
+===== ===========================================================================
+    6 Number of modes - 1
+      For each mode:
+    1   Block flag: 1 if the mode uses block size 1
+   16   Window type: 0
+   16   Transform type: 0
+    8   Mapping
+    1 Framing flag: 1
+===== ===========================================================================
+*/
+func ParseModes(b []byte) []bool {
+	if len(b) < 7 || b[0] != 5 || string(b[1:7]) != "vorbis" {
+		return nil
+	}
+	r := backwardReader{b: b[7:], pos: 8*(len(b)-7) - 1}
+	for r.pos >= 0 && r.read(1) == 0 {
+		// padding after the framing bit
+	}
+	framing := r.pos
+
+	n := 0
+	for count := 1; count <= 64 && r.pos+1 >= 41+6; count++ {
+		mapping, transform, window := r.read(8), r.read(16), r.read(16)
+		if mapping > 63 || transform != 0 || window != 0 {
+			break
+		}
+		r.read(1)
+		pos := r.pos
+		if r.read(6)+1 == uint32(count) {
+			n = count
+		}
+		r.pos = pos
+	}
+	if n == 0 {
+		return nil
+	}
+
+	flags := make([]bool, n)
+	r.pos = framing
+	for i := n - 1; i >= 0; i-- {
+		r.read(40)
+		flags[i] = r.read(1) == 1
+	}
+	return flags
+}
+
+// BlockFlag returns the block flag of the mode of the audio packet b, which
+// begins with the packet type 0 and the mode number, given the block flags
+// of the modes that ParseModes returns. It returns false for ok if b is not
+// an audio packet of one of the modes.
+func BlockFlag(modes []bool, b []byte) (flag, ok bool) {
+	if len(b) == 0 || b[0]&1 != 0 || len(modes) == 0 {
+		return false, false
+	}
+	bits := uint(0)
+	for n := len(modes) - 1; n > 0; n >>= 1 {
+		bits++
+	}
+	mode := int(b[0]>>1) & (1<<bits - 1)
+	if mode >= len(modes) {
+		return false, false
+	}
+	return modes[mode], true
+}
+
+// backwardReader reads the bits of b, which are packed LSB first, backwards
+// from the bit at pos. Reading a field of n bits in this way returns its
+// value as it was written.
+type backwardReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *backwardReader) read(n int) uint32 {
+	var v uint32
+	for ; n > 0 && r.pos >= 0; n-- {
+		v = v<<1 | uint32(r.b[r.pos/8]>>(uint(r.pos)%8)&1)
+		r.pos--
+	}
+	return v
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package vorbis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// vorbisSetup returns a setup header packet that ends with modes of the
+// given block flags, after bytes that stand in for the codebooks.
+func vorbisSetup(flags ...bool) []byte {
+	var b []byte
+	n := uint(0)
+	put := func(v uint32, bits uint) {
+		for i := uint(0); i < bits; i++ {
+			if n%8 == 0 {
+				b = append(b, 0)
+			}
+			b[len(b)-1] |= byte(v>>i&1) << (n % 8)
+			n++
+		}
+	}
+	for i := 0; i < 16; i++ {
+		put(0xFF, 8)
+	}
+	put(uint32(len(flags)-1), 6)
+	for i, f := range flags {
+		if f {
+			put(1, 1)
+		} else {
+			put(0, 1)
+		}
+		put(0, 16)
+		put(0, 16)
+		put(uint32(i), 8)
+	}
+	put(1, 1)
+	return append([]byte("\x05vorbis"), b...)
+}
+
+func TestParseModes(z *testing.T) {
+	assert := assert.New(z)
+	assert.Equal([]bool{false, true}, ParseModes(vorbisSetup(false, true)))
+	assert.Equal([]bool{true, false, true}, ParseModes(vorbisSetup(true, false, true)))
+	assert.Nil(ParseModes([]byte("\x05vorbis setup")))
+	assert.Nil(ParseModes(nil))
+}
+
+func TestBlockFlag(z *testing.T) {
+	modes := []bool{false, true, true}
+	tests := []struct {
+		In   []byte
+		Flag bool
+		OK   bool
+	}{
+		{[]byte{0 << 1}, false, true},
+		{[]byte{1 << 1}, true, true},
+		{[]byte{2<<1 | 0xF8}, true, true},
+		{[]byte{3 << 1}, false, false},
+		{[]byte{1}, false, false},
+		{nil, false, false},
+	}
+	for _, t := range tests {
+		flag, ok := BlockFlag(modes, t.In)
+		if flag != t.Flag || ok != t.OK {
+			z.Errorf("BlockFlag(%v) = %v, %v; expecting %v, %v", t.In, flag, ok, t.Flag, t.OK)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package wav
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/goulash/audio"
+)
+
+var ErrUnsupported = errors.New("audio format unsupported")
+
+// Decoder reads the PCM samples in the data chunk of a WAVE stream. Integer
+// samples of 8, 16, 24 and 32 bits and floating-point samples of 32 and 64
+// bits are supported.
+type Decoder struct {
+	meta   *Metadata
+	format *Format
+	r      *bufio.Reader // reads the audio data only
+	size   int           // bytes per sample
+	shift  uint          // BitsPerSample - ValidBits
+	buf    []byte
+}
+
+// NewDecoder reads the chunks of the stream in r up to the audio data, and
+// returns a Decoder that is positioned at the first sample. If r is an
+// io.Seeker, the chunks after the audio data are read as well.
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	var m *Metadata
+	if s, ok := r.(io.Seeker); ok {
+		start, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		m, err = ReadMetadata(r)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.Seek(start+m.DataOffset(), io.SeekStart); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		m, err = readMetadata(r, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f := m.format
+	switch {
+	case f.Tag == PCM && f.BitsPerSample%8 == 0 && f.BitsPerSample >= 8 && f.BitsPerSample <= 32:
+	case f.Tag == IEEEFloat && (f.BitsPerSample == 32 || f.BitsPerSample == 64):
+	default:
+		return nil, ErrUnsupported
+	}
+	size := int(f.BitsPerSample / 8)
+	if int(f.BlockAlign) != size*int(f.NumChannels) || f.ValidBits == 0 || f.ValidBits > f.BitsPerSample {
+		return nil, ErrInvalidStream
+	}
+	return &Decoder{
+		meta:   m,
+		format: f,
+		r:      bufio.NewReader(io.LimitReader(r, m.DataSize())),
+		size:   size,
+		shift:  uint(f.BitsPerSample - f.ValidBits),
+	}, nil
+}
+
+// Metadata returns the metadata that was read by NewDecoder.
+func (d *Decoder) Metadata() *Metadata { return d.meta }
+
+var _ = audio.Decoder(new(Decoder))
+
+func (d *Decoder) SampleRate() int  { return int(d.format.SampleRate) }
+func (d *Decoder) NumChannels() int { return int(d.format.NumChannels) }
+
+// BitsPerSample returns the number of significant bits of the samples that
+// Read returns, which is 32 for floating-point samples.
+func (d *Decoder) BitsPerSample() int {
+	if d.format.Tag == IEEEFloat {
+		return 32
+	}
+	return int(d.format.ValidBits)
+}
+
+// Read reads interleaved samples into p and returns the number of samples
+// read. Floating-point samples are scaled to 32-bit integers and clipped.
+// At the end of the stream, io.EOF is returned; an incomplete sample at the
+// end is discarded.
+func (d *Decoder) Read(p []int32) (int, error) {
+	b, err := d.read(len(p))
+	if err != nil {
+		return 0, err
+	}
+	n := len(b) / d.size
+	switch d.format.Tag {
+	case IEEEFloat:
+		for i := 0; i < n; i++ {
+			p[i] = floatToInt32(d.float(b[i*d.size:]))
+		}
+	default:
+		for i := 0; i < n; i++ {
+			p[i] = d.int(b[i*d.size:])
+		}
+	}
+	return n, nil
+}
+
+// ReadFloat reads interleaved samples into p and returns the number of
+// samples read. Integer samples are scaled to the range [-1, 1).
+// Floating-point samples are returned as they are, so that they can be
+// written again without loss.
+func (d *Decoder) ReadFloat(p []float64) (int, error) {
+	b, err := d.read(len(p))
+	if err != nil {
+		return 0, err
+	}
+	n := len(b) / d.size
+	switch d.format.Tag {
+	case IEEEFloat:
+		for i := 0; i < n; i++ {
+			p[i] = d.float(b[i*d.size:])
+		}
+	default:
+		scale := 1 / float64(uint64(1)<<(d.format.ValidBits-1))
+		for i := 0; i < n; i++ {
+			p[i] = float64(d.int(b[i*d.size:])) * scale
+		}
+	}
+	return n, nil
+}
+
+// read reads up to n samples and returns their bytes.
+func (d *Decoder) read(n int) ([]byte, error) {
+	if n*d.size > cap(d.buf) {
+		d.buf = make([]byte, n*d.size)
+	}
+	b := d.buf[:n*d.size]
+	k, err := io.ReadFull(d.r, b)
+	k -= k % d.size
+	if k == 0 {
+		if err == nil || err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		if n == 0 {
+			err = nil
+		}
+		return nil, err
+	}
+	return b[:k], nil
+}
+
+// int returns the integer sample at the beginning of b.
+func (d *Decoder) int(b []byte) int32 {
+	var v int32
+	switch d.size {
+	case 1:
+		v = int32(b[0]) - 128
+	case 2:
+		v = int32(int16(binary.LittleEndian.Uint16(b)))
+	case 3:
+		v = int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+	case 4:
+		v = int32(binary.LittleEndian.Uint32(b))
+	}
+	return v >> d.shift
+}
+
+// float returns the floating-point sample at the beginning of b.
+func (d *Decoder) float(b []byte) float64 {
+	if d.size == 4 {
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+// floatToInt32 scales x from [-1, 1) to a 32-bit integer.
+func floatToInt32(x float64) int32 {
+	x = math.Round(x * (1 << 31))
+	switch {
+	case math.IsNaN(x):
+		return 0
+	case x >= math.MaxInt32:
+		return math.MaxInt32
+	case x <= math.MinInt32:
+		return math.MinInt32
+	}
+	return int32(x)
+}
@@ -0,0 +1,129 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/goulash/audio"
+	"github.com/stretchr/testify/assert"
+)
+
+// readTestSamples returns the samples of the test file, which are 16-bit
+// and start at offset 44.
+func readTestSamples() ([]int32, error) {
+	data, err := ioutil.ReadFile(testFile)
+	if err != nil {
+		return nil, err
+	}
+	data = data[44:]
+	samples := make([]int32, len(data)/2)
+	for i := range samples {
+		samples[i] = int32(int16(binary.LittleEndian.Uint16(data[2*i:])))
+	}
+	return samples, nil
+}
+
+// readAll reads all samples from d in uneven pieces.
+func readAll(d audio.Decoder) ([]int32, error) {
+	var samples []int32
+	buf := make([]int32, 777)
+	for {
+		n, err := d.Read(buf)
+		samples = append(samples, buf[:n]...)
+		if err == io.EOF {
+			return samples, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func TestDecoder(z *testing.T) {
+	assert := assert.New(z)
+	want, err := readTestSamples()
+	if !assert.Nil(err) {
+		return
+	}
+	data, err := ioutil.ReadFile(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+
+	for _, r := range []io.Reader{bytes.NewReader(data), onlyReader{bytes.NewReader(data)}} {
+		d, err := NewDecoder(r)
+		if !assert.Nil(err) {
+			continue
+		}
+		assert.Equal(44100, d.SampleRate())
+		assert.Equal(2, d.NumChannels())
+		assert.Equal(16, d.BitsPerSample())
+		got, err := readAll(d)
+		assert.Nil(err)
+		assert.Equal(want, got)
+	}
+
+	// A trailing chunk is not part of the audio data.
+	data = append(data, chunk("LIST", []byte("INFO"))...)
+	d, err := NewDecoder(bytes.NewReader(data))
+	if assert.Nil(err) {
+		got, err := readAll(d)
+		assert.Nil(err)
+		assert.Equal(want, got)
+		assert.Len(d.Metadata().Chunks(), 3)
+	}
+}
+
+func TestDecoderFloat(z *testing.T) {
+	assert := assert.New(z)
+
+	b := make([]byte, 16)
+	binary.LittleEndian.PutUint32(b, 0x3F000000)      // 0.5
+	binary.LittleEndian.PutUint32(b[4:], 0xBF800000)  // -1
+	binary.LittleEndian.PutUint32(b[8:], 0x40000000)  // 2
+	binary.LittleEndian.PutUint32(b[12:], 0x7FC00000) // NaN
+	f := pcmFormat(1, 44100, 32)
+	binary.LittleEndian.PutUint16(f, uint16(IEEEFloat))
+	data := riff("RIFF", chunk("fmt ", f), chunk("data", b))
+
+	d, err := NewDecoder(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(32, d.BitsPerSample())
+	got, err := readAll(d)
+	assert.Nil(err)
+	assert.Equal([]int32{1 << 30, -1 << 31, 1<<31 - 1, 0}, got)
+
+	_, err = NewDecoder(bytes.NewReader(riff("RIFF", chunk("fmt ", pcmFormat(1, 44100, 12)))))
+	assert.Equal(ErrUnsupported, err)
+}
+
+func TestAudioNewDecoder(z *testing.T) {
+	assert := assert.New(z)
+	want, err := readTestSamples()
+	if !assert.Nil(err) {
+		return
+	}
+	f, err := os.Open(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+	defer f.Close()
+
+	d, err := audio.NewDecoder(f)
+	if !assert.Nil(err) {
+		return
+	}
+	got, err := readAll(d)
+	assert.Nil(err)
+	assert.Equal(want, got)
+}
@@ -0,0 +1,312 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package wav
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+var (
+	ErrSampleRange   = errors.New("sample out of range")
+	ErrInvalidFormat = errors.New("invalid audio format")
+	ErrClosed        = errors.New("encoder closed")
+)
+
+// maxRIFFSize is the largest size of a RIFF chunk. Larger streams are
+// written as RF64.
+var maxRIFFSize int64 = math.MaxUint32
+
+// junkSize is the size of the JUNK chunk that reserves space for a ds64
+// chunk, in case the stream becomes too large for RIFF.
+const junkSize = 28
+
+// Encoder writes PCM samples to a WAVE stream.
+type Encoder struct {
+	w      io.WriteSeeker
+	bw     *bufio.Writer
+	format Format
+	start  int64 // position of the stream in w
+	fact   int64 // position of the fact chunk, or 0
+	data   int64 // position of the data chunk
+	bytes  int64 // number of bytes of audio data written
+	size   int   // bytes per sample
+	shift  uint  // BitsPerSample - ValidBits
+	min    int32 // minimum sample value
+	max    int32 // maximum sample value
+	buf    []byte
+	closed bool
+}
+
+// NewEncoder writes the header of a WAVE stream to w and returns an Encoder
+// that writes the audio data after it. The Tag, NumChannels, SampleRate,
+// BitsPerSample, ValidBits, ChannelMask and Extensible fields of f are used;
+// ValidBits may be 0 if it is the same as BitsPerSample.
+//
+// The format is written as WAVE_FORMAT_EXTENSIBLE if f.Extensible is set,
+// or if ValidBits or ChannelMask require it. The sizes in the header are
+// written when the encoder is closed; if the stream is larger than 4 GiB,
+// it is written as RF64.
+func NewEncoder(w io.WriteSeeker, f *Format) (*Encoder, error) {
+	fm := *f
+	if fm.ValidBits == 0 {
+		fm.ValidBits = fm.BitsPerSample
+	}
+	switch {
+	case fm.Tag == PCM && fm.BitsPerSample%8 == 0 && fm.BitsPerSample >= 8 && fm.BitsPerSample <= 32:
+	case fm.Tag == IEEEFloat && (fm.BitsPerSample == 32 || fm.BitsPerSample == 64):
+		fm.ValidBits = fm.BitsPerSample
+	default:
+		return nil, ErrInvalidFormat
+	}
+	if fm.NumChannels == 0 || fm.SampleRate == 0 || fm.ValidBits > fm.BitsPerSample {
+		return nil, ErrInvalidFormat
+	}
+	if fm.ValidBits != fm.BitsPerSample || fm.ChannelMask != 0 {
+		fm.Extensible = true
+	}
+	size := int(fm.BitsPerSample / 8)
+	fm.BlockAlign = uint16(size) * fm.NumChannels
+	fm.ByteRate = fm.SampleRate * uint32(fm.BlockAlign)
+
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	e := &Encoder{
+		w:      w,
+		bw:     bufio.NewWriter(w),
+		format: fm,
+		start:  start,
+		size:   size,
+		shift:  uint(fm.BitsPerSample - fm.ValidBits),
+		min:    -1 << (fm.ValidBits - 1),
+		max:    1<<(fm.ValidBits-1) - 1,
+	}
+	if fm.ValidBits == 32 {
+		e.min, e.max = math.MinInt32, math.MaxInt32
+	}
+	if err := e.writeHeader(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Format returns the format that is written, with all fields filled in.
+func (e *Encoder) Format() *Format { return &e.format }
+
+// writeHeader writes the RIFF header and all chunks up to the header of the
+// data chunk. The sizes are filled in by Close.
+func (e *Encoder) writeHeader() error {
+	f := &e.format
+	b := make([]byte, 0, 128)
+	b = append(b, "RIFF\x00\x00\x00\x00WAVE"...)
+	b = appendChunkHeader(b, "JUNK", junkSize)
+	b = append(b, make([]byte, junkSize)...)
+
+	var fmtc []byte
+	tag := f.Tag
+	if f.Extensible {
+		tag = Extensible
+	}
+	fmtc = appendUint16(fmtc, uint16(tag))
+	fmtc = appendUint16(fmtc, f.NumChannels)
+	fmtc = appendUint32(fmtc, f.SampleRate)
+	fmtc = appendUint32(fmtc, f.ByteRate)
+	fmtc = appendUint16(fmtc, f.BlockAlign)
+	fmtc = appendUint16(fmtc, f.BitsPerSample)
+	switch {
+	case f.Extensible:
+		fmtc = appendUint16(fmtc, 22)
+		fmtc = appendUint16(fmtc, f.ValidBits)
+		fmtc = appendUint32(fmtc, f.ChannelMask)
+		fmtc = appendUint16(fmtc, uint16(f.Tag))
+		fmtc = append(fmtc, subFormatGUID...)
+	case f.Tag != PCM:
+		fmtc = appendUint16(fmtc, 0)
+	}
+	b = appendChunkHeader(b, "fmt ", len(fmtc))
+	b = append(b, fmtc...)
+
+	// Every format except plain PCM needs a fact chunk.
+	if f.Tag != PCM || f.Extensible {
+		e.fact = int64(len(b))
+		b = appendChunkHeader(b, "fact", 4)
+		b = appendUint32(b, 0)
+	}
+	e.data = int64(len(b))
+	b = appendChunkHeader(b, "data", 0)
+	_, err := e.bw.Write(b)
+	return err
+}
+
+// Write writes the interleaved samples in p, which must fit into ValidBits.
+// For floating-point formats, the samples are scaled from 32-bit integers.
+func (e *Encoder) Write(p []int32) (int, error) {
+	if e.closed {
+		return 0, ErrClosed
+	}
+	b := e.buffer(len(p))
+	for i, v := range p {
+		if e.format.Tag == IEEEFloat {
+			e.putFloat(b[i*e.size:], float64(v)/(1<<31))
+			continue
+		}
+		if v < e.min || v > e.max {
+			if _, err := e.write(b[:i*e.size], i); err != nil {
+				return 0, err
+			}
+			return i, ErrSampleRange
+		}
+		e.putInt(b[i*e.size:], v)
+	}
+	return e.write(b, len(p))
+}
+
+// WriteFloat writes the interleaved samples in p. For integer formats, the
+// samples are scaled from the range [-1, 1) and clipped. For floating-point
+// formats, they are written as they are.
+func (e *Encoder) WriteFloat(p []float64) (int, error) {
+	if e.closed {
+		return 0, ErrClosed
+	}
+	b := e.buffer(len(p))
+	scale := float64(uint64(1) << (e.format.ValidBits - 1))
+	for i, x := range p {
+		if e.format.Tag == IEEEFloat {
+			e.putFloat(b[i*e.size:], x)
+			continue
+		}
+		v := math.Round(x * scale)
+		switch {
+		case math.IsNaN(v):
+			v = 0
+		case v > float64(e.max):
+			v = float64(e.max)
+		case v < float64(e.min):
+			v = float64(e.min)
+		}
+		e.putInt(b[i*e.size:], int32(v))
+	}
+	return e.write(b, len(p))
+}
+
+func (e *Encoder) buffer(n int) []byte {
+	if n*e.size > cap(e.buf) {
+		e.buf = make([]byte, n*e.size)
+	}
+	return e.buf[:n*e.size]
+}
+
+func (e *Encoder) write(b []byte, n int) (int, error) {
+	k, err := e.bw.Write(b)
+	e.bytes += int64(k)
+	if err != nil {
+		return k / e.size, err
+	}
+	return n, nil
+}
+
+func (e *Encoder) putInt(b []byte, v int32) {
+	v <<= e.shift
+	switch e.size {
+	case 1:
+		b[0] = byte(v + 128)
+	case 2:
+		binary.LittleEndian.PutUint16(b, uint16(v))
+	case 3:
+		b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+	case 4:
+		binary.LittleEndian.PutUint32(b, uint32(v))
+	}
+}
+
+func (e *Encoder) putFloat(b []byte, x float64) {
+	if e.size == 4 {
+		binary.LittleEndian.PutUint32(b, math.Float32bits(float32(x)))
+	} else {
+		binary.LittleEndian.PutUint64(b, math.Float64bits(x))
+	}
+}
+
+// Close pads the data chunk to an even size and writes the sizes in the
+// header. It does not close the underlying writer. An incomplete
+// inter-channel sample at the end of the stream is written as it is.
+func (e *Encoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	if e.bytes%2 == 1 {
+		if err := e.bw.WriteByte(0); err != nil {
+			return err
+		}
+	}
+	if err := e.bw.Flush(); err != nil {
+		return err
+	}
+
+	end, err := e.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	riffSize := end - e.start - 8
+	samples := e.bytes / int64(e.format.BlockAlign)
+	size32, samples32 := uint32(e.bytes), uint32(samples)
+	if riffSize > maxRIFFSize {
+		// The 32-bit sizes are replaced by the ds64 chunk.
+		b := append([]byte("RF64\xFF\xFF\xFF\xFFWAVE"), appendChunkHeader(nil, "ds64", junkSize)...)
+		b = appendUint64(b, uint64(riffSize))
+		b = appendUint64(b, uint64(e.bytes))
+		b = appendUint64(b, uint64(samples))
+		b = appendUint32(b, 0)
+		err = e.writeAt(0, b)
+		size32, samples32 = math.MaxUint32, math.MaxUint32
+	} else {
+		err = e.writeAt(4, appendUint32(nil, uint32(riffSize)))
+	}
+	if err != nil {
+		return err
+	}
+	if e.fact != 0 {
+		if err := e.writeAt(e.fact+8, appendUint32(nil, samples32)); err != nil {
+			return err
+		}
+	}
+	if err := e.writeAt(e.data+4, appendUint32(nil, size32)); err != nil {
+		return err
+	}
+	_, err = e.w.Seek(end, io.SeekStart)
+	return err
+}
+
+// writeAt writes b at the offset relative to the start of the stream.
+func (e *Encoder) writeAt(offset int64, b []byte) error {
+	if _, err := e.w.Seek(e.start+offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := e.w.Write(b)
+	return err
+}
+
+func appendChunkHeader(b []byte, id string, size int) []byte {
+	b = append(b, id...)
+	return appendUint32(b, uint32(size))
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v)), uint32(v>>32))
+}
@@ -0,0 +1,271 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package wav
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"github.com/goulash/audio"
+	"github.com/stretchr/testify/assert"
+)
+
+// memFile is an in-memory io.WriteSeeker.
+type memFile struct {
+	buf []byte
+	off int
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if n := f.off + len(p); n > len(f.buf) {
+		f.buf = append(f.buf, make([]byte, n-len(f.buf))...)
+	}
+	copy(f.buf[f.off:], p)
+	f.off += len(p)
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		f.off = int(offset)
+	case io.SeekCurrent:
+		f.off += int(offset)
+	case io.SeekEnd:
+		f.off = len(f.buf) + int(offset)
+	}
+	if f.off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	return int64(f.off), nil
+}
+
+func encode(f *Format, samples []int32) ([]byte, error) {
+	var w memFile
+	e, err := NewEncoder(&w, f)
+	if err != nil {
+		return nil, err
+	}
+	// Write in uneven pieces to exercise the buffering
+	for len(samples) > 0 {
+		n := 777
+		if n > len(samples) {
+			n = len(samples)
+		}
+		if _, err := e.Write(samples[:n]); err != nil {
+			return nil, err
+		}
+		samples = samples[n:]
+	}
+	if err := e.Close(); err != nil {
+		return nil, err
+	}
+	return w.buf, nil
+}
+
+// testSamples returns samples that cover the range of bits.
+func testSamples(bits uint, n int) []int32 {
+	min, max := int64(-1)<<(bits-1), int64(1)<<(bits-1)-1
+	samples := []int32{int32(min), int32(max), 0, -1, 1}
+	for i := 0; len(samples) < n; i++ {
+		x := math.Sin(float64(i)/10) * float64(max)
+		samples = append(samples, int32(x))
+	}
+	return samples
+}
+
+func TestEncoder(z *testing.T) {
+	assert := assert.New(z)
+	data, err := ioutil.ReadFile(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+	want, err := readTestSamples()
+	if !assert.Nil(err) {
+		return
+	}
+
+	m, err := ReadMetadata(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+	enc, err := encode(m.Format(), want)
+	if !assert.Nil(err) {
+		return
+	}
+	n, err := ReadMetadata(bytes.NewReader(enc))
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(m.Format(), n.Format())
+	assert.Equal([]string{"JUNK", "fmt ", "data"}, chunkIDs(n))
+
+	// The audio data is the same, byte for byte.
+	assert.Equal(data[m.DataOffset():], enc[n.DataOffset():])
+}
+
+func TestEncoderFormats(z *testing.T) {
+	tests := []Format{
+		{Tag: PCM, NumChannels: 1, SampleRate: 8000, BitsPerSample: 8},
+		{Tag: PCM, NumChannels: 2, SampleRate: 44100, BitsPerSample: 16},
+		{Tag: PCM, NumChannels: 3, SampleRate: 48000, BitsPerSample: 24},
+		{Tag: PCM, NumChannels: 2, SampleRate: 96000, BitsPerSample: 32},
+		{Tag: PCM, NumChannels: 2, SampleRate: 96000, BitsPerSample: 24, ValidBits: 20},
+		{Tag: PCM, NumChannels: 6, SampleRate: 48000, BitsPerSample: 24, ChannelMask: uint32(audio.Surround51)},
+		{Tag: PCM, NumChannels: 2, SampleRate: 44100, BitsPerSample: 16, Extensible: true},
+	}
+	for _, f := range tests {
+		bits := f.ValidBits
+		if bits == 0 {
+			bits = f.BitsPerSample
+		}
+		want := testSamples(uint(bits), 999*int(f.NumChannels))
+		enc, err := encode(&f, want)
+		if err != nil {
+			z.Errorf("encode(%+v) = %v", f, err)
+			continue
+		}
+		d, err := NewDecoder(bytes.NewReader(enc))
+		if err != nil {
+			z.Errorf("NewDecoder(%+v) = %v", f, err)
+			continue
+		}
+		got, err := readAll(d)
+		if err != nil || !assert.ObjectsAreEqual(want, got) {
+			z.Errorf("decoding %+v = %v; samples differ", f, err)
+		}
+		g := d.Metadata().Format()
+		ext := f.Extensible || f.ValidBits != 0 || f.ChannelMask != 0
+		if g.Tag != PCM || g.Extensible != ext || g.ChannelMask != f.ChannelMask || g.ValidBits != bits {
+			z.Errorf("format = %+v; expecting %+v", g, f)
+		}
+		if m := d.Metadata(); m.TotalSamples() != int64(len(want))/int64(f.NumChannels) {
+			z.Errorf("TotalSamples() = %d; expecting %d", m.TotalSamples(), len(want)/int(f.NumChannels))
+		}
+	}
+}
+
+func TestEncoderFloat(z *testing.T) {
+	assert := assert.New(z)
+
+	want := []float64{0, 0.5, -1, 1, 1.5, math.SmallestNonzeroFloat32, float64(float32(0.1)), math.Inf(-1)}
+	for _, bits := range []uint16{32, 64} {
+		var w memFile
+		e, err := NewEncoder(&w, &Format{Tag: IEEEFloat, NumChannels: 2, SampleRate: 48000, BitsPerSample: bits})
+		if !assert.Nil(err) {
+			continue
+		}
+		_, err = e.WriteFloat(want)
+		assert.Nil(err)
+		assert.Nil(e.Close())
+
+		m, err := ReadMetadata(bytes.NewReader(w.buf))
+		if assert.Nil(err) {
+			assert.Equal([]string{"JUNK", "fmt ", "fact", "data"}, chunkIDs(m))
+			assert.Equal(int64(4), m.TotalSamples())
+			assert.Equal(int(bits), m.BitsPerSample())
+		}
+		d, err := NewDecoder(bytes.NewReader(w.buf))
+		if !assert.Nil(err) {
+			continue
+		}
+		got := make([]float64, 10)
+		n, err := d.ReadFloat(got)
+		assert.Nil(err)
+		assert.Equal(want, got[:n])
+		_, err = d.ReadFloat(got)
+		assert.Equal(io.EOF, err)
+	}
+
+	// Integer formats are scaled and clipped.
+	var w memFile
+	e, err := NewEncoder(&w, &Format{Tag: PCM, NumChannels: 1, SampleRate: 48000, BitsPerSample: 16})
+	if assert.Nil(err) {
+		e.WriteFloat([]float64{0.5, -1, 2, math.NaN()})
+		assert.Nil(e.Close())
+		d, err := NewDecoder(bytes.NewReader(w.buf))
+		if assert.Nil(err) {
+			got, err := readAll(d)
+			assert.Nil(err)
+			assert.Equal([]int32{1 << 14, -1 << 15, 1<<15 - 1, 0}, got)
+		}
+	}
+}
+
+func TestEncoderRF64(z *testing.T) {
+	assert := assert.New(z)
+	defer func(n int64) { maxRIFFSize = n }(maxRIFFSize)
+	maxRIFFSize = 100
+
+	f := &Format{Tag: PCM, NumChannels: 2, SampleRate: 44100, BitsPerSample: 16, Extensible: true}
+	want := testSamples(16, 201)
+	enc, err := encode(f, want)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal("RF64", string(enc[:4]))
+	m, err := ReadMetadata(bytes.NewReader(enc))
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal([]string{"ds64", "fmt ", "fact", "data"}, chunkIDs(m))
+	assert.Equal(int64(402), m.DataSize())
+	assert.Equal(int64(100), m.TotalSamples())
+	assert.Equal(int64(len(enc)), m.DataOffset()+m.DataSize())
+
+	d, err := NewDecoder(bytes.NewReader(enc))
+	if assert.Nil(err) {
+		got, err := readAll(d)
+		assert.Nil(err)
+		assert.Equal(want, got)
+	}
+}
+
+func TestEncoderErrors(z *testing.T) {
+	assert := assert.New(z)
+
+	var w memFile
+	for _, f := range []Format{
+		{Tag: PCM, NumChannels: 2, SampleRate: 44100, BitsPerSample: 12},
+		{Tag: PCM, NumChannels: 0, SampleRate: 44100, BitsPerSample: 16},
+		{Tag: PCM, NumChannels: 2, SampleRate: 44100, BitsPerSample: 16, ValidBits: 20},
+		{Tag: IEEEFloat, NumChannels: 2, SampleRate: 44100, BitsPerSample: 16},
+		{Tag: ALaw, NumChannels: 1, SampleRate: 8000, BitsPerSample: 8},
+	} {
+		_, err := NewEncoder(&w, &f)
+		assert.Equal(ErrInvalidFormat, err)
+	}
+
+	w = memFile{}
+	e, err := NewEncoder(&w, &Format{Tag: PCM, NumChannels: 1, SampleRate: 44100, BitsPerSample: 24, ValidBits: 20})
+	if !assert.Nil(err) {
+		return
+	}
+	n, err := e.Write([]int32{1, 2, 1 << 19})
+	assert.Equal(2, n)
+	assert.Equal(ErrSampleRange, err)
+	assert.Nil(e.Close())
+	_, err = e.Write([]int32{1})
+	assert.Equal(ErrClosed, err)
+
+	d, err := NewDecoder(bytes.NewReader(w.buf))
+	if assert.Nil(err) {
+		got, err := readAll(d)
+		assert.Nil(err)
+		assert.Equal([]int32{1, 2}, got)
+	}
+}
+
+func chunkIDs(m *Metadata) []string {
+	var ids []string
+	for _, c := range m.Chunks() {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
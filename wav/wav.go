@@ -0,0 +1,707 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+// Package wav implements reading the metadata of WAVE files, including
+// Broadcast Wave (BWF) and RF64 files, and decoding and encoding PCM audio.
+//
+// Reference
+//
+//  http://www-mmsp.ece.mcgill.ca/Documents/AudioFormats/WAVE/WAVE.html
+//  https://tech.ebu.ch/docs/tech/tech3285.pdf
+//  https://tech.ebu.ch/docs/tech/tech3306.pdf
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/goulash/audio"
+	"github.com/goulash/audio/id3"
+)
+
+func init() {
+	audio.RegisterFormat(audio.Format{
+		Name:       "wav",
+		Codec:      audio.WAV,
+		Magic:      []string{"RIFF????WAVE", "RF64????WAVE", "BW64????WAVE"},
+		Extensions: []string{".wav", ".wave"},
+		MIMETypes:  []string{"audio/wav", "audio/x-wav", "audio/vnd.wave"},
+		ReadMetadata: func(r io.ReadSeeker) (audio.Metadata, error) {
+			m, err := ReadMetadata(r)
+			if err != nil {
+				return nil, err
+			}
+			return m, nil
+		},
+		NewDecoder: func(r io.Reader) (audio.Decoder, error) {
+			d, err := NewDecoder(r)
+			if err != nil {
+				return nil, err
+			}
+			return d, nil
+		},
+	})
+}
+
+var (
+	ErrUnexpectedEOF = errors.New("unexpected EOF")
+	ErrInvalidStream = errors.New("stream is invalid")
+)
+
+// maxChunkSize is the largest chunk that is read into memory. The only
+// chunks that can get this large are ID3 chunks with pictures.
+const maxChunkSize = 64 << 20
+
+// ReadFileMetadata reads the metadata of the WAVE file at path.
+func ReadFileMetadata(path string) (*Metadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadMetadata(f)
+}
+
+// ReadMetadata reads the chunks of the WAVE stream in r, skipping the audio
+// data in the data chunk. If r is an io.Seeker, the data is skipped by
+// seeking.
+//
+// A data chunk that extends beyond the end of the stream is truncated, since
+// this is what is left of a recording that was interrupted. Anything after
+// the last valid chunk is ignored.
+func ReadMetadata(r io.Reader) (*Metadata, error) {
+	return readMetadata(r, false)
+}
+
+// readMetadata reads the chunks of the stream in r. If stopAtData is true,
+// r is left at the beginning of the audio data, and the chunks after the
+// data chunk are not read.
+func readMetadata(r io.Reader, stopAtData bool) (*Metadata, error) {
+	remaining := int64(-1)
+	if s, ok := r.(io.Seeker); ok {
+		cur, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		end, err := s.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.Seek(cur, io.SeekStart); err != nil {
+			return nil, err
+		}
+		remaining = end - cur
+	}
+
+	b, err := readBytes(r, 12)
+	if err != nil {
+		return nil, err
+	}
+	switch string(b[:4]) {
+	case "RIFF", "RF64", "BW64":
+	default:
+		return nil, ErrInvalidStream
+	}
+	if string(b[8:]) != "WAVE" {
+		return nil, ErrInvalidStream
+	}
+
+	m := &Metadata{info: make(map[string]string)}
+	offset := int64(12)
+	for {
+		h := make([]byte, 8)
+		if _, err := io.ReadFull(r, h); err != nil {
+			break
+		}
+		id := string(h[:4])
+		if !isChunkID(id) {
+			break
+		}
+		offset += 8
+		c := Chunk{ID: id, Offset: offset, Size: int64(binary.LittleEndian.Uint32(h[4:]))}
+
+		if id == "data" {
+			if c.Size == 0xFFFFFFFF && m.ds64 != nil {
+				c.Size = m.ds64.dataSize
+			}
+			if remaining >= 0 && c.Offset+c.Size > remaining {
+				c.Size = remaining - c.Offset
+				if c.Size < 0 {
+					c.Size = 0
+				}
+			}
+			if stopAtData {
+				m.chunks = append(m.chunks, c)
+				break
+			}
+			n, err := skipBytes(r, c.Size)
+			c.Size = n
+			m.chunks = append(m.chunks, c)
+			if err != nil {
+				break
+			}
+		} else {
+			if err := m.readChunk(r, c); err == ErrUnexpectedEOF && m.format != nil {
+				// The chunk is truncated, but everything that is
+				// needed has been read.
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			m.chunks = append(m.chunks, c)
+		}
+		offset += c.Size
+		if c.Size%2 == 1 {
+			if _, err := skipBytes(r, 1); err != nil {
+				break
+			}
+			offset++
+		}
+	}
+
+	if m.format == nil {
+		return nil, ErrInvalidStream
+	}
+	for i := range m.chunks {
+		if m.chunks[i].ID == "data" {
+			m.data = &m.chunks[i]
+			break
+		}
+	}
+	return m, nil
+}
+
+// readChunk reads and parses the content of the chunk c, unless it is a
+// chunk that is not needed, which is skipped.
+func (m *Metadata) readChunk(r io.Reader, c Chunk) error {
+	switch c.ID {
+	case "fmt ", "fact", "LIST", "id3 ", "ID3 ", "bext", "ds64":
+	default:
+		_, err := skipBytes(r, c.Size)
+		return err
+	}
+	if c.Size > maxChunkSize {
+		return ErrInvalidStream
+	}
+	data, err := readBytes(r, int(c.Size))
+	if err != nil {
+		return err
+	}
+
+	switch c.ID {
+	case "fmt ":
+		if m.format == nil {
+			m.format, err = parseFormat(data)
+		}
+	case "fact":
+		if len(data) < 4 {
+			return ErrInvalidStream
+		}
+		m.factSamples = int64(binary.LittleEndian.Uint32(data))
+		if m.factSamples == 0xFFFFFFFF && m.ds64 != nil {
+			m.factSamples = m.ds64.sampleCount
+		}
+	case "LIST":
+		if len(data) >= 4 && string(data[:4]) == "INFO" {
+			parseInfo(data[4:], m.info)
+		}
+	case "id3 ", "ID3 ":
+		// A broken ID3 tag does not make the stream invalid.
+		if t, err := id3.Read(bytes.NewReader(data)); err == nil {
+			m.id3 = t
+		}
+	case "bext":
+		m.bext, err = parseBext(data)
+	case "ds64":
+		m.ds64, err = parseDS64(data)
+	}
+	return err
+}
+
+// isChunkID returns true if id consists of printable ASCII characters,
+// as every chunk ID does.
+func isChunkID(id string) bool {
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x20 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// Chunk is a chunk in a RIFF stream.
+type Chunk struct {
+	// ID is the four character chunk ID, such as "fmt " or "data".
+	ID string
+
+	// Offset is the position of the content of the chunk in the stream,
+	// after the chunk header, and Size is the size of the content.
+	Offset int64
+	Size   int64
+}
+
+// Metadata {{{
+
+// Metadata is the metadata of a WAVE stream. The tags are read from an ID3
+// chunk if there is one, and otherwise from the LIST INFO chunk.
+type Metadata struct {
+	chunks      []Chunk
+	data        *Chunk // the data chunk, or nil
+	format      *Format
+	factSamples int64
+	info        map[string]string
+	id3         *id3.Tag
+	bext        *Bext
+	ds64        *ds64Chunk
+}
+
+func (m *Metadata) Format() *Format         { return m.format }
+func (m *Metadata) Chunks() []Chunk         { return m.chunks }
+func (m *Metadata) Info() map[string]string { return m.info }
+func (m *Metadata) Bext() *Bext             { return m.bext }
+func (m *Metadata) ID3() *id3.Tag           { return m.id3 }
+
+// DataOffset returns the position of the audio data in the stream, and
+// DataSize returns its size in bytes. Both are 0 if there is no data chunk.
+func (m *Metadata) DataOffset() int64 {
+	if m.data == nil {
+		return 0
+	}
+	return m.data.Offset
+}
+func (m *Metadata) DataSize() int64 {
+	if m.data == nil {
+		return 0
+	}
+	return m.data.Size
+}
+
+func (m *Metadata) SampleRate() int                { return int(m.format.SampleRate) }
+func (m *Metadata) NumChannels() int               { return int(m.format.NumChannels) }
+func (m *Metadata) BitsPerSample() int             { return int(m.format.ValidBits) }
+func (m *Metadata) Duration() time.Duration        { return m.Length() }
+func (m *Metadata) BitrateMode() audio.BitrateMode { return audio.CBR }
+
+// TotalSamples returns the number of inter-channel samples, which is
+// calculated from the size of the data for PCM, and otherwise taken from
+// the fact chunk.
+func (m *Metadata) TotalSamples() int64 {
+	switch m.format.Tag {
+	case PCM, IEEEFloat, ALaw, MuLaw:
+		return m.DataSize() / int64(m.format.BlockAlign)
+	default:
+		return m.factSamples
+	}
+}
+
+// ChannelLayout returns the channel mask of the extensible format, or else
+// the default layout for the number of channels.
+func (m *Metadata) ChannelLayout() audio.ChannelLayout {
+	if m.format.ChannelMask != 0 {
+		return audio.ChannelLayout(m.format.ChannelMask)
+	}
+	return audio.DefaultChannelLayout(int(m.format.NumChannels))
+}
+
+func (m *Metadata) Length() time.Duration {
+	return time.Duration(m.TotalSamples()) * time.Second / time.Duration(m.format.SampleRate)
+}
+func (m *Metadata) Encoding() audio.Codec { return audio.WAV }
+func (m *Metadata) EncodingBitrate() int  { return int(m.format.ByteRate) * 8 / 1000 }
+
+func (m *Metadata) Title() string            { return m.text("TIT2", "INAM") }
+func (m *Metadata) Album() string            { return m.text("TALB", "IPRD") }
+func (m *Metadata) Artist() string           { return m.text("TPE1", "IART") }
+func (m *Metadata) AlbumArtist() string      { return m.text("TPE2", "") }
+func (m *Metadata) Composer() string         { return m.text("TCOM", "IMUS") }
+func (m *Metadata) Genre() string            { return m.str((*id3.Tag).Genre, "IGNR") }
+func (m *Metadata) Copyright() string        { return m.text("TCOP", "ICOP") }
+func (m *Metadata) Website() string          { return m.str(website, "") }
+func (m *Metadata) EncodedBy() string        { return m.text("TENC", "ITCH") }
+func (m *Metadata) EncoderSettings() string  { return m.text("TSSE", "ISFT") }
+func (m *Metadata) OriginalFilename() string { return m.text("TOFN", "") }
+
+func website(t *id3.Tag) string { return t.URL("WOAR") }
+
+// Comment returns the comment, or else the description in the broadcast
+// audio extension chunk.
+func (m *Metadata) Comment() string {
+	if s := m.str((*id3.Tag).Comment, "ICMT"); s != "" {
+		return s
+	}
+	if m.bext != nil {
+		return m.bext.Description
+	}
+	return ""
+}
+
+// Year returns the year of the creation date, or else of the origination
+// date in the broadcast audio extension chunk.
+func (m *Metadata) Year() int {
+	if m.id3 != nil && m.id3.Year() != 0 {
+		return m.id3.Year()
+	}
+	if y := parseYear(m.info["ICRD"]); y != 0 {
+		return y
+	}
+	if m.bext != nil {
+		return parseYear(m.bext.OriginationDate)
+	}
+	return 0
+}
+
+func (m *Metadata) Track() (int, int) {
+	if m.id3 != nil {
+		if n, total := m.id3.Track(); n != 0 {
+			return n, total
+		}
+	}
+	s := m.info["ITRK"]
+	if s == "" {
+		s = m.info["IPRT"]
+	}
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		n, _ := strconv.Atoi(s)
+		return n, 0
+	}
+	n, _ := strconv.Atoi(s[:i])
+	total, _ := strconv.Atoi(s[i+1:])
+	return n, total
+}
+
+func (m *Metadata) Disc() (int, int) {
+	if m.id3 == nil {
+		return 0, 0
+	}
+	return m.id3.Disc()
+}
+
+// str returns the value of the ID3 tag if it is set, or else the value of
+// the INFO key.
+func (m *Metadata) str(f func(*id3.Tag) string, key string) string {
+	if m.id3 != nil {
+		if s := f(m.id3); s != "" {
+			return s
+		}
+	}
+	return m.info[key]
+}
+
+// text returns the text of the ID3 frame if it is set, or else the value of
+// the INFO key.
+func (m *Metadata) text(id, key string) string {
+	if m.id3 != nil {
+		if s := m.id3.Text(id); s != "" {
+			return s
+		}
+	}
+	return m.info[key]
+}
+
+func parseYear(s string) int {
+	if len(s) < 4 {
+		return 0
+	}
+	y, err := strconv.Atoi(s[:4])
+	if err != nil {
+		return 0
+	}
+	return y
+}
+
+var _ = audio.Metadata(new(Metadata))
+
+// }}}
+
+// Chunk: fmt {{{
+
+// FormatTag is the format of the audio data.
+type FormatTag uint16
+
+const (
+	PCM        FormatTag = 0x0001
+	IEEEFloat  FormatTag = 0x0003
+	ALaw       FormatTag = 0x0006
+	MuLaw      FormatTag = 0x0007
+	Extensible FormatTag = 0xFFFE
+)
+
+func (t FormatTag) String() string {
+	switch t {
+	case PCM:
+		return "PCM"
+	case IEEEFloat:
+		return "IEEE float"
+	case ALaw:
+		return "A-law"
+	case MuLaw:
+		return "µ-law"
+	case Extensible:
+		return "extensible"
+	default:
+		return "0x" + strconv.FormatUint(uint64(t), 16)
+	}
+}
+
+// Format is the format of the audio data, which is read from the fmt chunk.
+type Format struct {
+	// Tag is the format of the samples. For the extensible format, it is
+	// the format of the sub-format GUID if it has the standard form, so that
+	// Tag is only Extensible if the sub-format is unknown.
+	Tag FormatTag
+
+	// Extensible is true if the format is WAVE_FORMAT_EXTENSIBLE.
+	Extensible bool
+
+	NumChannels uint16
+	SampleRate  uint32
+	ByteRate    uint32 // the number of bytes per second
+	BlockAlign  uint16 // the number of bytes of an inter-channel sample
+
+	// BitsPerSample is the size of a sample in bits, which is a multiple
+	// of 8 for PCM, and ValidBits is the number of significant bits, which
+	// only differs from BitsPerSample in the extensible format.
+	BitsPerSample uint16
+	ValidBits     uint16
+
+	// ChannelMask is the speaker positions of the channels in the extensible
+	// format; see audio.ChannelLayout.
+	ChannelMask uint32
+}
+
+// subFormatGUID is the GUID of the extensible sub-formats without the
+// format tag in the first two bytes.
+var subFormatGUID = []byte("\x00\x00\x00\x00\x10\x00\x80\x00\x00\xAA\x00\x38\x9B\x71")
+
+/*
+parseFormat parses the fmt chunk.
+
+Encoding format
+
+BYTES DESCRIPTION
+===== ===========================================================================
+    2 Format tag
+    2 Number of channels
+    4 Sample rate
+    4 Byte rate
+    2 Block align
+    2 Bits per sample
+    2 Size of the extension (optional)
+    2 Valid bits per sample (extensible format only)
+    4 Channel mask (extensible format only)
+   16 Sub-format GUID (extensible format only)
+===== ===========================================================================
+
+All integers are little-endian.
+*/
+func parseFormat(b []byte) (*Format, error) {
+	if len(b) < 16 {
+		return nil, ErrInvalidStream
+	}
+	f := &Format{
+		Tag:           FormatTag(binary.LittleEndian.Uint16(b)),
+		NumChannels:   binary.LittleEndian.Uint16(b[2:]),
+		SampleRate:    binary.LittleEndian.Uint32(b[4:]),
+		ByteRate:      binary.LittleEndian.Uint32(b[8:]),
+		BlockAlign:    binary.LittleEndian.Uint16(b[12:]),
+		BitsPerSample: binary.LittleEndian.Uint16(b[14:]),
+	}
+	f.ValidBits = f.BitsPerSample
+	if f.Tag == Extensible {
+		if len(b) < 40 {
+			return nil, ErrInvalidStream
+		}
+		f.Extensible = true
+		if v := binary.LittleEndian.Uint16(b[18:]); v != 0 {
+			f.ValidBits = v
+		}
+		f.ChannelMask = binary.LittleEndian.Uint32(b[20:])
+		if bytes.Equal(b[26:40], subFormatGUID) {
+			f.Tag = FormatTag(binary.LittleEndian.Uint16(b[24:]))
+		}
+	}
+	if f.NumChannels == 0 || f.SampleRate == 0 || f.BlockAlign == 0 {
+		return nil, ErrInvalidStream
+	}
+	return f, nil
+}
+
+// }}}
+
+// Chunk: LIST INFO {{{
+
+// parseInfo parses the sub-chunks of a LIST INFO chunk into info, where each
+// sub-chunk contains a string that is usually terminated by a null byte.
+// Strings that are not UTF-8 are assumed to be Latin-1.
+func parseInfo(b []byte, info map[string]string) {
+	for len(b) >= 8 {
+		id := string(b[:4])
+		n := int(binary.LittleEndian.Uint32(b[4:]))
+		b = b[8:]
+		if n > len(b) {
+			n = len(b)
+		}
+		s := strings.TrimRight(string(b[:n]), "\x00 ")
+		if !utf8.ValidString(s) {
+			s = latin1(s)
+		}
+		if s != "" {
+			info[id] = s
+		}
+		n += n % 2
+		if n > len(b) {
+			n = len(b)
+		}
+		b = b[n:]
+	}
+}
+
+func latin1(s string) string {
+	rs := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		rs[i] = rune(s[i])
+	}
+	return string(rs)
+}
+
+// }}}
+
+// Chunk: bext {{{
+
+// Bext is the broadcast audio extension chunk of a Broadcast Wave file.
+type Bext struct {
+	Description         string
+	Originator          string // the name of the originator, such as the recorder
+	OriginatorReference string // a unique reference of the originator
+
+	// OriginationDate and OriginationTime are the local date and time of
+	// the recording, in the form yyyy-mm-dd and hh:mm:ss.
+	OriginationDate string
+	OriginationTime string
+
+	// TimeReference is the number of samples since midnight of the first
+	// sample of the recording.
+	TimeReference uint64
+
+	Version       uint16
+	UMID          []byte // the SMPTE UMID, or all zero
+	CodingHistory string
+}
+
+// Start returns the time of day of the first sample of the recording, which
+// is calculated from TimeReference.
+func (b *Bext) Start(sampleRate int) time.Duration {
+	if sampleRate <= 0 {
+		return 0
+	}
+	sr := uint64(sampleRate)
+	return time.Duration(b.TimeReference/sr)*time.Second +
+		time.Duration(b.TimeReference%sr)*time.Second/time.Duration(sr)
+}
+
+/*
+parseBext parses the broadcast audio extension chunk.
+
+Encoding format
+
+BYTES DESCRIPTION
+===== ===========================================================================
+  256 Description
+   32 Originator
+   32 Originator reference
+   10 Origination date
+    8 Origination time
+    8 Time reference
+    2 Version
+   64 UMID
+   10 Loudness values (version 2)
+  180 Reserved
+    n Coding history
+===== ===========================================================================
+
+All strings are ASCII and padded with null bytes, and all integers are
+little-endian.
+*/
+func parseBext(b []byte) (*Bext, error) {
+	if len(b) < 602 {
+		return nil, ErrInvalidStream
+	}
+	str := func(b []byte) string {
+		s := string(b)
+		if i := strings.IndexByte(s, 0); i >= 0 {
+			s = s[:i]
+		}
+		return strings.TrimSpace(s)
+	}
+	return &Bext{
+		Description:         str(b[:256]),
+		Originator:          str(b[256:288]),
+		OriginatorReference: str(b[288:320]),
+		OriginationDate:     str(b[320:330]),
+		OriginationTime:     str(b[330:338]),
+		TimeReference:       binary.LittleEndian.Uint64(b[338:]),
+		Version:             binary.LittleEndian.Uint16(b[346:]),
+		UMID:                append([]byte(nil), b[348:412]...),
+		CodingHistory:       str(b[602:]),
+	}, nil
+}
+
+// }}}
+
+// Chunk: ds64 {{{
+
+// ds64Chunk contains the 64-bit sizes of an RF64 stream, which are used
+// when the 32-bit sizes are 0xFFFFFFFF.
+type ds64Chunk struct {
+	riffSize    int64
+	dataSize    int64
+	sampleCount int64
+}
+
+func parseDS64(b []byte) (*ds64Chunk, error) {
+	if len(b) < 24 {
+		return nil, ErrInvalidStream
+	}
+	return &ds64Chunk{
+		riffSize:    int64(binary.LittleEndian.Uint64(b)),
+		dataSize:    int64(binary.LittleEndian.Uint64(b[8:])),
+		sampleCount: int64(binary.LittleEndian.Uint64(b[16:])),
+	}, nil
+}
+
+// }}}
+
+func readBytes(r io.Reader, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return nil, ErrUnexpectedEOF
+	}
+	return buf, nil
+}
+
+// skipBytes discards the next n bytes of r, by seeking if possible, and
+// returns the number of bytes that were discarded. When seeking, the caller
+// needs to make sure that n does not go beyond the end of the stream.
+func skipBytes(r io.Reader, n int64) (int64, error) {
+	if s, ok := r.(io.Seeker); ok {
+		if _, err := s.Seek(n, io.SeekCurrent); err == nil {
+			return n, nil
+		}
+	}
+	m, err := io.CopyN(ioutil.Discard, r, n)
+	if err != nil {
+		return m, ErrUnexpectedEOF
+	}
+	return m, nil
+}
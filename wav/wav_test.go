@@ -0,0 +1,298 @@
+// Copyright 2016 Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/goulash/audio"
+	"github.com/goulash/audio/id3"
+	"github.com/stretchr/testify/assert"
+)
+
+const testFile = "test.wav"
+
+// chunk returns a chunk with the content, padded to an even size.
+func chunk(id string, content []byte) []byte {
+	b := make([]byte, 8, 8+len(content)+1)
+	copy(b, id)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(content)))
+	b = append(b, content...)
+	if len(content)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func riff(id string, chunks ...[]byte) []byte {
+	b := append([]byte(id), 0, 0, 0, 0)
+	b = append(b, "WAVE"...)
+	for _, c := range chunks {
+		b = append(b, c...)
+	}
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	return b
+}
+
+// pcmFormat returns the content of a PCM fmt chunk.
+func pcmFormat(channels, rate, bits int) []byte {
+	b := make([]byte, 16)
+	align := channels * bits / 8
+	binary.LittleEndian.PutUint16(b, uint16(PCM))
+	binary.LittleEndian.PutUint16(b[2:], uint16(channels))
+	binary.LittleEndian.PutUint32(b[4:], uint32(rate))
+	binary.LittleEndian.PutUint32(b[8:], uint32(rate*align))
+	binary.LittleEndian.PutUint16(b[12:], uint16(align))
+	binary.LittleEndian.PutUint16(b[14:], uint16(bits))
+	return b
+}
+
+func TestReadFileMetadata(z *testing.T) {
+	assert := assert.New(z)
+	m, err := ReadFileMetadata(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+	f := m.Format()
+	assert.Equal(PCM, f.Tag)
+	assert.False(f.Extensible)
+	assert.Equal(uint16(2), f.NumChannels)
+	assert.Equal(uint32(44100), f.SampleRate)
+	assert.Equal(uint16(4), f.BlockAlign)
+	assert.Equal(uint16(16), f.ValidBits)
+
+	assert.Equal([]Chunk{{"fmt ", 20, 16}, {"data", 44, 66144}}, m.Chunks())
+	assert.Equal(int64(44), m.DataOffset())
+	assert.Equal(int64(66144), m.DataSize())
+
+	var p audio.Properties = m
+	assert.Equal(44100, p.SampleRate())
+	assert.Equal(2, p.NumChannels())
+	assert.Equal(16, p.BitsPerSample())
+	assert.Equal(int64(16536), p.TotalSamples())
+	assert.Equal(time.Duration(16536)*time.Second/44100, p.Duration())
+	assert.Equal(audio.CBR, p.BitrateMode())
+	assert.Equal(audio.Stereo, p.ChannelLayout())
+	assert.Equal(1411, m.EncodingBitrate())
+	assert.Equal("", m.Title())
+	assert.Nil(m.Bext())
+}
+
+func TestAudioReadMetadata(z *testing.T) {
+	assert := assert.New(z)
+	m, err := audio.ReadMetadata(testFile)
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(audio.WAV, m.Encoding())
+	assert.Equal(int64(16536), m.TotalSamples())
+}
+
+func TestInfo(z *testing.T) {
+	assert := assert.New(z)
+
+	var info []byte
+	info = append(info, "INFO"...)
+	info = append(info, chunk("INAM", []byte("Dawn Chorus\x00"))...)
+	info = append(info, chunk("IART", []byte("Field Recordist\x00"))...)
+	info = append(info, chunk("IPRD", []byte("Forest\x00"))...)
+	info = append(info, chunk("ICMT", []byte("Recorded in W\xFCrzburg\x00"))...)
+	info = append(info, chunk("ICRD", []byte("2016-05-01\x00"))...)
+	info = append(info, chunk("ITRK", []byte("3/12\x00"))...)
+	info = append(info, chunk("ISFT", []byte("Recorder 1.0"))...)
+	data := riff("RIFF",
+		chunk("fmt ", pcmFormat(1, 48000, 24)),
+		chunk("data", make([]byte, 3*48000)),
+		chunk("LIST", info),
+		chunk("junk", make([]byte, 7)),
+	)
+
+	m, err := ReadMetadata(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal("Dawn Chorus", m.Title())
+	assert.Equal("Field Recordist", m.Artist())
+	assert.Equal("Forest", m.Album())
+	assert.Equal("Recorded in Würzburg", m.Comment())
+	assert.Equal(2016, m.Year())
+	n, total := m.Track()
+	assert.Equal(3, n)
+	assert.Equal(12, total)
+	assert.Equal("Recorder 1.0", m.EncoderSettings())
+	assert.Equal(time.Second, m.Length())
+	assert.Equal(audio.Mono, m.ChannelLayout())
+	assert.Len(m.Chunks(), 4)
+	assert.Equal("junk", m.Chunks()[3].ID)
+}
+
+func TestID3(z *testing.T) {
+	assert := assert.New(z)
+
+	t := &id3.Tag{}
+	t.SetText("TIT2", "Tagged Title")
+	t.SetText("TCON", "(8)")
+	t.SetYear(2015)
+	t.SetURL("WOAR", "http://example.com")
+	tag, err := id3.Encode(t, 0)
+	if !assert.Nil(err) {
+		return
+	}
+	var info []byte
+	info = append(info, "INFO"...)
+	info = append(info, chunk("INAM", []byte("Info Title\x00"))...)
+	info = append(info, chunk("IART", []byte("Info Artist\x00"))...)
+	data := riff("RIFF",
+		chunk("fmt ", pcmFormat(2, 44100, 16)),
+		chunk("LIST", info),
+		chunk("id3 ", tag),
+		chunk("data", make([]byte, 4*44100)),
+	)
+
+	m, err := ReadMetadata(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+	if assert.NotNil(m.ID3()) {
+		assert.Len(m.ID3().Frames, 4)
+	}
+	assert.Equal("Tagged Title", m.Title())
+	assert.Equal("Info Artist", m.Artist())
+	assert.Equal("Jazz", m.Genre())
+	assert.Equal(2015, m.Year())
+	assert.Equal("http://example.com", m.Website())
+}
+
+func TestBext(z *testing.T) {
+	assert := assert.New(z)
+
+	b := make([]byte, 602, 620)
+	copy(b, "Dawn chorus, microphone 2")
+	copy(b[256:], "Recorder")
+	copy(b[320:], "2016-05-01")
+	copy(b[330:], "05:30:00")
+	binary.LittleEndian.PutUint64(b[338:], (5*3600+30*60)*48000+24000)
+	binary.LittleEndian.PutUint16(b[346:], 1)
+	b = append(b, "A=PCM,F=48000,W=24,M=mono\r\n"...)
+	data := riff("RIFF",
+		chunk("bext", b),
+		chunk("fmt ", pcmFormat(1, 48000, 24)),
+		chunk("data", make([]byte, 3*480)),
+	)
+
+	m, err := ReadMetadata(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+	x := m.Bext()
+	if assert.NotNil(x) {
+		assert.Equal("Recorder", x.Originator)
+		assert.Equal("05:30:00", x.OriginationTime)
+		assert.Equal(uint16(1), x.Version)
+		assert.Equal("A=PCM,F=48000,W=24,M=mono", x.CodingHistory)
+		assert.Equal(5*time.Hour+30*time.Minute+500*time.Millisecond, x.Start(48000))
+	}
+	assert.Equal("Dawn chorus, microphone 2", m.Comment())
+	assert.Equal(2016, m.Year())
+}
+
+func TestExtensible(z *testing.T) {
+	assert := assert.New(z)
+
+	f := make([]byte, 40)
+	binary.LittleEndian.PutUint16(f, uint16(Extensible))
+	binary.LittleEndian.PutUint16(f[2:], 6)
+	binary.LittleEndian.PutUint32(f[4:], 96000)
+	binary.LittleEndian.PutUint32(f[8:], 96000*24)
+	binary.LittleEndian.PutUint16(f[12:], 24)
+	binary.LittleEndian.PutUint16(f[14:], 32)
+	binary.LittleEndian.PutUint16(f[16:], 22)
+	binary.LittleEndian.PutUint16(f[18:], 24)
+	binary.LittleEndian.PutUint32(f[20:], uint32(audio.Surround51))
+	binary.LittleEndian.PutUint16(f[24:], uint16(IEEEFloat))
+	copy(f[26:], subFormatGUID)
+	data := riff("RIFF", chunk("fmt ", f), chunk("data", make([]byte, 24*10)))
+
+	m, err := ReadMetadata(bytes.NewReader(data))
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(IEEEFloat, m.Format().Tag)
+	assert.True(m.Format().Extensible)
+	assert.Equal(32, int(m.Format().BitsPerSample))
+	assert.Equal(24, m.BitsPerSample())
+	assert.Equal(audio.Surround51, m.ChannelLayout())
+	assert.Equal(int64(10), m.TotalSamples())
+
+	// An unknown sub-format remains extensible.
+	f[26] = 0xFF
+	m, err = ReadMetadata(bytes.NewReader(riff("RIFF", chunk("fmt ", f))))
+	if assert.Nil(err) {
+		assert.Equal(Extensible, m.Format().Tag)
+		assert.Equal(int64(0), m.DataSize())
+	}
+}
+
+func TestRF64(z *testing.T) {
+	assert := assert.New(z)
+
+	ds64 := make([]byte, 28)
+	binary.LittleEndian.PutUint64(ds64[8:], 400)
+	data := riff("RF64", chunk("ds64", ds64), chunk("fmt ", pcmFormat(2, 44100, 16)), chunk("data", make([]byte, 400)))
+	binary.LittleEndian.PutUint32(data[4:], 0xFFFFFFFF)
+	i := bytes.Index(data, []byte("data"))
+	binary.LittleEndian.PutUint32(data[i+4:], 0xFFFFFFFF)
+
+	m, err := ReadMetadata(bytes.NewReader(data))
+	if assert.Nil(err) {
+		assert.Equal(int64(400), m.DataSize())
+		assert.Equal(int64(100), m.TotalSamples())
+	}
+}
+
+// onlyReader hides the Seek method of a reader.
+type onlyReader struct{ io.Reader }
+
+func TestTruncated(z *testing.T) {
+	assert := assert.New(z)
+
+	// The data chunk of an interrupted recording claims more data than
+	// there is.
+	data := riff("RIFF", chunk("fmt ", pcmFormat(2, 44100, 16)), chunk("data", make([]byte, 4000)))
+	data = data[:len(data)-1000]
+	for _, r := range []io.Reader{bytes.NewReader(data), onlyReader{bytes.NewReader(data)}} {
+		m, err := ReadMetadata(r)
+		if assert.Nil(err) {
+			assert.Equal(int64(3000), m.DataSize())
+			assert.Equal(int64(750), m.TotalSamples())
+		}
+	}
+
+	// A truncated chunk after the data is ignored.
+	data = riff("RIFF", chunk("fmt ", pcmFormat(2, 44100, 16)), chunk("data", make([]byte, 400)), chunk("LIST", make([]byte, 100)))
+	m, err := ReadMetadata(bytes.NewReader(data[:len(data)-50]))
+	if assert.Nil(err) {
+		assert.Equal(int64(400), m.DataSize())
+	}
+
+	tests := [][]byte{
+		nil,
+		[]byte("RIFF\x00\x00\x00\x00WAVX"),
+		[]byte("fLaC\x00\x00\x00\x22\x10\x00\x10\x00"),
+		riff("RIFF", chunk("data", make([]byte, 4))),
+		riff("RIFF", chunk("fmt ", make([]byte, 16))),
+		riff("RIFF", chunk("fmt ", pcmFormat(2, 44100, 16))[:20]),
+	}
+	for _, t := range tests {
+		_, err := ReadMetadata(bytes.NewReader(t))
+		if err == nil {
+			z.Errorf("ReadMetadata(%q) = nil; expecting an error", t)
+		}
+	}
+}